@@ -2,4 +2,41 @@
 
 command="${INPUT_COMMAND} ${INPUT_ARGS}"
 echo "container-diff ${command}"
-/usr/local/bin/container-diff ${command}
+
+if [ -z "${INPUT_REPORT}" ] || [ "${INPUT_REPORT}" = "none" ]; then
+    exec /usr/local/bin/container-diff ${command}
+fi
+
+case "${INPUT_REPORT}" in
+    summary|comment|both) ;;
+    *)
+        echo "unknown report ${INPUT_REPORT}, expected none, summary, comment or both"
+        exit 1
+        ;;
+esac
+
+# Write the results as markdown, then post them. The exit code of
+# container-diff, e.g. 2 with --fail-on-diff, is kept for the step.
+report=$(mktemp)
+/usr/local/bin/container-diff ${command} --output-format=markdown --output="${report}"
+status=$?
+cat "${report}"
+
+if [ "${INPUT_REPORT}" = "summary" ] || [ "${INPUT_REPORT}" = "both" ]; then
+    cat "${report}" >> "${GITHUB_STEP_SUMMARY}"
+fi
+
+if [ "${INPUT_REPORT}" = "comment" ] || [ "${INPUT_REPORT}" = "both" ]; then
+    pr=$(jq -r '.pull_request.number // empty' "${GITHUB_EVENT_PATH}")
+    if [ -z "${pr}" ]; then
+        echo "not posting a comment outside of a pull request"
+    elif [ -s "${report}" ]; then
+        jq -n --rawfile body "${report}" '{body: $body}' | curl -sSf -o /dev/null -X POST \
+            -H "Authorization: Bearer ${INPUT_TOKEN}" \
+            -H "Accept: application/vnd.github+json" \
+            "${GITHUB_API_URL}/repos/${GITHUB_REPOSITORY}/issues/${pr}/comments" \
+            --data-binary @- || status=1
+    fi
+fi
+
+exit ${status}
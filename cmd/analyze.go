@@ -17,17 +17,24 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/GoogleContainerTools/container-diff/cmd/util/output"
 	"github.com/GoogleContainerTools/container-diff/differs"
+	"github.com/GoogleContainerTools/container-diff/pkg/containerdiff"
 	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+	"github.com/GoogleContainerTools/container-diff/util"
+	"github.com/google/go-containerregistry/pkg/v1"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+var outputFormat string
+
 var analyzeCmd = &cobra.Command{
 	Use:   "analyze image",
 	Short: "Analyzes an image: container-diff analyze image",
@@ -35,13 +42,15 @@ var analyzeCmd = &cobra.Command{
 
 For details on how to specify images, run: container-diff help`,
 	Args: func(cmd *cobra.Command, args []string) error {
-		if err := validateArgs(args, checkAnalyzeArgNum, checkIfValidAnalyzer); err != nil {
+		if err := validateArgs(args, checkAnalyzeArgNum, checkPluginConfig, checkOutputFormat, checkSBOMSources, checkIfValidAnalyzer, checkVulnDBFlag, checkFilterFlags, checkPlatformFlags, checkAnalyzePlatformNum); err != nil {
 			return err
 		}
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := analyzeImage(args[0], types); err != nil {
+		ctx, cancel := newContext()
+		defer cancel()
+		if err := analyzeImage(ctx, args[0], types); err != nil {
 			logrus.Error(err)
 			os.Exit(1)
 		}
@@ -55,44 +64,155 @@ func checkAnalyzeArgNum(args []string) error {
 	return nil
 }
 
-func analyzeImage(imageName string, analyzerArgs []string) error {
-	analyzeTypes, err := differs.GetAnalyzers(analyzerArgs)
+func checkAnalyzePlatformNum(_ []string) error {
+	if len(platforms) > 1 {
+		return errors.New("'analyze' accepts a single --platform")
+	}
+	return nil
+}
+
+// checkOutputFormat validates --output-format, and runs every package
+// analyzer for SBOMs unless analyzers are selected with --type.
+func checkOutputFormat(_ []string) error {
+	switch outputFormat {
+	case "":
+		return nil
+	case util.HTMLFormat, util.MarkdownFormat:
+		return checkReportFlags()
+	case pkgutil.SPDXJSON, pkgutil.CycloneDXJSON:
+	default:
+		return fmt.Errorf("unknown output format %s, expected %s, %s, %s or %s", outputFormat, pkgutil.SPDXJSON, pkgutil.CycloneDXJSON, util.HTMLFormat, util.MarkdownFormat)
+	}
+	if allPlatforms {
+		return errors.New("--output-format can't be used with --all-platforms, select a platform with --platform")
+	}
+	if len(types) == 0 {
+		types = differs.PackageAnalyzers[:]
+	}
+	return nil
+}
+
+func analyzeImage(ctx context.Context, imageName string, analyzerArgs []string) error {
+	if outputFormat == pkgutil.SPDXJSON || outputFormat == pkgutil.CycloneDXJSON {
+		return analyzeSBOM(ctx, imageName, getPlatform(0), analyzerArgs)
+	}
+	if allPlatforms {
+		return analyzeAllPlatforms(ctx, imageName, analyzerArgs)
+	}
+
+	analyses, err := analyzePlatform(ctx, imageName, getPlatform(0), analyzerArgs)
 	if err != nil {
-		return errors.Wrap(err, "getting analyzers")
+		return err
 	}
+	logrus.Info("retrieving analyses")
+	if outputFormat != "" {
+		return outputReport(imageName, []platformResults{{Results: analyses}}, nil)
+	}
+	outputResults(analyses)
+	return nil
+}
 
-	image, err := getImage(imageName)
+// analyzeAllPlatforms analyzes each platform of a multi-platform image.
+func analyzeAllPlatforms(ctx context.Context, imageName string, analyzerArgs []string) error {
+	imagePlatforms, err := pkgutil.GetPlatformsContext(ctx, imageName, tlsOptions())
 	if err != nil {
-		return errors.Wrapf(err, "error retrieving image %s", imageName)
+		return errors.Wrapf(err, "listing platforms of image %s", imageName)
+	}
+	if len(imagePlatforms) == 0 {
+		return fmt.Errorf("image %s is not a multi-platform image", imageName)
+	}
+	sortPlatforms(imagePlatforms)
+
+	var groups []platformResults
+	for i := range imagePlatforms {
+		platform := &imagePlatforms[i]
+		logrus.Infof("analyzing platform %s", platform)
+		analyses, err := analyzePlatform(ctx, imageName, platform, analyzerArgs)
+		if err != nil {
+			return errors.Wrapf(err, "analyzing platform %s", platform)
+		}
+		groups = append(groups, platformResults{Platform: platform.String(), Results: analyses})
 	}
+	logrus.Info("retrieving analyses")
+	if outputFormat != "" {
+		return outputReport(imageName, groups, nil)
+	}
+	outputPlatformResults(groups)
+	return nil
+}
 
-	if noCache && !save {
-		defer pkgutil.CleanupImage(image)
+// analyzePlatform runs the analyzers on the image for platform, or on the
+// default platform if nil.
+func analyzePlatform(ctx context.Context, imageName string, platform *v1.Platform, analyzerArgs []string) (map[string]util.Result, error) {
+	opts, err := getOptions(analyzerArgs, platform, nil)
+	if err != nil {
+		return nil, err
 	}
+	result, err := containerdiff.Analyze(ctx, imageName, opts)
 	if err != nil {
-		return fmt.Errorf("error processing image: %s", err)
+		return nil, err
+	}
+
+	if noCache && save {
+		logrus.Infof("image was saved at %s", result.Image.FSPath)
 	}
+	return result.Results, nil
+}
 
-	req := differs.SingleRequest{
-		Image:        image,
-		AnalyzeTypes: analyzeTypes}
-	analyses, err := req.GetAnalysis()
+// analyzeSBOM writes the packages found by the package analyzers as an SBOM
+// in the format selected with --output-format.
+func analyzeSBOM(ctx context.Context, imageName string, platform *v1.Platform, analyzerArgs []string) error {
+	analyzeTypes, err := differs.GetAnalyzers(analyzerArgs)
+	if err != nil {
+		return errors.Wrap(err, "getting analyzers")
+	}
+	for _, analyzer := range analyzeTypes {
+		if !isPackageAnalyzer(analyzer) {
+			logrus.Warningf("%s doesn't list packages, leaving it out of the SBOM", analyzer.Name())
+		}
+	}
+	opts, err := getOptions(analyzerArgs, platform, nil)
 	if err != nil {
-		return fmt.Errorf("error performing image analysis: %s", err)
+		return err
+	}
+	// the OS release is read from the filesystem once the analyzers are done
+	opts.KeepFilesystems = true
+	result, err := containerdiff.Analyze(ctx, imageName, opts)
+	if err != nil {
+		return err
+	}
+	image := result.Image
+	if noCache && !save {
+		defer pkgutil.CleanupImage(image)
+	} else {
+		defer pkgutil.ReleaseImage(image)
 	}
 
-	logrus.Info("retrieving analyses")
-	outputResults(analyses)
+	osID, osVersion := pkgutil.GetOSRelease(image.FSPath)
+	subject := pkgutil.SBOMSubject{Name: image.Source, Digest: image.Digest.String()}
+	sbom := util.GetSBOM(subject, osID, osVersion, result.Results)
+	sbom.Created = time.Now()
 
-	if noCache && save {
-		logrus.Infof("image was saved at %s", image.FSPath)
+	writer, err := getWriter(outputFile)
+	if err != nil {
+		return errors.Wrap(err, "getting writer for output file")
 	}
+	return pkgutil.WriteSBOM(writer, sbom, outputFormat)
+}
 
-	return nil
+func isPackageAnalyzer(analyzer differs.Analyzer) bool {
+	for _, name := range differs.PackageAnalyzers {
+		if differs.Analyzers[name] == analyzer {
+			return true
+		}
+	}
+	return false
 }
 
 func init() {
 	RootCmd.AddCommand(analyzeCmd)
 	addSharedFlags(analyzeCmd)
+	analyzeCmd.Flags().StringVar(&outputFormat, "output-format", "", "Write the packages found by the package analyzers as an SBOM instead: spdx-json or cyclonedx-json. Or write the analyses as a report: html, or markdown for a summary sized for pull request comments.")
+	addReportFlags(analyzeCmd)
 	output.AddFlags(analyzeCmd)
 }
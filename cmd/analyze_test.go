@@ -18,6 +18,8 @@ package cmd
 
 import (
 	"testing"
+
+	"github.com/GoogleContainerTools/container-diff/differs"
 )
 
 var analyzeArgNumTests = []testpair{
@@ -38,3 +40,43 @@ func TestAnalyzeArgNum(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckOutputFormat(t *testing.T) {
+	defer func() {
+		outputFormat, allPlatforms, types, json = "", false, nil, false
+	}()
+
+	outputFormat = "spdx-json"
+	if err := checkOutputFormat(nil); err != nil {
+		t.Errorf("Got unexpected error: %s", err)
+	}
+	if len(types) != len(differs.PackageAnalyzers) {
+		t.Errorf("Expected every package analyzer to run by default, got %v", types)
+	}
+
+	types = []string{"apt"}
+	if err := checkOutputFormat(nil); err != nil || len(types) != 1 {
+		t.Errorf("Expected the selected analyzers to be kept, got %v, %v", types, err)
+	}
+
+	allPlatforms = true
+	if err := checkOutputFormat(nil); err == nil {
+		t.Errorf("Expected error for --all-platforms but got none")
+	}
+
+	allPlatforms = false
+	outputFormat = "spdx-xml"
+	if err := checkOutputFormat(nil); err == nil {
+		t.Errorf("Expected error for unknown format but got none")
+	}
+
+	outputFormat, allPlatforms, types = "html", true, nil
+	if err := checkOutputFormat(nil); err != nil || len(types) != 0 {
+		t.Errorf("Expected html reports of every platform with the default analyzers, got %v, %v", types, err)
+	}
+
+	json = true
+	if err := checkOutputFormat(nil); err == nil {
+		t.Errorf("Expected error for --json with html but got none")
+	}
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"code.cloudfoundry.org/bytefmt"
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+	"github.com/GoogleContainerTools/container-diff/util"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var removeCorrupt bool
+var pruneAll bool
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the cache of image filesystems: container-diff cache [list|verify|prune]",
+	Long: `Manages the cache of unpacked image filesystems.
+
+Layers and image filesystems are cached by digest and shared across images.
+The cache lives in .container-diff/cache under --cache-dir, $CONTAINER_DIFF_CACHEDIR or $HOME.`,
+}
+
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the cached filesystems, most recently used first",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := listCache(); err != nil {
+			logrus.Error(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var cacheVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the cached filesystems against the digests recorded when they were unpacked",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := verifyCache(); err != nil {
+			logrus.Error(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove incomplete and least recently used filesystems from the cache",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := pruneCache(); err != nil {
+			logrus.Error(err)
+			os.Exit(1)
+		}
+	},
+}
+
+// cacheListEntry is the JSON output of an entry of the cache
+type cacheListEntry struct {
+	Kind     string
+	Digest   string
+	Size     int64
+	Files    int
+	Complete bool
+	Created  time.Time
+	LastUsed time.Time
+	Path     string
+}
+
+func getCache() (*pkgutil.Cache, error) {
+	root, err := getCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return &pkgutil.Cache{Root: root}, nil
+}
+
+func listCache() error {
+	cache, err := getCache()
+	if err != nil {
+		return err
+	}
+	entries, err := cache.Entries()
+	if err != nil {
+		return errors.Wrap(err, "listing cache entries")
+	}
+	writer, err := getWriter(outputFile)
+	if err != nil {
+		return errors.Wrap(err, "getting writer for output file")
+	}
+
+	if json {
+		output := []cacheListEntry{}
+		for _, entry := range entries {
+			output = append(output, cacheListEntry{
+				Kind:     entry.Kind,
+				Digest:   entry.Digest,
+				Size:     entry.Size,
+				Files:    entry.Files,
+				Complete: entry.Complete,
+				Created:  entry.Created,
+				LastUsed: entry.LastUsed,
+				Path:     entry.FSPath(),
+			})
+		}
+		return util.JSONify(writer, output)
+	}
+
+	w := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tDIGEST\tSIZE\tFILES\tLAST USED")
+	var total int64
+	for _, entry := range entries {
+		if !entry.Complete {
+			fmt.Fprintf(w, "%s\t%s\t-\t-\tincomplete\n", entry.Kind, entry.Digest)
+			continue
+		}
+		total += entry.Size
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", entry.Kind, entry.Digest,
+			bytefmt.ByteSize(uint64(entry.Size)), entry.Files, entry.LastUsed.Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "\nTotal: %d entries, %s in %s\n", len(entries), bytefmt.ByteSize(uint64(total)), cache.Root)
+	return w.Flush()
+}
+
+func verifyCache() error {
+	cache, err := getCache()
+	if err != nil {
+		return err
+	}
+	entries, err := cache.Entries()
+	if err != nil {
+		return errors.Wrap(err, "listing cache entries")
+	}
+	corrupt := 0
+	for _, entry := range entries {
+		if !entry.Complete {
+			logrus.Infof("skipping incomplete entry %s %s", entry.Kind, entry.Digest)
+			continue
+		}
+		err := cache.Verify(entry)
+		if err == nil {
+			fmt.Printf("OK\t%s %s\n", entry.Kind, entry.Digest)
+			continue
+		}
+		fmt.Printf("CORRUPT\t%s %s: %s\n", entry.Kind, entry.Digest, err)
+		if removeCorrupt {
+			if err := cache.Remove(entry); err != nil {
+				logrus.Errorf("removing %s %s: %s", entry.Kind, entry.Digest, err)
+				corrupt++
+			}
+			continue
+		}
+		corrupt++
+	}
+	if corrupt > 0 {
+		return fmt.Errorf("%d corrupt cache entries found, remove them with --remove-corrupt", corrupt)
+	}
+	return nil
+}
+
+func pruneCache() error {
+	cache, err := getCache()
+	if err != nil {
+		return err
+	}
+	var maxSize int64
+	if !pruneAll {
+		maxSize, err = getCacheMaxSize()
+		if err != nil {
+			return err
+		}
+		if maxSize == 0 {
+			return errors.New("please set a non-zero --cache-max-size, or use --all to empty the cache")
+		}
+	}
+	removed, err := cache.Prune(maxSize)
+	if err != nil {
+		return errors.Wrap(err, "pruning cache")
+	}
+	var freed int64
+	for _, entry := range removed {
+		freed += entry.Size
+	}
+	fmt.Printf("Removed %d entries, freeing %s\n", len(removed), bytefmt.ByteSize(uint64(freed)))
+	return nil
+}
+
+func init() {
+	cacheCmd.PersistentFlags().StringVarP(&cacheDir, "cache-dir", "c", "", "cache directory base to create .container-diff (default is $HOME).")
+	cacheListCmd.Flags().BoolVarP(&json, "json", "j", false, "JSON Output defines if the entries should be returned in a human readable format (false) or a JSON (true).")
+	cacheListCmd.Flags().StringVarP(&outputFile, "output", "w", "", "output file to write to (default writes to the screen).")
+	cacheListCmd.Flags().BoolVar(&forceWrite, "force", false, "force overwrite output file, if exists already.")
+	cacheVerifyCmd.Flags().BoolVar(&removeCorrupt, "remove-corrupt", false, "Remove the corrupt entries found from the cache.")
+	cachePruneCmd.Flags().StringVar(&cacheMaxSize, "cache-max-size", defaultCacheMaxSize, "Size to shrink the cache to, e.g. 10GB, by removing the least recently used filesystems.")
+	cachePruneCmd.Flags().BoolVar(&pruneAll, "all", false, "Remove every filesystem from the cache.")
+
+	cacheCmd.AddCommand(cacheListCmd, cacheVerifyCmd, cachePruneCmd)
+	RootCmd.AddCommand(cacheCmd)
+}
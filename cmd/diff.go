@@ -17,21 +17,43 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
-	"sync"
 
 	"github.com/GoogleContainerTools/container-diff/cmd/util/output"
 	"github.com/GoogleContainerTools/container-diff/differs"
+	"github.com/GoogleContainerTools/container-diff/pkg/containerdiff"
 	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
 	"github.com/GoogleContainerTools/container-diff/util"
+	"github.com/google/go-containerregistry/pkg/v1"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 var filename string
+var contentDiff bool
+var contentDiffLimits util.ContentDiffLimits
+var contentDiffMaxFileSize string
+var contentDiffMaxTotalSize string
+var failOnDiff bool
+var policyFile string
+
+// exit code used when the images differ in a way the policy doesn't allow,
+// to tell it apart from errors
+const policyViolationExitCode = 2
+
+// policyViolationError is returned by diffImages when the diff violates the
+// policy given with --policy or --fail-on-diff.
+type policyViolationError struct {
+	violations []util.Violation
+}
+
+func (e policyViolationError) Error() string {
+	return fmt.Sprintf("diff violates policy: %d violation(s) found", len(e.violations))
+}
 
 var diffCmd = &cobra.Command{
 	Use:   "diff image1 image2",
@@ -40,14 +62,19 @@ var diffCmd = &cobra.Command{
 
 For details on how to specify images, run: container-diff help`,
 	Args: func(cmd *cobra.Command, args []string) error {
-		if err := validateArgs(args, checkDiffArgNum, checkIfValidAnalyzer, checkFilenameFlag); err != nil {
+		if err := validateArgs(args, checkDiffArgNum, checkPluginConfig, checkDiffOutputFormat, checkSBOMSources, checkIfValidAnalyzer, checkVulnDBFlag, checkFilterFlags, checkFilenameFlag, checkContentDiffFlags, checkPolicyFlags, checkPlatformFlags, checkDiffPlatformFlags); err != nil {
 			return err
 		}
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := diffImages(args[0], args[1], types); err != nil {
+		ctx, cancel := newContext()
+		defer cancel()
+		if err := diffImages(ctx, args[0], args[1], types); err != nil {
 			logrus.Error(err)
+			if _, ok := err.(policyViolationError); ok {
+				os.Exit(policyViolationExitCode)
+			}
 			os.Exit(1)
 		}
 	},
@@ -60,6 +87,18 @@ func checkDiffArgNum(args []string) error {
 	return nil
 }
 
+// checkDiffOutputFormat validates --output-format, which only selects
+// reports for diffs.
+func checkDiffOutputFormat(_ []string) error {
+	switch outputFormat {
+	case "":
+		return nil
+	case util.HTMLFormat, util.MarkdownFormat:
+		return checkReportFlags()
+	}
+	return fmt.Errorf("unknown output format %s, expected %s or %s", outputFormat, util.HTMLFormat, util.MarkdownFormat)
+}
+
 func checkFilenameFlag(_ []string) error {
 	if filename == "" {
 		return nil
@@ -72,101 +111,258 @@ func checkFilenameFlag(_ []string) error {
 	return errors.New("please include --type=file with the --filename flag")
 }
 
-// processImage is a concurrency-friendly wrapper around getImageForName
-func processImage(imageName string, errChan chan<- error) *pkgutil.Image {
-	image, err := getImage(imageName)
+// checkContentDiffFlags sets the limits of the content diffs, and checks
+// --content-diff is used with the file differ.
+func checkContentDiffFlags(_ []string) error {
+	maxFileSize, err := parseByteSize(contentDiffMaxFileSize)
 	if err != nil {
-		errChan <- fmt.Errorf("error retrieving image %s: %s", imageName, err)
+		return errors.Wrap(err, "parsing --content-diff-max-file-size")
 	}
-	return &image
+	maxTotalSize, err := parseByteSize(contentDiffMaxTotalSize)
+	if err != nil {
+		return errors.Wrap(err, "parsing --content-diff-max-total-size")
+	}
+	contentDiffLimits = util.ContentDiffLimits{MaxFileSize: maxFileSize, MaxTotalSize: maxTotalSize}
+	if !contentDiff {
+		return nil
+	}
+	fileType := false
+	for _, t := range types {
+		if t == "file" {
+			fileType = true
+		}
+	}
+	if !fileType {
+		return errors.New("please include --type=file with the --content-diff flag")
+	}
+	return nil
 }
 
-// collects errors from a channel and combines them
-// assumes channel has already been closed
-func readErrorsFromChannel(c chan error) error {
-	errs := []string{}
-	for err := range c {
-		errs = append(errs, err.Error())
+func checkPolicyFlags(_ []string) error {
+	if failOnDiff && policyFile != "" {
+		return errors.New("please use either --fail-on-diff or --policy, not both")
 	}
+	return nil
+}
 
-	if len(errs) > 0 {
-		return errors.New(strings.Join(errs, "\n"))
+func checkDiffPlatformFlags(_ []string) error {
+	if len(platforms) > 2 {
+		return errors.New("'diff' accepts at most two --platform flags, one for each image")
+	}
+	if allPlatforms && filename != "" {
+		return errors.New("please use --platform rather than --all-platforms with the --filename flag")
 	}
 	return nil
 }
 
-func diffImages(image1Arg, image2Arg string, diffArgs []string) error {
-	diffTypes, err := differs.GetAnalyzers(diffArgs)
+// getPolicy returns the policy the diff is checked against, or nil if none
+// was requested.
+func getPolicy() (*util.Policy, error) {
+	if failOnDiff {
+		return util.FailOnDiffPolicy(), nil
+	}
+	if policyFile != "" {
+		return util.LoadPolicy(policyFile)
+	}
+	return nil, nil
+}
+
+// checkPolicy evaluates the diff results of each requested analyzer type
+// against policy and logs the violations found. A non-empty platform labels
+// the violations of one platform of a multi-platform diff. An analyzer that
+// failed can't be checked, so it is an error if the policy covers it.
+func checkPolicy(policy *util.Policy, diffArgs []string, diffs map[string]util.Result, platform string) ([]util.Violation, error) {
+	results := map[string]util.Result{}
+	var failed []string
+	for _, t := range diffArgs {
+		if result, ok := diffs[differs.Analyzers[t].Name()]; ok {
+			results[t] = result
+		} else if policy.Covers(t) {
+			failed = append(failed, t)
+		}
+	}
+	if len(failed) > 0 {
+		err := fmt.Errorf("cannot check policy: analyzers %s failed", strings.Join(failed, ", "))
+		if platform != "" {
+			return nil, errors.Wrapf(err, "platform %s", platform)
+		}
+		return nil, err
+	}
+	violations, err := policy.Evaluate(results)
 	if err != nil {
-		return errors.Wrap(err, "getting analyzers")
+		return nil, errors.Wrap(err, "evaluating policy")
+	}
+	for _, violation := range violations {
+		if platform != "" {
+			logrus.Errorf("policy violation on %s: %s", platform, violation)
+		} else {
+			logrus.Errorf("policy violation: %s", violation)
+		}
 	}
+	return violations, nil
+}
 
-	var wg sync.WaitGroup
-	wg.Add(2)
+func diffImages(ctx context.Context, image1Arg, image2Arg string, diffArgs []string) error {
+	// load the policy first, so a broken policy file fails before the
+	// images are retrieved
+	policy, err := getPolicy()
+	if err != nil {
+		return errors.Wrap(err, "loading policy")
+	}
+	if allPlatforms {
+		return diffAllPlatforms(ctx, image1Arg, image2Arg, diffArgs, policy)
+	}
 
 	logrus.Infof("starting diff on images %s and %s, using differs: %s\n", image1Arg, image2Arg, diffArgs)
 
-	var image1, image2 *pkgutil.Image
-	errChan := make(chan error, 2)
-
-	go func() {
-		defer wg.Done()
-		image1 = processImage(image1Arg, errChan)
-	}()
-	go func() {
-		defer wg.Done()
-		image2 = processImage(image2Arg, errChan)
-	}()
-
-	wg.Wait()
-	close(errChan)
-
-	if noCache && !save {
-		defer pkgutil.CleanupImage(*image1)
-		defer pkgutil.CleanupImage(*image2)
+	opts, err := getOptions(diffArgs, getPlatform(0), getPlatform(1))
+	if err != nil {
+		return err
 	}
-
-	if err := readErrorsFromChannel(errChan); err != nil {
+	result, err := containerdiff.Diff(ctx, image1Arg, image2Arg, opts)
+	if err != nil {
 		return err
 	}
+	diffs := result.Results
+	if outputFormat != "" {
+		if err := outputReport(image1Arg+" vs "+image2Arg, []platformResults{{Results: diffs}}, result.FileDiff); err != nil {
+			return err
+		}
+	} else {
+		outputResults(diffs)
+		if result.FileDiff != nil {
+			if err := outputFileDiff(result.FileDiff); err != nil {
+				return err
+			}
+		}
+	}
+
+	if noCache && save {
+		logrus.Infof("images were saved at %s and %s", result.Image1.FSPath,
+			result.Image2.FSPath)
+	}
+	if policy != nil {
+		violations, err := checkPolicy(policy, diffArgs, diffs, "")
+		if err != nil {
+			return err
+		}
+		if len(violations) > 0 {
+			return policyViolationError{violations: violations}
+		}
+	}
+	return nil
+}
 
-	logrus.Info("computing diffs")
-	req := differs.DiffRequest{
-		Image1:    *image1,
-		Image2:    *image2,
-		DiffTypes: diffTypes}
-	diffs, err := req.GetDiff()
+// diffAllPlatforms diffs each platform found in both multi-platform images.
+func diffAllPlatforms(ctx context.Context, image1Arg, image2Arg string, diffArgs []string, policy *util.Policy) error {
+	diffPlatforms, err := getCommonPlatforms(ctx, image1Arg, image2Arg)
 	if err != nil {
-		return fmt.Errorf("could not retrieve diff: %s", err)
+		return err
 	}
-	outputResults(diffs)
 
-	if filename != "" {
-		logrus.Info("computing filename diffs")
-		err := diffFile(image1, image2)
+	var groups []platformResults
+	var violations []util.Violation
+	for i := range diffPlatforms {
+		platform := &diffPlatforms[i]
+		logrus.Infof("computing diffs for platform %s", platform)
+		diffs, err := diffPlatform(ctx, image1Arg, image2Arg, platform, diffArgs)
 		if err != nil {
+			return errors.Wrapf(err, "diffing platform %s", platform)
+		}
+		groups = append(groups, platformResults{Platform: platform.String(), Results: diffs})
+		if policy != nil {
+			platformViolations, err := checkPolicy(policy, diffArgs, diffs, platform.String())
+			if err != nil {
+				return err
+			}
+			violations = append(violations, platformViolations...)
+		}
+	}
+	if outputFormat != "" {
+		if err := outputReport(image1Arg+" vs "+image2Arg, groups, nil); err != nil {
 			return err
 		}
+	} else {
+		outputPlatformResults(groups)
 	}
 
-	if noCache && save {
-		logrus.Infof("images were saved at %s and %s", image1.FSPath,
-			image2.FSPath)
+	if len(violations) > 0 {
+		return policyViolationError{violations: violations}
 	}
 	return nil
 }
 
-func diffFile(image1, image2 *pkgutil.Image) error {
-	diff, err := util.DiffFile(image1, image2, filename)
+// getCommonPlatforms lists the platforms found in both images, in order.
+func getCommonPlatforms(ctx context.Context, image1Arg, image2Arg string) ([]v1.Platform, error) {
+	platforms1, err := pkgutil.GetPlatformsContext(ctx, image1Arg, tlsOptions())
 	if err != nil {
-		return err
+		return nil, errors.Wrapf(err, "listing platforms of image %s", image1Arg)
+	}
+	platforms2, err := pkgutil.GetPlatformsContext(ctx, image2Arg, tlsOptions())
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing platforms of image %s", image2Arg)
+	}
+	if len(platforms1) == 0 {
+		return nil, fmt.Errorf("image %s is not a multi-platform image", image1Arg)
+	}
+	if len(platforms2) == 0 {
+		return nil, fmt.Errorf("image %s is not a multi-platform image", image2Arg)
 	}
+
+	var common []v1.Platform
+	for _, platform := range platforms1 {
+		if containsPlatform(platforms2, platform) {
+			common = append(common, platform)
+		} else {
+			logrus.Warnf("skipping platform %s, which is missing from image %s", platform, image2Arg)
+		}
+	}
+	for _, platform := range platforms2 {
+		if !containsPlatform(platforms1, platform) {
+			logrus.Warnf("skipping platform %s, which is missing from image %s", platform, image1Arg)
+		}
+	}
+	if len(common) == 0 {
+		return nil, fmt.Errorf("images %s and %s have no platform in common", image1Arg, image2Arg)
+	}
+	sortPlatforms(common)
+	return common, nil
+}
+
+func containsPlatform(platforms []v1.Platform, platform v1.Platform) bool {
+	for _, p := range platforms {
+		if p.Equals(platform) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffPlatform diffs the images of both image arguments for platform.
+func diffPlatform(ctx context.Context, image1Arg, image2Arg string, platform *v1.Platform, diffArgs []string) (map[string]util.Result, error) {
+	opts, err := getOptions(diffArgs, platform, platform)
+	if err != nil {
+		return nil, err
+	}
+	result, err := containerdiff.Diff(ctx, image1Arg, image2Arg, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if noCache && save {
+		logrus.Infof("images were saved at %s and %s", result.Image1.FSPath,
+			result.Image2.FSPath)
+	}
+	return result.Results, nil
+}
+
+// outputFileDiff writes the diff of the file given with --filename.
+func outputFileDiff(diff *util.FileNameDiff) error {
 	writer, err := getWriter(outputFile)
 	if err != nil {
 		return err
 	}
-	util.TemplateOutput(writer, diff, "FilenameDiff")
-	if err != nil {
+	if err := util.TemplateOutput(writer, diff, "FilenameDiff"); err != nil {
 		logrus.Error(err)
 		return err
 	}
@@ -174,7 +370,14 @@ func diffFile(image1, image2 *pkgutil.Image) error {
 }
 
 func init() {
+	diffCmd.Flags().StringVar(&outputFormat, "output-format", "", "Write the diffs as a report instead: html, or markdown for a summary sized for pull request comments.")
+	addReportFlags(diffCmd)
 	diffCmd.Flags().StringVarP(&filename, "filename", "f", "", "Set this flag to the path of a file in both containers to view the diff of the file. Must be used with --type=file flag.")
+	diffCmd.Flags().BoolVar(&contentDiff, "content-diff", false, "Set this flag to show the unified diff of each modified text file, and the digests of modified binary files. Must be used with --type=file flag.")
+	diffCmd.Flags().StringVar(&contentDiffMaxFileSize, "content-diff-max-file-size", "1MB", "Files larger than this, e.g. 512KB, are only compared by digest with --content-diff or --filename; 0 disables the limit.")
+	diffCmd.Flags().StringVar(&contentDiffMaxTotalSize, "content-diff-max-total-size", "10MB", "Once the content diffs reach this total size, e.g. 50MB, the remaining files are only compared by digest; 0 disables the limit.")
+	diffCmd.Flags().BoolVar(&failOnDiff, "fail-on-diff", false, fmt.Sprintf("Exit with code %d if the analyzers find any difference between the images.", policyViolationExitCode))
+	diffCmd.Flags().StringVar(&policyFile, "policy", "", fmt.Sprintf("Path to a JSON policy file. Exit with code %d if the differences found break any of its rules.", policyViolationExitCode))
 	RootCmd.AddCommand(diffCmd)
 	addSharedFlags(diffCmd)
 	output.AddFlags(diffCmd)
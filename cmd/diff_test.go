@@ -17,7 +17,10 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"testing"
+
+	"github.com/GoogleContainerTools/container-diff/util"
 )
 
 var diffArgNumTests = []testpair{
@@ -34,6 +37,100 @@ func TestDiffArgNum(t *testing.T) {
 	}
 }
 
+func TestContentDiffFlags(t *testing.T) {
+	defer func() {
+		types = nil
+		contentDiff = false
+		contentDiffLimits = util.ContentDiffLimits{}
+		contentDiffMaxFileSize, contentDiffMaxTotalSize = "", ""
+	}()
+	tests := []struct {
+		name         string
+		types        []string
+		maxFileSize  string
+		maxTotalSize string
+		shouldError  bool
+		expected     util.ContentDiffLimits
+	}{
+		{name: "file differ", types: []string{"file"}, maxFileSize: "1MB", maxTotalSize: "10MB", expected: util.ContentDiffLimits{MaxFileSize: 1 << 20, MaxTotalSize: 10 << 20}},
+		{name: "no limits", types: []string{"apt", "file"}, maxFileSize: "0", expected: util.ContentDiffLimits{}},
+		{name: "without file differ", types: []string{"apt"}, shouldError: true},
+		{name: "invalid size", types: []string{"file"}, maxFileSize: "lots", shouldError: true},
+	}
+	for _, tt := range tests {
+		types = tt.types
+		contentDiff = true
+		contentDiffLimits = util.ContentDiffLimits{}
+		contentDiffMaxFileSize, contentDiffMaxTotalSize = tt.maxFileSize, tt.maxTotalSize
+		err := checkContentDiffFlags(nil)
+		if (err != nil) != tt.shouldError {
+			t.Errorf("%s: expected error: %t, got: %v", tt.name, tt.shouldError, err)
+			continue
+		}
+		if !tt.shouldError && contentDiffLimits != tt.expected {
+			t.Errorf("%s: expected limits %+v, got %+v", tt.name, tt.expected, contentDiffLimits)
+		}
+	}
+}
+
+func TestDiffOutputFormat(t *testing.T) {
+	defer func() {
+		outputFormat, json, markdownTop, markdownMaxSize = "", false, 0, ""
+		markdownLimits = util.MarkdownLimits{}
+	}()
+	tests := []struct {
+		name        string
+		format      string
+		json        bool
+		top         int
+		maxSize     string
+		shouldError bool
+		expected    util.MarkdownLimits
+	}{
+		{name: "no format"},
+		{name: "html", format: "html"},
+		{name: "markdown", format: "markdown", top: 5, maxSize: "60KB", expected: util.MarkdownLimits{Top: 5, MaxSize: 60 << 10}},
+		{name: "markdown without limit", format: "markdown", maxSize: "0", expected: util.MarkdownLimits{}},
+		{name: "sbom", format: "spdx-json", shouldError: true},
+		{name: "json", format: "markdown", json: true, shouldError: true},
+		{name: "negative top", format: "markdown", top: -1, shouldError: true},
+		{name: "invalid size", format: "markdown", maxSize: "lots", shouldError: true},
+	}
+	for _, tt := range tests {
+		outputFormat, json, markdownTop, markdownMaxSize = tt.format, tt.json, tt.top, tt.maxSize
+		markdownLimits = util.MarkdownLimits{}
+		err := checkDiffOutputFormat(nil)
+		if (err != nil) != tt.shouldError {
+			t.Errorf("%s: expected error: %t, got: %v", tt.name, tt.shouldError, err)
+			continue
+		}
+		if !tt.shouldError && markdownLimits != tt.expected {
+			t.Errorf("%s: expected limits %+v, got %+v", tt.name, tt.expected, markdownLimits)
+		}
+	}
+}
+
+func TestCheckPolicyFailedAnalyzer(t *testing.T) {
+	diffs := map[string]util.Result{
+		"FileAnalyzer": &util.DirDiffResult{DiffType: "File"},
+	}
+	tests := []struct {
+		name        string
+		policy      *util.Policy
+		shouldError bool
+	}{
+		{name: "fail on diff", policy: util.FailOnDiffPolicy(), shouldError: true},
+		{name: "rule for the failed analyzer", policy: &util.Policy{Rules: []util.PolicyRule{{Type: "apt"}}}, shouldError: true},
+		{name: "rule for another analyzer", policy: &util.Policy{Rules: []util.PolicyRule{{Type: "file"}}}},
+	}
+	for _, tt := range tests {
+		_, err := checkPolicy(tt.policy, []string{"apt", "file"}, diffs, "")
+		if (err != nil) != tt.shouldError {
+			t.Errorf("%s: expected error: %t, got: %v", tt.name, tt.shouldError, err)
+		}
+	}
+}
+
 type imageDiff struct {
 	image1      string
 	image2      string
@@ -49,9 +146,9 @@ var imageDiffs = []imageDiff{
 
 func TestDiffImages(t *testing.T) {
 	for _, test := range imageDiffs {
-		err := diffImages(test.image1, test.image2, []string{"apt"})
+		err := diffImages(context.Background(), test.image1, test.image2, []string{"apt"})
 		checkError(t, err, test.shouldError)
-		err = diffImages(test.image1, test.image2, []string{"metadata"})
+		err = diffImages(context.Background(), test.image1, test.image2, []string{"metadata"})
 		checkError(t, err, test.shouldError)
 	}
 }
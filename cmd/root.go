@@ -17,17 +17,25 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	goflag "flag"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
+	"syscall"
+	"time"
 
+	"code.cloudfoundry.org/bytefmt"
 	"github.com/GoogleContainerTools/container-diff/differs"
+	"github.com/GoogleContainerTools/container-diff/pkg/containerdiff"
 	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
 	"github.com/GoogleContainerTools/container-diff/util"
+	"github.com/google/go-containerregistry/pkg/v1"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -38,18 +46,42 @@ import (
 var json bool
 
 var save bool
+var sortSize bool
 var types multiValueFlag
 var noCache bool
+var stream bool
+var parallelism int
+var platforms multiValueFlag
+var allPlatforms bool
 
 var outputFile string
 var forceWrite bool
 var cacheDir string
+var cacheMaxSize string
 var LogLevel string
 var format string
 var skipTsVerifyRegistries multiValueFlag
+var includePaths multiValueFlag
+var excludePaths multiValueFlag
+var ignoreFile string
+var excludePatterns []string
+var digests bool
+var vulnDB string
+var layerShareUncompressed bool
 var registriesCertificates keyValueFlag
+var timeout time.Duration
+var pluginConfig string
+var markdownTop int
+var markdownMaxSize string
+var markdownLimits util.MarkdownLimits
 
 const containerDiffEnvCacheDir = "CONTAINER_DIFF_CACHEDIR"
+const defaultPluginConfig = "plugins.json"
+const defaultCacheMaxSize = "20GB"
+
+// defaultMarkdownMaxSize keeps markdown summaries under the 65536 character
+// limit of GitHub comments
+const defaultMarkdownMaxSize = "60KB"
 
 type validatefxn func(args []string) error
 
@@ -63,7 +95,10 @@ To specify a local image, prefix the image ID with 'daemon://', e.g. 'daemon://g
 To specify a remote image, prefix the image ID with 'remote://', e.g. 'remote://gcr.io/foo/bar'.
 If no prefix is specified, the local daemon will be checked first.
 
-Tarballs can also be specified by simply providing the path to the .tar, .tar.gz, or .tgz file.`,
+Tarballs can also be specified by simply providing the path to the .tar, .tar.gz, or .tgz file.
+OCI image layout directories can be specified with the 'oci://' prefix, e.g. 'oci://path/to/layout'.
+If the layout holds several manifests, select one by digest or reference name, e.g.
+'oci://path/to/layout@sha256:...' or 'oci://path/to/layout:latest'.`,
 	PersistentPreRun: func(c *cobra.Command, s []string) {
 		ll, err := logrus.ParseLevel(LogLevel)
 		if err != nil {
@@ -71,24 +106,91 @@ Tarballs can also be specified by simply providing the path to the .tar, .tar.gz
 			os.Exit(1)
 		}
 		logrus.SetLevel(ll)
-		pkgutil.ConfigureTLS(skipTsVerifyRegistries, registriesCertificates)
 	},
 }
 
+// platformResults holds the diff/analysis results for one platform of a
+// multi-platform image.
+type platformResults struct {
+	Platform string
+	Results  map[string]util.Result
+}
+
 func outputResults(resultMap map[string]util.Result) {
-	// Outputs diff/analysis results in alphabetical order by analyzer name
-	sortedTypes := []string{}
-	for analyzerType := range resultMap {
-		sortedTypes = append(sortedTypes, analyzerType)
+	// Get the writer
+	writer, err := getWriter(outputFile)
+	if err != nil {
+		errors.Wrap(err, "getting writer for output file")
 	}
-	sort.Strings(sortedTypes)
 
-	// Get the writer
+	results := writeResults(writer, resultMap)
+	if json {
+		err := util.JSONify(writer, results)
+		if err != nil {
+			logrus.Error(err)
+		}
+	}
+}
+
+// outputPlatformResults outputs the results of each platform in turn,
+// grouped under the name of their platform.
+func outputPlatformResults(groups []platformResults) {
 	writer, err := getWriter(outputFile)
 	if err != nil {
 		errors.Wrap(err, "getting writer for output file")
 	}
 
+	jsonGroups := []interface{}{}
+	for _, group := range groups {
+		if !json {
+			fmt.Fprintf(writer, "\n=====Platform %s=====\n", group.Platform)
+		}
+		results := writeResults(writer, group.Results)
+		if json {
+			jsonGroups = append(jsonGroups, struct {
+				Platform string
+				Results  []interface{}
+			}{
+				Platform: group.Platform,
+				Results:  results,
+			})
+		}
+	}
+	if json {
+		err := util.JSONify(writer, jsonGroups)
+		if err != nil {
+			logrus.Error(err)
+		}
+	}
+}
+
+// outputReport writes the results of each group as a single report, in the
+// format selected with --output-format.
+func outputReport(title string, groups []platformResults, fileDiff *util.FileNameDiff) error {
+	report := util.Report{Title: title, Created: time.Now(), FileDiff: fileDiff}
+	for _, group := range groups {
+		report.Groups = append(report.Groups, util.ReportGroup{Platform: group.Platform, Results: group.Results})
+	}
+	writer, err := getWriter(outputFile)
+	if err != nil {
+		return errors.Wrap(err, "getting writer for output file")
+	}
+	if outputFormat == util.MarkdownFormat {
+		return util.WriteMarkdownReport(writer, report, markdownLimits)
+	}
+	return util.WriteHTMLReport(writer, report)
+}
+
+// writeResults writes the text output of each result to writer, or returns
+// their JSON output structs when --json is set.
+func writeResults(writer io.Writer, resultMap map[string]util.Result) []interface{} {
+	// Outputs diff/analysis results in alphabetical order by analyzer name
+	sortedTypes := []string{}
+	for analyzerType := range resultMap {
+		sortedTypes = append(sortedTypes, analyzerType)
+	}
+	sort.Strings(sortedTypes)
+
 	results := make([]interface{}, len(resultMap))
 	for i, analyzerType := range sortedTypes {
 		result := resultMap[analyzerType]
@@ -101,12 +203,7 @@ func outputResults(resultMap map[string]util.Result) {
 			}
 		}
 	}
-	if json {
-		err := util.JSONify(writer, results)
-		if err != nil {
-			logrus.Error(err)
-		}
-	}
+	return results
 }
 
 func validateArgs(args []string, validatefxns ...validatefxn) error {
@@ -118,6 +215,26 @@ func validateArgs(args []string, validatefxns ...validatefxn) error {
 	return nil
 }
 
+// checkPluginConfig registers the analyzer plugins listed in --plugin-config,
+// or in $HOME/.container-diff/plugins.json if it exists.
+func checkPluginConfig(_ []string) error {
+	config := pluginConfig
+	if config == "" {
+		dir, err := homedir.Dir()
+		if err != nil {
+			return nil
+		}
+		config = filepath.Join(dir, ".container-diff", defaultPluginConfig)
+		if _, err := os.Stat(config); err != nil {
+			return nil
+		}
+	}
+	if err := differs.LoadPlugins(config); err != nil {
+		return errors.Wrap(err, "loading plugins")
+	}
+	return nil
+}
+
 func checkIfValidAnalyzer(_ []string) error {
 	if len(types) == 0 {
 		types = []string{"size"}
@@ -130,31 +247,164 @@ func checkIfValidAnalyzer(_ []string) error {
 	return nil
 }
 
-func includeLayers() bool {
+// checkReportFlags checks no other output is selected along with a report,
+// and sets the limits of markdown summaries.
+func checkReportFlags() error {
+	if json {
+		return fmt.Errorf("--json can't be used with --output-format %s", outputFormat)
+	}
+	if format != "" {
+		return fmt.Errorf("--format can't be used with --output-format %s", outputFormat)
+	}
+	if markdownTop < 0 {
+		return errors.New("--markdown-top can't be negative")
+	}
+	maxSize, err := parseByteSize(markdownMaxSize)
+	if err != nil {
+		return errors.Wrap(err, "parsing --markdown-max-size")
+	}
+	markdownLimits = util.MarkdownLimits{Top: markdownTop, MaxSize: maxSize}
+	return nil
+}
+
+func checkPlatformFlags(_ []string) error {
+	if allPlatforms && len(platforms) > 0 {
+		return errors.New("please use either --platform or --all-platforms, not both")
+	}
+	for _, platform := range platforms {
+		if _, err := v1.ParsePlatform(platform); err != nil {
+			return fmt.Errorf("invalid platform %s: %s", platform, err)
+		}
+	}
+	return nil
+}
+
+func checkVulnDBFlag(_ []string) error {
 	for _, t := range types {
-		for _, a := range differs.LayerAnalyzers {
-			if t == a {
-				return true
-			}
+		if t == "vulns" && vulnDB == "" {
+			return errors.New("please set --vuln-db to a directory of OSV advisories with --type=vulns")
+		}
+	}
+	return nil
+}
+
+// checkSBOMSources restricts runs on sbom:// sources to the package
+// analyzers, which are the only ones an SBOM has data for, and runs all of
+// them by default.
+func checkSBOMSources(args []string) error {
+	var sources []string
+	for _, arg := range args {
+		if pkgutil.IsSBOM(arg) {
+			sources = append(sources, arg)
+		}
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+	if allPlatforms {
+		return errors.New("--all-platforms can't be used with sbom:// sources")
+	}
+	if filename != "" {
+		return errors.New("--filename can't be used with sbom:// sources")
+	}
+	if len(types) == 0 {
+		types = differs.PackageAnalyzers[:]
+	}
+	for _, name := range types {
+		analyzer, exists := differs.Analyzers[name]
+		if exists && !isPackageAnalyzer(analyzer) {
+			return fmt.Errorf("analyzer %s can't run on %s, only the package analyzers can: %s", name, strings.Join(sources, ", "), strings.Join(differs.PackageAnalyzers[:], ", "))
 		}
 	}
-	return false
+	return nil
 }
 
-func getImage(imageName string) (pkgutil.Image, error) {
-	var cachePath string
-	var err error
-	if !noCache {
-		cachePath, err = getCacheDir(imageName)
+// checkFilterFlags checks the patterns of the path filters of the filesystem
+// analyzers from --include, --exclude and --ignore-file. Patterns from the
+// ignore file come before the --exclude ones, so a negated --exclude can
+// re-include paths the ignore file drops.
+func checkFilterFlags(_ []string) error {
+	excludePatterns = nil
+	if ignoreFile != "" {
+		patterns, err := pkgutil.ReadIgnoreFile(ignoreFile)
 		if err != nil {
-			return pkgutil.Image{}, err
+			return errors.Wrap(err, "reading ignore file")
 		}
+		excludePatterns = append(excludePatterns, patterns...)
+	}
+	excludePatterns = append(excludePatterns, excludePaths...)
+	_, err := pkgutil.NewPathFilter(includePaths, excludePatterns)
+	return err
+}
+
+// getPlatform returns the platform selected with --platform for the i-th
+// image argument. A single --platform applies to every image.
+func getPlatform(i int) *v1.Platform {
+	if len(platforms) == 0 {
+		return nil
+	}
+	if i >= len(platforms) {
+		i = len(platforms) - 1
+	}
+	// already validated by checkPlatformFlags
+	platform, _ := v1.ParsePlatform(platforms[i])
+	return platform
+}
+
+func sortPlatforms(platforms []v1.Platform) {
+	sort.Slice(platforms, func(i, j int) bool {
+		return platforms[i].String() < platforms[j].String()
+	})
+}
+
+// tlsOptions returns the TLS options set by the flags.
+func tlsOptions() pkgutil.TLSOptions {
+	return pkgutil.TLSOptions{
+		SkipVerifyRegistries: skipTsVerifyRegistries,
+		Certificates:         registriesCertificates,
 	}
+}
 
-	return pkgutil.GetImage(imageName, includeLayers(), cachePath)
+// getOptions returns the options set by the flags for running the analyzers
+// of analyzerArgs on images of the given platforms.
+func getOptions(analyzerArgs []string, platform1, platform2 *v1.Platform) (containerdiff.Options, error) {
+	opts := containerdiff.Options{
+		Analyzers:               analyzerArgs,
+		Parallelism:             parallelism,
+		KeepFilesystems:         save,
+		Stream:                  stream,
+		Include:                 includePaths,
+		Exclude:                 excludePatterns,
+		Digests:                 digests,
+		ContentDiff:             contentDiff,
+		ContentDiffLimits:       contentDiffLimits,
+		Filename:                filename,
+		VulnDB:                  vulnDB,
+		LayerShareUncompressed:  layerShareUncompressed,
+		SortSize:                sortSize,
+		Platform:                platform1,
+		Platform2:               platform2,
+		SkipTLSVerifyRegistries: skipTsVerifyRegistries,
+		RegistryCertificates:    registriesCertificates,
+	}
+	if !noCache {
+		cachePath, err := getCacheDir()
+		if err != nil {
+			return opts, err
+		}
+		maxSize, err := getCacheMaxSize()
+		if err != nil {
+			return opts, err
+		}
+		opts.CacheDir = cachePath
+		opts.CacheMaxSize = maxSize
+	}
+	return opts, nil
 }
 
-func getCacheDir(imageName string) (string, error) {
+// getCacheDir returns the root of the extraction cache, which is shared by
+// all images.
+func getCacheDir() (string, error) {
 	// First preference for cache is set at command line
 	if cacheDir == "" {
 		// second preference is environment
@@ -170,9 +420,56 @@ func getCacheDir(imageName string) (string, error) {
 			cacheDir = dir
 		}
 	}
-	rootDir := filepath.Join(cacheDir, ".container-diff", "cache")
-	imageName = strings.Replace(imageName, string(os.PathSeparator), "", -1)
-	return filepath.Join(rootDir, pkgutil.CleanFilePath(imageName)), nil
+	return filepath.Join(cacheDir, ".container-diff", "cache"), nil
+}
+
+func getCacheMaxSize() (int64, error) {
+	size, err := parseByteSize(cacheMaxSize)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cache size %s: %s", cacheMaxSize, err)
+	}
+	return size, nil
+}
+
+// parseByteSize parses a size like 10GB, where an empty size or 0 means no
+// limit.
+func parseByteSize(size string) (int64, error) {
+	if size == "" || size == "0" {
+		return 0, nil
+	}
+	bytes, err := bytefmt.ToBytes(size)
+	if err != nil {
+		return 0, err
+	}
+	return int64(bytes), nil
+}
+
+// newContext returns the context a command runs in. It is canceled once
+// --timeout elapses, or on the first interrupt or termination signal, so the
+// image filesystems extracted so far are removed before exiting. A second
+// signal exits right away.
+func newContext() (context.Context, context.CancelFunc) {
+	ctx, cancelTimeout := context.Background(), context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			logrus.Warnf("received %s, cleaning up; send it again to exit immediately", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+	return ctx, func() {
+		cancel()
+		cancelTimeout()
+	}
 }
 
 func getWriter(outputFile string) (io.Writer, error) {
@@ -200,6 +497,7 @@ func init() {
 	RootCmd.PersistentFlags().VarP(&skipTsVerifyRegistries, "skip-tls-verify-registry", "", "Insecure registry ignoring TLS verify to push and pull. Set it repeatedly for multiple registries.")
 	registriesCertificates = make(keyValueFlag)
 	RootCmd.PersistentFlags().VarP(&registriesCertificates, "registry-certificate", "", "Use the provided certificate for TLS communication with the given registry. Expected format is 'my.registry=/path/to/the/server/certificate'.")
+	RootCmd.PersistentFlags().DurationVar(&timeout, "timeout", 0, "Give up fetching, extracting and analyzing images after this long, e.g. 5m. Zero means no timeout.")
 	pflag.CommandLine.AddGoFlagSet(goflag.CommandLine)
 }
 
@@ -252,6 +550,13 @@ func (f *keyValueFlag) Type() string {
 	return "keyValueFlag"
 }
 
+// addReportFlags adds the flags shaping the reports selected with
+// --output-format.
+func addReportFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&markdownTop, "markdown-top", 5, "Number of largest changes listed for each analyzer with --output-format markdown.")
+	cmd.Flags().StringVar(&markdownMaxSize, "markdown-max-size", defaultMarkdownMaxSize, "Leave out the detailed lists of --output-format markdown beyond this size, e.g. 1MB; 0 disables the limit.")
+}
+
 func addSharedFlags(cmd *cobra.Command) {
 	sortedTypes := []string{}
 	for analyzerType := range differs.Analyzers {
@@ -267,9 +572,21 @@ func addSharedFlags(cmd *cobra.Command) {
 			"Supported types: %s.",
 			supportedTypes))
 	cmd.Flags().BoolVarP(&save, "save", "s", false, "Set this flag to save rather than remove the final image filesystems on exit.")
-	cmd.Flags().BoolVarP(&util.SortSize, "order", "o", false, "Set this flag to sort any file/package results by descending size. Otherwise, they will be sorted by name.")
+	cmd.Flags().BoolVarP(&sortSize, "order", "o", false, "Set this flag to sort any file/package results by descending size. Otherwise, they will be sorted by name.")
 	cmd.Flags().BoolVarP(&noCache, "no-cache", "n", false, "Set this to force retrieval of image filesystem on each run.")
+	cmd.Flags().IntVar(&parallelism, "parallelism", runtime.NumCPU(), "Maximum number of analyzers to run concurrently.")
+	cmd.Flags().BoolVar(&stream, "stream", false, "Index image filesystems in memory from the streaming image tarballs instead of unpacking them to disk. Analyzers that need real files still trigger extraction.")
+	cmd.Flags().VarP(&platforms, "platform", "", "Platform of the image to use from a multi-platform image, in the form os/arch[/variant], e.g. linux/arm64.")
+	cmd.Flags().BoolVar(&allPlatforms, "all-platforms", false, "Run the analyzers once for each platform of a multi-platform image, grouping the results by platform.")
 	cmd.Flags().StringVarP(&cacheDir, "cache-dir", "c", "", "cache directory base to create .container-diff (default is $HOME).")
+	cmd.Flags().StringVar(&cacheMaxSize, "cache-max-size", defaultCacheMaxSize, "Maximum size of the cache, e.g. 10GB. Least recently used filesystems are evicted beyond it; 0 disables the limit.")
 	cmd.Flags().StringVarP(&outputFile, "output", "w", "", "output file to write to (default writes to the screen).")
+	cmd.Flags().StringVar(&vulnDB, "vuln-db", "", "Directory of OSV advisories, e.g. extracted from the OSV ecosystem dumps, for the vulns analyzer to match packages against.")
+	cmd.Flags().BoolVar(&layerShareUncompressed, "layershare-uncompressed", false, "Also report the uncompressed size of the layers with the layershare analyzer. This downloads and decompresses every layer, rather than only reading the manifests.")
 	cmd.Flags().BoolVar(&forceWrite, "force", false, "force overwrite output file, if exists already.")
+	cmd.Flags().VarP(&includePaths, "include", "", "Only analyze the paths matching this gitignore-style pattern, e.g. /usr/lib or *.so, in the file, layer, filemetadata and size analyzers. Set it repeatedly to include several.")
+	cmd.Flags().VarP(&excludePaths, "exclude", "", "Leave out the paths matching this gitignore-style pattern, e.g. /var/cache or *.pyc, from the file, layer, filemetadata and size analyzers. A leading ! re-includes paths. Set it repeatedly to exclude several.")
+	cmd.Flags().BoolVar(&digests, "digests", false, "Record the SHA-256 digest of every regular file in the results of the file and layer analyzers.")
+	cmd.Flags().StringVar(&pluginConfig, "plugin-config", "", "JSON file registering analyzer plugins, selected with --type like the built-in analyzers (default is $HOME/.container-diff/plugins.json).")
+	cmd.Flags().StringVar(&ignoreFile, "ignore-file", "", "File of gitignore-style patterns to exclude, one per line, applied before any --exclude.")
 }
@@ -18,7 +18,6 @@ package cmd
 
 import (
 	"os"
-	"path"
 	"path/filepath"
 	"reflect"
 	"testing"
@@ -41,35 +40,30 @@ func TestCacheDir(t *testing.T) {
 		cliFlag     string
 		envVar      string
 		expectedDir string
-		imageName   string
 	}{
 		{
 			name:        "default cache is at $HOME",
 			cliFlag:     "",
 			envVar:      "",
 			expectedDir: filepath.Join(homeDir, ".container-diff", "cache"),
-			imageName:   "pancakes",
 		},
 		{
 			name:        "setting cache via --cache-dir",
 			cliFlag:     "/tmp",
 			envVar:      "",
 			expectedDir: "/tmp/.container-diff/cache",
-			imageName:   "pancakes",
 		},
 		{
 			name:        "setting cache via CONTAINER_DIFF_CACHEDIR",
 			cliFlag:     "",
 			envVar:      "/tmp",
 			expectedDir: "/tmp/.container-diff/cache",
-			imageName:   "pancakes",
 		},
 		{
 			name:        "command line --cache-dir takes preference to CONTAINER_DIFF_CACHEDIR",
 			cliFlag:     "/tmp",
 			envVar:      "/opt",
 			expectedDir: "/tmp/.container-diff/cache",
-			imageName:   "pancakes",
 		},
 	}
 
@@ -83,12 +77,12 @@ func TestCacheDir(t *testing.T) {
 			cacheDir = tt.cliFlag
 
 			// call getCacheDir and make sure return is equal to expected
-			actualDir, err := getCacheDir(tt.imageName)
+			actualDir, err := getCacheDir()
 			if err != nil {
 				t.Errorf("Error getting cache dir %s: %s", tt.name, err.Error())
 			}
 
-			if path.Dir(actualDir) != tt.expectedDir {
+			if actualDir != tt.expectedDir {
 				t.Errorf("%s\nexpected: %v\ngot: %v", tt.name, tt.expectedDir, actualDir)
 			}
 		},
@@ -123,3 +117,45 @@ func Test_KeyValueArg_Set_shouldAcceptEqualAsValue(t *testing.T) {
 		t.Error("Invalid split. key=value=something should be split to key=>value=something")
 	}
 }
+
+func TestPlatformFlags(t *testing.T) {
+	defer func() {
+		platforms = nil
+		allPlatforms = false
+	}()
+	tests := []struct {
+		name        string
+		platforms   []string
+		all         bool
+		shouldError bool
+		expected    []string
+	}{
+		{name: "no platform", expected: []string{"", ""}},
+		{name: "one platform for both images", platforms: []string{"linux/arm64"}, expected: []string{"linux/arm64", "linux/arm64"}},
+		{name: "one platform per image", platforms: []string{"linux/amd64", "linux/arm/v7"}, expected: []string{"linux/amd64", "linux/arm/v7"}},
+		{name: "all platforms", all: true, expected: []string{"", ""}},
+		{name: "platform with all platforms", platforms: []string{"linux/amd64"}, all: true, shouldError: true},
+		{name: "invalid platform", platforms: []string{"linux/amd64/v3/extra"}, shouldError: true},
+	}
+	for _, tt := range tests {
+		platforms = tt.platforms
+		allPlatforms = tt.all
+		err := checkPlatformFlags(nil)
+		if (err != nil) != tt.shouldError {
+			t.Errorf("%s: expected error: %t, got: %v", tt.name, tt.shouldError, err)
+			continue
+		}
+		if tt.shouldError {
+			continue
+		}
+		for i, expected := range tt.expected {
+			actual := ""
+			if platform := getPlatform(i); platform != nil {
+				actual = platform.String()
+			}
+			if actual != expected {
+				t.Errorf("%s: expected platform %q for image %d, got %q", tt.name, expected, i+1, actual)
+			}
+		}
+	}
+}
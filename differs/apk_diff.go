@@ -0,0 +1,170 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package differs
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+	"github.com/GoogleContainerTools/container-diff/util"
+	"github.com/sirupsen/logrus"
+)
+
+// APK package database location
+const apkInstalledFile string = "lib/apk/db/installed"
+
+type ApkAnalyzer struct {
+}
+
+func (a ApkAnalyzer) Name() string {
+	return "ApkAnalyzer"
+}
+
+// ApkDiff compares the packages installed by apk.
+func (a ApkAnalyzer) Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error) {
+	diff, err := singleVersionDiff(ctx, cfg, image1, image2, a)
+	return diff, err
+}
+
+func (a ApkAnalyzer) Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error) {
+	analysis, err := singleVersionAnalysis(ctx, cfg, image, a)
+	return analysis, err
+}
+
+func (a ApkAnalyzer) getPackages(ctx context.Context, image pkgutil.Image) (map[string]util.PackageInfo, error) {
+	return readInstalledFile(image.FSPath)
+}
+
+func readInstalledFile(root string) (map[string]util.PackageInfo, error) {
+	packages := make(map[string]util.PackageInfo)
+	if _, err := os.Stat(root); err != nil {
+		// invalid image directory path
+		return packages, err
+	}
+	installedFile := filepath.Join(root, apkInstalledFile)
+	if _, err := os.Stat(installedFile); err != nil {
+		// installed file does not exist in this layer
+		return packages, nil
+	}
+	file, err := os.Open(installedFile)
+	if err != nil {
+		return packages, err
+	}
+	defer file.Close()
+
+	// packages are blocks of "K:value" lines, separated by blank lines
+	scanner := bufio.NewScanner(file)
+	var currPackage string
+	for scanner.Scan() {
+		currPackage = parseApkLine(scanner.Text(), currPackage, packages)
+	}
+	return packages, scanner.Err()
+}
+
+func parseApkLine(text string, currPackage string, packages map[string]util.PackageInfo) string {
+	if text == "" {
+		// end of the current package block
+		return ""
+	}
+	line := strings.SplitN(text, ":", 2)
+	if len(line) != 2 {
+		return currPackage
+	}
+	key := line[0]
+	value := line[1]
+
+	switch key {
+	case "P":
+		if _, ok := packages[value]; ok {
+			logrus.Warningln("Multiple versions of same package detected.  Diffing such multi-versioning not yet supported.")
+		}
+		packages[value] = util.PackageInfo{}
+		return value
+	case "V":
+		if currPackage == "" {
+			return currPackage
+		}
+		currPackageInfo := packages[currPackage]
+		currPackageInfo.Version = value
+		packages[currPackage] = currPackageInfo
+		return currPackage
+	case "I":
+		if currPackage == "" {
+			return currPackage
+		}
+		currPackageInfo := packages[currPackage]
+		// the installed size is already in bytes
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			logrus.Errorf("Could not get size for %s: %s", currPackage, err)
+			size = -1
+		}
+		currPackageInfo.Size = size
+		packages[currPackage] = currPackageInfo
+		return currPackage
+	default:
+		return currPackage
+	}
+}
+
+type ApkLayerAnalyzer struct {
+}
+
+func (a ApkLayerAnalyzer) Name() string {
+	return "ApkLayerAnalyzer"
+}
+
+// ApkDiff compares the packages installed by apk.
+func (a ApkLayerAnalyzer) Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error) {
+	diff, err := singleVersionLayerDiff(image1, image2, a)
+	return diff, err
+}
+
+func (a ApkLayerAnalyzer) Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error) {
+	analysis, err := singleVersionLayerAnalysis(ctx, cfg, image, a)
+	return analysis, err
+}
+
+func (a ApkLayerAnalyzer) getPackages(ctx context.Context, image pkgutil.Image) ([]map[string]util.PackageInfo, error) {
+	var packages []map[string]util.PackageInfo
+	if _, err := os.Stat(image.FSPath); err != nil {
+		// invalid image directory path
+		return packages, err
+	}
+	installedFile := filepath.Join(image.FSPath, apkInstalledFile)
+	if _, err := os.Stat(installedFile); err != nil {
+		// installed file does not exist in this image
+		return packages, nil
+	}
+	for _, layer := range image.Layers {
+		if err := ctx.Err(); err != nil {
+			return packages, err
+		}
+		layerPackages, err := getLayerPackages(layer, []string{apkInstalledFile}, readInstalledFile)
+		if err != nil {
+			return packages, err
+		}
+		packages = append(packages, layerPackages)
+	}
+
+	return packages, nil
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package differs
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+	"github.com/GoogleContainerTools/container-diff/util"
+)
+
+func TestParseApkLine(t *testing.T) {
+	testCases := []struct {
+		descrip     string
+		line        string
+		packages    map[string]util.PackageInfo
+		currPackage string
+		expPackage  string
+		expected    map[string]util.PackageInfo
+	}{
+		{
+			descrip:     "Not applicable line",
+			line:        "T:a description: with colons",
+			packages:    map[string]util.PackageInfo{"musl": {}},
+			currPackage: "musl",
+			expPackage:  "musl",
+			expected:    map[string]util.PackageInfo{"musl": {}},
+		},
+		{
+			descrip:     "Package line",
+			line:        "P:busybox",
+			currPackage: "musl",
+			expPackage:  "busybox",
+			packages:    map[string]util.PackageInfo{},
+			expected:    map[string]util.PackageInfo{"busybox": {}},
+		},
+		{
+			descrip:     "Version line",
+			line:        "V:1.36.1-r15",
+			packages:    map[string]util.PackageInfo{},
+			currPackage: "busybox",
+			expPackage:  "busybox",
+			expected:    map[string]util.PackageInfo{"busybox": {Version: "1.36.1-r15"}},
+		},
+		{
+			descrip:     "Installed size line",
+			line:        "I:946176",
+			packages:    map[string]util.PackageInfo{"busybox": {Version: "1.36.1-r15"}},
+			currPackage: "busybox",
+			expPackage:  "busybox",
+			expected:    map[string]util.PackageInfo{"busybox": {Version: "1.36.1-r15", Size: 946176}},
+		},
+		{
+			descrip:     "Bad installed size line",
+			line:        "I:big",
+			packages:    map[string]util.PackageInfo{"busybox": {}},
+			currPackage: "busybox",
+			expPackage:  "busybox",
+			expected:    map[string]util.PackageInfo{"busybox": {Size: -1}},
+		},
+		{
+			descrip:     "Blank line ends the package",
+			line:        "",
+			packages:    map[string]util.PackageInfo{"busybox": {}},
+			currPackage: "busybox",
+			expPackage:  "",
+			expected:    map[string]util.PackageInfo{"busybox": {}},
+		},
+		{
+			descrip:    "Version line outside a package",
+			line:       "V:1.0",
+			packages:   map[string]util.PackageInfo{},
+			expPackage: "",
+			expected:   map[string]util.PackageInfo{},
+		},
+	}
+
+	for _, test := range testCases {
+		currPackage := parseApkLine(test.line, test.currPackage, test.packages)
+		if currPackage != test.expPackage {
+			t.Errorf("%s: Expected current package to be: %s, but got: %s.", test.descrip, test.expPackage, currPackage)
+		}
+		if !reflect.DeepEqual(test.packages, test.expected) {
+			t.Errorf("%s: Expected: %#v but got: %#v", test.descrip, test.expected, test.packages)
+		}
+	}
+}
+
+func TestGetApkPackages(t *testing.T) {
+	testCases := []struct {
+		descrip  string
+		path     string
+		expected map[string]util.PackageInfo
+		err      bool
+	}{
+		{
+			descrip:  "no directory",
+			path:     "testDirs/notThere",
+			expected: map[string]util.PackageInfo{},
+			err:      true,
+		},
+		{
+			descrip:  "no packages",
+			path:     "testDirs/noPackages",
+			expected: map[string]util.PackageInfo{},
+		},
+		{
+			descrip: "packages in expected location",
+			path:    "testDirs/packageApk",
+			expected: map[string]util.PackageInfo{
+				"musl":                   {Version: "1.2.4-r2", Size: 622592},
+				"busybox":                {Version: "1.36.1-r15", Size: 946176},
+				"ca-certificates-bundle": {Version: "20230506-r0", Size: 233472}},
+		},
+	}
+	for _, test := range testCases {
+		d := ApkAnalyzer{}
+		image := pkgutil.Image{FSPath: test.path}
+		packages, err := d.getPackages(context.Background(), image)
+		if err != nil && !test.err {
+			t.Errorf("Got unexpected error: %s", err)
+		}
+		if err == nil && test.err {
+			t.Errorf("Expected error but got none.")
+		}
+		if !reflect.DeepEqual(packages, test.expected) {
+			t.Errorf("Expected: %v but got: %v", test.expected, packages)
+		}
+	}
+}
@@ -18,6 +18,7 @@ package differs
 
 import (
 	"bufio"
+	"context"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -39,17 +40,17 @@ func (a AptAnalyzer) Name() string {
 }
 
 // AptDiff compares the packages installed by apt-get.
-func (a AptAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
-	diff, err := singleVersionDiff(image1, image2, a)
+func (a AptAnalyzer) Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error) {
+	diff, err := singleVersionDiff(ctx, cfg, image1, image2, a)
 	return diff, err
 }
 
-func (a AptAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
-	analysis, err := singleVersionAnalysis(image, a)
+func (a AptAnalyzer) Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error) {
+	analysis, err := singleVersionAnalysis(ctx, cfg, image, a)
 	return analysis, err
 }
 
-func (a AptAnalyzer) getPackages(image pkgutil.Image) (map[string]util.PackageInfo, error) {
+func (a AptAnalyzer) getPackages(ctx context.Context, image pkgutil.Image) (map[string]util.PackageInfo, error) {
 	return readStatusFile(image.FSPath)
 }
 
@@ -95,12 +96,11 @@ func parseLine(text string, currPackage string, packages map[string]util.Package
 				logrus.Warningln("Multiple versions of same package detected.  Diffing such multi-versioning not yet supported.")
 				return currPackage
 			}
-			modifiedValue := strings.Replace(value, "+", " ", 1)
 			currPackageInfo, ok := packages[currPackage]
 			if !ok {
 				currPackageInfo = util.PackageInfo{}
 			}
-			currPackageInfo.Version = modifiedValue
+			currPackageInfo.Version = value
 			packages[currPackage] = currPackageInfo
 			return currPackage
 
@@ -135,17 +135,17 @@ func (a AptLayerAnalyzer) Name() string {
 }
 
 // AptDiff compares the packages installed by apt-get.
-func (a AptLayerAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
+func (a AptLayerAnalyzer) Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error) {
 	diff, err := singleVersionLayerDiff(image1, image2, a)
 	return diff, err
 }
 
-func (a AptLayerAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
-	analysis, err := singleVersionLayerAnalysis(image, a)
+func (a AptLayerAnalyzer) Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error) {
+	analysis, err := singleVersionLayerAnalysis(ctx, cfg, image, a)
 	return analysis, err
 }
 
-func (a AptLayerAnalyzer) getPackages(image pkgutil.Image) ([]map[string]util.PackageInfo, error) {
+func (a AptLayerAnalyzer) getPackages(ctx context.Context, image pkgutil.Image) ([]map[string]util.PackageInfo, error) {
 	var packages []map[string]util.PackageInfo
 	if _, err := os.Stat(image.FSPath); err != nil {
 		// invalid image directory path
@@ -157,7 +157,10 @@ func (a AptLayerAnalyzer) getPackages(image pkgutil.Image) ([]map[string]util.Pa
 		return packages, nil
 	}
 	for _, layer := range image.Layers {
-		layerPackages, err := readStatusFile(layer.FSPath)
+		if err := ctx.Err(); err != nil {
+			return packages, err
+		}
+		layerPackages, err := getLayerPackages(layer, []string{dpkgStatusFile}, readStatusFile)
 		if err != nil {
 			return packages, err
 		}
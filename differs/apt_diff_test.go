@@ -17,6 +17,7 @@ limitations under the License.
 package differs
 
 import (
+	"context"
 	"reflect"
 	"testing"
 
@@ -62,7 +63,7 @@ func TestParseLine(t *testing.T) {
 			packages:    map[string]util.PackageInfo{},
 			currPackage: "La-Croix",
 			expPackage:  "La-Croix",
-			expected:    map[string]util.PackageInfo{"La-Croix": {Version: "Lime extra_lime"}},
+			expected:    map[string]util.PackageInfo{"La-Croix": {Version: "Lime+extra_lime"}},
 		},
 		{
 			descrip:     "Size line",
@@ -123,7 +124,7 @@ func TestGetAptPackages(t *testing.T) {
 	for _, test := range testCases {
 		d := AptAnalyzer{}
 		image := pkgutil.Image{FSPath: test.path}
-		packages, err := d.getPackages(image)
+		packages, err := d.getPackages(context.Background(), image)
 		if err != nil && !test.err {
 			t.Errorf("Got unexpected error: %s", err)
 		}
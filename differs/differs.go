@@ -17,7 +17,10 @@ limitations under the License.
 package differs
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
 	"github.com/GoogleContainerTools/container-diff/util"
@@ -34,65 +37,133 @@ const sizeAnalyzer = "size"
 const sizeLayerAnalyzer = "sizelayer"
 const aptAnalyzer = "apt"
 const aptLayerAnalyzer = "aptlayer"
+const apkAnalyzer = "apk"
+const apkLayerAnalyzer = "apklayer"
 const rpmAnalyzer = "rpm"
 const rpmLayerAnalyzer = "rpmlayer"
 const pipAnalyzer = "pip"
 const nodeAnalyzer = "node"
 const emergeAnalyzer = "emerge"
+const goModAnalyzer = "gomod"
+const layerShareAnalyzer = "layershare"
+const vulnAnalyzer = "vulns"
+
+// Config holds the settings the analyzers run with. It is passed to every
+// Diff and Analyze call, so that runs with other settings don't interfere.
+type Config struct {
+	// FSOptions selects the paths the filesystem analyzers look at, and
+	// whether they record the digests of files
+	pkgutil.FSOptions
+	// ContentDiff makes the file differ diff the contents of the files
+	// modified between two images, within ContentDiffLimits
+	ContentDiff       bool
+	ContentDiffLimits util.ContentDiffLimits
+	// VulnDBDir is the directory of OSV advisories the vulns analyzer
+	// matches packages against
+	VulnDBDir string
+	// LayerShareUncompressed makes the layershare analyzer count the
+	// uncompressed size of the layers too, which downloads every layer
+	LayerShareUncompressed bool
+	// SortSize sorts the files and packages of the results by descending
+	// size rather than by name
+	SortSize bool
+}
 
 type DiffRequest struct {
 	Image1    pkgutil.Image
 	Image2    pkgutil.Image
 	DiffTypes []Analyzer
+	// Config is the configuration the differs run with
+	Config Config
+	// Parallelism is the maximum number of differs run at once. Values
+	// below 1 run the differs one at a time.
+	Parallelism int
 }
 
 type SingleRequest struct {
 	Image        pkgutil.Image
 	AnalyzeTypes []Analyzer
+	// Config is the configuration the analyzers run with
+	Config Config
+	// Parallelism is the maximum number of analyzers run at once. Values
+	// below 1 run the analyzers one at a time.
+	Parallelism int
 }
 
+// Analyzer diffs or analyzes the filesystems of images with the settings of
+// cfg. Diff and Analyze stop their work and return the error of ctx once it
+// is done.
 type Analyzer interface {
-	Diff(image1, image2 pkgutil.Image) (util.Result, error)
-	Analyze(image pkgutil.Image) (util.Result, error)
+	Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error)
+	Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error)
 	Name() string
 }
 
 var Analyzers = map[string]Analyzer{
-	historyAnalyzer:   HistoryAnalyzer{},
-	metadataAnalyzer:  MetadataAnalyzer{},
-	fileAnalyzer:      FileAnalyzer{},
-	layerAnalyzer:     FileLayerAnalyzer{},
-	fileMetaAnalyzer:  FileMetaAnalyzer{},
-	MetaLayerAnalyzer: FileMetaLayerAnalyzer{},
-	sizeAnalyzer:      SizeAnalyzer{},
-	sizeLayerAnalyzer: SizeLayerAnalyzer{},
-	aptAnalyzer:       AptAnalyzer{},
-	aptLayerAnalyzer:  AptLayerAnalyzer{},
-	rpmAnalyzer:       RPMAnalyzer{},
-	rpmLayerAnalyzer:  RPMLayerAnalyzer{},
-	pipAnalyzer:       PipAnalyzer{},
-	nodeAnalyzer:      NodeAnalyzer{},
-	emergeAnalyzer:    EmergeAnalyzer{},
+	historyAnalyzer:    HistoryAnalyzer{},
+	metadataAnalyzer:   MetadataAnalyzer{},
+	fileAnalyzer:       FileAnalyzer{},
+	layerAnalyzer:      FileLayerAnalyzer{},
+	fileMetaAnalyzer:   FileMetaAnalyzer{},
+	MetaLayerAnalyzer:  FileMetaLayerAnalyzer{},
+	sizeAnalyzer:       SizeAnalyzer{},
+	sizeLayerAnalyzer:  SizeLayerAnalyzer{},
+	aptAnalyzer:        AptAnalyzer{},
+	aptLayerAnalyzer:   AptLayerAnalyzer{},
+	apkAnalyzer:        ApkAnalyzer{},
+	apkLayerAnalyzer:   ApkLayerAnalyzer{},
+	rpmAnalyzer:        RPMAnalyzer{},
+	rpmLayerAnalyzer:   RPMLayerAnalyzer{},
+	pipAnalyzer:        PipAnalyzer{},
+	nodeAnalyzer:       NodeAnalyzer{},
+	emergeAnalyzer:     EmergeAnalyzer{},
+	goModAnalyzer:      GoModAnalyzer{},
+	layerShareAnalyzer: LayerShareAnalyzer{},
+	vulnAnalyzer:       VulnAnalyzer{},
 }
 
-var LayerAnalyzers = [...]string{layerAnalyzer, MetaLayerAnalyzer, sizeLayerAnalyzer, aptLayerAnalyzer, rpmLayerAnalyzer}
+var LayerAnalyzers = []string{layerAnalyzer, MetaLayerAnalyzer, sizeLayerAnalyzer, aptLayerAnalyzer, apkLayerAnalyzer, rpmLayerAnalyzer}
+
+// StreamingAnalyzers can run on the in-memory filesystem indexes of an
+// image, all other analyzers need its filesystems unpacked to disk.
+var StreamingAnalyzers = [...]string{historyAnalyzer, metadataAnalyzer, fileAnalyzer, layerAnalyzer, fileMetaAnalyzer, MetaLayerAnalyzer, sizeAnalyzer, sizeLayerAnalyzer, layerShareAnalyzer}
+
+// PackageAnalyzers list the packages installed in an image, with their
+// versions, sizes and, for multi version analyzers, installation paths.
+var PackageAnalyzers = [...]string{aptAnalyzer, apkAnalyzer, rpmAnalyzer, pipAnalyzer, nodeAnalyzer, emergeAnalyzer, goModAnalyzer}
+
+// ManifestAnalyzers only read the manifest, config and layer blobs of an
+// image, and need no filesystem unpacked or indexed.
+var ManifestAnalyzers = [...]string{historyAnalyzer, metadataAnalyzer, layerShareAnalyzer}
 
 func (req DiffRequest) GetDiff() (map[string]util.Result, error) {
+	return req.GetDiffContext(context.Background())
+}
+
+// GetDiffContext runs the differs like GetDiff, and stops them once ctx is
+// done.
+func (req DiffRequest) GetDiffContext(ctx context.Context) (map[string]util.Result, error) {
 	img1 := req.Image1
 	img2 := req.Image2
 	diffs := req.DiffTypes
 
+	diffResults, errs := runAnalyzers(ctx, diffs, req.Parallelism, func(ctx context.Context, differ Analyzer) (util.Result, error) {
+		return differ.Diff(ctx, req.Config, img1, img2)
+	})
+
 	results := map[string]util.Result{}
-	for _, differ := range diffs {
-		if diff, err := differ.Diff(img1, img2); err == nil {
-			results[differ.Name()] = diff
+	for i, differ := range diffs {
+		if errs[i] == nil {
+			results[differ.Name()] = diffResults[i]
 		} else {
-			logrus.Errorf("error getting diff with %s: %s", differ.Name(), err)
+			logrus.Errorf("error getting diff with %s: %s", differ.Name(), errs[i])
 		}
 	}
 
 	var err error
-	if len(results) == 0 {
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	} else if len(results) == 0 {
 		err = fmt.Errorf("could not perform diff on %v and %v", img1, img2)
 	} else {
 		err = nil
@@ -102,21 +173,33 @@ func (req DiffRequest) GetDiff() (map[string]util.Result, error) {
 }
 
 func (req SingleRequest) GetAnalysis() (map[string]util.Result, error) {
+	return req.GetAnalysisContext(context.Background())
+}
+
+// GetAnalysisContext runs the analyzers like GetAnalysis, and stops them
+// once ctx is done.
+func (req SingleRequest) GetAnalysisContext(ctx context.Context) (map[string]util.Result, error) {
 	img := req.Image
 	analyses := req.AnalyzeTypes
 
+	analysisResults, errs := runAnalyzers(ctx, analyses, req.Parallelism, func(ctx context.Context, analyzer Analyzer) (util.Result, error) {
+		return analyzer.Analyze(ctx, req.Config, img)
+	})
+
 	results := map[string]util.Result{}
-	for _, analyzer := range analyses {
+	for i, analyzer := range analyses {
 		analyzeName := analyzer.Name()
-		if analysis, err := analyzer.Analyze(img); err == nil {
-			results[analyzeName] = analysis
+		if errs[i] == nil {
+			results[analyzeName] = analysisResults[i]
 		} else {
-			logrus.Errorf("error getting analysis with %s: %s", analyzeName, err)
+			logrus.Errorf("error getting analysis with %s: %s", analyzeName, errs[i])
 		}
 	}
 
 	var err error
-	if len(results) == 0 {
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	} else if len(results) == 0 {
 		err = fmt.Errorf("could not perform analysis on %v", img)
 	} else {
 		err = nil
@@ -125,6 +208,52 @@ func (req SingleRequest) GetAnalysis() (map[string]util.Result, error) {
 	return results, err
 }
 
+// runAnalyzers calls run for every analyzer on a pool of at most parallelism
+// workers. The results and errors are returned in the order of analyzers,
+// regardless of the order in which the analyzers finish. Once ctx is done,
+// the analyzers not yet started are skipped, failing with the error of ctx,
+// and runAnalyzers waits for the running ones to stop, so that the images
+// can be cleaned up once it returns.
+func runAnalyzers(ctx context.Context, analyzers []Analyzer, parallelism int, run func(context.Context, Analyzer) (util.Result, error)) ([]util.Result, []error) {
+	results := make([]util.Result, len(analyzers))
+	errs := make([]error, len(analyzers))
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	if parallelism > len(analyzers) {
+		parallelism = len(analyzers)
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(parallelism)
+	for w := 0; w < parallelism; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := ctx.Err(); err != nil {
+					errs[i] = err
+					continue
+				}
+				start := time.Now()
+				results[i], errs[i] = run(ctx, analyzers[i])
+				if ctx.Err() != nil {
+					// results cut short by ctx are dropped
+					results[i], errs[i] = nil, ctx.Err()
+				}
+				elapsed := time.Now().Sub(start)
+				logrus.Infof("%s took %f seconds", analyzers[i].Name(), elapsed.Seconds())
+			}
+		}()
+	}
+	for i := range analyzers {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results, errs
+}
+
 func GetAnalyzers(analyzeNames []string) ([]Analyzer, error) {
 	var analyzeFuncs []Analyzer
 	for _, name := range analyzeNames {
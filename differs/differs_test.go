@@ -17,8 +17,16 @@ limitations under the License.
 package differs
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+	"github.com/GoogleContainerTools/container-diff/util"
 )
 
 func TestGetAnalyzers(t *testing.T) {
@@ -69,3 +77,116 @@ func TestGetAnalyzers(t *testing.T) {
 		})
 	}
 }
+
+// sleepAnalyzer finishes after a delay, so that analyzers started first
+// don't finish first, or once ctx is done
+type sleepAnalyzer struct {
+	name    string
+	delay   time.Duration
+	fail    bool
+	running *int32
+	maxSeen *int32
+}
+
+func (a sleepAnalyzer) Name() string {
+	return a.name
+}
+
+func (a sleepAnalyzer) Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error) {
+	return a.Analyze(ctx, cfg, image1)
+}
+
+func (a sleepAnalyzer) Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error) {
+	running := atomic.AddInt32(a.running, 1)
+	defer atomic.AddInt32(a.running, -1)
+	for {
+		seen := atomic.LoadInt32(a.maxSeen)
+		if running <= seen || atomic.CompareAndSwapInt32(a.maxSeen, seen, running) {
+			break
+		}
+	}
+	select {
+	case <-time.After(a.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if a.fail {
+		return nil, errors.New("analyzer failed")
+	}
+	return &util.ListAnalyzeResult{AnalyzeType: a.name}, nil
+}
+
+func TestGetAnalysisParallelism(t *testing.T) {
+	for _, parallelism := range []int{0, 1, 2, 8} {
+		t.Run(fmt.Sprintf("parallelism %d", parallelism), func(t *testing.T) {
+			var running, maxSeen int32
+			var analyzers []Analyzer
+			for i := 0; i < 6; i++ {
+				analyzers = append(analyzers, sleepAnalyzer{
+					name:    fmt.Sprintf("Analyzer%d", i),
+					delay:   time.Duration(6-i) * time.Millisecond,
+					fail:    i == 3,
+					running: &running,
+					maxSeen: &maxSeen,
+				})
+			}
+			req := SingleRequest{AnalyzeTypes: analyzers, Parallelism: parallelism}
+			results, err := req.GetAnalysis()
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+			if len(results) != 5 {
+				t.Errorf("Expected 5 results but got %d", len(results))
+			}
+			if _, ok := results["Analyzer3"]; ok {
+				t.Errorf("Expected no result for the failing analyzer")
+			}
+			for name, result := range results {
+				if result.(*util.ListAnalyzeResult).AnalyzeType != name {
+					t.Errorf("Result of %s stored under the wrong analyzer", name)
+				}
+			}
+
+			limit := int32(parallelism)
+			if limit < 1 {
+				limit = 1
+			}
+			if maxSeen > limit {
+				t.Errorf("Expected at most %d concurrent analyzers but saw %d", limit, maxSeen)
+			}
+		})
+	}
+}
+
+func TestGetAnalysisTimeout(t *testing.T) {
+	var running, maxSeen int32
+	analyzers := []Analyzer{
+		sleepAnalyzer{name: "Fast", running: &running, maxSeen: &maxSeen},
+		sleepAnalyzer{name: "Slow", delay: time.Second, running: &running, maxSeen: &maxSeen},
+		sleepAnalyzer{name: "Queued", running: &running, maxSeen: &maxSeen},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	req := SingleRequest{AnalyzeTypes: analyzers, Parallelism: 1}
+	results, err := req.GetAnalysisContext(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	// the slow analyzer is stopped at the deadline, and has returned
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("Expected analysis to stop at the deadline, took %s", elapsed)
+	}
+	if n := atomic.LoadInt32(&running); n != 0 {
+		t.Errorf("Expected no analyzer left running, got %d", n)
+	}
+	if _, ok := results["Fast"]; !ok {
+		t.Errorf("Expected a result for the analyzer done before the deadline")
+	}
+	for _, name := range []string{"Slow", "Queued"} {
+		if _, ok := results[name]; ok {
+			t.Errorf("Expected no result for %s", name)
+		}
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package differs
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -38,17 +39,17 @@ func (em EmergeAnalyzer) Name() string {
 }
 
 // Diff compares the packages installed by emerge.
-func (em EmergeAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
-	diff, err := singleVersionDiff(image1, image2, em)
+func (em EmergeAnalyzer) Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error) {
+	diff, err := singleVersionDiff(ctx, cfg, image1, image2, em)
 	return diff, err
 }
 
-func (em EmergeAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
-	analysis, err := singleVersionAnalysis(image, em)
+func (em EmergeAnalyzer) Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error) {
+	analysis, err := singleVersionAnalysis(ctx, cfg, image, em)
 	return analysis, err
 }
 
-func (em EmergeAnalyzer) getPackages(image pkgutil.Image) (map[string]util.PackageInfo, error) {
+func (em EmergeAnalyzer) getPackages(ctx context.Context, image pkgutil.Image) (map[string]util.PackageInfo, error) {
 	var path string
 	if image.FSPath == "" {
 		path = emergePkgFile
@@ -72,6 +73,9 @@ func (em EmergeAnalyzer) getPackages(image pkgutil.Image) (map[string]util.Packa
 	// for i := 0; i < len(contents); i++ {
 	for _, c := range contents {
 		// c := contents[i]
+		if err := ctx.Err(); err != nil {
+			return packages, err
+		}
 		pkgPrefix := c.Name()
 		pkgContents, err := ioutil.ReadDir(filepath.Join(path, pkgPrefix))
 		if err != nil {
@@ -17,6 +17,7 @@ limitations under the License.
 package differs
 
 import (
+	"context"
 	"reflect"
 	"testing"
 
@@ -54,7 +55,7 @@ func TestGetEmergePackages(t *testing.T) {
 	for _, test := range testCases {
 		d := EmergeAnalyzer{}
 		image := pkgutil.Image{FSPath: test.path}
-		packages, err := d.getPackages(image)
+		packages, err := d.getPackages(context.Background(), image)
 		if err != nil && !test.err {
 			t.Errorf("Got unexpected error: %s", err)
 		}
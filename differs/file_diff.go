@@ -17,6 +17,8 @@ limitations under the License.
 package differs
 
 import (
+	"context"
+
 	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
 	"github.com/GoogleContainerTools/container-diff/util"
 	"github.com/sirupsen/logrus"
@@ -29,45 +31,70 @@ func (a FileAnalyzer) Name() string {
 	return "FileAnalyzer"
 }
 
-// FileDiff diffs two packages and compares their contents
-func (a FileAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
-	diff, err := diffImageFiles(image1.FSPath, image2.FSPath)
+// Diff diffs the files of two images, and their contents if cfg.ContentDiff
+// is set. It stops walking, hashing and diffing the files once ctx is done.
+func (a FileAnalyzer) Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error) {
+	var diff util.DirDiff
+	var err error
+	if image1.Index != nil && image2.Index != nil {
+		diff, _ = util.DiffIndex(image1.Index, image2.Index, cfg.Digests)
+	} else {
+		diff, err = diffImageFiles(ctx, cfg, image1.FSPath, image2.FSPath)
+	}
+	if err == nil && cfg.ContentDiff {
+		if err = ctx.Err(); err != nil {
+			return &util.DirDiffResult{}, err
+		}
+		util.AddContentDiffs(&diff, image1.FSPath, image2.FSPath, image1.Source+":", image2.Source+":", cfg.ContentDiffLimits)
+	}
 	return &util.DirDiffResult{
 		Image1:   image1.Source,
 		Image2:   image2.Source,
 		DiffType: "File",
 		Diff:     diff,
+		SortSize: cfg.SortSize,
 	}, err
 }
 
-func (a FileAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
+// Analyze lists the files of an image, stopping the walk and hashing of its
+// filesystem once ctx is done.
+func (a FileAnalyzer) Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error) {
 	var result util.FileAnalyzeResult
+	result.Image = image.Source
+	result.AnalyzeType = "File"
+	result.SortSize = cfg.SortSize
+
+	if image.Index != nil {
+		result.Analysis = image.Index.DirectoryEntries(cfg.Digests)
+		return &result, nil
+	}
 
-	imgDir, err := pkgutil.GetDirectory(image.FSPath, true)
+	imgDir, err := pkgutil.GetDirectoryContext(ctx, image.FSPath, true, cfg.Filter)
 	if err != nil {
 		return result, err
 	}
 
-	result.Image = image.Source
-	result.AnalyzeType = "File"
-	result.Analysis = pkgutil.GetDirectoryEntries(imgDir)
-	return &result, err
+	result.Analysis, err = pkgutil.GetDirectoryEntriesContext(ctx, imgDir, cfg.FSOptions)
+	if err != nil {
+		return result, err
+	}
+	return &result, nil
 }
 
-func diffImageFiles(img1, img2 string) (util.DirDiff, error) {
+func diffImageFiles(ctx context.Context, cfg Config, img1, img2 string) (util.DirDiff, error) {
 	var diff util.DirDiff
 
-	img1Dir, err := pkgutil.GetDirectory(img1, true)
+	img1Dir, err := pkgutil.GetDirectoryContext(ctx, img1, true, cfg.Filter)
 	if err != nil {
 		return diff, err
 	}
-	img2Dir, err := pkgutil.GetDirectory(img2, true)
+	img2Dir, err := pkgutil.GetDirectoryContext(ctx, img2, true, cfg.Filter)
 	if err != nil {
 		return diff, err
 	}
 
-	diff, _ = util.DiffDirectory(img1Dir, img2Dir)
-	return diff, nil
+	diff, _, err = util.DiffDirectoryContext(ctx, img1Dir, img2Dir, cfg.FSOptions)
+	return diff, err
 }
 
 type FileLayerAnalyzer struct {
@@ -77,8 +104,9 @@ func (a FileLayerAnalyzer) Name() string {
 	return "FileLayerAnalyzer"
 }
 
-// FileDiff diffs two packages and compares their contents
-func (a FileLayerAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
+// Diff diffs the files of each layer, stopping between layers once ctx is
+// done.
+func (a FileLayerAnalyzer) Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error) {
 	var dirDiffs []util.DirDiff
 
 	// Go through each layer of the first image...
@@ -86,9 +114,17 @@ func (a FileLayerAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, erro
 		if index >= len(image2.Layers) {
 			continue
 		}
+		if err := ctx.Err(); err != nil {
+			return &util.MultipleDirDiffResult{}, err
+		}
 		// ...else, diff as usual
 		layer2 := image2.Layers[index]
-		diff, err := diffImageFiles(layer.FSPath, layer2.FSPath)
+		if layer.Index != nil && layer2.Index != nil {
+			diff, _ := util.DiffIndex(layer.Index, layer2.Index, cfg.Digests)
+			dirDiffs = append(dirDiffs, diff)
+			continue
+		}
+		diff, err := diffImageFiles(ctx, cfg, layer.FSPath, layer2.FSPath)
 		if err != nil {
 			return &util.MultipleDirDiffResult{}, err
 		}
@@ -110,22 +146,41 @@ func (a FileLayerAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, erro
 		Diff: util.MultipleDirDiff{
 			DirDiffs: dirDiffs,
 		},
+		SortSize: cfg.SortSize,
 	}, nil
 }
 
-func (a FileLayerAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
-	var directoryEntries [][]pkgutil.DirectoryEntry
+// Analyze lists the files of each layer, stopping between layers once ctx
+// is done.
+func (a FileLayerAnalyzer) Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error) {
+	var layerAnalyses []util.FileLayerAnalysis
 	for _, layer := range image.Layers {
-		layerDir, err := pkgutil.GetDirectory(layer.FSPath, true)
-		if err != nil {
+		if err := ctx.Err(); err != nil {
 			return util.FileLayerAnalyzeResult{}, err
 		}
-		directoryEntries = append(directoryEntries, pkgutil.GetDirectoryEntries(layerDir))
+		var entries []pkgutil.DirectoryEntry
+		if layer.Index != nil {
+			entries = layer.Index.DirectoryEntries(cfg.Digests)
+		} else {
+			layerDir, err := pkgutil.GetDirectoryContext(ctx, layer.FSPath, true, cfg.Filter)
+			if err != nil {
+				return util.FileLayerAnalyzeResult{}, err
+			}
+			entries, err = pkgutil.GetDirectoryEntriesContext(ctx, layerDir, cfg.FSOptions)
+			if err != nil {
+				return util.FileLayerAnalyzeResult{}, err
+			}
+		}
+		layerAnalyses = append(layerAnalyses, util.FileLayerAnalysis{
+			Entries:   entries,
+			Deletions: pkgutil.FilterWhiteouts(layer.Whiteouts, cfg.Filter),
+		})
 	}
 
 	return &util.FileLayerAnalyzeResult{
 		Image:       image.Source,
 		AnalyzeType: "FileLayer",
-		Analysis:    directoryEntries,
+		Analysis:    layerAnalyses,
+		SortSize:    cfg.SortSize,
 	}, nil
 }
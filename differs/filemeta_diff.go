@@ -17,6 +17,7 @@ limitations under the License.
 package differs
 
 import (
+	"context"
 	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
 	"github.com/GoogleContainerTools/container-diff/util"
 	"github.com/sirupsen/logrus"
@@ -30,8 +31,14 @@ func (a FileMetaAnalyzer) Name() string {
 }
 
 // FileDiff diffs two packages and compares their contents
-func (a FileMetaAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
-	diff, err := diffImageFileMetadata(image1.FSPath, image2.FSPath)
+func (a FileMetaAnalyzer) Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error) {
+	var diff util.MetaDirDiff
+	var err error
+	if image1.Index != nil && image2.Index != nil {
+		diff, _ = util.DiffIndexMetadata(image1.Index, image2.Index)
+	} else {
+		diff, err = diffImageFileMetadata(ctx, cfg, image1.FSPath, image2.FSPath)
+	}
 	return &util.MetaDirDiffResult{
 		Image1:   image1.Source,
 		Image2:   image2.Source,
@@ -40,10 +47,17 @@ func (a FileMetaAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error
 	}, err
 }
 
-func (a FileMetaAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
+func (a FileMetaAnalyzer) Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error) {
 	var result util.FileMetaAnalyzeResult
+	result.Image = image.Source
+	result.AnalyzeType = "FileMeta"
 
-	imgDir, err := pkgutil.GetDirectory(image.FSPath, true)
+	if image.Index != nil {
+		result.Analysis = image.Index.DirectoryMetaEntries()
+		return &result, nil
+	}
+
+	imgDir, err := pkgutil.GetDirectoryContext(ctx, image.FSPath, true, cfg.Filter)
 	if err != nil {
 		return result, err
 	}
@@ -52,20 +66,18 @@ func (a FileMetaAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
 		return result, err
 	}
 
-	result.Image = image.Source
-	result.AnalyzeType = "FileMeta"
 	result.Analysis = entries
 	return &result, err
 }
 
-func diffImageFileMetadata(img1, img2 string) (util.MetaDirDiff, error) {
+func diffImageFileMetadata(ctx context.Context, cfg Config, img1, img2 string) (util.MetaDirDiff, error) {
 	var diff util.MetaDirDiff
 
-	img1Dir, err := pkgutil.GetDirectory(img1, true)
+	img1Dir, err := pkgutil.GetDirectoryContext(ctx, img1, true, cfg.Filter)
 	if err != nil {
 		return util.MetaDirDiff{}, err
 	}
-	img2Dir, err := pkgutil.GetDirectory(img2, true)
+	img2Dir, err := pkgutil.GetDirectoryContext(ctx, img2, true, cfg.Filter)
 	if err != nil {
 		return util.MetaDirDiff{}, err
 	}
@@ -86,7 +98,7 @@ func (a FileMetaLayerAnalyzer) Name() string {
 }
 
 // FileDiff diffs two packages and compares their contents
-func (a FileMetaLayerAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
+func (a FileMetaLayerAnalyzer) Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error) {
 	var dirDiffs []util.MetaDirDiff
 
 	// Go through each layer of the first image...
@@ -94,9 +106,17 @@ func (a FileMetaLayerAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result,
 		if index >= len(image2.Layers) {
 			continue
 		}
+		if err := ctx.Err(); err != nil {
+			return &util.MultipleMetaDirDiffResult{}, err
+		}
 		// ...else, diff as usual
 		layer2 := image2.Layers[index]
-		diff, err := diffImageFileMetadata(layer.FSPath, layer2.FSPath)
+		if layer.Index != nil && layer2.Index != nil {
+			diff, _ := util.DiffIndexMetadata(layer.Index, layer2.Index)
+			dirDiffs = append(dirDiffs, diff)
+			continue
+		}
+		diff, err := diffImageFileMetadata(ctx, cfg, layer.FSPath, layer2.FSPath)
 		if err != nil {
 			return &util.MultipleDirDiffResult{}, err
 		}
@@ -121,10 +141,17 @@ func (a FileMetaLayerAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result,
 	}, nil
 }
 
-func (a FileMetaLayerAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
+func (a FileMetaLayerAnalyzer) Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error) {
 	var directoryEntries [][]pkgutil.DirectoryMetaEntry
 	for _, layer := range image.Layers {
-		layerDir, err := pkgutil.GetDirectory(layer.FSPath, true)
+		if err := ctx.Err(); err != nil {
+			return util.FileMetaLayerAnalyzeResult{}, err
+		}
+		if layer.Index != nil {
+			directoryEntries = append(directoryEntries, layer.Index.DirectoryMetaEntries())
+			continue
+		}
+		layerDir, err := pkgutil.GetDirectoryContext(ctx, layer.FSPath, true, cfg.Filter)
 		if err != nil {
 			return util.FileMetaLayerAnalyzeResult{}, err
 		}
@@ -0,0 +1,143 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package differs
+
+import (
+	"bytes"
+	"context"
+	"debug/buildinfo"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+	"github.com/GoogleContainerTools/container-diff/util"
+	"github.com/sirupsen/logrus"
+)
+
+// goStdlibPackage is the package name the Go toolchain version of a binary
+// is reported under
+const goStdlibPackage = "stdlib"
+
+var elfMagic = []byte("\x7fELF")
+
+type GoModAnalyzer struct {
+}
+
+func (a GoModAnalyzer) Name() string {
+	return "GoModAnalyzer"
+}
+
+// GoModDiff compares the Go modules built into the binaries of two images.
+func (a GoModAnalyzer) Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error) {
+	diff, err := multiVersionDiff(ctx, cfg, image1, image2, a)
+	return diff, err
+}
+
+func (a GoModAnalyzer) Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error) {
+	analysis, err := multiVersionAnalysis(ctx, cfg, image, a)
+	return analysis, err
+}
+
+// getPackages reads the build info of every Go executable in the image. The
+// main module, each dependency and the toolchain version are recorded as
+// packages installed at the path of the binary.
+func (a GoModAnalyzer) getPackages(ctx context.Context, image pkgutil.Image) (map[string]map[string]util.PackageInfo, error) {
+	root := image.FSPath
+	packages := make(map[string]map[string]util.PackageInfo)
+	if _, err := os.Stat(root); err != nil {
+		// path provided invalid
+		return packages, err
+	}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			logrus.Debugf("Skipping %s: %s", path, err)
+			return nil
+		}
+		// only executable regular files can be Go binaries
+		if !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			return nil
+		}
+		if !isELF(path) {
+			return nil
+		}
+		buildInfo, err := buildinfo.ReadFile(path)
+		if err != nil {
+			logrus.Debugf("No Go build info in %s: %s", path, err)
+			return nil
+		}
+		binPath := strings.Replace(path, root, "", 1)
+		addGoPackage(packages, buildInfo.Main.Path, binPath, util.PackageInfo{
+			Version: moduleVersion(&buildInfo.Main),
+			Size:    info.Size(),
+		})
+		for _, dep := range buildInfo.Deps {
+			addGoPackage(packages, dep.Path, binPath, util.PackageInfo{
+				Version: moduleVersion(dep),
+				Size:    -1,
+			})
+		}
+		addGoPackage(packages, goStdlibPackage, binPath, util.PackageInfo{
+			Version: buildInfo.GoVersion,
+			Size:    -1,
+		})
+		return nil
+	})
+	return packages, err
+}
+
+func addGoPackage(packages map[string]map[string]util.PackageInfo, name, binPath string, info util.PackageInfo) {
+	if name == "" {
+		// binaries built outside of a module have no main module path
+		return
+	}
+	if _, ok := packages[name]; !ok {
+		packages[name] = make(map[string]util.PackageInfo)
+	}
+	packages[name][binPath] = info
+}
+
+// moduleVersion returns the version of a module, including its replacement
+// if the module was replaced at build time.
+func moduleVersion(module *debug.Module) string {
+	if module.Replace == nil {
+		return module.Version
+	}
+	replace := module.Replace.Path
+	if module.Replace.Version != "" {
+		replace += " " + module.Replace.Version
+	}
+	return strings.TrimSpace(module.Version + " => " + replace)
+}
+
+func isELF(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, len(elfMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return bytes.Equal(magic, elfMagic)
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package differs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"testing"
+
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+	"github.com/GoogleContainerTools/container-diff/util"
+)
+
+func TestGetGoModPackages(t *testing.T) {
+	// the test binary itself is a Go executable with build info
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		t.Skip("test binary has no build info")
+	}
+	binary, err := ioutil.ReadFile(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "usr/bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]struct {
+		contents []byte
+		mode     os.FileMode
+	}{
+		"usr/bin/app":       {binary, 0755},
+		"usr/bin/script.sh": {[]byte("#!/bin/sh\necho hi\n"), 0755},
+		"usr/bin/notexec":   {binary, 0644},
+	}
+	for name, file := range files {
+		if err := ioutil.WriteFile(filepath.Join(root, name), file.contents, file.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	packages, err := GoModAnalyzer{}.getPackages(context.Background(), pkgutil.Image{FSPath: root})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	expected := map[string]util.PackageInfo{
+		goStdlibPackage: {Version: buildInfo.GoVersion, Size: -1},
+	}
+	if buildInfo.Main.Path != "" {
+		expected[buildInfo.Main.Path] = util.PackageInfo{Version: buildInfo.Main.Version, Size: int64(len(binary))}
+	}
+	for _, dep := range buildInfo.Deps {
+		expected[dep.Path] = util.PackageInfo{Version: moduleVersion(dep), Size: -1}
+	}
+	if len(packages) != len(expected) {
+		t.Errorf("Expected %d packages but got %d", len(expected), len(packages))
+	}
+	for name, info := range expected {
+		installs, ok := packages[name]
+		if !ok {
+			t.Errorf("Expected package %s but it wasn't found", name)
+			continue
+		}
+		if len(installs) != 1 || installs["/usr/bin/app"] != info {
+			t.Errorf("Expected %s to be installed once at /usr/bin/app as %v, got %v", name, info, installs)
+		}
+	}
+}
+
+func TestModuleVersion(t *testing.T) {
+	testCases := []struct {
+		descrip  string
+		module   debug.Module
+		expected string
+	}{
+		{
+			descrip:  "plain module",
+			module:   debug.Module{Path: "golang.org/x/sys", Version: "v0.17.0"},
+			expected: "v0.17.0",
+		},
+		{
+			descrip:  "replaced by another version",
+			module:   debug.Module{Path: "golang.org/x/sys", Version: "v0.17.0", Replace: &debug.Module{Path: "golang.org/x/sys", Version: "v0.18.0"}},
+			expected: "v0.17.0 => golang.org/x/sys v0.18.0",
+		},
+		{
+			descrip:  "replaced by a local directory",
+			module:   debug.Module{Path: "example.com/lib", Version: "v1.0.0", Replace: &debug.Module{Path: "../lib"}},
+			expected: "v1.0.0 => ../lib",
+		},
+	}
+	for _, test := range testCases {
+		if actual := moduleVersion(&test.module); actual != test.expected {
+			t.Errorf("%s: Expected: %s but got: %s", test.descrip, test.expected, actual)
+		}
+	}
+}
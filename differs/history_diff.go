@@ -17,26 +17,22 @@ limitations under the License.
 package differs
 
 import (
+	"context"
 	"strings"
 
 	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
 	"github.com/GoogleContainerTools/container-diff/util"
-	"github.com/google/go-containerregistry/pkg/v1"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 )
 
 type HistoryAnalyzer struct {
 }
 
-type HistDiff struct {
-	Adds []string
-	Dels []string
-}
-
 func (a HistoryAnalyzer) Name() string {
 	return "HistoryAnalyzer"
 }
 
-func (a HistoryAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
+func (a HistoryAnalyzer) Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error) {
 	diff, err := getHistoryDiff(image1, image2)
 	return &util.HistDiffResult{
 		Image1:   image1.Source,
@@ -46,7 +42,7 @@ func (a HistoryAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error)
 	}, err
 }
 
-func (a HistoryAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
+func (a HistoryAnalyzer) Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error) {
 	c, err := image.Image.ConfigFile()
 	if err != nil {
 		return util.ListAnalyzeResult{}, err
@@ -60,22 +56,51 @@ func (a HistoryAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
 	return &result, nil
 }
 
-func getHistoryDiff(image1, image2 pkgutil.Image) (HistDiff, error) {
-	c1, err := image1.Image.ConfigFile()
+func getHistoryDiff(image1, image2 pkgutil.Image) (util.HistDiff, error) {
+	history1, err := getHistoryEntries(image1.Image)
 	if err != nil {
-		return HistDiff{}, err
+		return util.HistDiff{}, err
 	}
-	c2, err := image2.Image.ConfigFile()
+	history2, err := getHistoryEntries(image2.Image)
 	if err != nil {
-		return HistDiff{}, err
+		return util.HistDiff{}, err
 	}
-	history1 := getHistoryList(c1.History)
-	history2 := getHistoryList(c2.History)
+	return util.DiffHistories(history1, history2), nil
+}
 
-	adds := util.GetAdditions(history1, history2)
-	dels := util.GetDeletions(history1, history2)
-	diff := HistDiff{adds, dels}
-	return diff, nil
+// getHistoryEntries pairs each history entry that created a layer with the
+// next layer of the image manifest. Layers left over, in images built without
+// a complete history, get entries of their own.
+func getHistoryEntries(image v1.Image) ([]util.HistoryEntry, error) {
+	c, err := image.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	m, err := image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	entries := []util.HistoryEntry{}
+	layers := m.Layers
+	for _, item := range c.History {
+		entry := util.HistoryEntry{
+			CreatedBy:  strings.TrimSpace(item.CreatedBy),
+			Created:    item.Created,
+			Comment:    item.Comment,
+			EmptyLayer: item.EmptyLayer,
+			Size:       -1,
+		}
+		if !item.EmptyLayer && len(layers) > 0 {
+			entry.Digest = layers[0].Digest.String()
+			entry.Size = layers[0].Size
+			layers = layers[1:]
+		}
+		entries = append(entries, entry)
+	}
+	for _, layer := range layers {
+		entries = append(entries, util.HistoryEntry{Digest: layer.Digest.String(), Size: layer.Size})
+	}
+	return entries, nil
 }
 
 func getHistoryList(historyItems []v1.History) []string {
@@ -0,0 +1,122 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package differs
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+	"github.com/GoogleContainerTools/container-diff/util"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// LayerShareAnalyzer reports the compressed layer blobs two images share,
+// for estimating pull costs and registry storage. It works from the image
+// manifests, without unpacking any filesystem.
+type LayerShareAnalyzer struct {
+}
+
+func (a LayerShareAnalyzer) Name() string {
+	return "LayerShareAnalyzer"
+}
+
+func (a LayerShareAnalyzer) Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error) {
+	layers1, err := getLayerBlobs(ctx, image1.Image, cfg.LayerShareUncompressed)
+	if err != nil {
+		return &util.LayerShareDiffResult{}, err
+	}
+	layers2, err := getLayerBlobs(ctx, image2.Image, cfg.LayerShareUncompressed)
+	if err != nil {
+		return &util.LayerShareDiffResult{}, err
+	}
+	return &util.LayerShareDiffResult{
+		Image1:   image1.Source,
+		Image2:   image2.Source,
+		DiffType: "LayerShare",
+		Diff:     util.DiffLayerShare(layers1, layers2),
+	}, nil
+}
+
+func (a LayerShareAnalyzer) Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error) {
+	layers, err := getLayerBlobs(ctx, image.Image, cfg.LayerShareUncompressed)
+	if err != nil {
+		return &util.LayerShareAnalyzeResult{}, err
+	}
+	return &util.LayerShareAnalyzeResult{
+		Image:       image.Source,
+		AnalyzeType: "LayerShare",
+		Analysis:    util.AnalyzeLayerShare(layers),
+	}, nil
+}
+
+// getLayerBlobs lists the layer blobs of an image in order. Their
+// uncompressed size isn't part of the manifest, so it is only counted from
+// the layer stream when uncompressed is set, which downloads and decompresses
+// every layer, and left out when the stream can't be read. It stops between
+// layers once ctx is done.
+func getLayerBlobs(ctx context.Context, image v1.Image, uncompressed bool) ([]util.LayerBlob, error) {
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting image layers")
+	}
+	blobs := []util.LayerBlob{}
+	for _, layer := range layers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, errors.Wrap(err, "getting layer digest")
+		}
+		diffID, err := layer.DiffID()
+		if err != nil {
+			return nil, errors.Wrap(err, "getting layer diff id")
+		}
+		size, err := layer.Size()
+		if err != nil {
+			return nil, errors.Wrap(err, "getting layer size")
+		}
+		uncompressedSize := int64(-1)
+		if uncompressed {
+			uncompressedSize, err = uncompressedLayerSize(layer)
+			if err != nil {
+				logrus.Warningf("could not get uncompressed size of layer %s: %s", digest, err)
+				uncompressedSize = -1
+			}
+		}
+		blobs = append(blobs, util.LayerBlob{
+			Digest:           digest.String(),
+			DiffID:           diffID.String(),
+			Size:             size,
+			UncompressedSize: uncompressedSize,
+		})
+	}
+	return blobs, nil
+}
+
+func uncompressedLayerSize(layer v1.Layer) (int64, error) {
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	return io.Copy(ioutil.Discard, rc)
+}
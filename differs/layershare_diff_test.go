@@ -0,0 +1,55 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package differs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestGetLayerBlobs(t *testing.T) {
+	image, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the manifest is read by default
+	blobs, err := getLayerBlobs(context.Background(), image, false)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if len(blobs) != 2 {
+		t.Fatalf("Expected 2 layer blobs, got %d", len(blobs))
+	}
+	for _, blob := range blobs {
+		if blob.Size <= 0 || blob.UncompressedSize != -1 {
+			t.Errorf("Expected a compressed size and no uncompressed size, got %+v", blob)
+		}
+	}
+
+	blobs, err = getLayerBlobs(context.Background(), image, true)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	for _, blob := range blobs {
+		if blob.UncompressedSize <= 0 {
+			t.Errorf("Expected an uncompressed size, got %+v", blob)
+		}
+	}
+}
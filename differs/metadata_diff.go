@@ -17,8 +17,10 @@ limitations under the License.
 package differs
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
 	"github.com/GoogleContainerTools/container-diff/util"
@@ -27,16 +29,11 @@ import (
 type MetadataAnalyzer struct {
 }
 
-type MetadataDiff struct {
-	Adds []string
-	Dels []string
-}
-
 func (a MetadataAnalyzer) Name() string {
 	return "MetadataAnalyzer"
 }
 
-func (a MetadataAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
+func (a MetadataAnalyzer) Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error) {
 	diff, err := getMetadataDiff(image1, image2)
 	return &util.MetadataDiffResult{
 		Image1:   image1.Source,
@@ -46,7 +43,7 @@ func (a MetadataAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error
 	}, err
 }
 
-func (a MetadataAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
+func (a MetadataAnalyzer) Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error) {
 	analysis, err := getMetadataList(image)
 	if err != nil {
 		return &util.ListAnalyzeResult{}, err
@@ -58,19 +55,16 @@ func (a MetadataAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
 	}, nil
 }
 
-func getMetadataDiff(image1, image2 pkgutil.Image) (MetadataDiff, error) {
-	m1, err := getMetadataList(image1)
+func getMetadataDiff(image1, image2 pkgutil.Image) ([]util.MetadataDiff, error) {
+	c1, err := image1.Image.ConfigFile()
 	if err != nil {
-		return MetadataDiff{}, err
+		return nil, err
 	}
-	m2, err := getMetadataList(image2)
+	c2, err := image2.Image.ConfigFile()
 	if err != nil {
-		return MetadataDiff{}, err
+		return nil, err
 	}
-
-	adds := util.GetAdditions(m1, m2)
-	dels := util.GetDeletions(m1, m2)
-	return MetadataDiff{adds, dels}, nil
+	return util.DiffConfigs(c1, c2), nil
 }
 
 func getMetadataList(image pkgutil.Image) ([]string, error) {
@@ -80,7 +74,21 @@ func getMetadataList(image pkgutil.Image) ([]string, error) {
 	}
 	c := configFile.Config
 
+	diffIDs := []string{}
+	for _, diffID := range configFile.RootFS.DiffIDs {
+		diffIDs = append(diffIDs, diffID.String())
+	}
+	healthcheck := ""
+	if c.Healthcheck != nil {
+		healthcheck = strings.Join(c.Healthcheck.Test, ",")
+	}
+
 	return []string{
+		fmt.Sprintf("Architecture: %s", configFile.Architecture),
+		fmt.Sprintf("OS: %s", configFile.OS),
+		fmt.Sprintf("Variant: %s", configFile.Variant),
+		fmt.Sprintf("Created: %s", configFile.Created.Format(time.RFC3339)),
+		fmt.Sprintf("Author: %s", configFile.Author),
 		fmt.Sprintf("Domainname: %s", c.Domainname),
 		fmt.Sprintf("User: %s", c.User),
 		fmt.Sprintf("AttachStdin: %t", c.AttachStdin),
@@ -102,6 +110,8 @@ func getMetadataList(image pkgutil.Image) ([]string, error) {
 		fmt.Sprintf("Labels: %v", pkgutil.SortMap(c.Labels)),
 		fmt.Sprintf("StopSignal: %s", c.StopSignal),
 		fmt.Sprintf("Shell: %s", strings.Join(c.Shell, ",")),
+		fmt.Sprintf("Healthcheck: %s", healthcheck),
+		fmt.Sprintf("DiffIDs: %s", strings.Join(diffIDs, ",")),
 	}, nil
 }
 
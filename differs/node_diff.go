@@ -17,6 +17,7 @@ limitations under the License.
 package differs
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"os"
@@ -36,17 +37,17 @@ func (a NodeAnalyzer) Name() string {
 }
 
 // NodeDiff compares the packages installed by apt-get.
-func (a NodeAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
-	diff, err := multiVersionDiff(image1, image2, a)
+func (a NodeAnalyzer) Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error) {
+	diff, err := multiVersionDiff(ctx, cfg, image1, image2, a)
 	return diff, err
 }
 
-func (a NodeAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
-	analysis, err := multiVersionAnalysis(image, a)
+func (a NodeAnalyzer) Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error) {
+	analysis, err := multiVersionAnalysis(ctx, cfg, image, a)
 	return analysis, err
 }
 
-func (a NodeAnalyzer) getPackages(image pkgutil.Image) (map[string]map[string]util.PackageInfo, error) {
+func (a NodeAnalyzer) getPackages(ctx context.Context, image pkgutil.Image) (map[string]map[string]util.PackageInfo, error) {
 	path := image.FSPath
 	packages := make(map[string]map[string]util.PackageInfo)
 	if _, err := os.Stat(path); err != nil {
@@ -62,6 +63,9 @@ func (a NodeAnalyzer) getPackages(image pkgutil.Image) (map[string]map[string]ut
 	for _, modulesDir := range layerStems {
 		packageJSONs, _ := util.BuildLayerTargets(modulesDir, "package.json")
 		for _, currPackage := range packageJSONs {
+			if err := ctx.Err(); err != nil {
+				return packages, err
+			}
 			if _, err := os.Stat(currPackage); err != nil {
 				// package.json file does not exist at this target path
 				continue
@@ -17,6 +17,7 @@ limitations under the License.
 package differs
 
 import (
+	"context"
 	"reflect"
 	"testing"
 
@@ -63,7 +64,7 @@ func TestGetNodePackages(t *testing.T) {
 	for _, test := range testCases {
 		image := pkgutil.Image{FSPath: test.path}
 		d := NodeAnalyzer{}
-		packages, err := d.getPackages(image)
+		packages, err := d.getPackages(context.Background(), image)
 		if err != nil && !test.err {
 			t.Errorf("Got unexpected error: %s", err)
 		}
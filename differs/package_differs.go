@@ -17,7 +17,10 @@ limitations under the License.
 package differs
 
 import (
+	"context"
 	"errors"
+	"os"
+	"path/filepath"
 	"strings"
 
 	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
@@ -25,56 +28,90 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// MultiVersionPackageAnalyzer, SingleVersionPackageAnalyzer and
+// SingleVersionPackageLayerAnalyzer find the packages installed in an image.
+// getPackages returns the error of ctx once it is done.
 type MultiVersionPackageAnalyzer interface {
-	getPackages(image pkgutil.Image) (map[string]map[string]util.PackageInfo, error)
+	getPackages(ctx context.Context, image pkgutil.Image) (map[string]map[string]util.PackageInfo, error)
 	Name() string
 }
 
 type SingleVersionPackageAnalyzer interface {
-	getPackages(image pkgutil.Image) (map[string]util.PackageInfo, error)
+	getPackages(ctx context.Context, image pkgutil.Image) (map[string]util.PackageInfo, error)
 	Name() string
 }
 
 type SingleVersionPackageLayerAnalyzer interface {
-	getPackages(image pkgutil.Image) ([]map[string]util.PackageInfo, error)
+	getPackages(ctx context.Context, image pkgutil.Image) ([]map[string]util.PackageInfo, error)
 	Name() string
 }
 
-func multiVersionDiff(image1, image2 pkgutil.Image, differ MultiVersionPackageAnalyzer) (*util.MultiVersionPackageDiffResult, error) {
-	pack1, err := differ.getPackages(image1)
+// getMultiVersionPackages returns the packages the analyzer finds in image,
+// or those listed in the SBOM of an sbom:// source. Analyzers stop looking
+// for packages once ctx is done.
+func getMultiVersionPackages(ctx context.Context, image pkgutil.Image, analyzer MultiVersionPackageAnalyzer) (map[string]map[string]util.PackageInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if image.SBOM != nil {
+		return util.GetSBOMMultiVersionPackages(*image.SBOM, strings.TrimSuffix(analyzer.Name(), "Analyzer")), nil
+	}
+	return analyzer.getPackages(ctx, image)
+}
+
+// getSingleVersionPackages returns the packages the analyzer finds in image,
+// or those listed in the SBOM of an sbom:// source.
+func getSingleVersionPackages(ctx context.Context, image pkgutil.Image, analyzer SingleVersionPackageAnalyzer) (map[string]util.PackageInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if image.SBOM != nil {
+		return util.GetSBOMPackages(*image.SBOM, strings.TrimSuffix(analyzer.Name(), "Analyzer")), nil
+	}
+	return analyzer.getPackages(ctx, image)
+}
+
+func multiVersionDiff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image, differ MultiVersionPackageAnalyzer) (*util.MultiVersionPackageDiffResult, error) {
+	pack1, err := getMultiVersionPackages(ctx, image1, differ)
 	if err != nil {
 		return &util.MultiVersionPackageDiffResult{}, err
 	}
-	pack2, err := differ.getPackages(image2)
+	pack2, err := getMultiVersionPackages(ctx, image2, differ)
 	if err != nil {
 		return &util.MultiVersionPackageDiffResult{}, err
 	}
 
+	diffType := strings.TrimSuffix(differ.Name(), "Analyzer")
 	diff := util.GetMultiVersionMapDiff(pack1, pack2)
+	diff.ClassifyChanges(diffType)
 	return &util.MultiVersionPackageDiffResult{
 		Image1:   image1.Source,
 		Image2:   image2.Source,
-		DiffType: strings.TrimSuffix(differ.Name(), "Analyzer"),
+		DiffType: diffType,
 		Diff:     diff,
+		SortSize: cfg.SortSize,
 	}, nil
 }
 
-func singleVersionDiff(image1, image2 pkgutil.Image, differ SingleVersionPackageAnalyzer) (*util.SingleVersionPackageDiffResult, error) {
-	pack1, err := differ.getPackages(image1)
+func singleVersionDiff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image, differ SingleVersionPackageAnalyzer) (*util.SingleVersionPackageDiffResult, error) {
+	pack1, err := getSingleVersionPackages(ctx, image1, differ)
 	if err != nil {
 		return &util.SingleVersionPackageDiffResult{}, err
 	}
-	pack2, err := differ.getPackages(image2)
+	pack2, err := getSingleVersionPackages(ctx, image2, differ)
 	if err != nil {
 		return &util.SingleVersionPackageDiffResult{}, err
 	}
 
+	diffType := strings.TrimSuffix(differ.Name(), "Analyzer")
 	diff := util.GetMapDiff(pack1, pack2)
+	diff.ClassifyChanges(diffType)
 	return &util.SingleVersionPackageDiffResult{
 		Image1:   image1.Source,
 		Image2:   image2.Source,
-		DiffType: strings.TrimSuffix(differ.Name(), "Analyzer"),
+		DiffType: diffType,
 		Diff:     diff,
+		SortSize: cfg.SortSize,
 	}, nil
 }
 
@@ -85,8 +122,8 @@ func singleVersionLayerDiff(image1, image2 pkgutil.Image, differ SingleVersionPa
 	return &util.SingleVersionPackageLayerDiffResult{}, errors.New("Diff for packages on layers is not supported, only analysis is supported")
 }
 
-func multiVersionAnalysis(image pkgutil.Image, analyzer MultiVersionPackageAnalyzer) (*util.MultiVersionPackageAnalyzeResult, error) {
-	pack, err := analyzer.getPackages(image)
+func multiVersionAnalysis(ctx context.Context, cfg Config, image pkgutil.Image, analyzer MultiVersionPackageAnalyzer) (*util.MultiVersionPackageAnalyzeResult, error) {
+	pack, err := getMultiVersionPackages(ctx, image, analyzer)
 	if err != nil {
 		return &util.MultiVersionPackageAnalyzeResult{}, err
 	}
@@ -95,12 +132,13 @@ func multiVersionAnalysis(image pkgutil.Image, analyzer MultiVersionPackageAnaly
 		Image:       image.Source,
 		AnalyzeType: strings.TrimSuffix(analyzer.Name(), "Analyzer"),
 		Analysis:    pack,
+		SortSize:    cfg.SortSize,
 	}
 	return &analysis, nil
 }
 
-func singleVersionAnalysis(image pkgutil.Image, analyzer SingleVersionPackageAnalyzer) (*util.SingleVersionPackageAnalyzeResult, error) {
-	pack, err := analyzer.getPackages(image)
+func singleVersionAnalysis(ctx context.Context, cfg Config, image pkgutil.Image, analyzer SingleVersionPackageAnalyzer) (*util.SingleVersionPackageAnalyzeResult, error) {
+	pack, err := getSingleVersionPackages(ctx, image, analyzer)
 	if err != nil {
 		return &util.SingleVersionPackageAnalyzeResult{}, err
 	}
@@ -109,42 +147,66 @@ func singleVersionAnalysis(image pkgutil.Image, analyzer SingleVersionPackageAna
 		Image:       image.Source,
 		AnalyzeType: strings.TrimSuffix(analyzer.Name(), "Analyzer"),
 		Analysis:    pack,
+		SortSize:    cfg.SortSize,
 	}
 	return &analysis, nil
 }
 
+// getLayerPackages returns the packages of the database made of files, paths
+// relative to the root of the image, as read by read from the filesystem of
+// layer. As a layer changing the packages holds the whole database, layers
+// that don't touch the database are reported as nil, while a layer deleting
+// it has no packages left.
+func getLayerPackages(layer pkgutil.Layer, files []string, read func(root string) (map[string]util.PackageInfo, error)) (map[string]util.PackageInfo, error) {
+	for _, file := range files {
+		if _, err := os.Stat(filepath.Join(layer.FSPath, file)); err == nil {
+			return read(layer.FSPath)
+		}
+	}
+	for _, file := range files {
+		if layer.Removes(filepath.Join("/", file)) {
+			return make(map[string]util.PackageInfo), nil
+		}
+	}
+	return nil, nil
+}
+
 // singleVersionLayerAnalysis returns the packages included, deleted or
 // updated in each layer
-func singleVersionLayerAnalysis(image pkgutil.Image, analyzer SingleVersionPackageLayerAnalyzer) (*util.SingleVersionPackageLayerAnalyzeResult, error) {
-	pack, err := analyzer.getPackages(image)
+func singleVersionLayerAnalysis(ctx context.Context, cfg Config, image pkgutil.Image, analyzer SingleVersionPackageLayerAnalyzer) (*util.SingleVersionPackageLayerAnalyzeResult, error) {
+	pack, err := analyzer.getPackages(ctx, image)
 	if err != nil {
 		return &util.SingleVersionPackageLayerAnalyzeResult{}, err
 	}
+	analyzeType := strings.TrimSuffix(analyzer.Name(), "Analyzer")
 	var pkgDiffs []util.PackageDiff
 
 	// Each layer with modified packages includes a complete list of packages
 	// in its package database. Thus we diff the current layer with the
 	// previous one that contains a package database. Layers that do not
-	// include a package database are omitted.
+	// include a package database are omitted, while a layer that deletes
+	// the package database is reported as an empty one.
 	preInd := -1
 	for i := range pack {
 		var pkgDiff util.PackageDiff
-		if preInd < 0 && len(pack[i]) > 0 {
+		if preInd < 0 && pack[i] != nil {
 			pkgDiff = util.GetMapDiff(make(map[string]util.PackageInfo), pack[i])
 			preInd = i
-		} else if preInd >= 0 && len(pack[i]) > 0 {
+		} else if preInd >= 0 && pack[i] != nil {
 			pkgDiff = util.GetMapDiff(pack[preInd], pack[i])
 			preInd = i
 		}
 
+		pkgDiff.ClassifyChanges(analyzeType)
 		pkgDiffs = append(pkgDiffs, pkgDiff)
 	}
 
 	return &util.SingleVersionPackageLayerAnalyzeResult{
 		Image:       image.Source,
-		AnalyzeType: strings.TrimSuffix(analyzer.Name(), "Analyzer"),
+		AnalyzeType: analyzeType,
 		Analysis: util.PackageLayerDiff{
 			PackageDiffs: pkgDiffs,
 		},
+		SortSize: cfg.SortSize,
 	}, nil
 }
@@ -18,6 +18,7 @@ package differs
 
 import (
 	"bufio"
+	"context"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -37,17 +38,17 @@ func (a PipAnalyzer) Name() string {
 }
 
 // PipDiff compares pip-installed Python packages between layers of two different images.
-func (a PipAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
-	diff, err := multiVersionDiff(image1, image2, a)
+func (a PipAnalyzer) Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error) {
+	diff, err := multiVersionDiff(ctx, cfg, image1, image2, a)
 	return diff, err
 }
 
-func (a PipAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
-	analysis, err := multiVersionAnalysis(image, a)
+func (a PipAnalyzer) Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error) {
+	analysis, err := multiVersionAnalysis(ctx, cfg, image, a)
 	return analysis, err
 }
 
-func (a PipAnalyzer) getPackages(image pkgutil.Image) (map[string]map[string]util.PackageInfo, error) {
+func (a PipAnalyzer) getPackages(ctx context.Context, image pkgutil.Image) (map[string]map[string]util.PackageInfo, error) {
 	path := image.FSPath
 	packages := make(map[string]map[string]util.PackageInfo)
 	pythonPaths := []string{}
@@ -76,6 +77,9 @@ func (a PipAnalyzer) getPackages(image pkgutil.Image) (map[string]map[string]uti
 	}
 
 	for _, pythonPath := range pythonPaths {
+		if err := ctx.Err(); err != nil {
+			return packages, err
+		}
 		contents, err := ioutil.ReadDir(pythonPath)
 		if err != nil {
 			// python version folder doesn't have a site-packages folder
@@ -17,6 +17,7 @@ limitations under the License.
 package differs
 
 import (
+	"context"
 	"reflect"
 	"testing"
 
@@ -166,7 +167,7 @@ func TestGetPythonPackages(t *testing.T) {
 	}
 	for _, test := range testCases {
 		d := PipAnalyzer{}
-		packages, _ := d.getPackages(test.image)
+		packages, _ := d.getPackages(context.Background(), test.image)
 		if !reflect.DeepEqual(packages, test.expectedPackages) {
 			t.Errorf("%s\nExpected: %v\nGot: %v", test.descrip, test.expectedPackages, packages)
 		}
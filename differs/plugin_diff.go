@@ -0,0 +1,275 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package differs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+	"github.com/GoogleContainerTools/container-diff/util"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Kinds of plugin output
+const (
+	// PluginPackages is a JSON object mapping package names to a
+	// util.PackageInfo
+	PluginPackages = "packages"
+	// PluginMultiVersionPackages is a JSON object mapping package names to
+	// objects mapping install paths to a util.PackageInfo
+	PluginMultiVersionPackages = "multiVersionPackages"
+	// PluginFiles is a JSON array of pkgutil.DirectoryEntry
+	PluginFiles = "files"
+)
+
+// pluginImageEnv names the image a plugin runs on, for its logs.
+const pluginImageEnv = "CONTAINER_DIFF_IMAGE"
+
+// PluginConfig lists the plugins to register, as read by LoadPlugins.
+type PluginConfig struct {
+	Plugins []Plugin
+}
+
+// Plugin describes an analyzer implemented by an external executable. The
+// executable is run with the path of an unpacked image filesystem appended
+// to Command, and prints what it finds in it as JSON on stdout.
+type Plugin struct {
+	// Name is the analyzer type selecting the plugin, as with --type
+	Name string
+	// Command is the executable to run, followed by its arguments
+	Command []string
+	// Output is the shape of the JSON the plugin prints, PluginPackages by
+	// default
+	Output string
+	// Layers runs the plugin on the filesystem of each layer, which must
+	// print null for a layer without packages. Only PluginPackages output
+	// is supported.
+	Layers bool
+}
+
+// LoadPlugins registers the plugins listed in the JSON config file. Relative
+// command paths are resolved against the directory of the file. Like
+// RegisterPlugin, it must be called before any analysis is started.
+func LoadPlugins(file string) error {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var config PluginConfig
+	if err := json.Unmarshal(contents, &config); err != nil {
+		return fmt.Errorf("parsing plugin config %s: %s", file, err)
+	}
+	for _, plugin := range config.Plugins {
+		if len(plugin.Command) > 0 && !filepath.IsAbs(plugin.Command[0]) && strings.ContainsRune(plugin.Command[0], filepath.Separator) {
+			plugin.Command[0] = filepath.Join(filepath.Dir(file), plugin.Command[0])
+		}
+		if err := RegisterPlugin(plugin); err != nil {
+			return fmt.Errorf("invalid plugin config %s: %s", file, err)
+		}
+	}
+	return nil
+}
+
+// RegisterPlugin adds plugin to Analyzers, under its name. Analyzers and
+// LayerAnalyzers aren't guarded against concurrent use, so plugins must be
+// registered before any analysis is started, e.g. while starting up.
+func RegisterPlugin(plugin Plugin) error {
+	if plugin.Name == "" || strings.ContainsAny(plugin.Name, " \t\n") {
+		return fmt.Errorf("invalid plugin name %q", plugin.Name)
+	}
+	if _, exists := Analyzers[plugin.Name]; exists {
+		return fmt.Errorf("plugin %s: analyzer %s already exists", plugin.Name, plugin.Name)
+	}
+	if len(plugin.Command) == 0 {
+		return fmt.Errorf("plugin %s: no command", plugin.Name)
+	}
+	switch plugin.Output {
+	case "":
+		plugin.Output = PluginPackages
+	case PluginPackages, PluginMultiVersionPackages, PluginFiles:
+	default:
+		return fmt.Errorf("plugin %s: unknown output %q, expected %s, %s or %s", plugin.Name, plugin.Output, PluginPackages, PluginMultiVersionPackages, PluginFiles)
+	}
+	if plugin.Layers && plugin.Output != PluginPackages {
+		return fmt.Errorf("plugin %s: only %s output can be analyzed per layer", plugin.Name, PluginPackages)
+	}
+
+	Analyzers[plugin.Name] = PluginAnalyzer{Plugin: plugin}
+	if plugin.Layers {
+		LayerAnalyzers = append(LayerAnalyzers, plugin.Name)
+	}
+	return nil
+}
+
+// PluginAnalyzer runs a Plugin. Its results are those of the built-in
+// analyzers with the same output: single or multi version packages, or
+// files.
+type PluginAnalyzer struct {
+	Plugin Plugin
+}
+
+func (a PluginAnalyzer) Name() string {
+	first, size := utf8.DecodeRuneInString(a.Plugin.Name)
+	return string(unicode.ToUpper(first)) + a.Plugin.Name[size:] + "Analyzer"
+}
+
+// Diff diffs the output of the plugin for two images, killing the plugin
+// once ctx is done.
+func (a PluginAnalyzer) Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error) {
+	switch {
+	case a.Plugin.Layers:
+		return singleVersionLayerDiff(image1, image2, pluginLayerPackages{a})
+	case a.Plugin.Output == PluginMultiVersionPackages:
+		return multiVersionDiff(ctx, cfg, image1, image2, pluginMultiVersionPackages{a})
+	case a.Plugin.Output == PluginFiles:
+		entries1, err := a.getFiles(ctx, image1)
+		if err != nil {
+			return &util.DirDiffResult{}, err
+		}
+		entries2, err := a.getFiles(ctx, image2)
+		if err != nil {
+			return &util.DirDiffResult{}, err
+		}
+		return &util.DirDiffResult{
+			Image1:   image1.Source,
+			Image2:   image2.Source,
+			DiffType: strings.TrimSuffix(a.Name(), "Analyzer"),
+			Diff:     util.DiffDirectoryEntries(entries1, entries2),
+			SortSize: cfg.SortSize,
+		}, nil
+	}
+	return singleVersionDiff(ctx, cfg, image1, image2, pluginPackages{a})
+}
+
+// Analyze analyzes an image with the plugin, killing it once ctx is done.
+func (a PluginAnalyzer) Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error) {
+	switch {
+	case a.Plugin.Layers:
+		return singleVersionLayerAnalysis(ctx, cfg, image, pluginLayerPackages{a})
+	case a.Plugin.Output == PluginMultiVersionPackages:
+		return multiVersionAnalysis(ctx, cfg, image, pluginMultiVersionPackages{a})
+	case a.Plugin.Output == PluginFiles:
+		entries, err := a.getFiles(ctx, image)
+		if err != nil {
+			return &util.FileAnalyzeResult{}, err
+		}
+		return &util.FileAnalyzeResult{
+			Image:       image.Source,
+			AnalyzeType: strings.TrimSuffix(a.Name(), "Analyzer"),
+			Analysis:    entries,
+			SortSize:    cfg.SortSize,
+		}, nil
+	}
+	return singleVersionAnalysis(ctx, cfg, image, pluginPackages{a})
+}
+
+func (a PluginAnalyzer) getFiles(ctx context.Context, image pkgutil.Image) ([]pkgutil.DirectoryEntry, error) {
+	var entries []pkgutil.DirectoryEntry
+	err := a.run(ctx, image.Source, image.FSPath, &entries)
+	return entries, err
+}
+
+// run executes the plugin on the filesystem at root, and decodes what it
+// prints into out.
+func (a PluginAnalyzer) run(ctx context.Context, source, root string, out interface{}) error {
+	if root == "" {
+		return fmt.Errorf("plugin %s needs the filesystem of %s unpacked", a.Plugin.Name, source)
+	}
+	args := append(append([]string{}, a.Plugin.Command[1:]...), root)
+	cmd := exec.CommandContext(ctx, a.Plugin.Command[0], args...)
+	cmd.Env = append(os.Environ(), pluginImageEnv+"="+source)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	logrus.Debugf("running plugin %s on %s", a.Plugin.Name, root)
+	output, err := cmd.Output()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return errors.Wrapf(err, "running plugin %s: %s", a.Plugin.Name, strings.TrimSpace(stderr.String()))
+	}
+	if stderr.Len() > 0 {
+		logrus.Debugf("plugin %s: %s", a.Plugin.Name, strings.TrimSpace(stderr.String()))
+	}
+	if err := json.Unmarshal(output, out); err != nil {
+		return errors.Wrapf(err, "decoding output of plugin %s", a.Plugin.Name)
+	}
+	return nil
+}
+
+// pluginPackages, pluginMultiVersionPackages and pluginLayerPackages adapt a
+// PluginAnalyzer to the package analyzer interfaces.
+type pluginPackages struct {
+	PluginAnalyzer
+}
+
+func (a pluginPackages) getPackages(ctx context.Context, image pkgutil.Image) (map[string]util.PackageInfo, error) {
+	packages := map[string]util.PackageInfo{}
+	if err := a.run(ctx, image.Source, image.FSPath, &packages); err != nil {
+		return nil, err
+	}
+	if packages == nil {
+		packages = map[string]util.PackageInfo{}
+	}
+	return packages, nil
+}
+
+type pluginMultiVersionPackages struct {
+	PluginAnalyzer
+}
+
+func (a pluginMultiVersionPackages) getPackages(ctx context.Context, image pkgutil.Image) (map[string]map[string]util.PackageInfo, error) {
+	packages := map[string]map[string]util.PackageInfo{}
+	if err := a.run(ctx, image.Source, image.FSPath, &packages); err != nil {
+		return nil, err
+	}
+	if packages == nil {
+		packages = map[string]map[string]util.PackageInfo{}
+	}
+	return packages, nil
+}
+
+type pluginLayerPackages struct {
+	PluginAnalyzer
+}
+
+func (a pluginLayerPackages) getPackages(ctx context.Context, image pkgutil.Image) ([]map[string]util.PackageInfo, error) {
+	var packages []map[string]util.PackageInfo
+	for i, layer := range image.Layers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var layerPackages map[string]util.PackageInfo
+		source := fmt.Sprintf("%s layer %d", image.Source, i)
+		if err := a.run(ctx, source, layer.FSPath, &layerPackages); err != nil {
+			return nil, err
+		}
+		packages = append(packages, layerPackages)
+	}
+	return packages, nil
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package differs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+	"github.com/GoogleContainerTools/container-diff/util"
+)
+
+// catManifest prints the vendor manifest of the filesystem it's given, or
+// null if there is none
+const catManifest = `#!/bin/sh
+cat "$1/opt/vendor/manifest.json" 2>/dev/null || echo null
+`
+
+// writePluginFS returns the root of a filesystem holding manifest as its
+// vendor manifest.
+func writePluginFS(t *testing.T, manifest string) string {
+	root := t.TempDir()
+	if manifest == "" {
+		return root
+	}
+	if err := os.MkdirAll(filepath.Join(root, "opt/vendor"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "opt/vendor/manifest.json"), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// loadTestPlugins registers the plugins of config, whose commands run
+// script, and unregisters them once the test is done.
+func loadTestPlugins(t *testing.T, script, config string) error {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "plugin.sh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "plugins.json")
+	if err := ioutil.WriteFile(file, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	layerAnalyzers := LayerAnalyzers
+	t.Cleanup(func() {
+		for name, analyzer := range Analyzers {
+			if _, ok := analyzer.(PluginAnalyzer); ok {
+				delete(Analyzers, name)
+			}
+		}
+		LayerAnalyzers = layerAnalyzers
+	})
+	return LoadPlugins(file)
+}
+
+func TestLoadPluginsInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"no command", `{"plugins": [{"name": "vendor"}]}`},
+		{"built-in name", `{"plugins": [{"name": "apt", "command": ["./plugin.sh"]}]}`},
+		{"unknown output", `{"plugins": [{"name": "vendor", "command": ["./plugin.sh"], "output": "rpms"}]}`},
+		{"files per layer", `{"plugins": [{"name": "vendor", "command": ["./plugin.sh"], "output": "files", "layers": true}]}`},
+		{"not json", `plugins: vendor`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := loadTestPlugins(t, catManifest, test.config); err == nil {
+				t.Errorf("Expected an error loading %s", test.config)
+			}
+		})
+	}
+}
+
+func TestPluginPackages(t *testing.T) {
+	if err := loadTestPlugins(t, catManifest, `{"plugins": [{"name": "vendor", "command": ["./plugin.sh"]}]}`); err != nil {
+		t.Fatal(err)
+	}
+	image1 := pkgutil.Image{Source: "image1", FSPath: writePluginFS(t, `{"libfoo": {"Version": "1.0", "Size": 10}, "libbar": {"Version": "2.0", "Size": 20}}`)}
+	image2 := pkgutil.Image{Source: "image2", FSPath: writePluginFS(t, `{"libfoo": {"Version": "1.1", "Size": 12}}`)}
+
+	analyzers, err := GetAnalyzers([]string{"vendor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := analyzers[0].Analyze(context.Background(), Config{}, image1)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	analysis := result.(*util.SingleVersionPackageAnalyzeResult)
+	expected := map[string]util.PackageInfo{
+		"libfoo": {Version: "1.0", Size: 10},
+		"libbar": {Version: "2.0", Size: 20},
+	}
+	if analysis.AnalyzeType != "Vendor" || !reflect.DeepEqual(analysis.Analysis, expected) {
+		t.Errorf("Expected Vendor analysis %v, got %s analysis %v", expected, analysis.AnalyzeType, analysis.Analysis)
+	}
+
+	result, err = analyzers[0].Diff(context.Background(), Config{}, image1, image2)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	diff := result.(*util.SingleVersionPackageDiffResult).Diff.(util.PackageDiff)
+	if _, ok := diff.Packages1["libbar"]; !ok || len(diff.Packages2) != 0 {
+		t.Errorf("Expected libbar only in image1, got %v and %v", diff.Packages1, diff.Packages2)
+	}
+	if len(diff.InfoDiff) != 1 || diff.InfoDiff[0].Package != "libfoo" {
+		t.Fatalf("Expected libfoo to differ, got %v", diff.InfoDiff)
+	}
+	if diff.InfoDiff[0].Change != util.PackageUpgraded {
+		t.Errorf("Expected libfoo to be upgraded, got %s", diff.InfoDiff[0].Change)
+	}
+}
+
+func TestPluginLayerPackages(t *testing.T) {
+	config := `{"plugins": [{"name": "vendorlayer", "command": ["./plugin.sh"], "layers": true}]}`
+	if err := loadTestPlugins(t, catManifest, config); err != nil {
+		t.Fatal(err)
+	}
+	image := pkgutil.Image{
+		Source: "image",
+		Layers: []pkgutil.Layer{
+			{FSPath: writePluginFS(t, `{"libfoo": {"Version": "1.0"}}`)},
+			{FSPath: writePluginFS(t, "")},
+			{FSPath: writePluginFS(t, `{"libfoo": {"Version": "1.1"}, "libbar": {"Version": "2.0"}}`)},
+		},
+	}
+	found := false
+	for _, name := range LayerAnalyzers {
+		found = found || name == "vendorlayer"
+	}
+	if !found {
+		t.Errorf("Expected vendorlayer among the layer analyzers, got %v", LayerAnalyzers)
+	}
+
+	result, err := Analyzers["vendorlayer"].Analyze(context.Background(), Config{}, image)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	diffs := result.(*util.SingleVersionPackageLayerAnalyzeResult).Analysis.(util.PackageLayerDiff).PackageDiffs
+	if len(diffs) != 3 {
+		t.Fatalf("Expected a diff per layer, got %v", diffs)
+	}
+	if len(diffs[0].Packages2) != 1 || len(diffs[1].Packages2) != 0 || len(diffs[2].Packages2) != 1 || len(diffs[2].InfoDiff) != 1 {
+		t.Errorf("Expected libfoo added, nothing, then libbar added and libfoo updated, got %v", diffs)
+	}
+}
+
+func TestPluginFiles(t *testing.T) {
+	config := `{"plugins": [{"name": "bundles", "command": ["./plugin.sh"], "output": "files"}]}`
+	if err := loadTestPlugins(t, catManifest, config); err != nil {
+		t.Fatal(err)
+	}
+	image1 := pkgutil.Image{Source: "image1", FSPath: writePluginFS(t, `[{"Name": "/opt/a", "Size": 1}, {"Name": "/opt/b", "Size": 2}]`)}
+	image2 := pkgutil.Image{Source: "image2", FSPath: writePluginFS(t, `[{"Name": "/opt/b", "Size": 3}, {"Name": "/opt/c", "Size": 4}]`)}
+
+	result, err := Analyzers["bundles"].Diff(context.Background(), Config{}, image1, image2)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	diff := result.(*util.DirDiffResult).Diff.(util.DirDiff)
+	expected := util.DirDiff{
+		Adds: []pkgutil.DirectoryEntry{{Name: "/opt/c", Size: 4}},
+		Dels: []pkgutil.DirectoryEntry{{Name: "/opt/a", Size: 1}},
+		Mods: []util.EntryDiff{{Name: "/opt/b", Size1: 2, Size2: 3}},
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Expected diff %v, got %v", expected, diff)
+	}
+}
+
+func TestPluginFailure(t *testing.T) {
+	script := "#!/bin/sh\necho manifest is corrupt >&2\nexit 3\n"
+	if err := loadTestPlugins(t, script, `{"plugins": [{"name": "vendor", "command": ["./plugin.sh"]}]}`); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Analyzers["vendor"].Analyze(context.Background(), Config{}, pkgutil.Image{Source: "image", FSPath: t.TempDir()})
+	if err == nil || !strings.Contains(err.Error(), "manifest is corrupt") {
+		t.Errorf("Expected the error printed by the plugin, got %v", err)
+	}
+}
+
+func TestPluginName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"vendor": "VendorAnalyzer",
+		"élan":   "ÉlanAnalyzer",
+		"日志":     "日志Analyzer",
+	} {
+		if got := (PluginAnalyzer{Plugin: Plugin{Name: name}}).Name(); got != expected {
+			t.Errorf("Expected %s for plugin %s, got %s", expected, name, got)
+		}
+	}
+}
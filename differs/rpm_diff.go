@@ -17,14 +17,11 @@ limitations under the License.
 package differs
 
 import (
-	"bufio"
 	"bytes"
 	"context"
-	"errors"
 	"fmt"
 	"math/rand"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -37,6 +34,7 @@ import (
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/random"
 
+	"github.com/GoogleContainerTools/container-diff/pkg/rpmdb"
 	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
 	"github.com/GoogleContainerTools/container-diff/util"
 	godocker "github.com/fsouza/go-dockerclient"
@@ -45,9 +43,6 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-// RPM macros file location
-const rpmMacros string = "/usr/lib/rpm/macros"
-
 // RPM command to extract packages from the rpm database
 var rpmCmd = []string{
 	"rpm", "--nodigest", "--nosignature",
@@ -70,19 +65,19 @@ func (a RPMAnalyzer) Name() string {
 }
 
 // Diff compares the installed rpm packages of image1 and image2.
-func (a RPMAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
-	diff, err := singleVersionDiff(image1, image2, a)
+func (a RPMAnalyzer) Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error) {
+	diff, err := singleVersionDiff(ctx, cfg, image1, image2, a)
 	return diff, err
 }
 
 // Analyze collects information of the installed rpm packages on image.
-func (a RPMAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
-	analysis, err := singleVersionAnalysis(image, a)
+func (a RPMAnalyzer) Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error) {
+	analysis, err := singleVersionAnalysis(ctx, cfg, image, a)
 	return analysis, err
 }
 
 // getPackages returns a map of installed rpm package on image.
-func (a RPMAnalyzer) getPackages(image pkgutil.Image) (map[string]util.PackageInfo, error) {
+func (a RPMAnalyzer) getPackages(ctx context.Context, image pkgutil.Image) (map[string]util.PackageInfo, error) {
 	path := image.FSPath
 	packages := make(map[string]util.PackageInfo)
 	if _, err := os.Stat(path); err != nil {
@@ -90,72 +85,31 @@ func (a RPMAnalyzer) getPackages(image pkgutil.Image) (map[string]util.PackageIn
 		return packages, err
 	}
 
-	// try to find the rpm binary in bin/ or usr/bin/
-	rpmBinary := filepath.Join(path, "bin/rpm")
-	if _, err := os.Stat(rpmBinary); err != nil {
-		rpmBinary = filepath.Join(path, "usr/bin/rpm")
-		if _, err = os.Stat(rpmBinary); err != nil {
-			logrus.Errorf("Could not detect RPM binary in unpacked image %s", image.Source)
-			return packages, nil
-		}
-	}
-
 	packages, err := rpmDataFromImageFS(image)
+	if err == rpmdb.ErrNoDatabase {
+		logrus.Infof("Could not detect RPM database in unpacked image %s", image.Source)
+		return make(map[string]util.PackageInfo), nil
+	}
 	if err != nil {
-		logrus.Info("Couldn't retrieve RPM data from extracted filesystem; running query in container")
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		logrus.Warnf("Couldn't retrieve RPM data from extracted filesystem: %s; running query in container", err)
 		return rpmDataFromContainer(image.Image)
 	}
 	return packages, err
 }
 
-// rpmDataFromImageFS runs a local rpm binary, if any, to query the image
-// rpmdb and returns a map of installed packages.
+// rpmDataFromImageFS reads the image rpmdb and returns a map of installed
+// packages.
 func rpmDataFromImageFS(image pkgutil.Image) (map[string]util.PackageInfo, error) {
-	dbPath, err := rpmEnvCheck(image.FSPath)
+	dbPath, err := rpmdb.FindDBPath(image.FSPath)
 	if err != nil {
-		logrus.Warnf("Couldn't find RPM database: %s", err.Error())
 		return nil, err
 	}
 	return rpmDataFromFS(image.FSPath, dbPath)
 }
 
-// rpmEnvCheck checks there is an rpm binary in the host and tries to
-// get the RPM database path from the /usr/lib/rpm/macros file in the
-// image rootfs
-func rpmEnvCheck(rootFSPath string) (string, error) {
-	if err := exec.Command("rpm", "--version").Run(); err != nil {
-		logrus.Warn("No RPM binary in host")
-		return "", err
-	}
-	imgMacrosFile, err := os.Open(filepath.Join(rootFSPath, rpmMacros))
-	if err != nil {
-		return "", err
-	}
-	defer imgMacrosFile.Close()
-
-	scanner := bufio.NewScanner(imgMacrosFile)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-
-		// We are looking for a macro definition like (form openSUSE Leap):
-		// %_dbpath                %{_usr}/lib/sysimage/rpm
-		if strings.HasPrefix(line, "%_dbpath") {
-			fields := strings.Fields(line)
-			if len(fields) < 2 {
-				break
-			}
-			out, err := exec.Command("rpm", "-E", fields[1]).Output()
-			if err != nil {
-				return "", err
-			}
-			dbPath := strings.TrimRight(string(out), "\r\n")
-			_, err = os.Stat(filepath.Join(rootFSPath, dbPath))
-			return dbPath, err
-		}
-	}
-	return "", errors.New("Failed parsing macros file")
-}
-
 // rpmDataFromContainer runs image in a container, queries the data of
 // installed rpm packages and returns a map of packages.
 func rpmDataFromContainer(image v1.Image) (map[string]util.PackageInfo, error) {
@@ -170,11 +124,10 @@ func rpmDataFromContainer(image v1.Image) (map[string]util.PackageInfo, error) {
 	}
 
 	imageName, err := loadImageToDaemon(image)
-
+	unlock()
 	if err != nil {
 		return packages, fmt.Errorf("Error loading image: %s", err)
 	}
-	unlock()
 
 	defer client.RemoveImage(imageName)
 	defer logrus.Infof("Removing image %s", imageName)
@@ -323,15 +276,16 @@ func lock() error {
 	// two seconds until the next retry (at most 10 times).  Return fatal
 	// errors immediately, as we can't recover.
 	for i := 0; i < 10; i++ {
-		if err = lock.TryLock(); err != nil {
-			switch err.(type) {
-			case lockfile.TemporaryError:
-				logrus.Debugf("[lock] busy: next retry in two seconds")
-				time.Sleep(2 * time.Second)
-			default:
-				daemonMutex.Unlock()
-				return fmt.Errorf("[lock] error acquiring lock: %s", err)
-			}
+		if err = lock.TryLock(); err == nil {
+			break
+		}
+		switch err.(type) {
+		case lockfile.TemporaryError:
+			logrus.Debugf("[lock] busy: next retry in two seconds")
+			time.Sleep(2 * time.Second)
+		default:
+			daemonMutex.Unlock()
+			return fmt.Errorf("[lock] error acquiring lock: %s", err)
 		}
 	}
 	if err != nil {
@@ -344,8 +298,10 @@ func lock() error {
 }
 
 // unlock releases the containerdiff file-system lock.  Note that errors can be
-// ignored as there's no meaningful way to recover.
+// ignored as there's no meaningful way to recover.  The mutex is released
+// even then, so other go-routines aren't blocked forever.
 func unlock() error {
+	defer daemonMutex.Unlock()
 	lock, err := getLockfile()
 	if err != nil {
 		return fmt.Errorf("[unlock] cannot init lockfile: %v", err)
@@ -355,7 +311,6 @@ func unlock() error {
 		return fmt.Errorf("[unlock] error releasing lock: %s", err)
 	}
 	logrus.Debugf("[unlock] lock released")
-	daemonMutex.Unlock()
 	return nil
 }
 
@@ -368,19 +323,19 @@ func (a RPMLayerAnalyzer) Name() string {
 }
 
 // Diff compares the installed rpm packages of image1 and image2 for each layer
-func (a RPMLayerAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
+func (a RPMLayerAnalyzer) Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error) {
 	diff, err := singleVersionLayerDiff(image1, image2, a)
 	return diff, err
 }
 
 // Analyze collects information of the installed rpm packages on each layer
-func (a RPMLayerAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
-	analysis, err := singleVersionLayerAnalysis(image, a)
+func (a RPMLayerAnalyzer) Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error) {
+	analysis, err := singleVersionLayerAnalysis(ctx, cfg, image, a)
 	return analysis, err
 }
 
 // getPackages returns an array of maps of installed rpm packages on each layer
-func (a RPMLayerAnalyzer) getPackages(image pkgutil.Image) ([]map[string]util.PackageInfo, error) {
+func (a RPMLayerAnalyzer) getPackages(ctx context.Context, image pkgutil.Image) ([]map[string]util.PackageInfo, error) {
 	path := image.FSPath
 	var packages []map[string]util.PackageInfo
 	if _, err := os.Stat(path); err != nil {
@@ -388,35 +343,42 @@ func (a RPMLayerAnalyzer) getPackages(image pkgutil.Image) ([]map[string]util.Pa
 		return packages, err
 	}
 
-	// try to find the rpm binary in bin/ or usr/bin/
-	rpmBinary := filepath.Join(path, "bin/rpm")
-	if _, err := os.Stat(rpmBinary); err != nil {
-		rpmBinary = filepath.Join(path, "usr/bin/rpm")
-		if _, err = os.Stat(rpmBinary); err != nil {
-			logrus.Errorf("Could not detect RPM binary in unpacked image %s", image.Source)
-			return packages, nil
-		}
+	packages, err := rpmDataFromLayerFS(ctx, image)
+	if err == rpmdb.ErrNoDatabase {
+		logrus.Infof("Could not detect RPM database in unpacked image %s", image.Source)
+		return nil, nil
 	}
-
-	packages, err := rpmDataFromLayerFS(image)
 	if err != nil {
-		logrus.Info("Couldn't retrieve RPM data from extracted filesystem; running query in container")
-		return rpmDataFromLayeredContainers(image.Image)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		logrus.Warnf("Couldn't retrieve RPM data from extracted filesystem: %s; running query in container", err)
+		return rpmDataFromLayeredContainers(ctx, image.Image)
 	}
 	return packages, err
 }
 
-// rpmDataFromLayerFS runs a local rpm binary, if any, to query the layer
-// rpmdb and returns an array of maps of installed packages.
-func rpmDataFromLayerFS(image pkgutil.Image) ([]map[string]util.PackageInfo, error) {
+// rpmDataFromLayerFS reads the rpmdb of each layer and returns an array of
+// maps of installed packages. The database location is looked up in the
+// flattened image, as the layer defining it may not contain the database.
+// It stops between layers once ctx is done.
+func rpmDataFromLayerFS(ctx context.Context, image pkgutil.Image) ([]map[string]util.PackageInfo, error) {
 	var packages []map[string]util.PackageInfo
-	dbPath, err := rpmEnvCheck(image.FSPath)
+	dbPath, err := rpmdb.FindDBPath(image.FSPath)
 	if err != nil {
-		logrus.Warnf("Couldn't find RPM database: %s", err.Error())
 		return packages, err
 	}
+	var dbFiles []string
+	for _, file := range rpmdb.DatabaseFiles() {
+		dbFiles = append(dbFiles, filepath.Join(dbPath, file))
+	}
 	for _, layer := range image.Layers {
-		layerPackages, err := rpmDataFromFS(layer.FSPath, dbPath)
+		if err := ctx.Err(); err != nil {
+			return packages, err
+		}
+		layerPackages, err := getLayerPackages(layer, dbFiles, func(root string) (map[string]util.PackageInfo, error) {
+			return rpmDataFromFS(root, dbPath)
+		})
 		if err != nil {
 			return packages, err
 		}
@@ -426,21 +388,19 @@ func rpmDataFromLayerFS(image pkgutil.Image) ([]map[string]util.PackageInfo, err
 	return packages, nil
 }
 
-// rpmDataFromFS runs a local rpm binary to query the image
-// rpmdb and returns a map of installed packages.
+// rpmDataFromFS reads the rpmdb located at dbPath in fsPath and returns a
+// map of installed packages.
 func rpmDataFromFS(fsPath string, dbPath string) (map[string]util.PackageInfo, error) {
+	rpmPackages, err := rpmdb.ReadPackages(fsPath, dbPath)
+	if err != nil {
+		return nil, err
+	}
 	packages := make(map[string]util.PackageInfo)
-	if _, err := os.Stat(filepath.Join(fsPath, dbPath)); err == nil {
-		cmdArgs := append([]string{"--root", fsPath, "--dbpath", dbPath}, rpmCmd[1:]...)
-		out, err := exec.Command(rpmCmd[0], cmdArgs...).Output()
-		if err != nil {
-			logrus.Warnf("RPM call failed: %s", err.Error())
-			return packages, err
-		}
-		output := strings.Split(string(out), "\n")
-		packages, err = parsePackageData(output)
-		if err != nil {
-			return packages, err
+	for _, pkg := range rpmPackages {
+		// keep in line with the rpmCmd query format used in containers
+		packages[pkg.Name] = util.PackageInfo{
+			Version: pkg.Version + "-" + pkg.Release,
+			Size:    pkg.Size,
 		}
 	}
 	return packages, nil
@@ -448,8 +408,8 @@ func rpmDataFromFS(fsPath string, dbPath string) (map[string]util.PackageInfo, e
 
 // rpmDataFromLayeredContainers runs a tmp image in a container for each layer,
 // queries the data of installed rpm packages and returns an array of maps of
-// packages.
-func rpmDataFromLayeredContainers(image v1.Image) ([]map[string]util.PackageInfo, error) {
+// packages. It stops between layers once ctx is done.
+func rpmDataFromLayeredContainers(ctx context.Context, image v1.Image) ([]map[string]util.PackageInfo, error) {
 	var packages []map[string]util.PackageInfo
 	tmpImage, err := random.Image(0, 0)
 	if err != nil {
@@ -462,6 +422,9 @@ func rpmDataFromLayeredContainers(image v1.Image) ([]map[string]util.PackageInfo
 	// Append layers one by one to an empty image and query rpm
 	// database on each iteration
 	for _, layer := range layers {
+		if err := ctx.Err(); err != nil {
+			return packages, err
+		}
 		tmpImage, err = mutate.AppendLayers(tmpImage, layer)
 		if err != nil {
 			return packages, err
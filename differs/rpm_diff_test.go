@@ -17,7 +17,10 @@ limitations under the License.
 package differs
 
 import (
+	"context"
 	"testing"
+
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
 )
 
 // TestLockUnlock runs some lock-unlock cycles to make sure that close,
@@ -66,3 +69,51 @@ func TestGoRoutineRace(t *testing.T) {
 		t.Errorf("Other goroutine didn't lock although lock was released")
 	}
 }
+
+// TestGetRPMLayerPackages checks that only layers writing or deleting the
+// rpmdb report packages.
+func TestGetRPMLayerPackages(t *testing.T) {
+	const fixture = "../pkg/rpmdb/testdata/sqlite"
+	image := pkgutil.Image{
+		FSPath: fixture,
+		Layers: []pkgutil.Layer{
+			{FSPath: fixture},
+			{FSPath: t.TempDir()},
+			{FSPath: t.TempDir(), Whiteouts: []pkgutil.Whiteout{{Path: "/var/lib/rpm"}}},
+		},
+	}
+	packages, err := RPMLayerAnalyzer{}.getPackages(context.Background(), image)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if len(packages) != 3 {
+		t.Fatalf("Expected 3 layers but got %d", len(packages))
+	}
+	if len(packages[0]) != 40 {
+		t.Errorf("Expected 40 packages in the first layer but got %d", len(packages[0]))
+	}
+	if packages[1] != nil {
+		t.Errorf("Expected nil for a layer not touching the database but got: %v", packages[1])
+	}
+	if packages[2] == nil || len(packages[2]) != 0 {
+		t.Errorf("Expected no packages for a layer deleting the database but got: %v", packages[2])
+	}
+}
+
+// TestGetRPMPackagesCanceled checks that the rpm analyzers neither read the
+// rpmdb nor fall back to querying a container once ctx is done.
+func TestGetRPMPackagesCanceled(t *testing.T) {
+	const fixture = "../pkg/rpmdb/testdata/sqlite"
+	image := pkgutil.Image{
+		FSPath: fixture,
+		Layers: []pkgutil.Layer{{FSPath: fixture}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := (RPMAnalyzer{}).Analyze(ctx, Config{}, image); err != context.Canceled {
+		t.Errorf("Expected %v from the analysis but got: %v", context.Canceled, err)
+	}
+	if _, err := (RPMLayerAnalyzer{}).getPackages(ctx, image); err != context.Canceled {
+		t.Errorf("Expected %v from the layer analysis but got: %v", context.Canceled, err)
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package differs
 
 import (
+	"context"
 	"strconv"
 
 	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
@@ -31,10 +32,10 @@ func (a SizeAnalyzer) Name() string {
 }
 
 // SizeDiff diffs two images and compares their size
-func (a SizeAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
+func (a SizeAnalyzer) Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error) {
 	diff := []util.SizeDiff{}
-	size1 := pkgutil.GetSize(image1.FSPath)
-	size2 := pkgutil.GetSize(image2.FSPath)
+	size1 := imageSize(image1, cfg.Filter)
+	size2 := imageSize(image2, cfg.Filter)
 
 	if size1 != size2 {
 		diff = append(diff, util.SizeDiff{
@@ -51,12 +52,12 @@ func (a SizeAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
 	}, nil
 }
 
-func (a SizeAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
+func (a SizeAnalyzer) Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error) {
 	entries := []util.SizeEntry{
 		{
 			Name:   image.Source,
 			Digest: image.Digest,
-			Size:   pkgutil.GetSize(image.FSPath),
+			Size:   imageSize(image, cfg.Filter),
 		},
 	}
 
@@ -75,7 +76,7 @@ func (a SizeLayerAnalyzer) Name() string {
 }
 
 // SizeLayerDiff diffs the layers of two images and compares their size
-func (a SizeLayerAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
+func (a SizeLayerAnalyzer) Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error) {
 	var layerDiffs []util.SizeDiff
 
 	maxLayer := len(image1.Layers)
@@ -84,12 +85,15 @@ func (a SizeLayerAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, erro
 	}
 
 	for index := 0; index < maxLayer; index++ {
+		if err := ctx.Err(); err != nil {
+			return &util.SizeLayerDiffResult{}, err
+		}
 		var size1, size2 int64 = -1, -1
 		if index < len(image1.Layers) {
-			size1 = pkgutil.GetSize(image1.Layers[index].FSPath)
+			size1 = layerSize(image1.Layers[index], cfg.Filter)
 		}
 		if index < len(image2.Layers) {
-			size2 = pkgutil.GetSize(image2.Layers[index].FSPath)
+			size2 = layerSize(image2.Layers[index], cfg.Filter)
 		}
 
 		if size1 != size2 {
@@ -110,13 +114,17 @@ func (a SizeLayerAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, erro
 	}, nil
 }
 
-func (a SizeLayerAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
+func (a SizeLayerAnalyzer) Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error) {
 	var entries []util.SizeEntry
 	for index, layer := range image.Layers {
+		if err := ctx.Err(); err != nil {
+			return &util.SizeLayerAnalyzeResult{}, err
+		}
 		entry := util.SizeEntry{
-			Name:   strconv.Itoa(index),
-			Digest: layer.Digest,
-			Size:   pkgutil.GetSize(layer.FSPath),
+			Name:      strconv.Itoa(index),
+			Digest:    layer.Digest,
+			Size:      layerSize(layer, cfg.Filter),
+			Deletions: pkgutil.FilterWhiteouts(layer.Whiteouts, cfg.Filter),
 		}
 		entries = append(entries, entry)
 	}
@@ -127,3 +135,19 @@ func (a SizeLayerAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
 		Analysis:    entries,
 	}, nil
 }
+
+// imageSize returns the size of the image filesystem, preferring its
+// in-memory index when it was built, without the paths filter drops
+func imageSize(image pkgutil.Image, filter *pkgutil.PathFilter) int64 {
+	if image.Index != nil {
+		return image.Index.TotalSize()
+	}
+	return pkgutil.GetFilteredSize(image.FSPath, "/", filter)
+}
+
+func layerSize(layer pkgutil.Layer, filter *pkgutil.PathFilter) int64 {
+	if layer.Index != nil {
+		return layer.Index.TotalSize()
+	}
+	return pkgutil.GetFilteredSize(layer.FSPath, "/", filter)
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package differs
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+	"github.com/GoogleContainerTools/container-diff/util"
+)
+
+// VulnAnalyzer matches the apt, apk and rpm packages of images against an
+// offline OSV advisory database.
+type VulnAnalyzer struct {
+}
+
+func (a VulnAnalyzer) Name() string {
+	return "VulnAnalyzer"
+}
+
+// Diff reports the vulnerabilities fixed, introduced and left unchanged by
+// image2 compared to image1.
+func (a VulnAnalyzer) Diff(ctx context.Context, cfg Config, image1, image2 pkgutil.Image) (util.Result, error) {
+	db, err := loadVulnDB(cfg.VulnDBDir)
+	if err != nil {
+		return &util.VulnDiffResult{}, err
+	}
+	vulns1, versions1, err := getVulns(ctx, db, image1)
+	if err != nil {
+		return &util.VulnDiffResult{}, err
+	}
+	vulns2, versions2, err := getVulns(ctx, db, image2)
+	if err != nil {
+		return &util.VulnDiffResult{}, err
+	}
+	return &util.VulnDiffResult{
+		Image1:   image1.Source,
+		Image2:   image2.Source,
+		DiffType: "Vuln",
+		Diff:     util.DiffVulns(vulns1, vulns2, versions1, versions2),
+	}, nil
+}
+
+func (a VulnAnalyzer) Analyze(ctx context.Context, cfg Config, image pkgutil.Image) (util.Result, error) {
+	db, err := loadVulnDB(cfg.VulnDBDir)
+	if err != nil {
+		return &util.VulnAnalyzeResult{}, err
+	}
+	vulns, _, err := getVulns(ctx, db, image)
+	if err != nil {
+		return &util.VulnAnalyzeResult{}, err
+	}
+	return &util.VulnAnalyzeResult{
+		Image:       image.Source,
+		AnalyzeType: "Vuln",
+		Analysis:    vulns,
+	}, nil
+}
+
+func loadVulnDB(dir string) (*util.OSVDatabase, error) {
+	if dir == "" {
+		return nil, errors.New("no vulnerability database given")
+	}
+	return util.LoadOSVDatabase(dir)
+}
+
+// getVulns finds the vulnerabilities of the apt, apk and rpm packages of an
+// image, and returns them with the versions of all those packages.
+func getVulns(ctx context.Context, db *util.OSVDatabase, image pkgutil.Image) ([]util.Vuln, map[string]string, error) {
+	var packages []util.VulnPackage
+	versions := map[string]string{}
+	sources := map[string]map[string]string{
+		"Apt": readPackageSources(filepath.Join(image.FSPath, dpkgStatusFile), ": ", "Package", "Source"),
+		"Apk": readPackageSources(filepath.Join(image.FSPath, apkInstalledFile), ":", "P", "o"),
+	}
+	for _, analyzer := range []SingleVersionPackageAnalyzer{AptAnalyzer{}, ApkAnalyzer{}, RPMAnalyzer{}} {
+		analyzeType := strings.TrimSuffix(analyzer.Name(), "Analyzer")
+		installed, err := analyzer.getPackages(ctx, image)
+		if err != nil {
+			return nil, nil, err
+		}
+		for name, info := range installed {
+			packages = append(packages, util.VulnPackage{
+				Name:        name,
+				Source:      sources[analyzeType][name],
+				Version:     info.Version,
+				AnalyzeType: analyzeType,
+			})
+			versions[name] = info.Version
+		}
+	}
+	osID, osVersion := pkgutil.GetOSRelease(image.FSPath)
+	return db.FindVulns(packages, osID, osVersion), versions, nil
+}
+
+// readPackageSources maps the names of the packages in a package database of
+// "key<sep>value" stanzas to the names of their source packages, for those
+// whose source package is named differently. A source may be followed by its
+// version in parentheses.
+func readPackageSources(path, sep, nameKey, sourceKey string) map[string]string {
+	sources := map[string]string{}
+	file, err := os.Open(path)
+	if err != nil {
+		return sources
+	}
+	defer file.Close()
+
+	var name string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), sep)
+		switch {
+		case !ok:
+			if scanner.Text() == "" {
+				name = ""
+			}
+		case key == nameKey:
+			name = value
+		case key == sourceKey && name != "":
+			source := strings.Fields(value)
+			if len(source) > 0 && source[0] != name {
+				sources[name] = source[0]
+			}
+		}
+	}
+	return sources
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package differs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+	"github.com/GoogleContainerTools/container-diff/util"
+)
+
+const testDpkgStatus = `Package: libssl3
+Status: install ok installed
+Installed-Size: 6000
+Source: openssl (3.0.11-1~deb12u1)
+Version: 3.0.11-1~deb12u1+b1
+
+Package: bash
+Status: install ok installed
+Installed-Size: 7000
+Version: 5.2.15-2+b2
+`
+
+const testOSVAdvisory = `{
+  "id": "DSA-5532-1",
+  "aliases": ["CVE-2023-5363"],
+  "affected": [{
+    "package": {"ecosystem": "Debian:12", "name": "openssl"},
+    "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "3.0.11-1~deb12u2"}]}]
+  }]
+}`
+
+func TestVulnAnalyze(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		dpkgStatusFile:   testDpkgStatus,
+		"etc/os-release": "ID=debian\nVERSION_ID=\"12\"\n",
+	}
+	for name, contents := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dbDir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dbDir, "DSA-5532-1.json"), []byte(testOSVAdvisory), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err := VulnAnalyzer{}.Analyze(context.Background(), Config{VulnDBDir: dbDir}, pkgutil.Image{FSPath: root})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	expected := []util.Vuln{{
+		ID:           "DSA-5532-1",
+		Aliases:      []string{"CVE-2023-5363"},
+		Severity:     util.SeverityUnknown,
+		Package:      "libssl3",
+		Version:      "3.0.11-1~deb12u1+b1",
+		FixedVersion: "3.0.11-1~deb12u2",
+	}}
+	if analysis := result.(*util.VulnAnalyzeResult).Analysis; !reflect.DeepEqual(analysis, expected) {
+		t.Errorf("Expected: %+v but got: %+v", expected, analysis)
+	}
+}
@@ -0,0 +1,363 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package containerdiff analyzes and compares container images from Go
+// programs, with the analyzers of the container-diff CLI.
+package containerdiff
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/GoogleContainerTools/container-diff/differs"
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+	"github.com/GoogleContainerTools/container-diff/util"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultAnalyzer runs when Options lists no analyzers, as in the CLI.
+const DefaultAnalyzer = "size"
+
+// Options configures Diff and Analyze.
+type Options struct {
+	// Analyzers lists the analyzer types to run, such as "file" or "apt".
+	// DefaultAnalyzer runs if empty.
+	Analyzers []string
+	// Parallelism is the maximum number of analyzers run at once. Values
+	// below 1 run the analyzers one at a time.
+	Parallelism int
+
+	// CacheDir is the root of the cache image filesystems are unpacked into.
+	// If empty, they are unpacked into temp dirs, which are removed once the
+	// images are analyzed unless KeepFilesystems is set.
+	CacheDir string
+	// CacheMaxSize bounds the size of the cache in bytes, zero means
+	// unbounded.
+	CacheMaxSize int64
+	// KeepFilesystems leaves the filesystems unpacked into temp dirs on
+	// disk, at the FSPath of the returned images. They are still removed
+	// if Diff or Analyze fails. With a CacheDir, the cached filesystems stay
+	// held, so that no run evicts them, until pkgutil.ReleaseImage.
+	KeepFilesystems bool
+	// Stream indexes image filesystems in memory for the analyzers that
+	// support it, rather than unpacking them to disk.
+	Stream bool
+
+	// Include and Exclude are gitignore-style patterns selecting the paths
+	// the filesystem analyzers look at, as for pkgutil.NewPathFilter.
+	Include []string
+	Exclude []string
+	// Digests records the SHA-256 digest of every regular file in the
+	// results of the file and layer analyzers.
+	Digests bool
+	// ContentDiff diffs the contents of the files modified between two
+	// images within ContentDiffLimits, with the file differ.
+	ContentDiff       bool
+	ContentDiffLimits util.ContentDiffLimits
+	// Filename also diffs the contents of this file between two images,
+	// within the MaxFileSize of ContentDiffLimits.
+	Filename string
+	// VulnDB is the directory of OSV advisories the vulns analyzer matches
+	// packages against.
+	VulnDB string
+	// LayerShareUncompressed makes the layershare analyzer also count the
+	// uncompressed size of the layers, which downloads every layer.
+	LayerShareUncompressed bool
+	// SortSize sorts the files and packages of the results by descending
+	// size when they are output, rather than by name.
+	SortSize bool
+
+	// Platform selects the image to use from a multi-platform image. If nil,
+	// the default platform of the image source is used.
+	Platform *v1.Platform
+	// Platform2 selects the platform of the second image of Diff. If nil,
+	// Platform applies to both images.
+	Platform2 *v1.Platform
+
+	// Keychain resolves the credentials for remote registries. If nil,
+	// authn.DefaultKeychain is used.
+	Keychain authn.Keychain
+	// SkipTLSVerifyRegistries lists registries whose TLS certificates
+	// aren't verified.
+	SkipTLSVerifyRegistries []string
+	// RegistryCertificates maps registries to the path of the certificate
+	// used to verify them.
+	RegistryCertificates map[string]string
+}
+
+// DiffResult holds the results of Diff.
+type DiffResult struct {
+	// Image1 and Image2 are the compared images. Their filesystems are only
+	// left on disk with a CacheDir or KeepFilesystems.
+	Image1 pkgutil.Image
+	Image2 pkgutil.Image
+	// Results maps the name of each analyzer that succeeded, such as
+	// "FileAnalyzer", to its result, such as a *util.DirDiffResult.
+	Results map[string]util.Result
+	// FileDiff is the diff of Options.Filename, if set.
+	FileDiff *util.FileNameDiff
+}
+
+// AnalyzeResult holds the results of Analyze.
+type AnalyzeResult struct {
+	// Image is the analyzed image. Its filesystem is only left on disk
+	// with a CacheDir or KeepFilesystems.
+	Image pkgutil.Image
+	// Results maps the name of each analyzer that succeeded, such as
+	// "FileAnalyzer", to its result, such as a *util.FileAnalyzeResult.
+	Results map[string]util.Result
+}
+
+// Diff compares the images ref1 and ref2, given in any form the CLI accepts,
+// with the analyzers of opts. An error is returned if every analyzer fails,
+// or if ctx is done first. Concurrent calls don't share any settings.
+func Diff(ctx context.Context, ref1, ref2 string, opts Options) (_ *DiffResult, err error) {
+	analyzers, cfg, err := prepare(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	platform2 := opts.Platform2
+	if platform2 == nil {
+		platform2 = opts.Platform
+	}
+	image1, image2, err := retrieveImages(ctx, ref1, ref2, opts, cfg, platform2)
+	defer func() {
+		cleanupImages(opts, err != nil, image1, image2)
+	}()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Platform != nil && !opts.Platform.Equals(*platform2) {
+		// tell the platforms apart when comparing two platforms of one image
+		image1.Source = fmt.Sprintf("%s [%s]", image1.Source, opts.Platform)
+		image2.Source = fmt.Sprintf("%s [%s]", image2.Source, platform2)
+	}
+
+	logrus.Info("computing diffs")
+	req := differs.DiffRequest{
+		Image1:      image1,
+		Image2:      image2,
+		DiffTypes:   analyzers,
+		Config:      cfg,
+		Parallelism: opts.Parallelism,
+	}
+	results, err := req.GetDiffContext(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not retrieve diff")
+	}
+	result := &DiffResult{Image1: image1, Image2: image2, Results: results}
+	if opts.Filename != "" {
+		logrus.Info("computing filename diffs")
+		limits := util.ContentDiffLimits{MaxFileSize: opts.ContentDiffLimits.MaxFileSize}
+		result.FileDiff, err = util.DiffFile(&image1, &image2, opts.Filename, limits)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// Analyze runs the analyzers of opts on the image ref, given in any form the
+// CLI accepts. An error is returned if every analyzer fails, or if ctx is
+// done first. Concurrent calls don't share any settings.
+func Analyze(ctx context.Context, ref string, opts Options) (_ *AnalyzeResult, err error) {
+	analyzers, cfg, err := prepare(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	image, err := getImage(ctx, ref, opts, cfg, opts.Platform)
+	defer func() {
+		cleanupImages(opts, err != nil, image)
+	}()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error retrieving image %s", ref)
+	}
+
+	req := differs.SingleRequest{
+		Image:        image,
+		AnalyzeTypes: analyzers,
+		Config:       cfg,
+		Parallelism:  opts.Parallelism,
+	}
+	results, err := req.GetAnalysisContext(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error performing image analysis")
+	}
+	return &AnalyzeResult{Image: image, Results: results}, nil
+}
+
+// prepare validates opts, returning the analyzers to run and the
+// configuration to run them with.
+func prepare(opts Options) ([]differs.Analyzer, differs.Config, error) {
+	analyzers, err := differs.GetAnalyzers(analyzerTypes(opts))
+	if err != nil {
+		return nil, differs.Config{}, errors.Wrap(err, "getting analyzers")
+	}
+	cfg := differs.Config{
+		FSOptions:              pkgutil.FSOptions{Digests: opts.Digests},
+		ContentDiff:            opts.ContentDiff,
+		ContentDiffLimits:      opts.ContentDiffLimits,
+		VulnDBDir:              opts.VulnDB,
+		LayerShareUncompressed: opts.LayerShareUncompressed,
+		SortSize:               opts.SortSize,
+	}
+	if len(opts.Include) > 0 || len(opts.Exclude) > 0 {
+		cfg.Filter, err = pkgutil.NewPathFilter(opts.Include, opts.Exclude)
+		if err != nil {
+			return nil, differs.Config{}, err
+		}
+	}
+	return analyzers, cfg, nil
+}
+
+func analyzerTypes(opts Options) []string {
+	if len(opts.Analyzers) == 0 {
+		return []string{DefaultAnalyzer}
+	}
+	return opts.Analyzers
+}
+
+// cleanupImages removes the filesystems of images once they are analyzed,
+// or releases them if they are cached, unless opts keeps them. Filesystems
+// are removed or released anyway when the run failed, as when ctx is
+// canceled halfway through an extraction.
+func cleanupImages(opts Options, failed bool, images ...pkgutil.Image) {
+	if opts.KeepFilesystems && !failed {
+		return
+	}
+	for _, image := range images {
+		if opts.CacheDir != "" {
+			pkgutil.ReleaseImage(image)
+		} else {
+			pkgutil.CleanupImage(image)
+		}
+	}
+}
+
+// retrieveImages retrieves both images concurrently. The images are returned
+// even on error, so whatever was already unpacked can be cleaned up.
+func retrieveImages(ctx context.Context, ref1, ref2 string, opts Options, cfg differs.Config, platform2 *v1.Platform) (pkgutil.Image, pkgutil.Image, error) {
+	var image1, image2 pkgutil.Image
+	var err1, err2 error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		image1, err1 = getImage(ctx, ref1, opts, cfg, opts.Platform)
+	}()
+	go func() {
+		defer wg.Done()
+		image2, err2 = getImage(ctx, ref2, opts, cfg, platform2)
+	}()
+	wg.Wait()
+
+	switch {
+	case err1 != nil && err2 != nil:
+		return image1, image2, fmt.Errorf("error retrieving image %s: %s\nerror retrieving image %s: %s", ref1, err1, ref2, err2)
+	case err1 != nil:
+		return image1, image2, fmt.Errorf("error retrieving image %s: %s", ref1, err1)
+	case err2 != nil:
+		return image1, image2, fmt.Errorf("error retrieving image %s: %s", ref2, err2)
+	}
+	return image1, image2, nil
+}
+
+func getImage(ctx context.Context, ref string, opts Options, cfg differs.Config, platform *v1.Platform) (pkgutil.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return pkgutil.Image{}, err
+	}
+	return pkgutil.GetImageContext(ctx, ref, imageOptions(opts, cfg, platform))
+}
+
+// imageOptions returns the options to retrieve an image with, making only
+// the filesystems the analyzers of opts need available, filtered like cfg.
+func imageOptions(opts Options, cfg differs.Config, platform *v1.Platform) pkgutil.ImageOptions {
+	types := analyzerTypes(opts)
+	imageOpts := pkgutil.ImageOptions{
+		IncludeLayers: containsAny(types, differs.LayerAnalyzers[:]),
+		Extract:       true,
+		Platform:      platform,
+		Keychain:      opts.Keychain,
+		TLS: pkgutil.TLSOptions{
+			SkipVerifyRegistries: opts.SkipTLSVerifyRegistries,
+			Certificates:         opts.RegistryCertificates,
+		},
+	}
+	if !needsFilesystems(opts, types) {
+		imageOpts.Extract = false
+	} else if opts.Stream {
+		imageOpts.Index = true
+		imageOpts.Extract = needsExtraction(opts, types)
+		if imageOpts.Extract {
+			logrus.Infof("some analyzers need real files, extracting image filesystems to disk")
+		}
+	}
+	if opts.CacheDir != "" && imageOpts.Extract {
+		imageOpts.CacheDir = opts.CacheDir
+		imageOpts.CacheMaxSize = opts.CacheMaxSize
+	}
+	imageOpts.Filter = cfg.Filter
+	// the paths the filters drop only need to be extracted for analyzers
+	// that don't apply them
+	imageOpts.ExtractAll = opts.Filename != "" || !containsAll(differs.StreamingAnalyzers[:], types)
+	return imageOpts
+}
+
+// needsExtraction returns whether any of types needs the image filesystems
+// unpacked to disk rather than indexed from the streaming tars.
+func needsExtraction(opts Options, types []string) bool {
+	if opts.Filename != "" || opts.ContentDiff {
+		return true
+	}
+	return !containsAll(differs.StreamingAnalyzers[:], types)
+}
+
+// needsFilesystems returns whether any of types looks at the image
+// filesystems, rather than only at its manifest and config.
+func needsFilesystems(opts Options, types []string) bool {
+	if opts.Filename != "" {
+		return true
+	}
+	return !containsAll(differs.ManifestAnalyzers[:], types)
+}
+
+// containsAll returns whether list contains every one of values.
+func containsAll(list, values []string) bool {
+	for _, value := range values {
+		if !containsAny(list, []string{value}) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsAny returns whether list contains any of values.
+func containsAny(list, values []string) bool {
+	for _, item := range list {
+		for _, value := range values {
+			if item == value {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package containerdiff
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/GoogleContainerTools/container-diff/util"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/pkg/errors"
+)
+
+// writeImage writes a single layer image holding files to a tarball in dir.
+func writeImage(t *testing.T, dir, filename string, files map[string]string) string {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, contents := range files {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(contents)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, filename)
+	tag, err := name.NewTag("container-diff.test/" + filename[:len(filename)-len(".tar")])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tarball.WriteToFile(path, tag, img); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	image1 := writeImage(t, dir, "image1.tar", map[string]string{
+		"etc/os-release":          "ID=alpine\n",
+		"var/cache/apk/index":     "old index",
+		"usr/share/doc/README":    "lime",
+		"usr/share/doc/REMOVED":   "pear",
+		"usr/local/bin/unchanged": "peach",
+	})
+	image2 := writeImage(t, dir, "image2.tar", map[string]string{
+		"etc/os-release":          "ID=alpine\n",
+		"var/cache/apk/index":     "new index",
+		"usr/share/doc/README":    "mango",
+		"usr/local/bin/unchanged": "peach",
+	})
+
+	for _, stream := range []bool{false, true} {
+		result, err := Diff(context.Background(), image1, image2, Options{
+			Analyzers: []string{"file"},
+			Exclude:   []string{"/var/cache"},
+			Stream:    stream,
+		})
+		if err != nil {
+			t.Fatalf("stream %t: unexpected error: %s", stream, err)
+		}
+		fileResult, ok := result.Results["FileAnalyzer"].(*util.DirDiffResult)
+		if !ok {
+			t.Fatalf("stream %t: expected a file diff, got %v", stream, result.Results)
+		}
+		diff := fileResult.Diff.(util.DirDiff)
+		var dels, mods []string
+		for _, entry := range diff.Dels {
+			dels = append(dels, entry.Name)
+		}
+		for _, entry := range diff.Mods {
+			mods = append(mods, entry.Name)
+		}
+		if len(diff.Adds) != 0 || !reflect.DeepEqual(dels, []string{"/usr/share/doc/REMOVED"}) || !reflect.DeepEqual(mods, []string{"/usr/share/doc/README"}) {
+			t.Errorf("stream %t: unexpected diff %+v", stream, diff)
+		}
+		if result.Image1.Source != image1 || result.Image2.Source != image2 {
+			t.Errorf("stream %t: unexpected images %s and %s", stream, result.Image1.Source, result.Image2.Source)
+		}
+	}
+}
+
+func TestDiffConcurrentOptions(t *testing.T) {
+	dir := t.TempDir()
+	image1 := writeImage(t, dir, "image1.tar", map[string]string{
+		"var/cache/apk/index":  "old index",
+		"usr/share/doc/README": "lime",
+	})
+	image2 := writeImage(t, dir, "image2.tar", map[string]string{
+		"var/cache/apk/index":  "new index",
+		"usr/share/doc/README": "mango",
+	})
+
+	// runs with other filters proceed at once, each with its own
+	excludes := [][]string{nil, {"/var/cache"}, {"/usr"}}
+	expected := [][]string{
+		{"/usr/share/doc/README", "/var/cache/apk/index"},
+		{"/usr/share/doc/README"},
+		{"/var/cache/apk/index"},
+	}
+	mods := make([][]string, len(excludes))
+	errs := make([]error, len(excludes))
+	var wg sync.WaitGroup
+	for i := range excludes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := Diff(context.Background(), image1, image2, Options{
+				Analyzers: []string{"file"},
+				Exclude:   excludes[i],
+				Stream:    true,
+			})
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			for _, entry := range result.Results["FileAnalyzer"].(*util.DirDiffResult).Diff.(util.DirDiff).Mods {
+				mods[i] = append(mods[i], entry.Name)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i := range excludes {
+		if errs[i] != nil {
+			t.Errorf("exclude %v: unexpected error: %s", excludes[i], errs[i])
+		} else if !reflect.DeepEqual(mods[i], expected[i]) {
+			t.Errorf("exclude %v: expected modified files %v, got %v", excludes[i], expected[i], mods[i])
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	image := writeImage(t, t.TempDir(), "image.tar", map[string]string{"etc/os-release": "ID=alpine\n"})
+
+	result, err := Analyze(context.Background(), image, Options{Analyzers: []string{"file", "size"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := result.Results["FileAnalyzer"].(*util.FileAnalyzeResult); !ok {
+		t.Errorf("expected a file analysis, got %v", result.Results)
+	}
+	if _, ok := result.Results["SizeAnalyzer"]; !ok {
+		t.Errorf("expected a size analysis, got %v", result.Results)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Analyze(ctx, image, Options{}); errors.Cause(err) != context.Canceled {
+		t.Errorf("expected %s for a canceled context, got %v", context.Canceled, err)
+	}
+
+	if _, err := Analyze(context.Background(), image, Options{Analyzers: []string{"unknown"}}); err == nil {
+		t.Errorf("expected an error for an unknown analyzer")
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rpmdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// BerkeleyDB constants, as defined in db_page.h
+const (
+	bdbHashMagic = 0x061561
+
+	bdbPageHeaderSize = 26
+
+	bdbPageTypeHashUnsorted = 2
+	bdbPageTypeOverflow     = 7
+	bdbPageTypeHash         = 13
+
+	bdbItemOffPage = 3
+)
+
+// readBerkeleyDB returns every header blob stored in a BerkeleyDB hash
+// database, the format used by rpm up to version 4.16.
+//
+// Rather than following the hash buckets, every hash page is scanned and
+// the off-page values referenced from it are collected. rpm always stores
+// headers off-page as they are bigger than a page.
+func readBerkeleyDB(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	meta := make([]byte, 512)
+	if _, err := io.ReadFull(f, meta); err != nil {
+		return nil, fmt.Errorf("reading BerkeleyDB metadata page: %s", err)
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if order.Uint32(meta[12:16]) != bdbHashMagic {
+		order = binary.BigEndian
+		if order.Uint32(meta[12:16]) != bdbHashMagic {
+			return nil, fmt.Errorf("%s is not a BerkeleyDB hash database", path)
+		}
+	}
+	pageSize := order.Uint32(meta[20:24])
+	lastPage := order.Uint32(meta[32:36])
+	if pageSize < 512 || pageSize > 64*1024 {
+		return nil, fmt.Errorf("invalid BerkeleyDB page size %d", pageSize)
+	}
+
+	var blobs [][]byte
+	page := make([]byte, pageSize)
+	for pgno := uint32(1); pgno <= lastPage; pgno++ {
+		if err := readBerkeleyDBPage(f, pgno, page); err != nil {
+			return nil, err
+		}
+		pageType := page[25]
+		if pageType != bdbPageTypeHash && pageType != bdbPageTypeHashUnsorted {
+			continue
+		}
+		entries := int(order.Uint16(page[20:22]))
+		// entries alternate between keys and values; only the values matter
+		for i := 1; i < entries; i += 2 {
+			indexOffset := bdbPageHeaderSize + i*2
+			if indexOffset+2 > len(page) {
+				break
+			}
+			itemOffset := int(order.Uint16(page[indexOffset:]))
+			if itemOffset+12 > len(page) || page[itemOffset] != bdbItemOffPage {
+				continue
+			}
+			overflowPage := order.Uint32(page[itemOffset+4:])
+			length := order.Uint32(page[itemOffset+8:])
+			blob, err := readBerkeleyDBOverflow(f, order, pageSize, overflowPage, length)
+			if err != nil {
+				return nil, err
+			}
+			blobs = append(blobs, blob)
+		}
+	}
+	return blobs, nil
+}
+
+func readBerkeleyDBPage(f *os.File, pgno uint32, page []byte) error {
+	if _, err := f.ReadAt(page, int64(pgno)*int64(len(page))); err != nil {
+		return fmt.Errorf("reading BerkeleyDB page %d: %s", pgno, err)
+	}
+	return nil
+}
+
+// readBerkeleyDBOverflow follows a chain of overflow pages starting at pgno
+// and returns the length bytes of data stored in them.
+func readBerkeleyDBOverflow(f *os.File, order binary.ByteOrder, pageSize, pgno, length uint32) ([]byte, error) {
+	data := make([]byte, 0, length)
+	page := make([]byte, pageSize)
+	for pgno != 0 && uint32(len(data)) < length {
+		if err := readBerkeleyDBPage(f, pgno, page); err != nil {
+			return nil, err
+		}
+		if page[25] != bdbPageTypeOverflow {
+			return nil, fmt.Errorf("BerkeleyDB page %d is not an overflow page", pgno)
+		}
+		// on overflow pages the free area offset holds the number of bytes used
+		used := uint32(order.Uint16(page[22:24]))
+		if bdbPageHeaderSize+used > pageSize {
+			return nil, fmt.Errorf("invalid BerkeleyDB overflow page %d", pgno)
+		}
+		data = append(data, page[bdbPageHeaderSize:bdbPageHeaderSize+used]...)
+		pgno = order.Uint32(page[16:20])
+	}
+	if uint32(len(data)) != length {
+		return nil, fmt.Errorf("truncated BerkeleyDB overflow chain: read %d of %d bytes", len(data), length)
+	}
+	return data, nil
+}
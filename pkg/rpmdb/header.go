@@ -0,0 +1,124 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rpmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Header tags used to build a Package, as defined in rpm's rpmtag.h
+const (
+	tagName     = 1000
+	tagVersion  = 1001
+	tagRelease  = 1002
+	tagEpoch    = 1003
+	tagSize     = 1009
+	tagArch     = 1022
+	tagLongSize = 5009
+)
+
+// Header entry data types, as defined in rpm's rpmtag.h
+const (
+	typeInt32  = 4
+	typeInt64  = 5
+	typeString = 6
+	typeI18N   = 9
+)
+
+const headerEntrySize = 16
+
+type headerEntry struct {
+	Tag    int32
+	Type   uint32
+	Offset int32
+	Count  uint32
+}
+
+// parseHeader decodes an rpm header blob, as stored in every rpmdb backend,
+// into a Package. Only the tags needed to describe a package are read.
+func parseHeader(blob []byte) (Package, error) {
+	var pkg Package
+	if len(blob) < 8 {
+		return pkg, fmt.Errorf("header blob too short: %d bytes", len(blob))
+	}
+	indexCount := int(binary.BigEndian.Uint32(blob[0:4]))
+	dataLength := int(binary.BigEndian.Uint32(blob[4:8]))
+	dataStart := 8 + indexCount*headerEntrySize
+	if indexCount <= 0 || dataLength < 0 || dataStart+dataLength > len(blob) {
+		return pkg, fmt.Errorf("invalid header blob: %d entries, %d bytes of data", indexCount, dataLength)
+	}
+	data := blob[dataStart : dataStart+dataLength]
+
+	var size, longSize int64 = -1, -1
+	for i := 0; i < indexCount; i++ {
+		var entry headerEntry
+		if err := binary.Read(bytes.NewReader(blob[8+i*headerEntrySize:]), binary.BigEndian, &entry); err != nil {
+			return pkg, err
+		}
+		if entry.Offset < 0 || int(entry.Offset) >= len(data) {
+			continue
+		}
+		value := data[entry.Offset:]
+		switch entry.Tag {
+		case tagName:
+			pkg.Name = headerString(entry, value)
+		case tagVersion:
+			pkg.Version = headerString(entry, value)
+		case tagRelease:
+			pkg.Release = headerString(entry, value)
+		case tagArch:
+			pkg.Arch = headerString(entry, value)
+		case tagEpoch:
+			pkg.Epoch = int(headerInt(entry, value))
+		case tagSize:
+			size = headerInt(entry, value)
+		case tagLongSize:
+			longSize = headerInt(entry, value)
+		}
+	}
+	// rpm only sets LONGSIZE for packages that overflow SIZE
+	pkg.Size = size
+	if longSize >= 0 {
+		pkg.Size = longSize
+	}
+	if pkg.Name == "" {
+		return pkg, fmt.Errorf("header blob has no package name")
+	}
+	return pkg, nil
+}
+
+func headerString(entry headerEntry, value []byte) string {
+	if entry.Type != typeString && entry.Type != typeI18N {
+		return ""
+	}
+	if end := bytes.IndexByte(value, 0); end >= 0 {
+		return string(value[:end])
+	}
+	return string(value)
+}
+
+func headerInt(entry headerEntry, value []byte) int64 {
+	switch {
+	case entry.Type == typeInt32 && len(value) >= 4:
+		return int64(int32(binary.BigEndian.Uint32(value)))
+	case entry.Type == typeInt64 && len(value) >= 8:
+		return int64(binary.BigEndian.Uint64(value))
+	}
+	return -1
+}
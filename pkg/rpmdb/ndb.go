@@ -0,0 +1,104 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rpmdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// NDB constants, as defined in rpm's lib/backend/ndb/rpmpkg.c
+const (
+	ndbHeaderMagic = 'R' | 'p'<<8 | 'm'<<16 | 'P'<<24
+	ndbSlotMagic   = 'S' | 'l'<<8 | 'o'<<16 | 't'<<24
+	ndbBlobMagic   = 'B' | 'l'<<8 | 'b'<<16 | 'S'<<24
+	ndbVersion     = 0
+
+	ndbHeaderSize   = 32
+	ndbPageSize     = 4096
+	ndbSlotSize     = 16
+	ndbBlockSize    = 16
+	ndbBlobHeadSize = 16
+)
+
+// readNDB returns every header blob stored in an NDB package database, the
+// format used by SUSE since rpm 4.16.
+func readNDB(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, ndbHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, fmt.Errorf("reading NDB header: %s", err)
+	}
+	if binary.LittleEndian.Uint32(header[0:4]) != ndbHeaderMagic {
+		return nil, fmt.Errorf("%s is not an NDB package database", path)
+	}
+	if version := binary.LittleEndian.Uint32(header[4:8]); version != ndbVersion {
+		return nil, fmt.Errorf("unsupported NDB version %d", version)
+	}
+	slotPages := binary.LittleEndian.Uint32(header[12:16])
+
+	// the slots fill the first slotPages pages, right after the header
+	slots := make([]byte, int64(slotPages)*ndbPageSize-ndbHeaderSize)
+	if _, err := io.ReadFull(f, slots); err != nil {
+		return nil, fmt.Errorf("reading NDB slots: %s", err)
+	}
+
+	var blobs [][]byte
+	for offset := 0; offset+ndbSlotSize <= len(slots); offset += ndbSlotSize {
+		slot := slots[offset : offset+ndbSlotSize]
+		if binary.LittleEndian.Uint32(slot[0:4]) != ndbSlotMagic {
+			return nil, fmt.Errorf("invalid NDB slot at offset %d", ndbHeaderSize+offset)
+		}
+		pkgIndex := binary.LittleEndian.Uint32(slot[4:8])
+		if pkgIndex == 0 {
+			// free slot
+			continue
+		}
+		blockOffset := binary.LittleEndian.Uint32(slot[8:12])
+		blob, err := readNDBBlob(f, pkgIndex, int64(blockOffset)*ndbBlockSize)
+		if err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, blob)
+	}
+	return blobs, nil
+}
+
+func readNDBBlob(f *os.File, pkgIndex uint32, offset int64) ([]byte, error) {
+	head := make([]byte, ndbBlobHeadSize)
+	if _, err := f.ReadAt(head, offset); err != nil {
+		return nil, fmt.Errorf("reading NDB blob for package %d: %s", pkgIndex, err)
+	}
+	if binary.LittleEndian.Uint32(head[0:4]) != ndbBlobMagic {
+		return nil, fmt.Errorf("invalid NDB blob magic for package %d", pkgIndex)
+	}
+	if index := binary.LittleEndian.Uint32(head[4:8]); index != pkgIndex {
+		return nil, fmt.Errorf("NDB blob for package %d belongs to package %d", pkgIndex, index)
+	}
+	blob := make([]byte, binary.LittleEndian.Uint32(head[12:16]))
+	if _, err := f.ReadAt(blob, offset+ndbBlobHeadSize); err != nil {
+		return nil, fmt.Errorf("reading NDB blob for package %d: %s", pkgIndex, err)
+	}
+	return blob, nil
+}
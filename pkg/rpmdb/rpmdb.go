@@ -0,0 +1,254 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rpmdb reads the rpm package database of an unpacked image
+// filesystem without relying on an rpm binary. The BerkeleyDB, NDB and
+// SQLite database backends are supported.
+package rpmdb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNoDatabase is returned when no rpm database can be found in a filesystem.
+var ErrNoDatabase = errors.New("no rpm database found")
+
+// Package describes a single installed rpm package.
+type Package struct {
+	Name    string
+	Epoch   int
+	Version string
+	Release string
+	Arch    string
+	Size    int64
+}
+
+// database files of each backend, in order of preference
+var backends = []struct {
+	file string
+	read func(path string) ([][]byte, error)
+}{
+	{"rpmdb.sqlite", readSQLite},
+	{"Packages.db", readNDB},
+	{"Packages", readBerkeleyDB},
+}
+
+// DatabaseFiles returns the names of the database files of each supported
+// backend, in order of preference.
+func DatabaseFiles() []string {
+	var files []string
+	for _, backend := range backends {
+		files = append(files, backend.file)
+	}
+	return files
+}
+
+// macro files read by rpm, in the order they are loaded
+var macroFiles = []string{
+	"usr/lib/rpm/macros",
+	"usr/lib/rpm/macros.d/macros.*",
+	"etc/rpm/macros.*",
+	"etc/rpm/macros",
+}
+
+// well known database locations, used when the macros don't define one
+var defaultDBPaths = []string{
+	"/var/lib/rpm",
+	"/usr/lib/sysimage/rpm",
+}
+
+// FindDBPath returns the location of the rpm database in the filesystem
+// rooted at root. The location is taken from the %_dbpath macro defined in
+// the image, falling back to well known locations. Symlinks are resolved
+// within root, so the returned path is relative to root and can also be
+// used to look up the database in the individual layers of an image.
+func FindDBPath(root string) (string, error) {
+	candidates := defaultDBPaths
+	if dbPath, err := dbPathFromMacros(root); err != nil {
+		logrus.Debugf("Couldn't read %%_dbpath macro: %s", err)
+	} else if dbPath != "" {
+		candidates = append([]string{dbPath}, candidates...)
+	}
+
+	for _, candidate := range candidates {
+		resolved, err := resolveInRoot(root, candidate)
+		if err != nil {
+			continue
+		}
+		for _, backend := range backends {
+			if _, err := os.Stat(filepath.Join(root, resolved, backend.file)); err == nil {
+				return resolved, nil
+			}
+		}
+	}
+	return "", ErrNoDatabase
+}
+
+// ReadPackages returns the packages stored in the rpm database found at
+// dbPath in the filesystem rooted at root. If the directory holds databases
+// of several backends, the most recent backend is used.
+func ReadPackages(root, dbPath string) ([]Package, error) {
+	for _, backend := range backends {
+		path := filepath.Join(root, dbPath, backend.file)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		logrus.Debugf("Reading rpm database %s", path)
+		blobs, err := backend.read(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading rpm database %s: %s", path, err)
+		}
+		var packages []Package
+		for _, blob := range blobs {
+			pkg, err := parseHeader(blob)
+			if err != nil {
+				logrus.Warnf("Skipping invalid rpm header in %s: %s", path, err)
+				continue
+			}
+			packages = append(packages, pkg)
+		}
+		return packages, nil
+	}
+	return nil, ErrNoDatabase
+}
+
+var macroRegex = regexp.MustCompile(`%(\{[?!]*[A-Za-z_][A-Za-z0-9_]*\}|[A-Za-z_][A-Za-z0-9_]*)`)
+
+// dbPathFromMacros loads the macro files found in the image and returns
+// the expansion of the %_dbpath macro, or "" if it is not defined.
+func dbPathFromMacros(root string) (string, error) {
+	macros := map[string]string{}
+	for _, pattern := range macroFiles {
+		files, err := filepath.Glob(filepath.Join(root, pattern))
+		if err != nil {
+			return "", err
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			if err := loadMacros(file, macros); err != nil {
+				logrus.Debugf("Couldn't read rpm macros %s: %s", file, err)
+			}
+		}
+	}
+	dbPath, ok := macros["_dbpath"]
+	if !ok {
+		return "", nil
+	}
+	return expandMacros(dbPath, macros, 0)
+}
+
+// loadMacros reads the simple, non-parametric macro definitions of a macro
+// file into macros, overriding earlier definitions.
+func loadMacros(path string, macros map[string]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		for strings.HasSuffix(line, "\\") && scanner.Scan() {
+			line = strings.TrimSuffix(line, "\\") + strings.TrimSpace(scanner.Text())
+		}
+		// We are looking for a macro definition like (from openSUSE Leap):
+		// %_dbpath                %{_usr}/lib/sysimage/rpm
+		if !strings.HasPrefix(line, "%") {
+			continue
+		}
+		fields := strings.Fields(line[1:])
+		if len(fields) < 2 || strings.Contains(fields[0], "(") {
+			continue
+		}
+		macros[fields[0]] = strings.Join(fields[1:], " ")
+	}
+	return scanner.Err()
+}
+
+// expandMacros substitutes %name, %{name} and %{?name} references in value.
+func expandMacros(value string, macros map[string]string, depth int) (string, error) {
+	if depth > 16 {
+		return "", fmt.Errorf("too many levels of macro recursion in %q", value)
+	}
+	var expandErr error
+	expanded := macroRegex.ReplaceAllStringFunc(value, func(ref string) string {
+		name := strings.Trim(ref, "%{}")
+		optional := strings.HasPrefix(name, "?")
+		name = strings.TrimLeft(name, "?!")
+		body, ok := macros[name]
+		if !ok {
+			if !optional && expandErr == nil {
+				expandErr = fmt.Errorf("undefined macro %%%s", name)
+			}
+			return ""
+		}
+		result, err := expandMacros(body, macros, depth+1)
+		if err != nil && expandErr == nil {
+			expandErr = err
+		}
+		return result
+	})
+	return expanded, expandErr
+}
+
+// resolveInRoot resolves symlinks in path as if root was the filesystem
+// root, and returns the resulting path relative to root.
+func resolveInRoot(root, path string) (string, error) {
+	resolved := "/"
+	remaining := strings.Split(filepath.Clean("/"+path), "/")
+	for links := 0; len(remaining) > 0; {
+		component := remaining[0]
+		remaining = remaining[1:]
+		if component == "" || component == "." {
+			continue
+		}
+		if component == ".." {
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, component)
+		info, err := os.Lstat(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > 40 {
+			return "", fmt.Errorf("too many levels of symbolic links in %s", path)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		remaining = append(strings.Split(target, "/"), remaining...)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rpmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// buildHeader creates a header blob holding the tags needed for a Package
+func buildHeader(name, version, release string, size int32) []byte {
+	var index, data bytes.Buffer
+	addEntry := func(tag int32, dataType uint32, value []byte) {
+		for dataType == typeInt32 && data.Len()%4 != 0 {
+			data.WriteByte(0)
+		}
+		binary.Write(&index, binary.BigEndian, headerEntry{Tag: tag, Type: dataType, Offset: int32(data.Len()), Count: 1})
+		data.Write(value)
+	}
+	addEntry(tagName, typeString, append([]byte(name), 0))
+	addEntry(tagVersion, typeString, append([]byte(version), 0))
+	addEntry(tagRelease, typeString, append([]byte(release), 0))
+	sizeBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(sizeBytes, uint32(size))
+	addEntry(tagSize, typeInt32, sizeBytes)
+
+	var blob bytes.Buffer
+	binary.Write(&blob, binary.BigEndian, uint32(index.Len()/headerEntrySize))
+	binary.Write(&blob, binary.BigEndian, uint32(data.Len()))
+	blob.Write(index.Bytes())
+	blob.Write(data.Bytes())
+	return blob.Bytes()
+}
+
+func TestParseHeader(t *testing.T) {
+	pkg, err := parseHeader(buildHeader("bash", "5.1.8", "6.el9", 7738634))
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	expected := Package{Name: "bash", Version: "5.1.8", Release: "6.el9", Size: 7738634}
+	if !reflect.DeepEqual(pkg, expected) {
+		t.Errorf("Expected: %v but got: %v", expected, pkg)
+	}
+
+	if _, err := parseHeader([]byte{0, 0, 0, 9, 0, 0, 0, 1}); err == nil {
+		t.Errorf("Expected error for truncated header but got none")
+	}
+}
+
+func TestReadSQLite(t *testing.T) {
+	packages, err := ReadPackages("testdata/sqlite", "/var/lib/rpm")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	// the fixture uses 512 byte pages, so the table spans interior pages
+	// and most headers spill onto overflow pages
+	if len(packages) != 40 {
+		t.Fatalf("Expected 40 packages but got %d", len(packages))
+	}
+	expected := Package{Name: "pkg39", Version: "1.39", Release: "39.el9", Arch: "x86_64", Size: 39000}
+	if !reflect.DeepEqual(packages[39], expected) {
+		t.Errorf("Expected: %v but got: %v", expected, packages[39])
+	}
+}
+
+const sqliteFixture = "testdata/sqlite/var/lib/rpm/rpmdb.sqlite"
+
+// readSQLiteData reads the packages of a database held in data, which must
+// not panic however data is corrupt.
+func readSQLiteData(data []byte) ([][]byte, error) {
+	db, err := newSQLiteDB(data, nil)
+	if err != nil {
+		return nil, err
+	}
+	return db.packageBlobs()
+}
+
+func TestReadSQLiteCorrupt(t *testing.T) {
+	data, err := ioutil.ReadFile(sqliteFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for size := 0; size < len(data); size += 97 {
+		if _, err := readSQLiteData(data[:size]); err == nil {
+			t.Errorf("Expected an error for a database truncated to %d bytes", size)
+		}
+	}
+	for offset := 0; offset < len(data); offset += 7 {
+		for _, b := range []byte{0x00, 0xff} {
+			corrupt := append([]byte{}, data...)
+			corrupt[offset] = b
+			readSQLiteData(corrupt)
+		}
+	}
+}
+
+func FuzzReadSQLite(f *testing.F) {
+	data, err := ioutil.ReadFile(sqliteFixture)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(data)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		readSQLiteData(data)
+	})
+}
+
+func TestReadNDB(t *testing.T) {
+	blob := buildHeader("zypper", "1.14.59", "150400.3.12.2", 8402322)
+	file := make([]byte, 2*ndbPageSize)
+	binary.LittleEndian.PutUint32(file[0:], ndbHeaderMagic)
+	binary.LittleEndian.PutUint32(file[12:], 1)
+	for offset := ndbHeaderSize; offset < ndbPageSize; offset += ndbSlotSize {
+		binary.LittleEndian.PutUint32(file[offset:], ndbSlotMagic)
+	}
+	// a single used slot pointing to a blob on the second page
+	binary.LittleEndian.PutUint32(file[ndbHeaderSize+4:], 1)
+	binary.LittleEndian.PutUint32(file[ndbHeaderSize+8:], ndbPageSize/ndbBlockSize)
+	binary.LittleEndian.PutUint32(file[ndbPageSize:], ndbBlobMagic)
+	binary.LittleEndian.PutUint32(file[ndbPageSize+4:], 1)
+	binary.LittleEndian.PutUint32(file[ndbPageSize+12:], uint32(len(blob)))
+	copy(file[ndbPageSize+ndbBlobHeadSize:], blob)
+
+	root := writeDatabase(t, "Packages.db", file)
+	packages, err := ReadPackages(root, "/var/lib/rpm")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	expected := []Package{{Name: "zypper", Version: "1.14.59", Release: "150400.3.12.2", Size: 8402322}}
+	if !reflect.DeepEqual(packages, expected) {
+		t.Errorf("Expected: %v but got: %v", expected, packages)
+	}
+}
+
+func TestReadBerkeleyDB(t *testing.T) {
+	const pageSize = 512
+	blob := buildHeader("glibc", "2.17", "326.el7_9", 14102498)
+	file := make([]byte, 3*pageSize)
+	binary.LittleEndian.PutUint32(file[12:], bdbHashMagic)
+	binary.LittleEndian.PutUint32(file[20:], pageSize)
+	binary.LittleEndian.PutUint32(file[32:], 2)
+
+	// page 1: a hash page with one key and one off-page value
+	hashPage := file[pageSize : 2*pageSize]
+	hashPage[25] = bdbPageTypeHash
+	binary.LittleEndian.PutUint16(hashPage[20:], 2)
+	binary.LittleEndian.PutUint16(hashPage[bdbPageHeaderSize:], 500)
+	binary.LittleEndian.PutUint16(hashPage[bdbPageHeaderSize+2:], 480)
+	hashPage[480] = bdbItemOffPage
+	binary.LittleEndian.PutUint32(hashPage[484:], 2)
+	binary.LittleEndian.PutUint32(hashPage[488:], uint32(len(blob)))
+
+	// page 2: the overflow page holding the header
+	overflowPage := file[2*pageSize:]
+	overflowPage[25] = bdbPageTypeOverflow
+	binary.LittleEndian.PutUint16(overflowPage[22:], uint16(len(blob)))
+	copy(overflowPage[bdbPageHeaderSize:], blob)
+
+	root := writeDatabase(t, "Packages", file)
+	packages, err := ReadPackages(root, "/var/lib/rpm")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	expected := []Package{{Name: "glibc", Version: "2.17", Release: "326.el7_9", Size: 14102498}}
+	if !reflect.DeepEqual(packages, expected) {
+		t.Errorf("Expected: %v but got: %v", expected, packages)
+	}
+}
+
+func TestFindDBPath(t *testing.T) {
+	root := t.TempDir()
+	macros := "%_usr\t\t\t/usr\n%_dbpath\t\t%{_usr}/lib/sysimage/rpm\n"
+	writeFile(t, filepath.Join(root, "usr/lib/rpm/macros"), []byte(macros))
+	writeFile(t, filepath.Join(root, "usr/lib/sysimage/rpm/rpmdb.sqlite"), nil)
+
+	dbPath, err := FindDBPath(root)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if dbPath != "/usr/lib/sysimage/rpm" {
+		t.Errorf("Expected: /usr/lib/sysimage/rpm but got: %s", dbPath)
+	}
+
+	// absolute symlinks must be resolved inside the image
+	if err := os.Symlink("/usr/lib/sysimage/rpm", filepath.Join(root, "rpm")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(root, "etc/rpm/macros.dbpath"), []byte("%_dbpath /rpm\n"))
+	dbPath, err = FindDBPath(root)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if dbPath != "/usr/lib/sysimage/rpm" {
+		t.Errorf("Expected: /usr/lib/sysimage/rpm but got: %s", dbPath)
+	}
+
+	if _, err := FindDBPath(t.TempDir()); err != ErrNoDatabase {
+		t.Errorf("Expected ErrNoDatabase but got: %v", err)
+	}
+}
+
+func writeDatabase(t *testing.T, name string, contents []byte) string {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "var/lib/rpm", name), contents)
+	return root
+}
+
+func writeFile(t *testing.T, path string, contents []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,404 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rpmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// SQLite file format constants, see https://www.sqlite.org/fileformat.html
+const (
+	sqliteMagic      = "SQLite format 3\x00"
+	sqliteHeaderSize = 100
+
+	sqliteInteriorTable = 0x05
+	sqliteLeafTable     = 0x0d
+
+	sqliteWALHeaderSize      = 32
+	sqliteWALFrameHeaderSize = 24
+
+	// the smallest usable size of a page allowed by SQLite
+	sqliteMinUsableSize = 480
+
+	// rpm's sqlite backend stores headers in this table, keyed by hnum
+	sqlitePackagesTable = "Packages"
+)
+
+// sqliteDB is a minimal, read-only reader for SQLite databases. It supports
+// exactly what is needed to dump a table: walking table b-trees, following
+// overflow pages and decoding records. Pages from a write-ahead log are
+// applied on top of the database file, up to the last committed transaction.
+// A truncated or corrupt database fails with an error.
+type sqliteDB struct {
+	data       []byte
+	pageSize   int
+	usableSize int
+	walPages   map[uint32][]byte
+}
+
+// readSQLite returns every header blob stored in the Packages table of an
+// rpm sqlite database, the format used by Fedora and RHEL since rpm 4.16.
+func readSQLite(path string) ([][]byte, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	return db.packageBlobs()
+}
+
+// packageBlobs returns every header blob stored in the Packages table.
+func (db *sqliteDB) packageBlobs() ([][]byte, error) {
+	rootPage, err := db.tableRootPage(sqlitePackagesTable)
+	if err != nil {
+		return nil, err
+	}
+	var blobs [][]byte
+	err = db.walkTable(rootPage, map[uint32]bool{}, func(record []interface{}) error {
+		// Packages(hnum INTEGER PRIMARY KEY, blob BLOB NOT NULL)
+		if len(record) < 2 {
+			return fmt.Errorf("unexpected %s record with %d columns", sqlitePackagesTable, len(record))
+		}
+		blob, ok := record[1].([]byte)
+		if !ok {
+			return fmt.Errorf("unexpected %s record: blob column is %T", sqlitePackagesTable, record[1])
+		}
+		blobs = append(blobs, blob)
+		return nil
+	})
+	return blobs, err
+}
+
+func openSQLite(path string) (*sqliteDB, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	wal, err := ioutil.ReadFile(path + "-wal")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	db, err := newSQLiteDB(data, wal)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return db, nil
+}
+
+// newSQLiteDB reads a database from the contents of its file and of its
+// write-ahead log, which may be empty.
+func newSQLiteDB(data, wal []byte) (*sqliteDB, error) {
+	if len(data) < sqliteHeaderSize || string(data[:len(sqliteMagic)]) != sqliteMagic {
+		return nil, fmt.Errorf("not a SQLite database")
+	}
+	pageSize := int(binary.BigEndian.Uint16(data[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return nil, fmt.Errorf("invalid SQLite page size %d", pageSize)
+	}
+	usableSize := pageSize - int(data[20])
+	if usableSize < sqliteMinUsableSize {
+		return nil, fmt.Errorf("invalid SQLite usable page size %d", usableSize)
+	}
+	return &sqliteDB{
+		data:       data,
+		pageSize:   pageSize,
+		usableSize: usableSize,
+		walPages:   parseSQLiteWAL(wal, pageSize),
+	}, nil
+}
+
+// parseSQLiteWAL returns the most recent committed version of every page
+// found in a write-ahead log. Frame checksums are not verified; frames are
+// only trusted when their salt matches the log header.
+func parseSQLiteWAL(wal []byte, pageSize int) map[uint32][]byte {
+	pages := map[uint32][]byte{}
+	if len(wal) < sqliteWALHeaderSize || int(binary.BigEndian.Uint32(wal[8:12])) != pageSize {
+		return pages
+	}
+	salt := wal[16:24]
+	pending := map[uint32][]byte{}
+	frameSize := sqliteWALFrameHeaderSize + pageSize
+	for offset := sqliteWALHeaderSize; offset+frameSize <= len(wal); offset += frameSize {
+		frame := wal[offset : offset+frameSize]
+		if !bytes.Equal(frame[8:16], salt) {
+			break
+		}
+		pending[binary.BigEndian.Uint32(frame[0:4])] = frame[sqliteWALFrameHeaderSize:]
+		// a non-zero database size marks the last frame of a transaction
+		if binary.BigEndian.Uint32(frame[4:8]) != 0 {
+			for pgno, page := range pending {
+				pages[pgno] = page
+			}
+			pending = map[uint32][]byte{}
+		}
+	}
+	return pages
+}
+
+func (db *sqliteDB) page(pgno uint32) ([]byte, error) {
+	if page, ok := db.walPages[pgno]; ok {
+		return page, nil
+	}
+	start := int(pgno-1) * db.pageSize
+	if pgno == 0 || start+db.pageSize > len(db.data) {
+		return nil, fmt.Errorf("SQLite page %d out of range", pgno)
+	}
+	return db.data[start : start+db.pageSize], nil
+}
+
+// tableRootPage looks up the root page of a table in the sqlite_master
+// table, which is always rooted at page 1.
+func (db *sqliteDB) tableRootPage(table string) (uint32, error) {
+	var rootPage uint32
+	err := db.walkTable(1, map[uint32]bool{}, func(record []interface{}) error {
+		// sqlite_master(type, name, tbl_name, rootpage, sql)
+		if len(record) < 4 || rootPage != 0 {
+			return nil
+		}
+		if kind, _ := record[0].(string); kind != "table" {
+			return nil
+		}
+		if name, _ := record[1].(string); name != table {
+			return nil
+		}
+		if page, ok := record[3].(int64); ok {
+			rootPage = uint32(page)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if rootPage == 0 {
+		return 0, fmt.Errorf("table %s not found in SQLite database", table)
+	}
+	return rootPage, nil
+}
+
+// walkTable calls fn with the decoded record of every row stored in the
+// table b-tree rooted at pgno. visited holds the pages walked so far, as a
+// page referenced twice can only be found in a corrupt database.
+func (db *sqliteDB) walkTable(pgno uint32, visited map[uint32]bool, fn func([]interface{}) error) error {
+	if visited[pgno] {
+		return fmt.Errorf("SQLite page %d referenced twice", pgno)
+	}
+	visited[pgno] = true
+	page, err := db.page(pgno)
+	if err != nil {
+		return err
+	}
+	headerOffset := 0
+	if pgno == 1 {
+		headerOffset = sqliteHeaderSize
+	}
+	header := page[headerOffset:]
+	cellCount := int(binary.BigEndian.Uint16(header[3:5]))
+	headerSize := 8
+	if header[0] == sqliteInteriorTable {
+		headerSize = 12
+	}
+	if headerSize+cellCount*2 > len(header) {
+		return fmt.Errorf("invalid SQLite cell count %d on page %d", cellCount, pgno)
+	}
+	cellPointers := header[headerSize:]
+
+	switch header[0] {
+	case sqliteInteriorTable:
+		for i := 0; i < cellCount; i++ {
+			cell := int(binary.BigEndian.Uint16(cellPointers[i*2:]))
+			if cell+4 > len(page) {
+				return fmt.Errorf("invalid SQLite cell pointer on page %d", pgno)
+			}
+			if err := db.walkTable(binary.BigEndian.Uint32(page[cell:]), visited, fn); err != nil {
+				return err
+			}
+		}
+		return db.walkTable(binary.BigEndian.Uint32(header[8:12]), visited, fn)
+	case sqliteLeafTable:
+		for i := 0; i < cellCount; i++ {
+			cell := int(binary.BigEndian.Uint16(cellPointers[i*2:]))
+			if cell >= len(page) {
+				return fmt.Errorf("invalid SQLite cell pointer on page %d", pgno)
+			}
+			payload, err := db.leafPayload(page[cell:])
+			if err != nil {
+				return err
+			}
+			record, err := decodeSQLiteRecord(payload)
+			if err != nil {
+				return err
+			}
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unexpected SQLite page type %#x on page %d", header[0], pgno)
+	}
+}
+
+// leafPayload returns the full payload of a table leaf cell, reading the
+// overflow pages the payload spills onto if necessary.
+func (db *sqliteDB) leafPayload(cell []byte) ([]byte, error) {
+	payloadSize, n := sqliteVarint(cell)
+	cell = cell[n:]
+	_, n = sqliteVarint(cell) // rowid
+	cell = cell[n:]
+
+	// a payload can't be larger than the database holding it
+	if n == 0 || payloadSize > uint64(len(db.data)+len(db.walPages)*db.pageSize) {
+		return nil, fmt.Errorf("invalid SQLite cell payload size %d", payloadSize)
+	}
+	size := int(payloadSize)
+	local := db.localPayloadSize(size)
+	if local > len(cell) {
+		return nil, fmt.Errorf("SQLite cell payload exceeds page")
+	}
+	payload := make([]byte, 0, size)
+	payload = append(payload, cell[:local]...)
+	if local == size {
+		return payload, nil
+	}
+	if local+4 > len(cell) {
+		return nil, fmt.Errorf("SQLite cell is missing its overflow page")
+	}
+	next := binary.BigEndian.Uint32(cell[local:])
+	for next != 0 && len(payload) < size {
+		page, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+		chunk := page[4:db.usableSize]
+		if remaining := size - len(payload); remaining < len(chunk) {
+			chunk = chunk[:remaining]
+		}
+		payload = append(payload, chunk...)
+		next = binary.BigEndian.Uint32(page[0:4])
+	}
+	if len(payload) != size {
+		return nil, fmt.Errorf("truncated SQLite overflow chain: read %d of %d bytes", len(payload), size)
+	}
+	return payload, nil
+}
+
+// localPayloadSize computes how much of a table leaf payload is stored on
+// the b-tree page itself, as described in the SQLite file format.
+func (db *sqliteDB) localPayloadSize(size int) int {
+	maxLocal := db.usableSize - 35
+	if size <= maxLocal {
+		return size
+	}
+	minLocal := (db.usableSize-12)*32/255 - 23
+	local := minLocal + (size-minLocal)%(db.usableSize-4)
+	if local > maxLocal {
+		return minLocal
+	}
+	return local
+}
+
+// decodeSQLiteRecord decodes a record into its column values, which are
+// nil, int64, float64 (returned as raw bits), string or []byte.
+func decodeSQLiteRecord(payload []byte) ([]interface{}, error) {
+	headerSize, n := sqliteVarint(payload)
+	if n == 0 || headerSize < uint64(n) || headerSize > uint64(len(payload)) {
+		return nil, fmt.Errorf("invalid SQLite record header")
+	}
+	header := payload[n:headerSize]
+	body := payload[headerSize:]
+
+	var values []interface{}
+	for len(header) > 0 {
+		serialType, n := sqliteVarint(header)
+		if n == 0 {
+			return nil, fmt.Errorf("invalid SQLite record header")
+		}
+		header = header[n:]
+
+		var size int
+		switch {
+		case serialType == 0, serialType == 8, serialType == 9:
+			size = 0
+		case serialType <= 4:
+			size = int(serialType)
+		case serialType == 5:
+			size = 6
+		case serialType == 6, serialType == 7:
+			size = 8
+		case serialType >= 12:
+			if (serialType-12)/2 > uint64(len(body)) {
+				return nil, fmt.Errorf("SQLite record value exceeds payload")
+			}
+			size = int(serialType-12) / 2
+		default:
+			return nil, fmt.Errorf("invalid SQLite serial type %d", serialType)
+		}
+		if size > len(body) {
+			return nil, fmt.Errorf("SQLite record value exceeds payload")
+		}
+		value := body[:size]
+		body = body[size:]
+
+		switch {
+		case serialType == 0:
+			values = append(values, nil)
+		case serialType == 8:
+			values = append(values, int64(0))
+		case serialType == 9:
+			values = append(values, int64(1))
+		case serialType <= 7:
+			var v int64
+			if size > 0 && value[0]&0x80 != 0 && serialType != 7 {
+				v = -1
+			}
+			for _, b := range value {
+				v = v<<8 | int64(b)
+			}
+			values = append(values, v)
+		case serialType%2 == 0:
+			values = append(values, value)
+		default:
+			values = append(values, string(value))
+		}
+	}
+	return values, nil
+}
+
+// sqliteVarint decodes a SQLite variable-length integer and returns it
+// along with the number of bytes read, or 0 if buf is too short.
+func sqliteVarint(buf []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(buf) {
+			return 0, 0
+		}
+		if i == 8 {
+			return v<<8 | uint64(buf[i]), 9
+		}
+		v = v<<7 | uint64(buf[i]&0x7f)
+		if buf[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return v, 9
+}
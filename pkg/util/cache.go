@@ -0,0 +1,599 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/nightlyone/lockfile"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Kinds of cache entry
+const (
+	CacheKindLayer = "layer"
+	CacheKindImage = "image"
+)
+
+const (
+	// cacheMarkerFile is written once an entry is completely unpacked
+	cacheMarkerFile = "entry.json"
+	cacheFSDir      = "fs"
+	cacheLockSuffix = ".lock"
+	// cacheUseSuffix names the file runs hold a shared lock on while they
+	// use an entry
+	cacheUseSuffix = ".use"
+
+	cacheLockRetryInterval = 500 * time.Millisecond
+)
+
+// ErrCacheEntryBusy is returned when a cache entry is locked by another
+// container-diff process or go-routine.
+var ErrCacheEntryBusy = errors.New("cache entry is in use")
+
+// cacheMutexes holds a mutex per cache entry. Like daemonMutex, they protect
+// against other go-routines, as lockfile locks are held per process.
+var cacheMutexes sync.Map
+
+// Cache is a content-addressed store of unpacked filesystems, shared by all
+// images: layers are keyed by their digest and flattened image filesystems
+// by the image digest. An entry only counts as cached once its completion
+// marker is written, and each entry is locked while it is unpacked, so
+// concurrent runs never use a partial filesystem. The entries handed out are
+// held until Release, so that no process removes them while they are used.
+type Cache struct {
+	// Root is the directory holding the cache entries
+	Root string
+	// MaxSize bounds the total size of the entries in bytes. Least recently
+	// used entries are evicted beyond it. Zero means unbounded.
+	MaxSize int64
+
+	mu    sync.Mutex
+	holds []*os.File
+}
+
+// CacheEntry describes an entry of the cache, as recorded by its completion
+// marker.
+type CacheEntry struct {
+	Kind   string
+	Digest string
+	// Size is the total size of the files in the unpacked filesystem
+	Size  int64
+	Files int
+	// TreeDigest hashes the paths, modes and contents of the filesystem
+	TreeDigest string
+	Created    time.Time
+	// LastUsed is read from the modification time of the marker
+	LastUsed time.Time `json:"-"`
+	// Complete is false for an entry that is being, or failed to be, unpacked
+	Complete bool `json:"-"`
+
+	dir string
+}
+
+// FSPath returns the path of the unpacked filesystem of the entry.
+func (e CacheEntry) FSPath() string {
+	return filepath.Join(e.dir, cacheFSDir)
+}
+
+// GetLayer returns the path of the unpacked filesystem of layer, unpacking
+// it into the cache first if needed, along with the deletions it marks.
+func (c *Cache) GetLayer(layer v1.Layer) (string, []Whiteout, error) {
+	return c.GetLayerContext(context.Background(), layer)
+}
+
+// GetLayerContext is GetLayer, but gives up waiting for or unpacking the
+// entry once ctx is done, leaving no partial entry behind.
+func (c *Cache) GetLayerContext(ctx context.Context, layer v1.Layer) (string, []Whiteout, error) {
+	digest, err := layer.Digest()
+	if err != nil {
+		return "", nil, errors.Wrap(err, "getting layer digest")
+	}
+	path, err := c.get(ctx, CacheKindLayer, digest.String(), func(root string, digests map[string]string) error {
+		contents, err := layer.Uncompressed()
+		if err != nil {
+			return err
+		}
+		defer contents.Close()
+		// cached filesystems are shared by runs with different path
+		// filters, so they are always unpacked whole
+		whiteouts, err := unpackTar(tar.NewReader(newContextReader(ctx, contents)), root, nil, nil, digests)
+		if err != nil {
+			return err
+		}
+		return writeWhiteouts(root, whiteouts)
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	whiteouts, err := readWhiteouts(path)
+	return path, whiteouts, err
+}
+
+// GetImage returns the path of the flattened filesystem of image, unpacking
+// it into the cache first if needed.
+func (c *Cache) GetImage(image v1.Image) (string, error) {
+	return c.GetImageContext(context.Background(), image)
+}
+
+// GetImageContext is GetImage, but gives up waiting for or unpacking the
+// entry once ctx is done, leaving no partial entry behind.
+func (c *Cache) GetImageContext(ctx context.Context, image v1.Image) (string, error) {
+	digest, err := image.Digest()
+	if err != nil {
+		return "", errors.Wrap(err, "getting image digest")
+	}
+	return c.get(ctx, CacheKindImage, digest.String(), func(root string, digests map[string]string) error {
+		// mutate.Extract applies the whiteouts of each layer, so none are
+		// left in the flattened filesystem
+		contents := mutate.Extract(image)
+		defer contents.Close()
+		_, err := unpackTar(tar.NewReader(newContextReader(ctx, contents)), root, nil, nil, digests)
+		return err
+	})
+}
+
+// get returns the filesystem of the entry for digest, calling unpack to fill
+// it unless a complete entry exists. unpack records the digests of the files
+// it writes, so that they aren't read again to hash the entry. The entry is
+// held before its lock is released.
+func (c *Cache) get(ctx context.Context, kind, digest string, unpack func(root string, digests map[string]string) error) (string, error) {
+	dir := c.entryDir(kind, digest)
+	unlock, err := c.lock(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	entry, err := readCacheEntry(dir)
+	if err == nil && entry.Complete {
+		if err := c.hold(dir); err != nil {
+			return "", err
+		}
+		logrus.Infof("using cached filesystem in %s", entry.FSPath())
+		now := time.Now()
+		if err := os.Chtimes(filepath.Join(dir, cacheMarkerFile), now, now); err != nil {
+			logrus.Warnf("updating last use of cache entry %s: %s", dir, err)
+		}
+		return entry.FSPath(), nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		logrus.Warnf("discarding unreadable cache entry %s: %s", dir, err)
+	}
+
+	// anything left without a marker is a partial filesystem, start over
+	if err := os.RemoveAll(dir); err != nil {
+		return "", errors.Wrapf(err, "removing partial cache entry %s", dir)
+	}
+	root := filepath.Join(dir, cacheFSDir)
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return "", err
+	}
+	logrus.Infof("caching filesystem at %s", root)
+	digests := map[string]string{}
+	if err := unpack(root, digests); err != nil {
+		if err := os.RemoveAll(dir); err != nil {
+			logrus.Warnf("removing partial cache entry %s: %s", dir, err)
+		}
+		return "", err
+	}
+	entry = CacheEntry{
+		Kind:    kind,
+		Digest:  digest,
+		Created: time.Now(),
+		dir:     dir,
+	}
+	hash, err := hashTree(root, digests)
+	if err != nil {
+		return "", errors.Wrapf(err, "hashing cache entry %s", dir)
+	}
+	entry.TreeDigest, entry.Size, entry.Files = hash.Digest, hash.Size, hash.Files
+	// the file digests are kept for the analyzers recording them
+	if err := writeDigests(root, hash.FileDigests); err != nil {
+		return "", errors.Wrapf(err, "writing digests of cache entry %s", dir)
+	}
+	if err := writeCacheEntry(dir, entry); err != nil {
+		return "", errors.Wrapf(err, "writing cache entry %s", dir)
+	}
+	if err := c.hold(dir); err != nil {
+		return "", err
+	}
+	return root, nil
+}
+
+// hold takes a shared lock on the entry at dir until Release. Remove needs
+// an exclusive one, so neither this nor another process removes the entry
+// in the meantime. Callers hold the lock of the entry, which Remove takes
+// first, so this never waits.
+func (c *Cache) hold(dir string) error {
+	use, err := os.OpenFile(dir+cacheUseSuffix, os.O_RDONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "holding cache entry %s", dir)
+	}
+	if err := lockFile(use, false); err != nil {
+		use.Close()
+		return errors.Wrapf(err, "holding cache entry %s", dir)
+	}
+	c.mu.Lock()
+	c.holds = append(c.holds, use)
+	c.mu.Unlock()
+	return nil
+}
+
+// Release drops the holds on the entries handed out so far, once the run
+// using them is done, so that they can be evicted again.
+func (c *Cache) Release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, use := range c.holds {
+		if err := use.Close(); err != nil {
+			logrus.Warnf("releasing cache entry %s: %s", strings.TrimSuffix(use.Name(), cacheUseSuffix), err)
+		}
+	}
+	c.holds = nil
+}
+
+// Entries lists the entries of the cache, most recently used first.
+// Incomplete entries are listed last.
+func (c *Cache) Entries() ([]CacheEntry, error) {
+	var entries []CacheEntry
+	for _, kind := range []string{CacheKindLayer, CacheKindImage} {
+		kindDir := filepath.Join(c.Root, kind+"s")
+		files, err := ioutil.ReadDir(kindDir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if !file.IsDir() {
+				continue
+			}
+			dir := filepath.Join(kindDir, file.Name())
+			entry, err := readCacheEntry(dir)
+			if err != nil {
+				if !os.IsNotExist(err) {
+					logrus.Warnf("reading cache entry %s: %s", dir, err)
+				}
+				entry = CacheEntry{
+					Kind:   kind,
+					Digest: strings.Replace(file.Name(), "-", ":", 1),
+					dir:    dir,
+				}
+			}
+			entries = append(entries, entry)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Complete != entries[j].Complete {
+			return entries[i].Complete
+		}
+		return entries[i].LastUsed.After(entries[j].LastUsed)
+	})
+	return entries, nil
+}
+
+// Size returns the total size of the complete entries of the cache.
+func (c *Cache) Size() (int64, error) {
+	entries, err := c.Entries()
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, entry := range entries {
+		size += entry.Size
+	}
+	return size, nil
+}
+
+// Verify checks that the filesystem of a complete entry still matches the
+// digest recorded when it was unpacked.
+func (c *Cache) Verify(entry CacheEntry) error {
+	if !entry.Complete {
+		return errors.New("entry is incomplete")
+	}
+	hash, err := hashTree(entry.FSPath(), nil)
+	if err != nil {
+		return err
+	}
+	if hash.Digest != entry.TreeDigest {
+		return fmt.Errorf("filesystem has digest %s (%d files, %d bytes), expected %s (%d files, %d bytes)",
+			hash.Digest, hash.Files, hash.Size, entry.TreeDigest, entry.Files, entry.Size)
+	}
+	return nil
+}
+
+// Remove deletes an entry from the cache, unless a run holds it or another
+// process is unpacking it.
+func (c *Cache) Remove(entry CacheEntry) error {
+	unlock, err := c.tryLock(entry.dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	use, err := os.OpenFile(entry.dir+cacheUseSuffix, os.O_RDONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if err := lockFile(use, true); err != nil {
+		use.Close()
+		if err == ErrCacheEntryBusy {
+			return err
+		}
+		return errors.Wrapf(err, "locking cache entry %s", entry.dir)
+	}
+	if err := os.RemoveAll(entry.dir); err != nil {
+		use.Close()
+		return err
+	}
+	// Windows can't remove open files. No run can hold the entry again
+	// before the file is removed, as that needs the entry lock.
+	if err := use.Close(); err != nil {
+		return err
+	}
+	return os.Remove(use.Name())
+}
+
+// Prune removes incomplete entries left by interrupted runs, then the least
+// recently used entries until the cache fits in maxSize bytes, or all of them
+// if maxSize is zero. Entries held by a run of any process, or being unpacked,
+// are kept. It returns the entries removed.
+func (c *Cache) Prune(maxSize int64) ([]CacheEntry, error) {
+	entries, err := c.Entries()
+	if err != nil {
+		return nil, err
+	}
+	var size int64
+	for _, entry := range entries {
+		size += entry.Size
+	}
+
+	var removed []CacheEntry
+	// least recently used entries, then incomplete ones, are at the end
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.Complete && maxSize > 0 && size <= maxSize {
+			break
+		}
+		if err := c.Remove(entry); err != nil {
+			if err == ErrCacheEntryBusy {
+				logrus.Debugf("keeping cache entry %s: %s", entry.dir, err)
+				continue
+			}
+			return removed, errors.Wrapf(err, "removing cache entry %s", entry.dir)
+		}
+		logrus.Infof("removed cache entry %s", entry.dir)
+		size -= entry.Size
+		removed = append(removed, entry)
+	}
+	if size > maxSize {
+		logrus.Warnf("cache size %d bytes exceeds limit of %d bytes, the remaining entries are in use", size, maxSize)
+	}
+	return removed, nil
+}
+
+// Evict removes least recently used entries beyond MaxSize, if set.
+func (c *Cache) Evict() error {
+	if c.MaxSize <= 0 {
+		return nil
+	}
+	_, err := c.Prune(c.MaxSize)
+	return err
+}
+
+func (c *Cache) entryDir(kind, digest string) string {
+	return filepath.Join(c.Root, kind+"s", strings.Replace(digest, ":", "-", 1))
+}
+
+// lock takes the lock of the entry at dir, waiting for other processes and
+// go-routines to release it, or for ctx to be done.
+func (c *Cache) lock(ctx context.Context, dir string) (func(), error) {
+	waiting := false
+	for {
+		unlock, err := c.tryLock(dir)
+		if err != ErrCacheEntryBusy {
+			return unlock, err
+		}
+		if !waiting {
+			logrus.Infof("waiting for cache entry %s to be released", dir)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(cacheLockRetryInterval):
+		}
+	}
+}
+
+// tryLock takes the lock of the entry at dir, or returns ErrCacheEntryBusy
+// if another process or go-routine holds it.
+func (c *Cache) tryLock(dir string) (func(), error) {
+	m, _ := cacheMutexes.LoadOrStore(dir, &sync.Mutex{})
+	mutex := m.(*sync.Mutex)
+	if !mutex.TryLock() {
+		return nil, ErrCacheEntryBusy
+	}
+
+	lockPath, err := filepath.Abs(dir + cacheLockSuffix)
+	if err != nil {
+		mutex.Unlock()
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0700); err != nil {
+		mutex.Unlock()
+		return nil, err
+	}
+	lock, err := lockfile.New(lockPath)
+	if err != nil {
+		mutex.Unlock()
+		return nil, err
+	}
+	if err := lock.TryLock(); err != nil {
+		mutex.Unlock()
+		if _, ok := err.(lockfile.TemporaryError); ok {
+			return nil, ErrCacheEntryBusy
+		}
+		return nil, errors.Wrapf(err, "locking cache entry %s", dir)
+	}
+	return func() {
+		if err := lock.Unlock(); err != nil {
+			logrus.Warnf("unlocking cache entry %s: %s", dir, err)
+		}
+		mutex.Unlock()
+	}, nil
+}
+
+func readCacheEntry(dir string) (CacheEntry, error) {
+	marker := filepath.Join(dir, cacheMarkerFile)
+	contents, err := ioutil.ReadFile(marker)
+	if err != nil {
+		return CacheEntry{}, err
+	}
+	info, err := os.Stat(marker)
+	if err != nil {
+		return CacheEntry{}, err
+	}
+	var entry CacheEntry
+	if err := json.Unmarshal(contents, &entry); err != nil {
+		return CacheEntry{}, err
+	}
+	entry.LastUsed = info.ModTime()
+	entry.Complete = true
+	entry.dir = dir
+	return entry, nil
+}
+
+// writeCacheEntry writes the completion marker of an entry atomically, so
+// an interrupted run never leaves a marker behind.
+func writeCacheEntry(dir string, entry CacheEntry) error {
+	contents, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, cacheMarkerFile)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, cacheMarkerFile))
+}
+
+// treeHash summarises an unpacked filesystem
+type treeHash struct {
+	// Digest hashes the paths, modes, link targets and contents of the files
+	Digest string
+	Size   int64
+	Files  int
+	// FileDigests maps the readable regular files, by path from the root,
+	// to the digests of their contents
+	FileDigests map[string]string
+}
+
+// hashTree hashes the filesystem at root. The contents of the files listed
+// in digests, keyed by their path from root, aren't read again.
+func hashTree(root string, digests map[string]string) (treeHash, error) {
+	tree := sha256.New()
+	hash := treeHash{FileDigests: map[string]string{}}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsPermission(err) {
+				// unpacked files keep the permissions of the image
+				fmt.Fprintf(tree, "%s\x00unreadable\n", path)
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tree, "%s\x00%s\x00", filepath.ToSlash(rel), info.Mode())
+		switch {
+		case info.Mode().IsRegular():
+			hash.Files++
+			f, err := os.Open(path)
+			if os.IsPermission(err) {
+				fmt.Fprintf(tree, "%d\x00unreadable\n", info.Size())
+				hash.Size += info.Size()
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			name := "/" + filepath.ToSlash(rel)
+			digest, ok := digests[name]
+			size := info.Size()
+			if !ok {
+				content := sha256.New()
+				if size, err = io.Copy(content, f); err != nil {
+					return err
+				}
+				digest = "sha256:" + hex.EncodeToString(content.Sum(nil))
+			}
+			fmt.Fprintf(tree, "%d\x00%s\n", size, strings.TrimPrefix(digest, "sha256:"))
+			hash.Size += size
+			hash.FileDigests[name] = digest
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(tree, "%s\n", target)
+			hash.Files++
+		default:
+			fmt.Fprint(tree, "\n")
+		}
+		return nil
+	})
+	if err != nil {
+		return treeHash{}, err
+	}
+	hash.Digest = "sha256:" + hex.EncodeToString(tree.Sum(nil))
+	return hash, nil
+}
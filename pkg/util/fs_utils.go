@@ -18,26 +18,46 @@ package util
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"io"
 	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/sirupsen/logrus"
 )
 
+// FSOptions configures what the filesystem analyzers look at and record.
+type FSOptions struct {
+	// Filter selects the paths looked at. If nil, every path is.
+	Filter *PathFilter
+	// Digests records the SHA-256 digest of every regular file.
+	Digests bool
+}
+
 // Directory stores a representation of a file directory.
 type Directory struct {
 	Root    string
 	Content []string
+	// Digests maps the regular files of Content to their SHA-256 digests,
+	// once recorded by HashDirectory
+	Digests map[string]string `json:",omitempty"`
 }
 
 type DirectoryEntry struct {
 	Name string
 	Size int64
+	// Digest is the SHA-256 digest of a regular file, only set when
+	// FSOptions.Digests is
+	Digest string `json:",omitempty"`
 }
 
 type DirectoryMetaEntry struct {
@@ -63,6 +83,45 @@ func GetSize(path string) int64 {
 	return stat.Size()
 }
 
+// GetFilteredSize returns the size of the entry at name in the filesystem
+// at root like GetSize, but leaves the paths filter drops out of the size of
+// a directory.
+func GetFilteredSize(root, name string, filter *PathFilter) int64 {
+	entryPath := filepath.Join(root, name)
+	if filter == nil {
+		return GetSize(entryPath)
+	}
+	stat, err := os.Lstat(entryPath)
+	if err != nil {
+		logrus.Errorf("Could not obtain size for %s: %s", entryPath, err)
+		return -1
+	}
+	if !stat.IsDir() {
+		return stat.Size()
+	}
+	var size int64
+	err = filepath.Walk(entryPath, func(currPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		currName := filepath.Join("/", name, strings.TrimPrefix(currPath, entryPath))
+		if info.IsDir() {
+			if currPath != entryPath && filter.SkipDir(currName) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filter.Keep(currName, false) {
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.Errorf("Could not obtain directory size for %s: %s", entryPath, err)
+	}
+	return size
+}
+
 // GetFileContents returns the contents of a file at the specified path
 func GetFileContents(path string) (*string, error) {
 	if _, err := os.Lstat(path); os.IsNotExist(err) {
@@ -95,13 +154,28 @@ func getDirectorySize(path string) (int64, error) {
 
 // GetDirectoryContents converts the directory starting at the provided path into a Directory struct.
 func GetDirectory(path string, deep bool) (Directory, error) {
+	return GetDirectoryContext(context.Background(), path, deep, nil)
+}
+
+// GetDirectoryContext is GetDirectory, but leaves out the paths filter drops
+// and stops walking the directory once ctx is done.
+func GetDirectoryContext(ctx context.Context, path string, deep bool, filter *PathFilter) (Directory, error) {
 	var directory Directory
 	directory.Root = path
 	var err error
 	if deep {
 		walkFn := func(currPath string, info os.FileInfo, err error) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			newContent := strings.TrimPrefix(currPath, directory.Root)
-			if newContent != "" {
+			if newContent == "" {
+				return nil
+			}
+			if info != nil && info.IsDir() && filter.SkipDir(newContent) {
+				return filepath.SkipDir
+			}
+			if filter.Keep(newContent, info != nil && info.IsDir()) {
 				directory.Content = append(directory.Content, newContent)
 			}
 			return nil
@@ -116,30 +190,216 @@ func GetDirectory(path string, deep bool) (Directory, error) {
 
 		for _, file := range contents {
 			fileName := "/" + file.Name()
-			directory.Content = append(directory.Content, fileName)
+			if filter.Keep(fileName, file.IsDir()) {
+				directory.Content = append(directory.Content, fileName)
+			}
 		}
 	}
 	return directory, err
 }
 
+// FilterDirectory returns d without the paths filter drops.
+func FilterDirectory(d Directory, filter *PathFilter) Directory {
+	if filter == nil {
+		return d
+	}
+	filtered := Directory{Root: d.Root, Digests: d.Digests}
+	for _, name := range d.Content {
+		dir := false
+		if filter.dirOnly {
+			info, err := os.Lstat(filepath.Join(d.Root, name))
+			dir = err == nil && info.IsDir()
+		}
+		if filter.Keep(name, dir) {
+			filtered.Content = append(filtered.Content, name)
+		}
+	}
+	return filtered
+}
+
+// GetDirectoryEntries returns the entries of every path of d.
 func GetDirectoryEntries(d Directory) []DirectoryEntry {
-	return CreateDirectoryEntries(d.Root, d.Content)
+	entries, _ := GetDirectoryEntriesContext(context.Background(), d, FSOptions{})
+	return entries
+}
+
+// GetDirectoryEntriesContext is GetDirectoryEntries with opts. When
+// opts.Digests is set, the regular files of d are hashed concurrently first,
+// until ctx is done.
+func GetDirectoryEntriesContext(ctx context.Context, d Directory, opts FSOptions) ([]DirectoryEntry, error) {
+	if opts.Digests && d.Digests == nil {
+		if err := HashDirectoryContext(ctx, &d); err != nil {
+			return nil, err
+		}
+	}
+	return d.CreateDirectoryEntries(d.Content, opts), nil
 }
 
 func CreateDirectoryEntries(root string, entryNames []string) (entries []DirectoryEntry) {
+	return Directory{Root: root}.CreateDirectoryEntries(entryNames, FSOptions{})
+}
+
+// CreateDirectoryEntries returns the entries of the given paths of d, with
+// the sizes of directories left without the paths opts.Filter drops. When
+// opts.Digests is set, the digests recorded in d are used if there are any.
+func (d Directory) CreateDirectoryEntries(entryNames []string, opts FSOptions) (entries []DirectoryEntry) {
 	for _, name := range entryNames {
-		entryPath := filepath.Join(root, name)
-		size := GetSize(entryPath)
+		size := GetFilteredSize(d.Root, name, opts.Filter)
 
 		entry := DirectoryEntry{
 			Name: name,
 			Size: size,
 		}
+		if opts.Digests {
+			digest, err := d.Digest(name)
+			if err != nil {
+				logrus.Errorf("Could not hash %s: %s", filepath.Join(d.Root, name), err)
+			}
+			entry.Digest = digest
+		}
 		entries = append(entries, entry)
 	}
 	return entries
 }
 
+// Digest returns the SHA-256 digest of the path name of d, or an empty
+// string if it isn't a regular file. The file is only read if its digest
+// wasn't recorded.
+func (d Directory) Digest(name string) (string, error) {
+	if digest, ok := d.Digests[name]; ok {
+		return digest, nil
+	}
+	path := filepath.Join(d.Root, name)
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", nil
+	}
+	return FileDigest(path)
+}
+
+// HashDirectory records the SHA-256 digests of the regular files of d in
+// d.Digests. The digests recorded when d was unpacked into the cache are
+// reused, and the other files are hashed several at once. Files that can't
+// be read are logged and left out.
+func HashDirectory(d *Directory) {
+	HashDirectoryContext(context.Background(), d)
+}
+
+// HashDirectoryContext is HashDirectory, but stops hashing once ctx is done,
+// returning its error and leaving d.Digests unset.
+func HashDirectoryContext(ctx context.Context, d *Directory) error {
+	recorded, err := readDigests(d.Root)
+	if err != nil {
+		logrus.Warnf("Could not read the recorded digests of %s: %s", d.Root, err)
+	}
+	names := make(chan string)
+	var mu sync.Mutex
+	digests := map[string]string{}
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				if ctx.Err() != nil {
+					continue
+				}
+				digest, err := d.Digest(name)
+				if err != nil {
+					logrus.Warnf("Could not hash %s: %s", filepath.Join(d.Root, name), err)
+					continue
+				}
+				if digest != "" {
+					mu.Lock()
+					digests[name] = digest
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, name := range d.Content {
+		if ctx.Err() != nil {
+			break
+		}
+		if digest, ok := recorded[name]; ok {
+			mu.Lock()
+			digests[name] = digest
+			mu.Unlock()
+			continue
+		}
+		names <- name
+	}
+	close(names)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	d.Digests = digests
+	return nil
+}
+
+// LoadDigests sets d.Digests to the digests recorded when d was unpacked into
+// the cache, unless d was hashed already. Files aren't read, so d.Digests is
+// left nil when nothing was recorded.
+func (d *Directory) LoadDigests() {
+	if d.Digests != nil {
+		return
+	}
+	recorded, err := readDigests(d.Root)
+	if err != nil {
+		logrus.Warnf("Could not read the recorded digests of %s: %s", d.Root, err)
+	}
+	d.Digests = recorded
+}
+
+// digestsPath is where the digests of the regular files of the filesystem
+// at root are recorded, next to it like its whiteouts.
+func digestsPath(root string) string {
+	return filepath.Clean(root) + ".digests.json"
+}
+
+// readDigests returns the digests recorded for the filesystem at root, keyed
+// by path from root, or nil if there are none.
+func readDigests(root string) (map[string]string, error) {
+	contents, err := ioutil.ReadFile(digestsPath(root))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var digests map[string]string
+	if err := json.Unmarshal(contents, &digests); err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
+
+func writeDigests(root string, digests map[string]string) error {
+	contents, err := json.Marshal(digests)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(digestsPath(root), contents, 0600)
+}
+
+// FileDigest returns the SHA-256 digest of the contents of the file at path.
+func FileDigest(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 func GetDirectoryMetaEntries(d Directory) ([]DirectoryMetaEntry, error) {
 	return CreateDirectoryMetaEntries(d.Root, d.Content)
 }
@@ -17,7 +17,8 @@ limitations under the License.
 package util
 
 import (
-	"archive/tar"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -44,21 +45,107 @@ import (
 const (
 	daemonPrefix = "daemon://"
 	remotePrefix = "remote://"
+	ociPrefix    = "oci://"
+	sbomPrefix   = "sbom://"
 
 	tagRegexStr = ".*:([^/]+$)"
 )
 
 type Layer struct {
-	FSPath string
-	Digest v1.Hash
+	FSPath    string
+	Index     *FileIndex
+	Digest    v1.Hash
+	Whiteouts []Whiteout
+}
+
+// Whiteout records a path deleted by a layer, as marked by an OCI whiteout
+// file. An opaque whiteout deletes the contents a directory had in the
+// layers below, but not the directory itself.
+type Whiteout struct {
+	Path   string
+	Opaque bool
+}
+
+// FilterWhiteouts returns whiteouts without the deletions of paths filter
+// drops. Only opaque whiteouts are known to be of directories.
+func FilterWhiteouts(whiteouts []Whiteout, filter *PathFilter) []Whiteout {
+	if filter == nil {
+		return whiteouts
+	}
+	var filtered []Whiteout
+	for _, w := range whiteouts {
+		if filter.Keep(w.Path, w.Opaque) {
+			filtered = append(filtered, w)
+		}
+	}
+	return filtered
+}
+
+// Removes returns whether the layer deletes path from the layers below it.
+func (l Layer) Removes(path string) bool {
+	for _, w := range l.Whiteouts {
+		if w.Opaque && filepath.Clean(path) == w.Path {
+			continue
+		}
+		if HasFilepathPrefix(path, w.Path) {
+			return true
+		}
+	}
+	return false
 }
 
 type Image struct {
 	Image  v1.Image
 	Source string
 	FSPath string
+	Index  *FileIndex
 	Digest v1.Hash
 	Layers []Layer
+	// SBOM holds the packages of an image given as an sbom:// source, which
+	// has no v1.Image or filesystem
+	SBOM *SBOM
+
+	// cache holds the cached filesystems of the image until ReleaseImage
+	cache *Cache
+}
+
+// ImageOptions controls how GetImageWithOptions makes the filesystems of an
+// image available to the analyzers.
+type ImageOptions struct {
+	// IncludeLayers also retrieves the filesystem of each layer
+	IncludeLayers bool
+	// CacheDir is the root of the Cache filesystems are unpacked into. Temp
+	// dirs are used if empty.
+	CacheDir string
+	// CacheMaxSize bounds the size of the cache in bytes, zero means unbounded
+	CacheMaxSize int64
+	// Extract unpacks the filesystems to disk
+	Extract bool
+	// Index streams the filesystems into in-memory FileIndexes
+	Index bool
+	// Platform selects an image from a multi-platform image index. If nil,
+	// the default platform of the image source is used.
+	Platform *v1.Platform
+	// Filter leaves the paths it drops out of the indexes, and skips
+	// extracting them unless ExtractAll is set. Filesystems unpacked into the
+	// cache are always extracted whole.
+	Filter *PathFilter
+	// ExtractAll extracts the paths Filter drops too, for analyzers that
+	// don't apply it
+	ExtractAll bool
+	// Keychain resolves the credentials for remote registries. If nil,
+	// authn.DefaultKeychain is used.
+	Keychain authn.Keychain
+	// TLS configures the TLS connections to remote registries
+	TLS TLSOptions
+}
+
+// extractFilter returns the filter to extract the filesystems with.
+func (opts ImageOptions) extractFilter() *PathFilter {
+	if opts.ExtractAll {
+		return nil
+	}
+	return opts.Filter
 }
 
 type ImageHistoryItem struct {
@@ -75,6 +162,159 @@ func GetImageForName(imageName string) (Image, error) {
 // Once a reference is obtained, it attempts to unpack the v1.Image's reader's contents
 // into a temp directory on the local filesystem.
 func GetImage(imageName string, includeLayers bool, cacheDir string) (Image, error) {
+	return GetImageWithOptions(imageName, ImageOptions{
+		IncludeLayers: includeLayers,
+		CacheDir:      cacheDir,
+		Extract:       true,
+	})
+}
+
+// GetImageWithOptions retrieves an image like GetImage, but lets the caller
+// choose whether its filesystems are unpacked to disk, indexed in memory
+// straight from the streaming tars, or both.
+func GetImageWithOptions(imageName string, opts ImageOptions) (Image, error) {
+	return GetImageContext(context.Background(), imageName, opts)
+}
+
+// GetImageContext retrieves an image like GetImageWithOptions, and stops
+// fetching, extracting and indexing it once ctx is done. As on any other
+// error, the filesystems unpacked so far are returned for cleanup. Cached
+// filesystems are held until ReleaseImage is called on the image, and
+// released right away on error.
+func GetImageContext(ctx context.Context, imageName string, opts ImageOptions) (_ Image, err error) {
+	if IsSBOM(imageName) {
+		return getSBOMImage(imageName)
+	}
+	img, imageName, err := retrieveImage(ctx, imageName, opts.Platform, opts.Keychain, opts.TLS)
+	if err != nil {
+		return Image{}, err
+	}
+	var cache *Cache
+	if opts.CacheDir != "" {
+		cache = &Cache{Root: opts.CacheDir, MaxSize: opts.CacheMaxSize}
+		defer func() {
+			if err != nil {
+				cache.Release()
+			}
+		}()
+	}
+
+	// create tempdir and extract fs into it
+	var layers []Layer
+	if opts.IncludeLayers {
+		start := time.Now()
+		imgLayers, err := img.Layers()
+		if err != nil {
+			return Image{}, errors.Wrap(err, "getting image layers")
+		}
+		for _, layer := range imgLayers {
+			if err := ctx.Err(); err != nil {
+				return Image{
+					Layers: layers,
+				}, errors.Wrap(err, "retrieving image layers")
+			}
+			layerStart := time.Now()
+			digest, err := layer.Digest()
+			if err != nil {
+				return Image{
+					Layers: layers,
+				}, errors.Wrap(err, "getting layer digest")
+			}
+			imgLayer := Layer{
+				Digest: digest,
+			}
+			if opts.Extract && cache != nil {
+				imgLayer.FSPath, imgLayer.Whiteouts, err = cache.GetLayerContext(ctx, layer)
+				if err != nil {
+					return Image{
+						Layers: layers,
+					}, errors.Wrap(err, "getting cached filesystem for layer")
+				}
+			} else if opts.Extract {
+				path, err := ioutil.TempDir("", "extracttar")
+				if err != nil {
+					return Image{
+						Layers: layers,
+					}, errors.Wrap(err, "getting extract path for layer")
+				}
+				imgLayer.FSPath = path
+				imgLayer.Whiteouts, err = getFileSystemForLayer(ctx, layer, path, nil, opts.extractFilter())
+				if err != nil {
+					return Image{
+						Layers: append(layers, imgLayer),
+					}, errors.Wrap(err, "getting filesystem for layer")
+				}
+			}
+			if opts.Index {
+				imgLayer.Index, imgLayer.Whiteouts, err = indexLayer(ctx, layer, opts.Filter)
+				if err != nil {
+					return Image{
+						Layers: append(layers, imgLayer),
+					}, errors.Wrap(err, "indexing layer")
+				}
+			}
+			layers = append(layers, imgLayer)
+			elapsed := time.Now().Sub(layerStart)
+			logrus.Infof("time elapsed retrieving layer: %fs", elapsed.Seconds())
+		}
+		elapsed := time.Now().Sub(start)
+		logrus.Infof("time elapsed retrieving image layers: %fs", elapsed.Seconds())
+	}
+
+	imageDigest, err := getImageDigest(img)
+	if err != nil {
+		return Image{}, err
+	}
+	image := Image{
+		Image:  img,
+		Source: imageName,
+		Digest: imageDigest,
+		Layers: layers,
+		cache:  cache,
+	}
+	if opts.Extract && cache != nil {
+		image.FSPath, err = cache.GetImageContext(ctx, img)
+		if err != nil {
+			return Image{
+				Layers: layers,
+			}, errors.Wrap(err, "getting cached filesystem for image")
+		}
+		if err := cache.Evict(); err != nil {
+			logrus.Warnf("evicting cache entries: %s", err)
+		}
+	} else if opts.Extract {
+		path, err := ioutil.TempDir("", "extracttar")
+		if err != nil {
+			return Image{}, err
+		}
+		image.FSPath = path
+		// extract fs into provided dir
+		if err := getFileSystemForImage(ctx, img, path, nil, opts.extractFilter()); err != nil {
+			return Image{
+				FSPath: path,
+				Layers: layers,
+			}, errors.Wrap(err, "getting filesystem for image")
+		}
+	}
+	if opts.Index {
+		start := time.Now()
+		image.Index, err = indexImage(ctx, img, opts.Filter)
+		if err != nil {
+			return Image{
+				FSPath: image.FSPath,
+				Layers: layers,
+			}, errors.Wrap(err, "indexing image filesystem")
+		}
+		elapsed := time.Now().Sub(start)
+		logrus.Infof("time elapsed indexing image filesystem: %fs", elapsed.Seconds())
+	}
+	return image, nil
+}
+
+// retrieveImage infers the source of an image and retrieves a v1.Image
+// reference to it, along with the image name stripped of its source prefix.
+// If platform is set, the image for that platform is picked from an index.
+func retrieveImage(ctx context.Context, imageName string, platform *v1.Platform, keychain authn.Keychain, tlsOpts TLSOptions) (v1.Image, string, error) {
 	logrus.Infof("retrieving image: %s", imageName)
 	var img v1.Image
 	var err error
@@ -82,125 +322,106 @@ func GetImage(imageName string, includeLayers bool, cacheDir string) (Image, err
 		start := time.Now()
 		img, err = tarball.ImageFromPath(imageName, nil)
 		if err != nil {
-			return Image{}, errors.Wrap(err, "retrieving tar from path")
+			return nil, "", errors.Wrap(err, "retrieving tar from path")
 		}
 		elapsed := time.Now().Sub(start)
 		logrus.Infof("retrieving image ref from tar took %f seconds", elapsed.Seconds())
+		if err := checkImagePlatform(img, platform); err != nil {
+			return nil, "", err
+		}
+	} else if strings.HasPrefix(imageName, ociPrefix) {
+		// remove the oci prefix
+		imageName = strings.Replace(imageName, ociPrefix, "", 1)
+
+		start := time.Now()
+		img, err = getOCIImage(imageName, platform)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "retrieving image from OCI layout")
+		}
+		elapsed := time.Now().Sub(start)
+		logrus.Infof("retrieving image ref from OCI layout took %f seconds", elapsed.Seconds())
 	} else if strings.HasPrefix(imageName, daemonPrefix) {
 		// remove the daemon prefix
 		imageName = strings.Replace(imageName, daemonPrefix, "", -1)
 
 		ref, err := name.ParseReference(imageName, name.WeakValidation)
 		if err != nil {
-			return Image{}, errors.Wrap(err, "parsing image reference")
+			return nil, "", errors.Wrap(err, "parsing image reference")
 		}
 
 		start := time.Now()
 		// TODO(nkubala): specify gzip.NoCompression here when functional options are supported
-		img, err = daemon.Image(ref, daemon.WithBufferedOpener())
+		img, err = daemon.Image(ref, daemon.WithBufferedOpener(), daemon.WithContext(ctx))
 		if err != nil {
-			return Image{}, errors.Wrap(err, "retrieving image from daemon")
+			return nil, "", errors.Wrap(err, "retrieving image from daemon")
 		}
 		elapsed := time.Now().Sub(start)
 		logrus.Infof("retrieving local image ref took %f seconds", elapsed.Seconds())
+		if err := checkImagePlatform(img, platform); err != nil {
+			return nil, "", err
+		}
 	} else {
 		// either has remote prefix or has no prefix, in which case we force remote
 		imageName = strings.Replace(imageName, remotePrefix, "", -1)
 		ref, err := name.ParseReference(imageName, name.WeakValidation)
 		if err != nil {
-			return Image{}, errors.Wrap(err, "parsing image reference")
+			return nil, "", errors.Wrap(err, "parsing image reference")
 		}
-		auth, err := authn.DefaultKeychain.Resolve(ref.Context().Registry)
+		opts, err := remoteOptions(ctx, ref, platform, keychain, tlsOpts)
 		if err != nil {
-			return Image{}, errors.Wrap(err, "resolving auth")
+			return nil, "", err
 		}
 		start := time.Now()
-		img, err = remote.Image(ref, remote.WithAuth(auth), remote.WithTransport(BuildTransport(ref.Context().Registry)))
+		img, err = remote.Image(ref, opts...)
 		if err != nil {
-			return Image{}, errors.Wrap(err, "retrieving remote image")
+			return nil, "", errors.Wrap(err, "retrieving remote image")
 		}
 		elapsed := time.Now().Sub(start)
 		logrus.Infof("retrieving remote image ref took %f seconds", elapsed.Seconds())
 	}
+	return img, imageName, nil
+}
 
-	// create tempdir and extract fs into it
-	var layers []Layer
-	if includeLayers {
-		start := time.Now()
-		imgLayers, err := img.Layers()
-		if err != nil {
-			return Image{}, errors.Wrap(err, "getting image layers")
-		}
-		for _, layer := range imgLayers {
-			layerStart := time.Now()
-			digest, err := layer.Digest()
-			path, err := getExtractPathForName(digest.String(), cacheDir)
-			if err != nil {
-				return Image{
-					Layers: layers,
-				}, errors.Wrap(err, "getting extract path for layer")
-			}
-			if err := GetFileSystemForLayer(layer, path, nil); err != nil {
-				return Image{
-					Layers: layers,
-				}, errors.Wrap(err, "getting filesystem for layer")
-			}
-			layers = append(layers, Layer{
-				FSPath: path,
-				Digest: digest,
-			})
-			elapsed := time.Now().Sub(layerStart)
-			logrus.Infof("time elapsed retrieving layer: %fs", elapsed.Seconds())
-		}
-		elapsed := time.Now().Sub(start)
-		logrus.Infof("time elapsed retrieving image layers: %fs", elapsed.Seconds())
-	}
+// IsSBOM returns whether imageName is an SBOM document given with the
+// sbom:// prefix rather than an image.
+func IsSBOM(imageName string) bool {
+	return strings.HasPrefix(imageName, sbomPrefix)
+}
 
-	imageDigest, err := getImageDigest(img)
+// getSBOMImage reads the packages of an image from an SBOM document. Only the
+// package analyzers can run on the result.
+func getSBOMImage(imageName string) (Image, error) {
+	path := strings.TrimPrefix(imageName, sbomPrefix)
+	sbom, err := ReadSBOMFile(path)
 	if err != nil {
-		return Image{}, err
+		return Image{}, errors.Wrap(err, "reading SBOM")
 	}
-	path, err := getExtractPathForName(RemoveTag(imageName)+"@"+imageDigest.String(), cacheDir)
-	if err != nil {
-		return Image{}, err
+	image := Image{
+		Source: path,
+		SBOM:   &sbom,
 	}
-	// extract fs into provided dir
-	if err := GetFileSystemForImage(img, path, nil); err != nil {
-		return Image{
-			FSPath: path,
-			Layers: layers,
-		}, errors.Wrap(err, "getting filesystem for image")
+	if digest, err := v1.NewHash(sbom.Subject.Digest); err == nil {
+		image.Digest = digest
 	}
-	return Image{
-		Image:  img,
-		Source: imageName,
-		FSPath: path,
-		Digest: imageDigest,
-		Layers: layers,
-	}, nil
+	return image, nil
 }
 
-func getExtractPathForName(name string, cacheDir string) (string, error) {
-	path := cacheDir
-	var err error
-	if cacheDir != "" {
-		// if cachedir doesn't exist, create it
-		if _, err := os.Stat(cacheDir); err != nil && os.IsNotExist(err) {
-			err = os.MkdirAll(cacheDir, 0700)
-			if err != nil {
-				return "", err
-			}
-			logrus.Infof("caching filesystem at %s", cacheDir)
-		}
-	} else {
-		// otherwise, create tempdir
-		logrus.Infof("skipping caching")
-		path, err = ioutil.TempDir("", "extracttar")
-		if err != nil {
-			return "", err
-		}
+// remoteOptions returns the options to retrieve ref from its registry, with
+// credentials from keychain, or from authn.DefaultKeychain if nil, over TLS
+// connections configured by tlsOpts. Requests are canceled when ctx is done.
+func remoteOptions(ctx context.Context, ref name.Reference, platform *v1.Platform, keychain authn.Keychain, tlsOpts TLSOptions) ([]remote.Option, error) {
+	if keychain == nil {
+		keychain = authn.DefaultKeychain
+	}
+	auth, err := keychain.Resolve(ref.Context().Registry)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving auth")
+	}
+	opts := []remote.Option{remote.WithAuth(auth), remote.WithTransport(BuildTransport(ref.Context().Registry, tlsOpts)), remote.WithContext(ctx)}
+	if platform != nil {
+		opts = append(opts, remote.WithPlatform(*platform))
 	}
-	return path, nil
+	return opts, nil
 }
 
 func getImageDigest(image v1.Image) (digest v1.Hash, err error) {
@@ -214,6 +435,14 @@ func getImageDigest(image v1.Image) (digest v1.Hash, err error) {
 	return digest, nil
 }
 
+// ReleaseImage lets the cached filesystems of image be evicted again, once
+// the image is analyzed. It does nothing for images that aren't cached.
+func ReleaseImage(image Image) {
+	if image.cache != nil {
+		image.cache.Release()
+	}
+}
+
 func CleanupImage(image Image) {
 	if image.FSPath != "" {
 		logrus.Infof("Removing image filesystem directory %s from system", image.FSPath)
@@ -223,9 +452,15 @@ func CleanupImage(image Image) {
 	}
 	if image.Layers != nil {
 		for _, layer := range image.Layers {
+			if layer.FSPath == "" {
+				continue
+			}
 			if err := os.RemoveAll(layer.FSPath); err != nil {
 				logrus.Warn(err.Error())
 			}
+			if err := os.RemoveAll(whiteoutsPath(layer.FSPath)); err != nil {
+				logrus.Warn(err.Error())
+			}
 		}
 	}
 }
@@ -239,26 +474,66 @@ func SortMap(m map[string]string) string {
 	return strings.Join(pairs, " ")
 }
 
-// GetFileSystemForLayer unpacks a layer to local disk
-func GetFileSystemForLayer(layer v1.Layer, root string, whitelist []string) error {
+// GetFileSystemForLayer unpacks a layer to local disk and returns the
+// deletions marked by the whiteout files it contains. The whiteouts are
+// stored next to root, so they survive when the filesystem is cached.
+func GetFileSystemForLayer(layer v1.Layer, root string, whitelist []string) ([]Whiteout, error) {
+	return getFileSystemForLayer(context.Background(), layer, root, whitelist, nil)
+}
+
+func getFileSystemForLayer(ctx context.Context, layer v1.Layer, root string, whitelist []string, filter *PathFilter) ([]Whiteout, error) {
 	empty, err := DirIsEmpty(root)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if !empty {
 		logrus.Infof("using cached filesystem in %s", root)
+		return readWhiteouts(root)
+	}
+	whiteouts, err := unpackFilteredTar(ctx, layer.Uncompressed, root, whitelist, filter)
+	if err != nil {
+		return nil, err
+	}
+	return whiteouts, writeWhiteouts(root, whiteouts)
+}
+
+func whiteoutsPath(root string) string {
+	return filepath.Clean(root) + ".whiteouts.json"
+}
+
+func readWhiteouts(root string) ([]Whiteout, error) {
+	contents, err := ioutil.ReadFile(whiteoutsPath(root))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var whiteouts []Whiteout
+	if err := json.Unmarshal(contents, &whiteouts); err != nil {
+		return nil, errors.Wrap(err, "reading layer whiteouts")
+	}
+	return whiteouts, nil
+}
+
+func writeWhiteouts(root string, whiteouts []Whiteout) error {
+	if len(whiteouts) == 0 {
 		return nil
 	}
-	contents, err := layer.Uncompressed()
+	contents, err := json.Marshal(whiteouts)
 	if err != nil {
 		return err
 	}
-	return unpackTar(tar.NewReader(contents), root, whitelist)
+	return ioutil.WriteFile(whiteoutsPath(root), contents, 0600)
 }
 
 // unpack image filesystem to local disk
 // if provided directory is not empty, do nothing
 func GetFileSystemForImage(image v1.Image, root string, whitelist []string) error {
+	return getFileSystemForImage(context.Background(), image, root, whitelist, nil)
+}
+
+func getFileSystemForImage(ctx context.Context, image v1.Image, root string, whitelist []string, filter *PathFilter) error {
 	empty, err := DirIsEmpty(root)
 	if err != nil {
 		return err
@@ -267,10 +542,42 @@ func GetFileSystemForImage(image v1.Image, root string, whitelist []string) erro
 		logrus.Infof("using cached filesystem in %s", root)
 		return nil
 	}
-	if err := unpackTar(tar.NewReader(mutate.Extract(image)), root, whitelist); err != nil {
-		return err
+	// mutate.Extract applies the whiteouts of each layer, so none are left
+	// in the flattened filesystem
+	open := func() (io.ReadCloser, error) {
+		return mutate.Extract(image), nil
 	}
-	return nil
+	_, err = unpackFilteredTar(ctx, open, root, whitelist, filter)
+	return err
+}
+
+// GetIndexForLayer streams the contents of a layer into a FileIndex and
+// returns it along with the deletions marked by the layer's whiteout files.
+// The index leaves out the paths filter drops.
+func GetIndexForLayer(layer v1.Layer, filter *PathFilter) (*FileIndex, []Whiteout, error) {
+	return indexLayer(context.Background(), layer, filter)
+}
+
+func indexLayer(ctx context.Context, layer v1.Layer, filter *PathFilter) (*FileIndex, []Whiteout, error) {
+	contents, err := layer.Uncompressed()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer contents.Close()
+	return IndexTar(newContextReader(ctx, contents), nil, filter)
+}
+
+// GetIndexForImage streams the flattened filesystem of an image into a
+// FileIndex, leaving out the paths filter drops.
+func GetIndexForImage(image v1.Image, filter *PathFilter) (*FileIndex, error) {
+	return indexImage(context.Background(), image, filter)
+}
+
+func indexImage(ctx context.Context, image v1.Image, filter *PathFilter) (*FileIndex, error) {
+	contents := mutate.Extract(image)
+	defer contents.Close()
+	index, _, err := IndexTar(newContextReader(ctx, contents), nil, filter)
+	return index, err
 }
 
 func GetImageLayers(pathToImage string) []string {
@@ -0,0 +1,152 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/pkg/errors"
+)
+
+// ociRefNameAnnotation holds the reference name of a manifest in the
+// index.json of an OCI image layout, e.g. "latest"
+const ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+
+// parseOCIName splits an OCI layout image name, stripped of its oci:// prefix,
+// into the layout path and an optional manifest digest or reference name:
+// "path@sha256:..." selects a manifest by digest, "path:ref" by reference name.
+func parseOCIName(imageName string) (path, digest, ref string) {
+	if info, err := os.Stat(imageName); err == nil && info.IsDir() {
+		return imageName, "", ""
+	}
+	if i := strings.LastIndex(imageName, "@"); i > 0 && strings.Contains(imageName[i+1:], ":") {
+		return imageName[:i], imageName[i+1:], ""
+	}
+	if i := strings.LastIndex(imageName, ":"); i > strings.LastIndex(imageName, "/") && i > 0 {
+		return imageName[:i], "", imageName[i+1:]
+	}
+	return imageName, "", ""
+}
+
+// getOCIImage retrieves an image from the OCI image layout named by imageName.
+func getOCIImage(imageName string, platform *v1.Platform) (v1.Image, error) {
+	path, digest, ref := parseOCIName(imageName)
+	index, err := layout.ImageIndexFromPath(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading OCI image layout %s", path)
+	}
+	img, err := selectOCIImage(index, digest, ref, platform)
+	if err != nil {
+		return nil, errors.Wrapf(err, "selecting image from OCI image layout %s", path)
+	}
+	return img, nil
+}
+
+// selectOCIImage picks the image manifest matching digest or ref from an
+// index. Without either, the index must hold a single manifest. Nested
+// indexes, such as multi-platform images, are searched for the digest, and
+// otherwise resolved to their only image for platform.
+func selectOCIImage(index v1.ImageIndex, digest, ref string, platform *v1.Platform) (v1.Image, error) {
+	desc, err := findOCIDescriptor(index, digest, ref, platform)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case desc.MediaType.IsImage():
+		return index.Image(desc.Digest)
+	case desc.MediaType.IsIndex():
+		child, err := index.ImageIndex(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		// keep looking for the digest of an image inside the nested index
+		if desc.Digest.String() == digest {
+			digest = ""
+		}
+		return selectOCIImage(child, digest, "", platform)
+	default:
+		return nil, fmt.Errorf("manifest %s has unsupported media type %s", desc.Digest, desc.MediaType)
+	}
+}
+
+// findOCIDescriptor returns the single descriptor of index matching digest,
+// ref and platform. Descriptors that don't declare a platform match any.
+func findOCIDescriptor(index v1.ImageIndex, digest, ref string, platform *v1.Platform) (*v1.Descriptor, error) {
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	var matches []v1.Descriptor
+	for _, desc := range manifest.Manifests {
+		if digest != "" && desc.Digest.String() != digest {
+			continue
+		}
+		if ref != "" && desc.Annotations[ociRefNameAnnotation] != ref {
+			continue
+		}
+		if platform != nil && desc.Platform != nil && !desc.Platform.Satisfies(*platform) {
+			continue
+		}
+		matches = append(matches, desc)
+	}
+	if len(matches) == 0 && digest != "" {
+		// the digest may be that of an image inside a nested index
+		for _, desc := range manifest.Manifests {
+			if !desc.MediaType.IsIndex() {
+				continue
+			}
+			child, err := index.ImageIndex(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := findOCIDescriptor(child, digest, "", platform); err == nil {
+				return &desc, nil
+			}
+		}
+	}
+	switch len(matches) {
+	case 0:
+		if digest != "" {
+			return nil, fmt.Errorf("no manifest with digest %s", digest)
+		}
+		if ref != "" {
+			return nil, fmt.Errorf("no manifest with reference name %s", ref)
+		}
+		if platform != nil {
+			return nil, fmt.Errorf("no manifest for platform %s", platform)
+		}
+		return nil, errors.New("index holds no manifests")
+	case 1:
+		return &matches[0], nil
+	}
+	var choices []string
+	for _, desc := range matches {
+		choice := desc.Digest.String()
+		if name, ok := desc.Annotations[ociRefNameAnnotation]; ok {
+			choice += fmt.Sprintf(" (%s)", name)
+		} else if desc.Platform != nil {
+			choice += fmt.Sprintf(" (%s)", desc.Platform)
+		}
+		choices = append(choices, choice)
+	}
+	return nil, fmt.Errorf("index holds %d manifests, select one with @<digest>, :<ref> or a platform: %s",
+		len(matches), strings.Join(choices, ", "))
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PathFilter keeps or drops the paths of an image filesystem with
+// gitignore-style patterns. A pattern holding a slash other than a trailing
+// one is anchored at the root of the filesystem, any other pattern matches
+// at any depth. '*' and '?' match within a path element and '**' across
+// elements. A pattern matching a directory also matches everything under it,
+// and a pattern with a trailing slash only matches directories.
+type PathFilter struct {
+	includes []pathPattern
+	excludes []pathPattern
+	// negated tells whether an exclude pattern starting with '!' re-includes
+	// paths, so excluded directories can't be skipped as a whole
+	negated bool
+	// dirOnly tells whether a pattern only matches directories, so whether
+	// paths are directories matters
+	dirOnly bool
+}
+
+type pathPattern struct {
+	elements []string
+	negate   bool
+	dirOnly  bool
+}
+
+// NewPathFilter returns a filter keeping the paths matching any of includes,
+// or all paths if there are none, except those matching excludes. As in a
+// gitignore file, the last matching exclude wins, and one starting with '!'
+// keeps the paths an earlier exclude dropped.
+func NewPathFilter(includes, excludes []string) (*PathFilter, error) {
+	filter := &PathFilter{}
+	for _, include := range includes {
+		pattern, err := parsePathPattern(include)
+		if err != nil {
+			return nil, err
+		}
+		if pattern.negate {
+			return nil, errors.Errorf("include pattern %s can't be negated", include)
+		}
+		filter.includes = append(filter.includes, pattern)
+		filter.dirOnly = filter.dirOnly || pattern.dirOnly
+	}
+	for _, exclude := range excludes {
+		pattern, err := parsePathPattern(exclude)
+		if err != nil {
+			return nil, err
+		}
+		filter.excludes = append(filter.excludes, pattern)
+		filter.negated = filter.negated || pattern.negate
+		filter.dirOnly = filter.dirOnly || pattern.dirOnly
+	}
+	return filter, nil
+}
+
+// ReadIgnoreFile reads the exclude patterns of a gitignore-style file, one
+// per line. Blank lines and lines starting with '#' are skipped.
+func ReadIgnoreFile(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+func parsePathPattern(pattern string) (pathPattern, error) {
+	var p pathPattern
+	s := strings.TrimSpace(pattern)
+	if strings.HasPrefix(s, "!") {
+		p.negate, s = true, s[1:]
+	}
+	if strings.HasSuffix(s, "/") {
+		p.dirOnly, s = true, strings.TrimRight(s, "/")
+	}
+	if s == "" || s == "/" {
+		return p, errors.Errorf("invalid path pattern %q", pattern)
+	}
+	if !strings.Contains(strings.TrimPrefix(s, "/"), "/") {
+		// like in gitignore files, a single element matches at any depth
+		s = "**/" + strings.TrimPrefix(s, "/")
+	}
+	p.elements = strings.Split(strings.TrimPrefix(s, "/"), "/")
+	for _, element := range p.elements {
+		if _, err := path.Match(element, ""); err != nil {
+			return p, errors.Wrapf(err, "invalid path pattern %q", pattern)
+		}
+	}
+	return p, nil
+}
+
+// Keep reports whether the filter keeps name, a path of the image filesystem
+// such as /etc/passwd, which is a directory if dir is set. A nil filter keeps
+// all paths.
+func (f *PathFilter) Keep(name string, dir bool) bool {
+	if f == nil {
+		return true
+	}
+	elements := splitPath(name)
+	if len(f.includes) > 0 {
+		included := false
+		for _, pattern := range f.includes {
+			if pattern.match(elements, dir) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	return !f.excluded(elements, dir)
+}
+
+// SkipDir reports whether a walk can skip the directory name along with
+// everything under it.
+func (f *PathFilter) SkipDir(name string) bool {
+	return f != nil && !f.negated && f.excluded(splitPath(name), true)
+}
+
+func (f *PathFilter) excluded(elements []string, dir bool) bool {
+	excluded := false
+	for _, pattern := range f.excludes {
+		if pattern.match(elements, dir) {
+			excluded = !pattern.negate
+		}
+	}
+	return excluded
+}
+
+// match reports whether the pattern matches the path, which is a directory
+// if dir is set, or one of its parent directories.
+func (p pathPattern) match(elements []string, dir bool) bool {
+	for i := 1; i <= len(elements); i++ {
+		if p.dirOnly && i == len(elements) && !dir {
+			break
+		}
+		if matchElements(p.elements, elements[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchElements(patterns, elements []string) bool {
+	for len(patterns) > 0 {
+		if patterns[0] == "**" {
+			for i := 0; i <= len(elements); i++ {
+				if matchElements(patterns[1:], elements[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elements) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(patterns[0], elements[0]); !ok {
+			return false
+		}
+		patterns, elements = patterns[1:], elements[1:]
+	}
+	return len(elements) == 0
+}
+
+func splitPath(name string) []string {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, "/")
+}
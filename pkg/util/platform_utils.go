@@ -0,0 +1,133 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// GetPlatforms lists the platforms of a multi-platform image. Images that
+// don't resolve to an image index, such as tarballs and daemon images, have
+// no platforms to choose from and return none.
+func GetPlatforms(imageName string) ([]v1.Platform, error) {
+	return GetPlatformsContext(context.Background(), imageName, TLSOptions{})
+}
+
+// GetPlatformsContext is GetPlatforms, with the registry requests canceled
+// once ctx is done, and the TLS connections configured by tlsOpts.
+func GetPlatformsContext(ctx context.Context, imageName string, tlsOpts TLSOptions) ([]v1.Platform, error) {
+	switch {
+	case IsTar(imageName), strings.HasPrefix(imageName, daemonPrefix):
+		return nil, nil
+	case strings.HasPrefix(imageName, ociPrefix):
+		return getOCIPlatforms(strings.Replace(imageName, ociPrefix, "", 1))
+	}
+	ref, err := name.ParseReference(strings.Replace(imageName, remotePrefix, "", -1), name.WeakValidation)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing image reference")
+	}
+	opts, err := remoteOptions(ctx, ref, nil, nil, tlsOpts)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := remote.Get(ref, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving remote manifest")
+	}
+	if !desc.MediaType.IsIndex() {
+		return nil, nil
+	}
+	index, err := desc.ImageIndex()
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving remote image index")
+	}
+	return indexPlatforms(index)
+}
+
+func getOCIPlatforms(imageName string) ([]v1.Platform, error) {
+	path, digest, ref := parseOCIName(imageName)
+	index, err := layout.ImageIndexFromPath(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading OCI image layout %s", path)
+	}
+	desc, err := findOCIDescriptor(index, digest, ref, nil)
+	if err != nil {
+		if digest == "" && ref == "" {
+			// the layout may list the images of each platform directly
+			return indexPlatforms(index)
+		}
+		return nil, errors.Wrapf(err, "selecting image from OCI image layout %s", path)
+	}
+	if !desc.MediaType.IsIndex() {
+		return nil, nil
+	}
+	child, err := index.ImageIndex(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	return indexPlatforms(child)
+}
+
+// indexPlatforms lists the distinct platforms of the images in an index,
+// leaving out the "unknown" platform of attestation manifests.
+func indexPlatforms(index v1.ImageIndex) ([]v1.Platform, error) {
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	var platforms []v1.Platform
+	seen := map[string]bool{}
+	for _, desc := range manifest.Manifests {
+		if desc.Platform == nil || desc.Platform.OS == "unknown" || seen[desc.Platform.String()] {
+			continue
+		}
+		seen[desc.Platform.String()] = true
+		platforms = append(platforms, *desc.Platform)
+	}
+	return platforms, nil
+}
+
+// checkImagePlatform returns an error if the config of a single-platform
+// image, such as a tarball or daemon image, declares a platform other than
+// the requested one.
+func checkImagePlatform(img v1.Image, platform *v1.Platform) error {
+	if platform == nil {
+		return nil
+	}
+	config, err := img.ConfigFile()
+	if err != nil {
+		return errors.Wrap(err, "getting image config")
+	}
+	imgPlatform := config.Platform()
+	if imgPlatform == nil || imgPlatform.OS == "" {
+		logrus.Debugf("image declares no platform, assuming %s", platform)
+		return nil
+	}
+	if !imgPlatform.Satisfies(*platform) {
+		return fmt.Errorf("image is for platform %s, not %s", imgPlatform, platform)
+	}
+	return nil
+}
@@ -0,0 +1,405 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SBOM formats container-diff can write
+const (
+	SPDXJSON      = "spdx-json"
+	CycloneDXJSON = "cyclonedx-json"
+)
+
+const sbomTool = "container-diff"
+
+// SBOM is a software bill of materials listing the packages found in an image
+type SBOM struct {
+	Subject  SBOMSubject
+	Packages []SBOMPackage
+	Created  time.Time
+}
+
+// SBOMSubject is the image an SBOM describes
+type SBOMSubject struct {
+	Name   string
+	Digest string
+}
+
+// SBOMPackage is a package found in an image. Locations are the paths the
+// package is installed at, for package managers that track them.
+type SBOMPackage struct {
+	Name      string
+	Version   string
+	PURL      string
+	Locations []string
+}
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	DocumentDescribes []string           `json:"documentDescribes,omitempty"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID                string            `json:"SPDXID"`
+	Name                  string            `json:"name"`
+	VersionInfo           string            `json:"versionInfo,omitempty"`
+	DownloadLocation      string            `json:"downloadLocation"`
+	FilesAnalyzed         bool              `json:"filesAnalyzed"`
+	Checksums             []spdxChecksum    `json:"checksums,omitempty"`
+	SourceInfo            string            `json:"sourceInfo,omitempty"`
+	PrimaryPackagePurpose string            `json:"primaryPackagePurpose,omitempty"`
+	ExternalRefs          []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+type cycloneDXDocument struct {
+	BOMFormat   string               `json:"bomFormat"`
+	SpecVersion string               `json:"specVersion"`
+	Version     int                  `json:"version"`
+	Metadata    cycloneDXMetadata    `json:"metadata"`
+	Components  []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string             `json:"timestamp"`
+	Tools     cycloneDXTools     `json:"tools"`
+	Component cycloneDXComponent `json:"component"`
+}
+
+type cycloneDXTools struct {
+	Components []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXComponent struct {
+	Type       string               `json:"type"`
+	BOMRef     string               `json:"bom-ref,omitempty"`
+	Name       string               `json:"name"`
+	Version    string               `json:"version,omitempty"`
+	PURL       string               `json:"purl,omitempty"`
+	Hashes     []cycloneDXHash      `json:"hashes,omitempty"`
+	Evidence   *cycloneDXEvidence   `json:"evidence,omitempty"`
+	Components []cycloneDXComponent `json:"components,omitempty"`
+}
+
+type cycloneDXHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cycloneDXEvidence struct {
+	Occurrences []cycloneDXOccurrence `json:"occurrences"`
+}
+
+type cycloneDXOccurrence struct {
+	Location string `json:"location"`
+}
+
+// WriteSBOM writes the SBOM as a JSON document in one of the SBOM formats.
+func WriteSBOM(w io.Writer, sbom SBOM, format string) error {
+	var doc interface{}
+	switch format {
+	case SPDXJSON:
+		doc = spdxFromSBOM(sbom)
+	case CycloneDXJSON:
+		doc = cycloneDXFromSBOM(sbom)
+	default:
+		return fmt.Errorf("unknown SBOM format %s", format)
+	}
+	bytes, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	f := bufio.NewWriter(w)
+	defer f.Flush()
+	_, err = f.Write(append(bytes, '\n'))
+	return err
+}
+
+func spdxFromSBOM(sbom SBOM) spdxDocument {
+	imageID := "SPDXRef-Image"
+	image := spdxPackage{
+		SPDXID:                imageID,
+		Name:                  sbom.Subject.Name,
+		DownloadLocation:      "NOASSERTION",
+		PrimaryPackagePurpose: "CONTAINER",
+	}
+	if algorithm, hex, ok := strings.Cut(sbom.Subject.Digest, ":"); ok {
+		image.VersionInfo = sbom.Subject.Digest
+		image.Checksums = []spdxChecksum{{Algorithm: strings.ToUpper(algorithm), ChecksumValue: hex}}
+	}
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              sbom.Subject.Name,
+		DocumentNamespace: fmt.Sprintf("https://github.com/GoogleContainerTools/container-diff/spdx/%s@%s", url.PathEscape(sbom.Subject.Name), sbom.Subject.Digest),
+		CreationInfo: spdxCreationInfo{
+			Created:  sbom.Created.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: " + sbomTool},
+		},
+		Packages:      []spdxPackage{image},
+		Relationships: []spdxRelationship{{SPDXElementID: "SPDXRef-DOCUMENT", RelationshipType: "DESCRIBES", RelatedSPDXElement: imageID}},
+	}
+	for i, pkg := range sbom.Packages {
+		id := fmt.Sprintf("SPDXRef-Package-%d", i+1)
+		spdxPkg := spdxPackage{
+			SPDXID:           id,
+			Name:             pkg.Name,
+			VersionInfo:      pkg.Version,
+			DownloadLocation: "NOASSERTION",
+		}
+		if pkg.PURL != "" {
+			spdxPkg.ExternalRefs = []spdxExternalRef{{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: pkg.PURL}}
+		}
+		if len(pkg.Locations) > 0 {
+			spdxPkg.SourceInfo = "installed at: " + strings.Join(pkg.Locations, ", ")
+		}
+		doc.Packages = append(doc.Packages, spdxPkg)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{SPDXElementID: imageID, RelationshipType: "CONTAINS", RelatedSPDXElement: id})
+	}
+	return doc
+}
+
+func cycloneDXFromSBOM(sbom SBOM) cycloneDXDocument {
+	image := cycloneDXComponent{
+		Type:    "container",
+		BOMRef:  "image",
+		Name:    sbom.Subject.Name,
+		Version: sbom.Subject.Digest,
+	}
+	if algorithm, hex, ok := strings.Cut(sbom.Subject.Digest, ":"); ok {
+		alg := strings.ToUpper(algorithm)
+		if strings.HasPrefix(alg, "SHA") {
+			alg = "SHA-" + strings.TrimPrefix(alg, "SHA")
+		}
+		image.Hashes = []cycloneDXHash{{Alg: alg, Content: hex}}
+	}
+	doc := cycloneDXDocument{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.5",
+		Version:     1,
+		Metadata: cycloneDXMetadata{
+			Timestamp: sbom.Created.UTC().Format(time.RFC3339),
+			Tools:     cycloneDXTools{Components: []cycloneDXComponent{{Type: "application", Name: sbomTool}}},
+			Component: image,
+		},
+		Components: []cycloneDXComponent{},
+	}
+	for i, pkg := range sbom.Packages {
+		component := cycloneDXComponent{
+			Type:    "library",
+			BOMRef:  pkg.PURL,
+			Name:    pkg.Name,
+			Version: pkg.Version,
+			PURL:    pkg.PURL,
+		}
+		if component.BOMRef == "" {
+			component.BOMRef = fmt.Sprintf("package-%d", i+1)
+		}
+		if len(pkg.Locations) > 0 {
+			component.Evidence = &cycloneDXEvidence{}
+			for _, location := range pkg.Locations {
+				component.Evidence.Occurrences = append(component.Evidence.Occurrences, cycloneDXOccurrence{Location: location})
+			}
+		}
+		doc.Components = append(doc.Components, component)
+	}
+	return doc
+}
+
+// ReadSBOM reads an SPDX or CycloneDX JSON document. The package the
+// document describes, usually the image, becomes the subject of the SBOM,
+// and every other package with a package URL is listed in it.
+func ReadSBOM(r io.Reader) (SBOM, error) {
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		return SBOM{}, err
+	}
+	var probe struct {
+		SPDXVersion string `json:"spdxVersion"`
+		BOMFormat   string `json:"bomFormat"`
+	}
+	if err := json.Unmarshal(contents, &probe); err != nil {
+		return SBOM{}, errors.Wrap(err, "parsing SBOM")
+	}
+	switch {
+	case probe.SPDXVersion != "":
+		var doc spdxDocument
+		if err := json.Unmarshal(contents, &doc); err != nil {
+			return SBOM{}, errors.Wrap(err, "parsing SPDX document")
+		}
+		return sbomFromSPDX(doc), nil
+	case probe.BOMFormat == "CycloneDX":
+		var doc cycloneDXDocument
+		if err := json.Unmarshal(contents, &doc); err != nil {
+			return SBOM{}, errors.Wrap(err, "parsing CycloneDX document")
+		}
+		return sbomFromCycloneDX(doc), nil
+	}
+	return SBOM{}, errors.New("not an SPDX or CycloneDX JSON document")
+}
+
+// ReadSBOMFile reads the SBOM document at path.
+func ReadSBOMFile(path string) (SBOM, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return SBOM{}, err
+	}
+	defer f.Close()
+	return ReadSBOM(f)
+}
+
+func sbomFromSPDX(doc spdxDocument) SBOM {
+	described := map[string]bool{}
+	for _, id := range doc.DocumentDescribes {
+		described[id] = true
+	}
+	for _, relationship := range doc.Relationships {
+		if relationship.SPDXElementID == doc.SPDXID && relationship.RelationshipType == "DESCRIBES" {
+			described[relationship.RelatedSPDXElement] = true
+		}
+	}
+	sbom := SBOM{Subject: SBOMSubject{Name: doc.Name}}
+	if created, err := time.Parse(time.RFC3339, doc.CreationInfo.Created); err == nil {
+		sbom.Created = created
+	}
+	for _, pkg := range doc.Packages {
+		if described[pkg.SPDXID] {
+			sbom.Subject.Name = pkg.Name
+			for _, checksum := range pkg.Checksums {
+				if checksum.Algorithm == "SHA256" {
+					sbom.Subject.Digest = "sha256:" + checksum.ChecksumValue
+				}
+			}
+			continue
+		}
+		sbomPkg := SBOMPackage{Name: pkg.Name, Version: pkg.VersionInfo}
+		for _, ref := range pkg.ExternalRefs {
+			if ref.ReferenceType == "purl" {
+				sbomPkg.PURL = ref.ReferenceLocator
+			}
+		}
+		if sbomPkg.PURL == "" {
+			continue
+		}
+		if locations := strings.TrimPrefix(pkg.SourceInfo, "installed at: "); locations != pkg.SourceInfo {
+			sbomPkg.Locations = strings.Split(locations, ", ")
+		}
+		sbom.Packages = append(sbom.Packages, sbomPkg)
+	}
+	return sbom
+}
+
+func sbomFromCycloneDX(doc cycloneDXDocument) SBOM {
+	sbom := SBOM{Subject: SBOMSubject{Name: doc.Metadata.Component.Name}}
+	if created, err := time.Parse(time.RFC3339, doc.Metadata.Timestamp); err == nil {
+		sbom.Created = created
+	}
+	for _, hash := range doc.Metadata.Component.Hashes {
+		if hash.Alg == "SHA-256" {
+			sbom.Subject.Digest = "sha256:" + hash.Content
+		}
+	}
+	var addComponents func(components []cycloneDXComponent)
+	addComponents = func(components []cycloneDXComponent) {
+		for _, component := range components {
+			if component.PURL != "" {
+				pkg := SBOMPackage{Name: component.Name, Version: component.Version, PURL: component.PURL}
+				if component.Evidence != nil {
+					for _, occurrence := range component.Evidence.Occurrences {
+						pkg.Locations = append(pkg.Locations, occurrence.Location)
+					}
+				}
+				sbom.Packages = append(sbom.Packages, pkg)
+			}
+			addComponents(component.Components)
+		}
+	}
+	addComponents(doc.Components)
+	return sbom
+}
+
+// GetOSRelease reads the ID and VERSION_ID of the distribution installed in
+// the filesystem at root from its os-release file. Both are empty if the file
+// can't be found.
+func GetOSRelease(root string) (id, versionID string) {
+	if root == "" {
+		return "", ""
+	}
+	for _, path := range []string{"etc/os-release", "usr/lib/os-release"} {
+		contents, err := os.ReadFile(filepath.Join(root, path))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(contents), "\n") {
+			key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+			if !ok {
+				continue
+			}
+			value = strings.Trim(value, `"'`)
+			switch key {
+			case "ID":
+				id = value
+			case "VERSION_ID":
+				versionID = value
+			}
+		}
+		return id, versionID
+	}
+	return "", ""
+}
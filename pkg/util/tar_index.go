@@ -0,0 +1,264 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// FileIndex is an in-memory listing of a filesystem, built by streaming a
+// tar archive instead of unpacking it to disk. It records what the file
+// analyzers need: paths, sizes, modes, owners and content digests.
+type FileIndex struct {
+	entries  map[string]IndexEntry
+	names    []string
+	dirSizes map[string]int64
+}
+
+// IndexEntry describes a single path of a FileIndex.
+type IndexEntry struct {
+	Name     string
+	Mode     fs.FileMode
+	UID      uint32
+	GID      uint32
+	Size     int64
+	Linkname string
+	// Digest is the sha256 digest of the content of regular files
+	Digest string
+}
+
+// IndexTar reads the tar archive r into a FileIndex. Like unpackTar, it
+// records whiteout files as deletions rather than as entries, and only keeps
+// directories, regular files, symlinks and hard links. Paths that filter
+// drops are left out of the index and of its directory sizes.
+func IndexTar(r io.Reader, whitelist []string, filter *PathFilter) (*FileIndex, []Whiteout, error) {
+	tr := tar.NewReader(r)
+	index := &FileIndex{entries: map[string]IndexEntry{}}
+	hardlinks := map[string]string{}
+	var whiteouts []Whiteout
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "Error getting next tar header")
+		}
+		name := filepath.Clean("/" + header.Name)
+		if name == "/" || checkWhitelist(name, whitelist) {
+			continue
+		}
+		if whiteout, ok := parseWhiteout(header.Name); ok {
+			logrus.Debugf("Recording whiteout %s for %s", header.Name, whiteout.Path)
+			whiteouts = append(whiteouts, whiteout)
+			continue
+		}
+		if strings.HasPrefix(filepath.Base(name), whiteoutPrefix) {
+			logrus.Debugf("Skipping whiteout metadata file %s", header.Name)
+			continue
+		}
+
+		entry := IndexEntry{
+			Name: name,
+			Mode: header.FileInfo().Mode(),
+			UID:  uint32(header.Uid),
+			GID:  uint32(header.Gid),
+		}
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeSymlink, tar.TypeLink:
+			// a directory replaced by another kind of file loses its
+			// contents, as when unpacking
+			if existing, ok := index.entries[name]; ok && existing.Mode.IsDir() {
+				index.removeChildren(name)
+				for link := range hardlinks {
+					if strings.HasPrefix(link, name+"/") {
+						delete(hardlinks, link)
+					}
+				}
+			}
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			// an existing directory is left untouched, as when unpacking
+			if existing, ok := index.entries[name]; ok && existing.Mode.IsDir() {
+				continue
+			}
+		case tar.TypeReg:
+			hash := sha256.New()
+			size, err := io.Copy(hash, tr)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "reading %s", header.Name)
+			}
+			entry.Size = size
+			entry.Digest = "sha256:" + hex.EncodeToString(hash.Sum(nil))
+		case tar.TypeSymlink:
+			// match the size reported by lstat for a symlink
+			entry.Linkname = header.Linkname
+			entry.Size = int64(len(header.Linkname))
+		case tar.TypeLink:
+			hardlinks[name] = filepath.Clean("/" + header.Linkname)
+			continue
+		default:
+			continue
+		}
+		index.add(entry)
+	}
+
+	// hard links share the content and metadata of their target
+	for name, linkname := range hardlinks {
+		target, ok := index.entries[linkname]
+		if !ok {
+			logrus.Debugf("Skipping hard link %s to missing file %s", name, linkname)
+			continue
+		}
+		target.Name = name
+		index.add(target)
+	}
+	index.finish(filter)
+	return index, whiteouts, nil
+}
+
+// add stores entry in the index, along with any parent directory the
+// archive omitted.
+func (i *FileIndex) add(entry IndexEntry) {
+	for dir := filepath.Dir(entry.Name); dir != "/"; dir = filepath.Dir(dir) {
+		if _, ok := i.entries[dir]; ok {
+			break
+		}
+		i.entries[dir] = IndexEntry{Name: dir, Mode: os.ModeDir | 0755}
+	}
+	i.entries[entry.Name] = entry
+}
+
+// removeChildren drops every path under the directory dir.
+func (i *FileIndex) removeChildren(dir string) {
+	for name := range i.entries {
+		if strings.HasPrefix(name, dir+"/") {
+			delete(i.entries, name)
+		}
+	}
+}
+
+// finish drops the paths filter doesn't keep, then sorts the paths of the
+// index and computes directory sizes.
+func (i *FileIndex) finish(filter *PathFilter) {
+	i.names = make([]string, 0, len(i.entries))
+	i.dirSizes = map[string]int64{}
+	for name, entry := range i.entries {
+		if !filter.Keep(name, entry.Mode.IsDir()) {
+			delete(i.entries, name)
+			continue
+		}
+		i.names = append(i.names, name)
+		if entry.Mode.IsDir() {
+			continue
+		}
+		for dir := filepath.Dir(name); ; dir = filepath.Dir(dir) {
+			i.dirSizes[dir] += entry.Size
+			if dir == "/" {
+				break
+			}
+		}
+	}
+	// order paths like filepath.Walk does, so a directory is directly
+	// followed by its contents
+	sort.Slice(i.names, func(a, b int) bool {
+		return strings.Replace(i.names[a], "/", "\x00", -1) < strings.Replace(i.names[b], "/", "\x00", -1)
+	})
+}
+
+// Names returns every path in the index, in the order GetDirectory lists
+// the paths of an unpacked filesystem.
+func (i *FileIndex) Names() []string {
+	return i.names
+}
+
+// Entry returns the index entry for name, if it exists.
+func (i *FileIndex) Entry(name string) (IndexEntry, bool) {
+	entry, ok := i.entries[name]
+	return entry, ok
+}
+
+// Size returns the size of the entry at name, as GetSize does for unpacked
+// files: directories report the total size of their contents.
+func (i *FileIndex) Size(name string) int64 {
+	entry, ok := i.entries[name]
+	if name == "/" || (ok && entry.Mode.IsDir()) {
+		return i.dirSizes[name]
+	}
+	if !ok {
+		return -1
+	}
+	return entry.Size
+}
+
+// TotalSize returns the size of the whole filesystem.
+func (i *FileIndex) TotalSize() int64 {
+	return i.Size("/")
+}
+
+// DirectoryEntries returns the name and size of every path in the index, and
+// the digest of regular files when digests is set.
+func (i *FileIndex) DirectoryEntries(digests bool) []DirectoryEntry {
+	return i.CreateDirectoryEntries(i.names, digests)
+}
+
+// CreateDirectoryEntries returns the name and size of the given paths, and
+// the digest of regular files when digests is set.
+func (i *FileIndex) CreateDirectoryEntries(names []string, digests bool) (entries []DirectoryEntry) {
+	for _, name := range names {
+		entry := DirectoryEntry{
+			Name: name,
+			Size: i.Size(name),
+		}
+		if digests {
+			entry.Digest = i.entries[name].Digest
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// DirectoryMetaEntries returns the metadata of every path in the index.
+func (i *FileIndex) DirectoryMetaEntries() []DirectoryMetaEntry {
+	return i.CreateDirectoryMetaEntries(i.names)
+}
+
+// CreateDirectoryMetaEntries returns the metadata of the given paths.
+func (i *FileIndex) CreateDirectoryMetaEntries(names []string) (entries []DirectoryMetaEntry) {
+	for _, name := range names {
+		entry := i.entries[name]
+		entries = append(entries, DirectoryMetaEntry{
+			Name: name,
+			Mode: entry.Mode,
+			UID:  entry.UID,
+			GID:  entry.GID,
+		})
+	}
+	return entries
+}
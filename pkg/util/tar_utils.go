@@ -18,6 +18,9 @@ package util
 
 import (
 	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
@@ -31,15 +34,32 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	whiteoutPrefix = ".wh."
+	// whiteoutMetaPrefix marks special files such as opaque markers and aufs metadata
+	whiteoutMetaPrefix = whiteoutPrefix + whiteoutPrefix
+	whiteoutOpaqueDir  = whiteoutMetaPrefix + ".opq"
+)
+
+// errFilteredLinkTarget is returned by unpackTar for a kept hard link whose
+// target was filtered out, so it can't be created.
+var errFilteredLinkTarget = errors.New("hard link target is filtered out")
+
 type OriginalPerm struct {
 	path string
 	perm os.FileMode
 }
 
-func unpackTar(tr *tar.Reader, path string, whitelist []string) error {
+// unpackTar extracts the contents of tr into path, except for the paths
+// filter drops. Whiteout files are not written to disk; the deletions they
+// mark are returned instead. If digests isn't nil, the SHA-256 digests of
+// the regular files are recorded in it as they are written, keyed by their
+// path from "/". Hard links are left out.
+func unpackTar(tr *tar.Reader, path string, whitelist []string, filter *PathFilter, digests map[string]string) ([]Whiteout, error) {
 	// Thread safe Map of target:linkname
 	var hardlinks sync.Map
 
+	var whiteouts []Whiteout
 	originalPerms := make([]OriginalPerm, 0)
 	for {
 		header, err := tr.Next()
@@ -48,13 +68,26 @@ func unpackTar(tr *tar.Reader, path string, whitelist []string) error {
 			break
 		}
 		if err != nil {
-			return errors.Wrap(err, "Error getting next tar header")
+			return nil, errors.Wrap(err, "Error getting next tar header")
 		}
 		target := filepath.Clean(filepath.Join(path, header.Name))
 		// Make sure the target isn't part of the whitelist
 		if checkWhitelist(target, whitelist) {
 			continue
 		}
+		if whiteout, ok := parseWhiteout(header.Name); ok {
+			logrus.Debugf("Recording whiteout %s for %s", header.Name, whiteout.Path)
+			whiteouts = append(whiteouts, whiteout)
+			continue
+		}
+		if strings.HasPrefix(filepath.Base(header.Name), whiteoutPrefix) {
+			logrus.Debugf("Skipping whiteout metadata file %s", header.Name)
+			continue
+		}
+		if !filter.Keep(header.Name, header.Typeflag == tar.TypeDir) {
+			logrus.Debugf("Not extracting %s, which is filtered out", header.Name)
+			continue
+		}
 		mode := header.FileInfo().Mode()
 		switch header.Typeflag {
 
@@ -73,11 +106,11 @@ func unpackTar(tr *tar.Reader, path string, whitelist []string) error {
 				}
 				logrus.Debugf("Creating directory %s with permissions %v", target, mode)
 				if err := os.MkdirAll(target, mode); err != nil {
-					return err
+					return nil, err
 				}
 				// In some cases, MkdirAll doesn't change the permissions, so run Chmod
 				if err := os.Chmod(target, mode); err != nil {
-					return err
+					return nil, err
 				}
 			}
 
@@ -88,16 +121,16 @@ func unpackTar(tr *tar.Reader, path string, whitelist []string) error {
 			if _, err := os.Stat(baseDir); os.IsNotExist(err) {
 				logrus.Debugf("baseDir %s for file %s does not exist. Creating", baseDir, target)
 				if err := os.MkdirAll(baseDir, 0755); err != nil {
-					return err
+					return nil, err
 				}
 			}
 			// It's possible we end up creating files that can't be overwritten based on their permissions.
 			// Explicitly delete an existing file before continuing.
 			if _, err := os.Stat(target); !os.IsNotExist(err) {
 				logrus.Debugf("Removing %s for overwrite", target)
-				if err := os.Remove(target); err != nil {
+				if err := os.RemoveAll(target); err != nil {
 					logrus.Errorf("error removing file %s", target)
-					return err
+					return nil, err
 				}
 			}
 
@@ -105,18 +138,26 @@ func unpackTar(tr *tar.Reader, path string, whitelist []string) error {
 			currFile, err := os.Create(target)
 			if err != nil {
 				logrus.Errorf("Error creating file %s %s", target, err)
-				return err
+				return nil, err
 			}
 			// manually set permissions on file, since the default umask (022) will interfere
 			if err = os.Chmod(target, mode); err != nil {
 				logrus.Errorf("Error updating file permissions on %s", target)
-				return err
+				return nil, err
 			}
-			_, err = io.Copy(currFile, tr)
+			var writer io.Writer = currFile
+			hash := sha256.New()
+			if digests != nil {
+				writer = io.MultiWriter(currFile, hash)
+			}
+			_, err = io.Copy(writer, tr)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			currFile.Close()
+			if digests != nil {
+				digests[entryName(header.Name)] = "sha256:" + hex.EncodeToString(hash.Sum(nil))
+			}
 		case tar.TypeSymlink:
 			// It's possible we end up creating files that can't be overwritten based on their permissions.
 			// Explicitly delete an existing file before continuing.
@@ -131,6 +172,12 @@ func unpackTar(tr *tar.Reader, path string, whitelist []string) error {
 				logrus.Errorf("Failed to create symlink between %s and %s: %s", header.Linkname, target, err)
 			}
 		case tar.TypeLink:
+			if !filter.Keep(header.Linkname, false) {
+				return nil, errFilteredLinkTarget
+			}
+			if digests != nil {
+				delete(digests, entryName(header.Name))
+			}
 			linkname := filepath.Clean(filepath.Join(path, header.Linkname))
 			// Check if the linkname already exists
 			if _, err := os.Stat(linkname); !os.IsNotExist(err) {
@@ -156,19 +203,85 @@ func unpackTar(tr *tar.Reader, path string, whitelist []string) error {
 		return true
 	})
 	if resolveError.Load() != nil {
-		return resolveError.Load().(error)
+		return nil, resolveError.Load().(error)
 	}
 
 	// reset all original file
 	for _, perm := range originalPerms {
 		if err := os.Chmod(perm.path, perm.perm); err != nil {
-			return err
+			return nil, err
 		}
 	}
-	return nil
+	return whiteouts, nil
+}
+
+// unpackFilteredTar extracts the tar opened by open into path like
+// unpackTar. If a kept hard link points to a file the filter drops, the
+// extraction starts over without the filter, so that the link is created.
+func unpackFilteredTar(ctx context.Context, open func() (io.ReadCloser, error), path string, whitelist []string, filter *PathFilter) ([]Whiteout, error) {
+	unpack := func(filter *PathFilter) ([]Whiteout, error) {
+		contents, err := open()
+		if err != nil {
+			return nil, err
+		}
+		defer contents.Close()
+		return unpackTar(tar.NewReader(newContextReader(ctx, contents)), path, whitelist, filter, nil)
+	}
+	whiteouts, err := unpack(filter)
+	if err != errFilteredLinkTarget {
+		return whiteouts, err
+	}
+	logrus.Infof("extracting %s without path filters, as it hard links files they leave out", path)
+	if err := os.RemoveAll(path); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, err
+	}
+	return unpack(nil)
+}
+
+// entryName returns the path of a tar entry from "/", as in Directory.Content
+func entryName(name string) string {
+	return filepath.Clean("/" + name)
+}
+
+// parseWhiteout returns the deletion marked by the whiteout file at name,
+// if name is one. Paths are rooted at "/", like directory entries.
+func parseWhiteout(name string) (Whiteout, bool) {
+	dir, base := filepath.Split(filepath.Clean("/" + name))
+	if base == whiteoutOpaqueDir {
+		return Whiteout{Path: filepath.Clean(dir), Opaque: true}, true
+	}
+	if !strings.HasPrefix(base, whiteoutPrefix) || strings.HasPrefix(base, whiteoutMetaPrefix) {
+		return Whiteout{}, false
+	}
+	return Whiteout{Path: filepath.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))}, true
+}
+
+// contextReader fails reads once its context is done, so that extraction
+// and indexing of a layer stop at the next read after cancellation.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func newContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, r: r}
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
 }
 
 func resolveHardlink(linkname, target string) error {
+	// as for files, the directory of a link may not have been created yet
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
 	if err := os.Link(linkname, target); err != nil {
 		return err
 	}
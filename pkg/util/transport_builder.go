@@ -25,33 +25,34 @@ import (
 	"net/http"
 )
 
-var tlsConfiguration = struct {
-	certifiedRegistries     map[string]string
-	skipTLSVerifyRegistries map[string]struct{}
-}{
-	certifiedRegistries:     make(map[string]string),
-	skipTLSVerifyRegistries: make(map[string]struct{}),
+// TLSOptions configures the TLS connections to registries.
+type TLSOptions struct {
+	// SkipVerifyRegistries lists registries whose TLS certificates aren't
+	// verified
+	SkipVerifyRegistries []string
+	// Certificates maps registries to the path of the certificate used to
+	// verify them
+	Certificates map[string]string
 }
 
-func ConfigureTLS(skipTsVerifyRegistries []string, registriesToCertificates map[string]string) {
-	tlsConfiguration.skipTLSVerifyRegistries = make(map[string]struct{})
-	for _, registry := range skipTsVerifyRegistries {
-		tlsConfiguration.skipTLSVerifyRegistries[registry] = struct{}{}
-	}
-	tlsConfiguration.certifiedRegistries = make(map[string]string)
-	for registry := range registriesToCertificates {
-		tlsConfiguration.certifiedRegistries[registry] = registriesToCertificates[registry]
+// skipVerify returns whether the certificates of registry aren't verified.
+func (opts TLSOptions) skipVerify(registry string) bool {
+	for _, r := range opts.SkipVerifyRegistries {
+		if r == registry {
+			return true
+		}
 	}
+	return false
 }
 
-func BuildTransport(registry Registry) http.RoundTripper {
+func BuildTransport(registry Registry, opts TLSOptions) http.RoundTripper {
 	var tr http.RoundTripper = http.DefaultTransport.(*http.Transport).Clone()
 
-	if _, present := tlsConfiguration.skipTLSVerifyRegistries[registry.RegistryStr()]; present {
+	if opts.skipVerify(registry.RegistryStr()) {
 		tr.(*http.Transport).TLSClientConfig = &tls.Config{
 			InsecureSkipVerify: true,
 		}
-	} else if certificatePath := tlsConfiguration.certifiedRegistries[registry.RegistryStr()]; certificatePath != "" {
+	} else if certificatePath := opts.Certificates[registry.RegistryStr()]; certificatePath != "" {
 		systemCertPool := defaultX509Handler()
 		if err := appendCertificate(systemCertPool, certificatePath); err != nil {
 			logrus.WithError(err).Warnf("Failed to load certificate %s for %s\n", certificatePath, registry.RegistryStr())
@@ -34,6 +34,9 @@ type AnalyzeResult struct {
 	Image       string
 	AnalyzeType string
 	Analysis    interface{}
+	// SortSize sorts the files and packages of the output by descending
+	// size rather than by name
+	SortSize bool `json:"-"`
 }
 
 type ListAnalyzeResult AnalyzeResult
@@ -61,7 +64,7 @@ func (r MultiVersionPackageAnalyzeResult) OutputStruct() interface{} {
 		logrus.Error("Unexpected structure of Analysis.  Should be of type map[string]map[string]PackageInfo")
 		return fmt.Errorf("Could not output %s analysis result", r.AnalyzeType)
 	}
-	analysisOutput := getMultiVersionPackageOutput(analysis)
+	analysisOutput := getMultiVersionPackageOutput(analysis, r.AnalyzeType, r.SortSize)
 	output := struct {
 		Image       string
 		AnalyzeType string
@@ -80,7 +83,7 @@ func (r MultiVersionPackageAnalyzeResult) OutputText(writer io.Writer, resultTyp
 		logrus.Error("Unexpected structure of Analysis.  Should be of type map[string]map[string]PackageInfo")
 		return fmt.Errorf("Could not output %s analysis result", r.AnalyzeType)
 	}
-	analysisOutput := getMultiVersionPackageOutput(analysis)
+	analysisOutput := getMultiVersionPackageOutput(analysis, r.AnalyzeType, r.SortSize)
 
 	strAnalysis := stringifyPackages(analysisOutput)
 	strResult := struct {
@@ -103,7 +106,7 @@ func (r SingleVersionPackageAnalyzeResult) OutputStruct() interface{} {
 		logrus.Error("Unexpected structure of Analysis.  Should be of type map[string]PackageInfo")
 		return fmt.Errorf("Could not output %s analysis result", r.AnalyzeType)
 	}
-	analysisOutput := getSingleVersionPackageOutput(analysis)
+	analysisOutput := getSingleVersionPackageOutput(analysis, r.AnalyzeType, r.SortSize)
 	output := struct {
 		Image       string
 		AnalyzeType string
@@ -122,7 +125,7 @@ func (r SingleVersionPackageAnalyzeResult) OutputText(writer io.Writer, diffType
 		logrus.Error("Unexpected structure of Analysis.  Should be of type map[string]PackageInfo")
 		return fmt.Errorf("Could not output %s analysis result", r.AnalyzeType)
 	}
-	analysisOutput := getSingleVersionPackageOutput(analysis)
+	analysisOutput := getSingleVersionPackageOutput(analysis, r.AnalyzeType, r.SortSize)
 
 	strAnalysis := stringifyPackages(analysisOutput)
 	strResult := struct {
@@ -155,9 +158,9 @@ func (r SingleVersionPackageLayerAnalyzeResult) OutputStruct() interface{} {
 	var analysisOutput []PkgDiff
 	for _, d := range analysis.PackageDiffs {
 		diffOutput := PkgDiff{
-			Packages1: getSingleVersionPackageOutput(d.Packages1),
-			Packages2: getSingleVersionPackageOutput(d.Packages2),
-			InfoDiff:  getSingleVersionInfoDiffOutput(d.InfoDiff),
+			Packages1: getSingleVersionPackageOutput(d.Packages1, r.AnalyzeType, r.SortSize),
+			Packages2: getSingleVersionPackageOutput(d.Packages2, r.AnalyzeType, r.SortSize),
+			InfoDiff:  getSingleVersionInfoDiffOutput(d.InfoDiff, r.SortSize),
 		}
 		analysisOutput = append(analysisOutput, diffOutput)
 	}
@@ -190,9 +193,9 @@ func (r SingleVersionPackageLayerAnalyzeResult) OutputText(writer io.Writer, dif
 	var analysisOutput []StrDiff
 	for _, d := range analysis.PackageDiffs {
 		diffOutput := StrDiff{
-			Packages1: stringifyPackages(getSingleVersionPackageOutput(d.Packages1)),
-			Packages2: stringifyPackages(getSingleVersionPackageOutput(d.Packages2)),
-			InfoDiff:  stringifyPackageDiff(getSingleVersionInfoDiffOutput(d.InfoDiff)),
+			Packages1: stringifyPackages(getSingleVersionPackageOutput(d.Packages1, r.AnalyzeType, r.SortSize)),
+			Packages2: stringifyPackages(getSingleVersionPackageOutput(d.Packages2, r.AnalyzeType, r.SortSize)),
+			InfoDiff:  stringifyPackageDiff(getSingleVersionInfoDiffOutput(d.InfoDiff, r.SortSize)),
 		}
 		analysisOutput = append(analysisOutput, diffOutput)
 	}
@@ -216,21 +219,21 @@ type PackageOutput struct {
 	Size    int64
 }
 
-func getSingleVersionPackageOutput(packageMap map[string]PackageInfo) []PackageOutput {
+func getSingleVersionPackageOutput(packageMap map[string]PackageInfo, analyzeType string, sortSize bool) []PackageOutput {
 	packages := []PackageOutput{}
 	for name, info := range packageMap {
 		packages = append(packages, PackageOutput{Name: name, Version: info.Version, Size: info.Size})
 	}
 
-	if SortSize {
-		packageBy(packageSizeSort).Sort(packages)
+	if sortSize {
+		packageBy(packageSizeSort(analyzeType)).Sort(packages)
 	} else {
-		packageBy(packageNameSort).Sort(packages)
+		packageBy(packageNameSort(analyzeType)).Sort(packages)
 	}
 	return packages
 }
 
-func getMultiVersionPackageOutput(packageMap map[string]map[string]PackageInfo) []PackageOutput {
+func getMultiVersionPackageOutput(packageMap map[string]map[string]PackageInfo, analyzeType string, sortSize bool) []PackageOutput {
 	packages := []PackageOutput{}
 	for name, versionMap := range packageMap {
 		for path, info := range versionMap {
@@ -238,14 +241,24 @@ func getMultiVersionPackageOutput(packageMap map[string]map[string]PackageInfo)
 		}
 	}
 
-	if SortSize {
-		packageBy(packageSizeSort).Sort(packages)
+	if sortSize {
+		packageBy(packageSizeSort(analyzeType)).Sort(packages)
 	} else {
-		packageBy(packageNameSort).Sort(packages)
+		packageBy(packageNameSort(analyzeType)).Sort(packages)
 	}
 	return packages
 }
 
+// hasDigests returns whether the digest of any of entries is recorded.
+func hasDigests(entries []util.DirectoryEntry) bool {
+	for _, entry := range entries {
+		if entry.Digest != "" {
+			return true
+		}
+	}
+	return false
+}
+
 type FileAnalyzeResult AnalyzeResult
 
 func (r FileAnalyzeResult) OutputStruct() interface{} {
@@ -255,7 +268,7 @@ func (r FileAnalyzeResult) OutputStruct() interface{} {
 		return errors.New("Could not output FileAnalyzer analysis result")
 	}
 
-	if SortSize {
+	if r.SortSize {
 		directoryBy(directorySizeSort).Sort(analysis)
 	} else {
 		directoryBy(directoryNameSort).Sort(analysis)
@@ -271,7 +284,7 @@ func (r FileAnalyzeResult) OutputText(writer io.Writer, analyzeType string, form
 		return errors.New("Could not output FileAnalyzer analysis result")
 	}
 
-	if SortSize {
+	if r.SortSize {
 		directoryBy(directorySizeSort).Sort(analysis)
 	} else {
 		directoryBy(directoryNameSort).Sort(analysis)
@@ -282,10 +295,12 @@ func (r FileAnalyzeResult) OutputText(writer io.Writer, analyzeType string, form
 		Image       string
 		AnalyzeType string
 		Analysis    []StrDirectoryEntry
+		Digests     bool
 	}{
 		Image:       r.Image,
 		AnalyzeType: r.AnalyzeType,
 		Analysis:    strAnalysis,
+		Digests:     hasDigests(analysis),
 	}
 	return TemplateOutputFromFormat(writer, strResult, "FileAnalyze", format)
 }
@@ -326,54 +341,94 @@ func (r FileMetaAnalyzeResult) OutputText(writer io.Writer, analyzeType string,
 	return TemplateOutputFromFormat(writer, strResult, "FileMetaAnalyze", format)
 }
 
+// FileLayerAnalysis holds the files added or modified by a single layer,
+// along with the paths the layer deleted from the layers below it.
+type FileLayerAnalysis struct {
+	Entries   []util.DirectoryEntry
+	Deletions []util.Whiteout
+}
+
+// layersHaveDigests returns whether the digest of any file of analysis is
+// recorded.
+func layersHaveDigests(analysis []FileLayerAnalysis) bool {
+	for _, a := range analysis {
+		if hasDigests(a.Entries) {
+			return true
+		}
+	}
+	return false
+}
+
 type FileLayerAnalyzeResult AnalyzeResult
 
 func (r FileLayerAnalyzeResult) OutputStruct() interface{} {
-	analysis, valid := r.Analysis.([][]util.DirectoryEntry)
+	analysis, valid := r.Analysis.([]FileLayerAnalysis)
 	if !valid {
-		logrus.Error("Unexpected structure of Analysis.  Should be of type []DirectoryEntry")
+		logrus.Error("Unexpected structure of Analysis.  Should be of type []FileLayerAnalysis")
 		return errors.New("Could not output FileAnalyzer analysis result")
 	}
 
+	var entries [][]util.DirectoryEntry
+	var deletions [][]util.Whiteout
+	hasDeletions := false
 	for _, a := range analysis {
-		if SortSize {
-			directoryBy(directorySizeSort).Sort(a)
+		if r.SortSize {
+			directoryBy(directorySizeSort).Sort(a.Entries)
 		} else {
-			directoryBy(directoryNameSort).Sort(a)
+			directoryBy(directoryNameSort).Sort(a.Entries)
 		}
+		entries = append(entries, a.Entries)
+		deletions = append(deletions, a.Deletions)
+		hasDeletions = hasDeletions || len(a.Deletions) > 0
+	}
+	if !hasDeletions {
+		deletions = nil
 	}
 
-	r.Analysis = analysis
-	return r
+	return struct {
+		Image       string
+		AnalyzeType string
+		Analysis    [][]util.DirectoryEntry
+		Deletions   [][]util.Whiteout `json:",omitempty"`
+	}{
+		Image:       r.Image,
+		AnalyzeType: r.AnalyzeType,
+		Analysis:    entries,
+		Deletions:   deletions,
+	}
 }
 
 func (r FileLayerAnalyzeResult) OutputText(writer io.Writer, analyzeType string, format string) error {
-	analysis, valid := r.Analysis.([][]util.DirectoryEntry)
+	analysis, valid := r.Analysis.([]FileLayerAnalysis)
 	if !valid {
-		logrus.Error("Unexpected structure of Analysis.  Should be of type []DirectoryEntry")
+		logrus.Error("Unexpected structure of Analysis.  Should be of type []FileLayerAnalysis")
 		return errors.New("Could not output FileAnalyzer analysis result")
 	}
 
-	var strDirectoryEntries [][]StrDirectoryEntry
+	var strAnalysis []StrFileLayerAnalysis
 
 	for _, a := range analysis {
-		if SortSize {
-			directoryBy(directorySizeSort).Sort(a)
+		if r.SortSize {
+			directoryBy(directorySizeSort).Sort(a.Entries)
 		} else {
-			directoryBy(directoryNameSort).Sort(a)
+			directoryBy(directoryNameSort).Sort(a.Entries)
 		}
-		strAnalysis := stringifyDirectoryEntries(a)
-		strDirectoryEntries = append(strDirectoryEntries, strAnalysis)
+		strAnalysis = append(strAnalysis, StrFileLayerAnalysis{
+			Entries:   stringifyDirectoryEntries(a.Entries),
+			Deletions: stringifyWhiteouts(a.Deletions),
+		})
 	}
 
 	strResult := struct {
 		Image       string
 		AnalyzeType string
-		Analysis    [][]StrDirectoryEntry
+		Analysis    []StrFileLayerAnalysis
+		Digests     bool
 	}{
 		Image:       r.Image,
 		AnalyzeType: r.AnalyzeType,
-		Analysis:    strDirectoryEntries,
+		Analysis:    strAnalysis,
+		Digests:     layersHaveDigests(analysis),
 	}
 	return TemplateOutputFromFormat(writer, strResult, "FileLayerAnalyze", format)
 }
@@ -487,3 +542,57 @@ func (r SizeLayerAnalyzeResult) OutputText(writer io.Writer, analyzeType string,
 	}
 	return TemplateOutputFromFormat(writer, strResult, "SizeLayerAnalyze", format)
 }
+
+type LayerShareAnalyzeResult AnalyzeResult
+
+func (r LayerShareAnalyzeResult) OutputStruct() interface{} {
+	return r
+}
+
+func (r LayerShareAnalyzeResult) OutputText(writer io.Writer, analyzeType string, format string) error {
+	analysis, valid := r.Analysis.(LayerShareAnalysis)
+	if !valid {
+		logrus.Error("Unexpected structure of Analysis.  Should follow the LayerShareAnalysis struct")
+		return errors.New("Could not output LayerShareAnalyzer analysis result")
+	}
+
+	strResult := struct {
+		Image       string
+		AnalyzeType string
+		Analysis    StrLayerShareAnalysis
+	}{
+		Image:       r.Image,
+		AnalyzeType: r.AnalyzeType,
+		Analysis: StrLayerShareAnalysis{
+			Layers:           stringifyLayerBlobs(analysis.Layers),
+			Size:             stringifySize(analysis.Size),
+			UncompressedSize: stringifySize(analysis.UncompressedSize),
+		},
+	}
+	return TemplateOutputFromFormat(writer, strResult, "LayerShareAnalyze", format)
+}
+
+type VulnAnalyzeResult AnalyzeResult
+
+func (r VulnAnalyzeResult) OutputStruct() interface{} {
+	return r
+}
+
+func (r VulnAnalyzeResult) OutputText(writer io.Writer, analyzeType string, format string) error {
+	analysis, valid := r.Analysis.([]Vuln)
+	if !valid {
+		logrus.Error("Unexpected structure of Analysis.  Should follow the []Vuln struct")
+		return errors.New("Could not output VulnAnalyzer analysis result")
+	}
+
+	strResult := struct {
+		Image       string
+		AnalyzeType string
+		Analysis    []StrVuln
+	}{
+		Image:       r.Image,
+		AnalyzeType: r.AnalyzeType,
+		Analysis:    stringifyVulns(analysis),
+	}
+	return TemplateOutputFromFormat(writer, strResult, "VulnAnalyze", format)
+}
@@ -0,0 +1,253 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/pkg/errors"
+)
+
+func TestCache(t *testing.T) {
+	image, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := image.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := &pkgutil.Cache{Root: t.TempDir()}
+
+	// a partial filesystem left without a completion marker is unpacked again
+	digest, _ := layers[0].Digest()
+	partial := filepath.Join(cache.Root, "layers", strings.Replace(digest.String(), ":", "-", 1), "fs")
+	if err := os.MkdirAll(partial, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(partial, "leftover"), []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var layerPaths []string
+	for _, layer := range layers {
+		path, _, err := cache.GetLayer(layer)
+		if err != nil {
+			t.Fatalf("Got unexpected error caching layer: %s", err)
+		}
+		layerPaths = append(layerPaths, path)
+	}
+	if _, err := os.Stat(filepath.Join(partial, "leftover")); !os.IsNotExist(err) {
+		t.Errorf("Expected partial cache entry to be unpacked again")
+	}
+	imagePath, err := cache.GetImage(image)
+	if err != nil {
+		t.Fatalf("Got unexpected error caching image: %s", err)
+	}
+	// the file digests are recorded while unpacking, and used instead of
+	// hashing the files again
+	dir, err := pkgutil.GetDirectory(layerPaths[0], true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkgutil.HashDirectory(&dir)
+	if len(dir.Digests) == 0 {
+		t.Fatalf("Expected digests for the files of %s", layerPaths[0])
+	}
+	recorded := map[string]string{}
+	for name := range dir.Digests {
+		recorded[name] = "sha256:recorded"
+	}
+	contents, err := json.Marshal(recorded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(layerPaths[0]+".digests.json", contents, 0600); err != nil {
+		t.Fatal(err)
+	}
+	dir.Digests = nil
+	pkgutil.HashDirectory(&dir)
+	if !reflect.DeepEqual(dir.Digests, recorded) {
+		t.Errorf("Expected recorded digests %v, got %v", recorded, dir.Digests)
+	}
+	// a second retrieval reuses the complete entry
+	if path, err := cache.GetImage(image); err != nil || path != imagePath {
+		t.Errorf("Expected cached image at %s, got %s (error: %v)", imagePath, path, err)
+	}
+
+	entries, err := cache.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 cache entries, got %d", len(entries))
+	}
+	for _, entry := range entries {
+		if !entry.Complete {
+			t.Errorf("Expected entry %s to be complete", entry.Digest)
+		}
+		if err := cache.Verify(entry); err != nil {
+			t.Errorf("Got unexpected error verifying entry %s: %s", entry.Digest, err)
+		}
+	}
+
+	// tampering with an unpacked filesystem is caught by Verify
+	files, err := ioutil.ReadDir(layerPaths[0])
+	if err != nil || len(files) == 0 {
+		t.Fatalf("Expected files in cached layer: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(layerPaths[0], files[0].Name()), []byte("tampered"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		err := cache.Verify(entry)
+		if entry.FSPath() == layerPaths[0] && err == nil {
+			t.Errorf("Expected error verifying tampered entry %s", entry.Digest)
+		}
+		if entry.FSPath() != layerPaths[0] && err != nil {
+			t.Errorf("Got unexpected error verifying entry %s: %s", entry.Digest, err)
+		}
+	}
+
+	// incomplete entries are pruned, held entries are kept, even by another
+	// cache sharing the root as other processes do
+	incomplete := filepath.Join(cache.Root, "images", "sha256-0000")
+	if err := os.MkdirAll(incomplete, 0700); err != nil {
+		t.Fatal(err)
+	}
+	other := &pkgutil.Cache{Root: cache.Root}
+	removed, err := other.Prune(0)
+	if err != nil {
+		t.Fatalf("Got unexpected error pruning cache: %s", err)
+	}
+	if len(removed) != 1 || removed[0].Complete {
+		t.Errorf("Expected only the incomplete entry to be pruned, got %v", removed)
+	}
+	if _, err := os.Stat(incomplete); !os.IsNotExist(err) {
+		t.Errorf("Expected incomplete entry %s to be removed", incomplete)
+	}
+	if err := other.Remove(entries[0]); err != pkgutil.ErrCacheEntryBusy {
+		t.Errorf("Expected %v removing a held entry, got %v", pkgutil.ErrCacheEntryBusy, err)
+	}
+
+	cache.Release()
+	for _, entry := range entries {
+		if err := cache.Remove(entry); err != nil {
+			t.Errorf("Got unexpected error removing entry %s: %s", entry.Digest, err)
+		}
+	}
+	if entries, _ := cache.Entries(); len(entries) != 0 {
+		t.Errorf("Expected empty cache, got %d entries", len(entries))
+	}
+}
+
+// blockingLayer is a layer whose contents can't be read until release is
+// closed. started is closed once they are first asked for.
+type blockingLayer struct {
+	v1.Layer
+	started, release chan struct{}
+}
+
+func (l *blockingLayer) Uncompressed() (io.ReadCloser, error) {
+	close(l.started)
+	<-l.release
+	return l.Layer.Uncompressed()
+}
+
+func TestCachePruneUnpacking(t *testing.T) {
+	layer, err := random.Layer(1024, "application/vnd.docker.image.rootfs.diff.tar.gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocking := &blockingLayer{Layer: layer, started: make(chan struct{}), release: make(chan struct{})}
+	cache := &pkgutil.Cache{Root: t.TempDir()}
+
+	unpacked := make(chan error)
+	go func() {
+		_, _, err := cache.GetLayer(blocking)
+		unpacked <- err
+	}()
+	<-blocking.started
+
+	// an entry another go-routine is unpacking is skipped, not waited for
+	pruned := make(chan error)
+	go func() {
+		removed, err := cache.Prune(0)
+		if err == nil && len(removed) != 0 {
+			err = errors.Errorf("removed %v", removed)
+		}
+		pruned <- err
+	}()
+	select {
+	case err := <-pruned:
+		if err != nil {
+			t.Errorf("Got unexpected error pruning cache: %s", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Pruning the cache waited for the entry being unpacked")
+	}
+
+	close(blocking.release)
+	if err := <-unpacked; err != nil {
+		t.Fatalf("Got unexpected error caching layer: %s", err)
+	}
+	cache.Release()
+	if entries, _ := cache.Entries(); len(entries) != 1 || !entries[0].Complete {
+		t.Errorf("Expected the unpacked entry to be cached, got %v", entries)
+	}
+}
+
+func TestCacheCanceled(t *testing.T) {
+	image, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := image.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := &pkgutil.Cache{Root: t.TempDir()}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := cache.GetLayerContext(ctx, layers[0]); errors.Cause(err) != context.Canceled {
+		t.Errorf("Expected %v caching layer, got %v", context.Canceled, err)
+	}
+	if _, err := cache.GetImageContext(ctx, image); errors.Cause(err) != context.Canceled {
+		t.Errorf("Expected %v caching image, got %v", context.Canceled, err)
+	}
+	// the interrupted extractions leave no partial entries behind
+	entries, err := cache.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected no cache entries, got %v", entries)
+	}
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"code.cloudfoundry.org/bytefmt"
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/sirupsen/logrus"
+)
+
+// number of leading bytes searched for a NUL byte to tell binary files from
+// text files, as git does
+const binarySniffLen = 8000
+
+// ContentDiffLimits caps the contents read when diffing modified files. A
+// zero limit disables it.
+type ContentDiffLimits struct {
+	// MaxFileSize is the size above which a file isn't diffed
+	MaxFileSize int64
+	// MaxTotalSize is the total size of the diffs after which the remaining
+	// files aren't diffed. Once a diff doesn't fit, no later file is diffed.
+	MaxTotalSize int64
+}
+
+// ContentDiff holds the changes to the contents of a modified file: a
+// unified diff for text files, and the digests and sizes of both versions
+// for binary files or files that weren't diffed because of a limit.
+type ContentDiff struct {
+	Binary  bool   `json:",omitempty"`
+	Digest1 string `json:",omitempty"`
+	Digest2 string `json:",omitempty"`
+	Size1   int64  `json:",omitempty"`
+	Size2   int64  `json:",omitempty"`
+	Diff    string `json:",omitempty"`
+	Skipped string `json:",omitempty"`
+}
+
+// AddContentDiffs sets the Content of each modified regular file of diff,
+// reading both versions from the unpacked filesystems at root1 and root2.
+// The unified diffs name the files after label1 and label2.
+func AddContentDiffs(diff *DirDiff, root1, root2, label1, label2 string, limits ContentDiffLimits) {
+	var total int64
+	for i, mod := range diff.Mods {
+		path1 := filepath.Join(root1, mod.Name)
+		path2 := filepath.Join(root2, mod.Name)
+		if !isRegularFile(path1) || !isRegularFile(path2) {
+			continue
+		}
+		content, err := diffContents(path1, path2, label1+mod.Name, label2+mod.Name, limits, &total)
+		if err != nil {
+			logrus.Warningf("Error diffing the contents of %s: %s", mod.Name, err)
+			continue
+		}
+		diff.Mods[i].Content = content
+	}
+}
+
+// diffContents diffs the files at path1 and path2, adding the size of the
+// diff to total. Once total reaches limits.MaxTotalSize, files are only
+// digested, without being read into memory.
+func diffContents(path1, path2, label1, label2 string, limits ContentDiffLimits, total *int64) (*ContentDiff, error) {
+	totalSkipped := fmt.Sprintf("content diffs exceed %s in total", bytefmt.ByteSize(uint64(limits.MaxTotalSize)))
+	if limits.MaxTotalSize > 0 && *total >= limits.MaxTotalSize {
+		return digestContents(path1, path2, totalSkipped)
+	}
+	if limits.MaxFileSize > 0 {
+		if size := maxFileSize(path1, path2); size > limits.MaxFileSize {
+			return digestContents(path1, path2, fmt.Sprintf("larger than %s", bytefmt.ByteSize(uint64(limits.MaxFileSize))))
+		}
+	}
+	contents1, err := os.ReadFile(path1)
+	if err != nil {
+		return nil, err
+	}
+	contents2, err := os.ReadFile(path2)
+	if err != nil {
+		return nil, err
+	}
+	if isBinary(contents1) || isBinary(contents2) {
+		return &ContentDiff{
+			Binary:  true,
+			Digest1: digestBytes(contents1),
+			Digest2: digestBytes(contents2),
+			Size1:   int64(len(contents1)),
+			Size2:   int64(len(contents2)),
+		}, nil
+	}
+	text, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(string(contents1)),
+		B:        splitLines(string(contents2)),
+		FromFile: label1,
+		ToFile:   label2,
+		Context:  3,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if limits.MaxTotalSize > 0 && *total+int64(len(text)) > limits.MaxTotalSize {
+		// smaller diffs may still fit, but leaving them out keeps the cut
+		// at a single point
+		*total = limits.MaxTotalSize
+		return &ContentDiff{
+			Digest1: digestBytes(contents1),
+			Digest2: digestBytes(contents2),
+			Size1:   int64(len(contents1)),
+			Size2:   int64(len(contents2)),
+			Skipped: totalSkipped,
+		}, nil
+	}
+	*total += int64(len(text))
+	return &ContentDiff{Diff: text}, nil
+}
+
+// digestContents summarises two files that aren't diffed by their digests
+// and sizes.
+func digestContents(path1, path2 string, skipped string) (*ContentDiff, error) {
+	content := &ContentDiff{Skipped: skipped}
+	for _, file := range []struct {
+		path   string
+		digest *string
+		size   *int64
+	}{{path1, &content.Digest1, &content.Size1}, {path2, &content.Digest2, &content.Size2}} {
+		info, err := os.Stat(file.path)
+		if err != nil {
+			return nil, err
+		}
+		*file.size = info.Size()
+		if *file.digest, err = pkgutil.FileDigest(file.path); err != nil {
+			return nil, err
+		}
+	}
+	return content, nil
+}
+
+// Summary describes the change to the contents of a file: its unified diff,
+// or the digests and sizes of both versions when there is none.
+func (c ContentDiff) Summary() string {
+	versions := fmt.Sprintf("%s (%s) -> %s (%s)", c.Digest1, stringifySize(c.Size1), c.Digest2, stringifySize(c.Size2))
+	switch {
+	case c.Binary:
+		return "Binary files differ: " + versions
+	case c.Skipped != "":
+		return fmt.Sprintf("Not diffed, %s: %s", c.Skipped, versions)
+	}
+	return c.Diff
+}
+
+// noNewlineMarker follows a last line without a newline in unified diffs,
+// as with git
+const noNewlineMarker = "\\ No newline at end of file\n"
+
+// splitLines splits text into lines for difflib, which unlike
+// difflib.SplitLines doesn't count the end of a last terminated line as
+// another, empty line. An unterminated last line is followed by
+// noNewlineMarker, so that it differs from the same line with a newline.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n" + noNewlineMarker
+	return lines
+}
+
+// isBinary reports whether contents look like those of a binary file, as
+// they hold a NUL byte near their start.
+func isBinary(contents []byte) bool {
+	if len(contents) > binarySniffLen {
+		contents = contents[:binarySniffLen]
+	}
+	return bytes.IndexByte(contents, 0) >= 0
+}
+
+func isRegularFile(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+func maxFileSize(path1, path2 string) int64 {
+	var size int64
+	for _, path := range []string{path1, path2} {
+		if info, err := os.Lstat(path); err == nil && info.Size() > size {
+			size = info.Size()
+		}
+	}
+	return size
+}
+
+func digestBytes(contents []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(contents))
+}
+
+// digestString digests contents read with GetFileContents, which are nil
+// for an empty file.
+func digestString(contents *string) string {
+	if contents == nil {
+		return digestBytes(nil)
+	}
+	return digestBytes([]byte(*contents))
+}
+
+// contentSize is the size of contents read with GetFileContents.
+func contentSize(contents *string) int64 {
+	if contents == nil {
+		return 0
+	}
+	return int64(len(*contents))
+}
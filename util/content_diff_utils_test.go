@@ -0,0 +1,120 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAddContentDiffs(t *testing.T) {
+	root1, root2 := t.TempDir(), t.TempDir()
+	files := []struct {
+		name      string
+		contents1 string
+		contents2 string
+	}{
+		{"/etc/motd", "hello\nworld\n", "hello\ncontainers\n"},
+		{"/usr/bin/tool", "ELF\x00\x01", "ELF\x00\x02"},
+		{"/var/log/big.log", strings.Repeat("a\n", 100), strings.Repeat("b\n", 100)},
+		{"/etc/hosts", "127.0.0.1 localhost\n", "127.0.0.1 localhost\n::1 localhost\n"},
+		{"/etc/tiny", "a\n", "b\n"},
+	}
+	var mods []EntryDiff
+	for _, file := range files {
+		for root, contents := range map[string]string{root1: file.contents1, root2: file.contents2} {
+			path := filepath.Join(root, file.name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		mods = append(mods, EntryDiff{Name: file.name})
+	}
+	mods = append(mods, EntryDiff{Name: "/missing"})
+
+	diff := DirDiff{Mods: mods}
+	AddContentDiffs(&diff, root1, root2, "image1:", "image2:", ContentDiffLimits{MaxFileSize: 100, MaxTotalSize: 150})
+
+	motd := diff.Mods[0].Content
+	expectedMotd := "--- image1:/etc/motd\n+++ image2:/etc/motd\n@@ -1,2 +1,2 @@\n hello\n-world\n+containers\n"
+	if motd == nil || motd.Diff != expectedMotd {
+		t.Errorf("Expected unified diff %q but got: %+v", expectedMotd, motd)
+	}
+	if tool := diff.Mods[1].Content; tool == nil || !tool.Binary || tool.Digest1 == tool.Digest2 || tool.Size1 != 5 || tool.Size2 != 5 || tool.Diff != "" {
+		t.Errorf("Expected binary file summarised by digests and sizes but got: %+v", tool)
+	} else if summary := tool.Summary(); !strings.HasPrefix(summary, "Binary files differ: "+tool.Digest1+" (5B) -> ") {
+		t.Errorf("Expected summary with digests and sizes but got: %s", summary)
+	}
+	if big := diff.Mods[2].Content; big == nil || big.Skipped == "" || big.Digest1 == "" || big.Size1 != 200 || big.Diff != "" {
+		t.Errorf("Expected file over the size limit to be skipped but got: %+v", big)
+	}
+	if hosts := diff.Mods[3].Content; hosts == nil || hosts.Skipped == "" || hosts.Diff != "" {
+		t.Errorf("Expected file over the total limit to be skipped but got: %+v", hosts)
+	}
+	// a smaller diff that would still fit isn't added after the cut
+	if tiny := diff.Mods[4].Content; tiny == nil || tiny.Skipped == "" || tiny.Digest1 == "" || tiny.Diff != "" {
+		t.Errorf("Expected file after the total limit was reached to be skipped but got: %+v", tiny)
+	}
+	if missing := diff.Mods[5].Content; missing != nil {
+		t.Errorf("Expected no content diff for a missing file but got: %+v", missing)
+	}
+}
+
+func TestDiffContentsTrailingNewline(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name      string
+		contents1 string
+		contents2 string
+		expected  string
+	}{
+		{
+			name:      "newline added",
+			contents1: "a\nb",
+			contents2: "a\nb\n",
+			expected:  "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			name:      "no newline in either",
+			contents1: "a\nb",
+			contents2: "c\nb",
+			expected:  "@@ -1,2 +1,2 @@\n-a\n+c\n b\n\\ No newline at end of file\n",
+		},
+	}
+	for _, test := range tests {
+		path1, path2 := filepath.Join(dir, "1"), filepath.Join(dir, "2")
+		if err := os.WriteFile(path1, []byte(test.contents1), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path2, []byte(test.contents2), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var total int64
+		content, err := diffContents(path1, path2, "a", "b", ContentDiffLimits{}, &total)
+		if err != nil {
+			t.Fatalf("%s: got unexpected error: %s", test.name, err)
+		}
+		if expected := "--- a\n+++ b\n" + test.expected; content.Diff != expected {
+			t.Errorf("%s: expected diff %q but got %q", test.name, expected, content.Diff)
+		}
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sort"
 
 	"github.com/sirupsen/logrus"
 )
@@ -29,6 +30,9 @@ type DiffResult struct {
 	Image2   string
 	DiffType string
 	Diff     interface{}
+	// SortSize sorts the files and packages of the output by descending
+	// size rather than by name
+	SortSize bool `json:"-"`
 }
 
 type MultiVersionPackageDiffResult DiffResult
@@ -45,9 +49,9 @@ func (r MultiVersionPackageDiffResult) OutputStruct() interface{} {
 		Packages2 []PackageOutput
 		InfoDiff  []MultiVersionInfo
 	}{
-		Packages1: getMultiVersionPackageOutput(diff.Packages1),
-		Packages2: getMultiVersionPackageOutput(diff.Packages2),
-		InfoDiff:  getMultiVersionInfoDiffOutput(diff.InfoDiff),
+		Packages1: getMultiVersionPackageOutput(diff.Packages1, r.DiffType, r.SortSize),
+		Packages2: getMultiVersionPackageOutput(diff.Packages2, r.DiffType, r.SortSize),
+		InfoDiff:  getMultiVersionInfoDiffOutput(diff.InfoDiff, r.DiffType, r.SortSize),
 	}
 	r.Diff = diffOutput
 	return r
@@ -60,9 +64,9 @@ func (r MultiVersionPackageDiffResult) OutputText(writer io.Writer, diffType str
 		return fmt.Errorf("Could not output %s diff result", r.DiffType)
 	}
 
-	strPackages1 := stringifyPackages(getMultiVersionPackageOutput(diff.Packages1))
-	strPackages2 := stringifyPackages(getMultiVersionPackageOutput(diff.Packages2))
-	strInfoDiff := stringifyMultiVersionPackageDiff(getMultiVersionInfoDiffOutput(diff.InfoDiff))
+	strPackages1 := stringifyPackages(getMultiVersionPackageOutput(diff.Packages1, r.DiffType, r.SortSize))
+	strPackages2 := stringifyPackages(getMultiVersionPackageOutput(diff.Packages2, r.DiffType, r.SortSize))
+	strInfoDiff := stringifyMultiVersionPackageDiff(getMultiVersionInfoDiffOutput(diff.InfoDiff, r.DiffType, r.SortSize))
 
 	type StrDiff struct {
 		Packages1 []StrPackageOutput
@@ -88,8 +92,12 @@ func (r MultiVersionPackageDiffResult) OutputText(writer io.Writer, diffType str
 	return TemplateOutputFromFormat(writer, strResult, "MultiVersionPackageDiff", format)
 }
 
-func getMultiVersionInfoDiffOutput(infoDiff []MultiVersionInfo) []MultiVersionInfo {
-	if SortSize {
+func getMultiVersionInfoDiffOutput(infoDiff []MultiVersionInfo, diffType string, sortSize bool) []MultiVersionInfo {
+	for _, info := range infoDiff {
+		sort.Sort(packageInfoByVersion{info.Info1, diffType})
+		sort.Sort(packageInfoByVersion{info.Info2, diffType})
+	}
+	if sortSize {
 		multiInfoBy(multiInfoSizeSort).Sort(infoDiff)
 	} else {
 		multiInfoBy(multiInfoNameSort).Sort(infoDiff)
@@ -111,9 +119,9 @@ func (r SingleVersionPackageDiffResult) OutputStruct() interface{} {
 		Packages2 []PackageOutput
 		InfoDiff  []Info
 	}{
-		Packages1: getSingleVersionPackageOutput(diff.Packages1),
-		Packages2: getSingleVersionPackageOutput(diff.Packages2),
-		InfoDiff:  getSingleVersionInfoDiffOutput(diff.InfoDiff),
+		Packages1: getSingleVersionPackageOutput(diff.Packages1, r.DiffType, r.SortSize),
+		Packages2: getSingleVersionPackageOutput(diff.Packages2, r.DiffType, r.SortSize),
+		InfoDiff:  getSingleVersionInfoDiffOutput(diff.InfoDiff, r.SortSize),
 	}
 	r.Diff = diffOutput
 	return r
@@ -126,9 +134,9 @@ func (r SingleVersionPackageDiffResult) OutputText(writer io.Writer, diffType st
 		return fmt.Errorf("Could not output %s diff result", r.DiffType)
 	}
 
-	strPackages1 := stringifyPackages(getSingleVersionPackageOutput(diff.Packages1))
-	strPackages2 := stringifyPackages(getSingleVersionPackageOutput(diff.Packages2))
-	strInfoDiff := stringifyPackageDiff(getSingleVersionInfoDiffOutput(diff.InfoDiff))
+	strPackages1 := stringifyPackages(getSingleVersionPackageOutput(diff.Packages1, r.DiffType, r.SortSize))
+	strPackages2 := stringifyPackages(getSingleVersionPackageOutput(diff.Packages2, r.DiffType, r.SortSize))
+	strInfoDiff := stringifyPackageDiff(getSingleVersionInfoDiffOutput(diff.InfoDiff, r.SortSize))
 
 	type StrDiff struct {
 		Packages1 []StrPackageOutput
@@ -154,8 +162,8 @@ func (r SingleVersionPackageDiffResult) OutputText(writer io.Writer, diffType st
 	return TemplateOutputFromFormat(writer, strResult, "SingleVersionPackageDiff", format)
 }
 
-func getSingleVersionInfoDiffOutput(infoDiff []Info) []Info {
-	if SortSize {
+func getSingleVersionInfoDiffOutput(infoDiff []Info, sortSize bool) []Info {
+	if sortSize {
 		singleInfoBy(singleInfoSizeSort).Sort(infoDiff)
 	} else {
 		singleInfoBy(singleInfoNameSort).Sort(infoDiff)
@@ -181,9 +189,9 @@ func (r SingleVersionPackageLayerDiffResult) OutputStruct() interface{} {
 	var diffOutputs []PkgDiff
 	for _, d := range diff.PackageDiffs {
 		diffOutput := PkgDiff{
-			Packages1: getSingleVersionPackageOutput(d.Packages1),
-			Packages2: getSingleVersionPackageOutput(d.Packages2),
-			InfoDiff:  getSingleVersionInfoDiffOutput(d.InfoDiff),
+			Packages1: getSingleVersionPackageOutput(d.Packages1, r.DiffType, r.SortSize),
+			Packages2: getSingleVersionPackageOutput(d.Packages2, r.DiffType, r.SortSize),
+			InfoDiff:  getSingleVersionInfoDiffOutput(d.InfoDiff, r.SortSize),
 		}
 		diffOutputs = append(diffOutputs, diffOutput)
 	}
@@ -208,9 +216,9 @@ func (r SingleVersionPackageLayerDiffResult) OutputText(writer io.Writer, diffTy
 	var diffOutputs []StrDiff
 	for _, d := range diff.PackageDiffs {
 		diffOutput := StrDiff{
-			Packages1: stringifyPackages(getSingleVersionPackageOutput(d.Packages1)),
-			Packages2: stringifyPackages(getSingleVersionPackageOutput(d.Packages2)),
-			InfoDiff:  stringifyPackageDiff(getSingleVersionInfoDiffOutput(d.InfoDiff)),
+			Packages1: stringifyPackages(getSingleVersionPackageOutput(d.Packages1, r.DiffType, r.SortSize)),
+			Packages2: stringifyPackages(getSingleVersionPackageOutput(d.Packages2, r.DiffType, r.SortSize)),
+			InfoDiff:  stringifyPackageDiff(getSingleVersionInfoDiffOutput(d.InfoDiff, r.SortSize)),
 		}
 		diffOutputs = append(diffOutputs, diffOutput)
 	}
@@ -236,7 +244,24 @@ func (r HistDiffResult) OutputStruct() interface{} {
 }
 
 func (r HistDiffResult) OutputText(writer io.Writer, diffType string, format string) error {
-	return TemplateOutputFromFormat(writer, r, "HistDiff", format)
+	diff, valid := r.Diff.(HistDiff)
+	if !valid {
+		logrus.Error("Unexpected structure of Diff.  Should follow the HistDiff struct")
+		return errors.New("Could not output HistoryAnalyzer diff result")
+	}
+
+	strResult := struct {
+		Image1   string
+		Image2   string
+		DiffType string
+		Diff     StrHistDiff
+	}{
+		Image1:   r.Image1,
+		Image2:   r.Image2,
+		DiffType: r.DiffType,
+		Diff:     stringifyHistDiff(diff),
+	}
+	return TemplateOutputFromFormat(writer, strResult, "HistDiff", format)
 }
 
 type MetadataDiffResult DiffResult
@@ -246,7 +271,24 @@ func (r MetadataDiffResult) OutputStruct() interface{} {
 }
 
 func (r MetadataDiffResult) OutputText(writer io.Writer, diffType string, format string) error {
-	return TemplateOutputFromFormat(writer, r, "MetadataDiff", format)
+	diff, valid := r.Diff.([]MetadataDiff)
+	if !valid {
+		logrus.Error("Unexpected structure of Diff.  Should be of type []MetadataDiff")
+		return errors.New("Could not output MetadataAnalyzer diff result")
+	}
+
+	strResult := struct {
+		Image1   string
+		Image2   string
+		DiffType string
+		Diff     []StrMetadataDiff
+	}{
+		Image1:   r.Image1,
+		Image2:   r.Image2,
+		DiffType: r.DiffType,
+		Diff:     stringifyMetadataDiffs(diff),
+	}
+	return TemplateOutputFromFormat(writer, strResult, "MetadataDiff", format)
 }
 
 type DirDiffResult DiffResult
@@ -258,7 +300,7 @@ func (r DirDiffResult) OutputStruct() interface{} {
 		return errors.New("Could not output FileAnalyzer diff result")
 	}
 
-	r.Diff = sortDirDiff(diff)
+	r.Diff = sortDirDiff(diff, r.SortSize)
 	return r
 }
 
@@ -268,7 +310,7 @@ func (r DirDiffResult) OutputText(writer io.Writer, diffType string, format stri
 		logrus.Error("Unexpected structure of Diff.  Should follow the DirDiff struct")
 		return errors.New("Could not output FileAnalyzer diff result")
 	}
-	diff = sortDirDiff(diff)
+	diff = sortDirDiff(diff, r.SortSize)
 
 	strAdds := stringifyDirectoryEntries(diff.Adds)
 	strDels := stringifyDirectoryEntries(diff.Dels)
@@ -428,7 +470,7 @@ func (r MultipleDirDiffResult) OutputStruct() interface{} {
 		return errors.New("Could not output FileLayerAnalyzer diff result")
 	}
 	for i, d := range diff.DirDiffs {
-		diff.DirDiffs[i] = sortDirDiff(d)
+		diff.DirDiffs[i] = sortDirDiff(d, r.SortSize)
 	}
 	r.Diff = diff
 	return r
@@ -441,7 +483,7 @@ func (r MultipleDirDiffResult) OutputText(writer io.Writer, diffType string, for
 		return errors.New("Could not output FileLayerAnalyzer diff result")
 	}
 	for i, d := range diff.DirDiffs {
-		diff.DirDiffs[i] = sortDirDiff(d)
+		diff.DirDiffs[i] = sortDirDiff(d, r.SortSize)
 	}
 
 	type StrDiff struct {
@@ -542,3 +584,57 @@ func (r MultipleMetaDirDiffResult) OutputText(writer io.Writer, diffType string,
 	}
 	return TemplateOutputFromFormat(writer, strResult, "MultipleMetaDirDiff", format)
 }
+
+type LayerShareDiffResult DiffResult
+
+func (r LayerShareDiffResult) OutputStruct() interface{} {
+	return r
+}
+
+func (r LayerShareDiffResult) OutputText(writer io.Writer, diffType string, format string) error {
+	diff, valid := r.Diff.(LayerShareDiff)
+	if !valid {
+		logrus.Error("Unexpected structure of Diff.  Should follow the LayerShareDiff struct")
+		return errors.New("Could not output LayerShareAnalyzer diff result")
+	}
+
+	strResult := struct {
+		Image1   string
+		Image2   string
+		DiffType string
+		Diff     StrLayerShareDiff
+	}{
+		Image1:   r.Image1,
+		Image2:   r.Image2,
+		DiffType: r.DiffType,
+		Diff:     stringifyLayerShareDiff(diff),
+	}
+	return TemplateOutputFromFormat(writer, strResult, "LayerShareDiff", format)
+}
+
+type VulnDiffResult DiffResult
+
+func (r VulnDiffResult) OutputStruct() interface{} {
+	return r
+}
+
+func (r VulnDiffResult) OutputText(writer io.Writer, diffType string, format string) error {
+	diff, valid := r.Diff.(VulnDiff)
+	if !valid {
+		logrus.Error("Unexpected structure of Diff.  Should follow the VulnDiff struct")
+		return errors.New("Could not output VulnAnalyzer diff result")
+	}
+
+	strResult := struct {
+		Image1   string
+		Image2   string
+		DiffType string
+		Diff     StrVulnDiff
+	}{
+		Image1:   r.Image1,
+		Image2:   r.Image2,
+		DiffType: r.DiffType,
+		Diff:     stringifyVulnDiff(diff),
+	}
+	return TemplateOutputFromFormat(writer, strResult, "VulnDiff", format)
+}
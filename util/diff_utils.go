@@ -17,13 +17,16 @@ limitations under the License.
 package util
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"sort"
+	"sync"
 	"syscall"
 
+	"code.cloudfoundry.org/bytefmt"
 	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
 	"github.com/sirupsen/logrus"
 
@@ -36,6 +39,16 @@ type DirDiff struct {
 	Mods []EntryDiff
 }
 
+// hasDigests returns whether the digest of any file of the diff is recorded.
+func (d DirDiff) hasDigests() bool {
+	for _, mod := range d.Mods {
+		if mod.Digest1 != "" || mod.Digest2 != "" {
+			return true
+		}
+	}
+	return hasDigests(d.Adds) || hasDigests(d.Dels)
+}
+
 type MetaDirDiff struct {
 	Adds []pkgutil.DirectoryMetaEntry
 	Dels []pkgutil.DirectoryMetaEntry
@@ -60,6 +73,12 @@ type EntryDiff struct {
 	Name  string
 	Size1 int64
 	Size2 int64
+	// Digest1 and Digest2 are only set for regular files when
+	// pkgutil.FSOptions.Digests is
+	Digest1 string `json:",omitempty"`
+	Digest2 string `json:",omitempty"`
+	// Content is only set when the contents of modified files are diffed
+	Content *ContentDiff `json:",omitempty"`
 }
 
 type MetaEntryDiff struct {
@@ -122,17 +141,33 @@ func GetMatches(a, b []string) []string {
 
 // DiffDirectory takes the diff of two directories, assuming both are completely unpacked
 func DiffDirectory(d1, d2 pkgutil.Directory) (DirDiff, bool) {
+	diff, same, _ := DiffDirectoryContext(context.Background(), d1, d2, pkgutil.FSOptions{})
+	return diff, same
+}
+
+// DiffDirectoryContext is DiffDirectory with opts, but stops hashing and
+// comparing files once ctx is done.
+func DiffDirectoryContext(ctx context.Context, d1, d2 pkgutil.Directory, opts pkgutil.FSOptions) (DirDiff, bool, error) {
+	d1, d2 = pkgutil.FilterDirectory(d1, opts.Filter), pkgutil.FilterDirectory(d2, opts.Filter)
+	if opts.Digests {
+		if err := hashDirectories(ctx, &d1, &d2); err != nil {
+			return DirDiff{}, false, err
+		}
+	}
 	adds := GetAddedEntries(d1, d2)
 	sort.Strings(adds)
-	addedEntries := pkgutil.CreateDirectoryEntries(d2.Root, adds)
+	addedEntries := d2.CreateDirectoryEntries(adds, opts)
 
 	dels := GetDeletedEntries(d1, d2)
 	sort.Strings(dels)
-	deletedEntries := pkgutil.CreateDirectoryEntries(d1.Root, dels)
+	deletedEntries := d1.CreateDirectoryEntries(dels, opts)
 
-	mods := GetModifiedEntries(d1, d2)
+	mods, err := getModifiedEntries(ctx, d1, d2)
+	if err != nil {
+		return DirDiff{}, false, err
+	}
 	sort.Strings(mods)
-	modifiedEntries := createEntryDiffs(d1.Root, d2.Root, mods)
+	modifiedEntries := createEntryDiffs(d1, d2, mods, opts)
 
 	var same bool
 	if len(adds) == 0 && len(dels) == 0 && len(mods) == 0 {
@@ -141,7 +176,7 @@ func DiffDirectory(d1, d2 pkgutil.Directory) (DirDiff, bool) {
 		same = false
 	}
 
-	return DirDiff{addedEntries, deletedEntries, modifiedEntries}, same
+	return DirDiff{addedEntries, deletedEntries, modifiedEntries}, same, nil
 }
 
 // DiffDirectoryMetadata takes the diff of metadata between two directories, assuming both are completely unpacked
@@ -177,11 +212,154 @@ func DiffDirectoryMetadata(d1, d2 pkgutil.Directory) (MetaDirDiff, bool, error)
 	return MetaDirDiff{addedEntries, deletedEntries, modifiedEntries}, same, nil
 }
 
-func DiffFile(image1, image2 *pkgutil.Image, filename string) (*FileNameDiff, error) {
+// DiffIndex takes the diff of two in-memory filesystem indexes, reporting
+// the same changes DiffDirectory reports for unpacked directories, with the
+// digests of regular files when digests is set
+func DiffIndex(i1, i2 *pkgutil.FileIndex, digests bool) (DirDiff, bool) {
+	adds, dels, matches := compareIndexNames(i1, i2)
+	addedEntries := i2.CreateDirectoryEntries(adds, digests)
+	deletedEntries := i1.CreateDirectoryEntries(dels, digests)
+
+	var modifiedEntries []EntryDiff
+	for _, name := range matches {
+		e1, _ := i1.Entry(name)
+		e2, _ := i2.Entry(name)
+		if !indexEntryModified(e1, e2) {
+			continue
+		}
+		entry := EntryDiff{
+			Name:  name,
+			Size1: i1.Size(name),
+			Size2: i2.Size(name),
+		}
+		if digests {
+			entry.Digest1, entry.Digest2 = e1.Digest, e2.Digest
+		}
+		modifiedEntries = append(modifiedEntries, entry)
+	}
+
+	same := len(adds) == 0 && len(dels) == 0 && len(modifiedEntries) == 0
+	return DirDiff{addedEntries, deletedEntries, modifiedEntries}, same
+}
+
+// DiffIndexMetadata takes the diff of metadata between two in-memory
+// filesystem indexes
+func DiffIndexMetadata(i1, i2 *pkgutil.FileIndex) (MetaDirDiff, bool) {
+	adds, dels, matches := compareIndexNames(i1, i2)
+	addedEntries := i2.CreateDirectoryMetaEntries(adds)
+	deletedEntries := i1.CreateDirectoryMetaEntries(dels)
+
+	var modifiedEntries []MetaEntryDiff
+	for _, name := range matches {
+		e1, _ := i1.Entry(name)
+		e2, _ := i2.Entry(name)
+		if e1.Mode == e2.Mode && e1.UID == e2.UID && e1.GID == e2.GID {
+			continue
+		}
+		modifiedEntries = append(modifiedEntries, MetaEntryDiff{
+			Name:  name,
+			Mode1: e1.Mode,
+			Mode2: e2.Mode,
+			UID1:  e1.UID,
+			UID2:  e2.UID,
+			GID1:  e1.GID,
+			GID2:  e2.GID,
+		})
+	}
+
+	same := len(adds) == 0 && len(dels) == 0 && len(modifiedEntries) == 0
+	return MetaDirDiff{addedEntries, deletedEntries, modifiedEntries}, same
+}
+
+// DiffDirectoryEntries diffs two listings of directory entries, such as
+// those printed by file plugins. Entries found in both listings are modified
+// when their sizes or digests differ.
+func DiffDirectoryEntries(entries1, entries2 []pkgutil.DirectoryEntry) DirDiff {
+	byName1 := map[string]pkgutil.DirectoryEntry{}
+	for _, entry := range entries1 {
+		byName1[entry.Name] = entry
+	}
+	byName2 := map[string]pkgutil.DirectoryEntry{}
+	for _, entry := range entries2 {
+		byName2[entry.Name] = entry
+	}
+
+	var diff DirDiff
+	for _, e2 := range entries2 {
+		e1, ok := byName1[e2.Name]
+		if !ok {
+			diff.Adds = append(diff.Adds, e2)
+			continue
+		}
+		if e1.Size != e2.Size || e1.Digest != e2.Digest {
+			diff.Mods = append(diff.Mods, EntryDiff{
+				Name:    e2.Name,
+				Size1:   e1.Size,
+				Size2:   e2.Size,
+				Digest1: e1.Digest,
+				Digest2: e2.Digest,
+			})
+		}
+	}
+	for _, e1 := range entries1 {
+		if _, ok := byName2[e1.Name]; !ok {
+			diff.Dels = append(diff.Dels, e1)
+		}
+	}
+	return diff
+}
+
+// compareIndexNames returns the sorted paths only found in i2, only found
+// in i1, and found in both
+func compareIndexNames(i1, i2 *pkgutil.FileIndex) (adds, dels, matches []string) {
+	for _, name := range i2.Names() {
+		if _, ok := i1.Entry(name); !ok {
+			adds = append(adds, name)
+		}
+	}
+	for _, name := range i1.Names() {
+		if _, ok := i2.Entry(name); ok {
+			matches = append(matches, name)
+		} else {
+			dels = append(dels, name)
+		}
+	}
+	sort.Strings(adds)
+	sort.Strings(dels)
+	sort.Strings(matches)
+	return adds, dels, matches
+}
+
+// indexEntryModified compares two index entries of the same path the way
+// GetModifiedEntries compares unpacked files
+func indexEntryModified(e1, e2 pkgutil.IndexEntry) bool {
+	if e1.Mode.Type() != e2.Mode.Type() {
+		return true
+	}
+	switch {
+	case e1.Mode&os.ModeSymlink != 0:
+		return e1.Linkname != e2.Linkname
+	case e1.Mode.IsDir():
+		return false
+	}
+	return e1.Size != e2.Size || e1.Digest != e2.Digest
+}
+
+// DiffFile diffs the contents of the file at filename in both images. Binary
+// files and files larger than limits.MaxFileSize are only compared by digest.
+func DiffFile(image1, image2 *pkgutil.Image, filename string, limits ContentDiffLimits) (*FileNameDiff, error) {
 	//Join paths
 	image1FilePath := filepath.Join(image1.FSPath, filename)
 	image2FilePath := filepath.Join(image2.FSPath, filename)
 
+	if limits.MaxFileSize > 0 && maxFileSize(image1FilePath, image2FilePath) > limits.MaxFileSize {
+		content, err := digestContents(image1FilePath, image2FilePath, fmt.Sprintf("larger than %s", bytefmt.ByteSize(uint64(limits.MaxFileSize))))
+		if err != nil {
+			return nil, err
+		}
+		return &FileNameDiff{filename, content.Summary(), ""}, nil
+	}
+
 	//Get contents of files
 	image1FileContents, err := pkgutil.GetFileContents(image1FilePath)
 	if err != nil {
@@ -194,6 +372,19 @@ func DiffFile(image1, image2 *pkgutil.Image, filename string) (*FileNameDiff, er
 	}
 
 	description := ""
+	//Binary files are only compared by digest
+	if image1FileContents != nil && isBinary([]byte(*image1FileContents)) ||
+		image2FileContents != nil && isBinary([]byte(*image2FileContents)) {
+		content := ContentDiff{
+			Binary:  true,
+			Digest1: digestString(image1FileContents),
+			Digest2: digestString(image2FileContents),
+			Size1:   contentSize(image1FileContents),
+			Size2:   contentSize(image2FileContents),
+		}
+		return &FileNameDiff{filename, content.Summary(), ""}, nil
+	}
+
 	//Check if file contents are empty or if they are the same
 	if image1FileContents == nil && image2FileContents == nil {
 		description := "Both files are empty"
@@ -277,6 +468,15 @@ func GetModifiedMetaEntries(d1, d2 pkgutil.Directory) []string {
 
 // Checks for content differences between files of the same name from different directories
 func GetModifiedEntries(d1, d2 pkgutil.Directory) []string {
+	modified, _ := getModifiedEntries(context.Background(), d1, d2)
+	return modified
+}
+
+// getModifiedEntries is GetModifiedEntries, but stops comparing files once
+// ctx is done.
+func getModifiedEntries(ctx context.Context, d1, d2 pkgutil.Directory) ([]string, error) {
+	d1.LoadDigests()
+	d2.LoadDigests()
 	d1files := d1.Content
 	d2files := d2.Content
 
@@ -284,6 +484,9 @@ func GetModifiedEntries(d1, d2 pkgutil.Directory) []string {
 
 	modified := []string{}
 	for _, f := range filematches {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		f1path := fmt.Sprintf("%s%s", d1.Root, f)
 		f2path := fmt.Sprintf("%s%s", d2.Root, f)
 
@@ -298,8 +501,13 @@ func GetModifiedEntries(d1, d2 pkgutil.Directory) []string {
 			continue
 		}
 
+		if f1stat.Mode().Type() != f2stat.Mode().Type() {
+			modified = append(modified, f)
+			continue
+		}
+
 		// If the directory entry is a symlink, make sure the symlinks point to the same place
-		if f1stat.Mode()&os.ModeSymlink != 0 && f2stat.Mode()&os.ModeSymlink != 0 {
+		if f1stat.Mode()&os.ModeSymlink != 0 {
 			same, err := pkgutil.CheckSameSymlink(f1path, f2path)
 			if err != nil {
 				logrus.Errorf("Error determining if symlink %s and %s are equivalent: %s\n", f1path, f2path, err)
@@ -311,17 +519,18 @@ func GetModifiedEntries(d1, d2 pkgutil.Directory) []string {
 			continue
 		}
 
-		// If the directory entry in question is a tar, verify that the two have the same size
-		if pkgutil.IsTar(f1path) {
-			if f1stat.Size() != f2stat.Size() {
-				modified = append(modified, f)
-			}
-			continue
-		}
-
-		// If the directory entry is not a tar and not a directory, then it's a file so make sure the file contents are the same
+		// If the directory entry is not a directory, then it's a file so make sure the file contents are the same,
+		// comparing the digests known for both directories if there are any
 		// Note: We skip over directory entries because to compare directories, we compare their contents
 		if !f1stat.IsDir() {
+			digest1, ok1 := d1.Digests[f]
+			digest2, ok2 := d2.Digests[f]
+			if ok1 && ok2 {
+				if digest1 != digest2 {
+					modified = append(modified, f)
+				}
+				continue
+			}
 			same, err := pkgutil.CheckSameFile(f1path, f2path)
 			if err != nil {
 				logrus.Errorf("Error diffing contents of %s and %s: %s\n", f1path, f2path, err)
@@ -332,7 +541,7 @@ func GetModifiedEntries(d1, d2 pkgutil.Directory) []string {
 			}
 		}
 	}
-	return modified
+	return modified, nil
 }
 
 func GetAddedEntries(d1, d2 pkgutil.Directory) []string {
@@ -343,24 +552,40 @@ func GetDeletedEntries(d1, d2 pkgutil.Directory) []string {
 	return GetDeletions(d1.Content, d2.Content)
 }
 
-func createEntryDiffs(root1, root2 string, entryNames []string) (entries []EntryDiff) {
+func createEntryDiffs(d1, d2 pkgutil.Directory, entryNames []string, opts pkgutil.FSOptions) (entries []EntryDiff) {
 	for _, name := range entryNames {
-		entryPath1 := filepath.Join(root1, name)
-		size1 := pkgutil.GetSize(entryPath1)
-
-		entryPath2 := filepath.Join(root2, name)
-		size2 := pkgutil.GetSize(entryPath2)
+		size1 := pkgutil.GetFilteredSize(d1.Root, name, opts.Filter)
+		size2 := pkgutil.GetFilteredSize(d2.Root, name, opts.Filter)
 
 		entry := EntryDiff{
 			Name:  name,
 			Size1: size1,
 			Size2: size2,
 		}
+		if opts.Digests {
+			entry.Digest1, _ = d1.Digest(name)
+			entry.Digest2, _ = d2.Digest(name)
+		}
 		entries = append(entries, entry)
 	}
 	return entries
 }
 
+// hashDirectories records the digests of the regular files of both
+// directories, hashing them concurrently until ctx is done.
+func hashDirectories(ctx context.Context, d1, d2 *pkgutil.Directory) error {
+	var wg sync.WaitGroup
+	for _, d := range []*pkgutil.Directory{d1, d2} {
+		wg.Add(1)
+		go func(d *pkgutil.Directory) {
+			defer wg.Done()
+			pkgutil.HashDirectoryContext(ctx, d)
+		}(d)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
 func createMetaEntryDiffs(root1, root2 string, entryNames []string) (entries []MetaEntryDiff, err error) {
 	for _, name := range entryNames {
 		entryPath1 := filepath.Join(root1, name)
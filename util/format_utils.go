@@ -45,6 +45,10 @@ var templates = map[string]string{
 	"SizeLayerAnalyze":                 SizeLayerAnalysisOutput,
 	"SizeDiff":                         SizeDiffOutput,
 	"SizeLayerDiff":                    SizeLayerDiffOutput,
+	"LayerShareDiff":                   LayerShareDiffOutput,
+	"LayerShareAnalyze":                LayerShareAnalysisOutput,
+	"VulnDiff":                         VulnDiffOutput,
+	"VulnAnalyze":                      VulnAnalysisOutput,
 	"MultiVersionPackageAnalyze":       MultiVersionPackageOutput,
 	"SingleVersionPackageAnalyze":      SingleVersionPackageOutput,
 	"SingleVersionPackageLayerAnalyze": SingleVersionPackageLayerOutput,
@@ -17,6 +17,12 @@ limitations under the License.
 package util
 
 import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -85,6 +91,135 @@ func TestGetModifiedEntries(t *testing.T) {
 	}
 }
 
+func TestDiffDirectoryDigests(t *testing.T) {
+	files1 := map[string]string{"same.txt": "lime", "layer.tar": "peach", "removed.txt": "pear"}
+	files2 := map[string]string{"same.txt": "lime", "layer.tar": "mango", "added.txt": "kiwi"}
+	var dirs [2]pkgutil.Directory
+	for i, files := range []map[string]string{files1, files2} {
+		root, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatalf("Error creating temp dir: %s", err)
+		}
+		defer os.RemoveAll(root)
+		for name, contents := range files {
+			if err := ioutil.WriteFile(filepath.Join(root, name), []byte(contents), 0644); err != nil {
+				t.Fatalf("Error writing %s: %s", name, err)
+			}
+		}
+		dirs[i], err = pkgutil.GetDirectory(root, true)
+		if err != nil {
+			t.Fatalf("Error converting directory to Directory struct: %s", err)
+		}
+	}
+	digest := func(contents string) string {
+		return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(contents)))
+	}
+
+	// tars of the same size are compared by content too
+	if mods := GetModifiedEntries(dirs[0], dirs[1]); !reflect.DeepEqual(mods, []string{"/layer.tar"}) {
+		t.Errorf("Expected /layer.tar to be modified, got %v", mods)
+	}
+	diff, _ := DiffDirectory(dirs[0], dirs[1])
+	if diff.Adds[0].Digest != "" || diff.Mods[0].Digest1 != "" {
+		t.Errorf("Expected no digests unless recorded, got %v", diff)
+	}
+
+	opts := pkgutil.FSOptions{Digests: true}
+	diff, _, err := DiffDirectoryContext(context.Background(), dirs[0], dirs[1], opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := DirDiff{
+		Adds: []pkgutil.DirectoryEntry{{Name: "/added.txt", Size: 4, Digest: digest("kiwi")}},
+		Dels: []pkgutil.DirectoryEntry{{Name: "/removed.txt", Size: 4, Digest: digest("pear")}},
+		Mods: []EntryDiff{{Name: "/layer.tar", Size1: 5, Size2: 5, Digest1: digest("peach"), Digest2: digest("mango")}},
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("\nExpected: %v\nGot: %v\n", expected, diff)
+	}
+	entries, err := pkgutil.GetDirectoryEntriesContext(context.Background(), dirs[0], opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Digest != digest(files1[entry.Name[1:]]) {
+			t.Errorf("Expected %s to have digest %s, got %s", entry.Name, digest(files1[entry.Name[1:]]), entry.Digest)
+		}
+	}
+}
+
+func TestGetModifiedEntriesRecordedDigests(t *testing.T) {
+	files := [2]map[string]string{
+		{"lime.txt": "lime", "peach.txt": "peach"},
+		{"lime.txt": "lime", "peach.txt": "mango"},
+	}
+	var dirs [2]pkgutil.Directory
+	for i := range files {
+		root := filepath.Join(t.TempDir(), "fs")
+		if err := os.Mkdir(root, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for name, contents := range files[i] {
+			if err := ioutil.WriteFile(filepath.Join(root, name), []byte(contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		dirs[i] = pkgutil.Directory{Root: root, Content: []string{"/lime.txt", "/peach.txt"}}
+	}
+
+	// without recorded digests the contents are compared
+	if mods := GetModifiedEntries(dirs[0], dirs[1]); !reflect.DeepEqual(mods, []string{"/peach.txt"}) {
+		t.Errorf("Expected /peach.txt to be modified, got %v", mods)
+	}
+
+	// recorded digests are trusted over the contents, files with a digest
+	// recorded on one side only are compared by contents
+	recorded := [2]string{
+		`{"/peach.txt": "sha256:1234"}`,
+		`{"/peach.txt": "sha256:1234", "/lime.txt": "sha256:5678"}`,
+	}
+	for i, digests := range recorded {
+		if err := ioutil.WriteFile(dirs[i].Root+".digests.json", []byte(digests), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mods := GetModifiedEntries(dirs[0], dirs[1]); len(mods) != 0 {
+		t.Errorf("Expected no modified files, got %v", mods)
+	}
+}
+
+func TestHashDirectory(t *testing.T) {
+	root := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(root, "lime.txt"), []byte("lime"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// a file that can't be read doesn't discard the other digests
+	d := pkgutil.Directory{Root: root, Content: []string{"/lime.txt", "/missing.txt"}}
+	pkgutil.HashDirectory(&d)
+	expected := map[string]string{"/lime.txt": fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("lime")))}
+	if !reflect.DeepEqual(d.Digests, expected) {
+		t.Errorf("Expected digests %v, got %v", expected, d.Digests)
+	}
+}
+
+func TestDirectoryCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pkgutil.GetDirectoryContext(ctx, "testTars/la-croix3-full", true, nil); err != context.Canceled {
+		t.Errorf("Expected %v walking directory, got %v", context.Canceled, err)
+	}
+	d, err := pkgutil.GetDirectory("testTars/la-croix3-full", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pkgutil.HashDirectoryContext(ctx, &d); err != context.Canceled || d.Digests != nil {
+		t.Errorf("Expected %v and no digests hashing directory, got %v and %v", context.Canceled, err, d.Digests)
+	}
+	if _, _, err := DiffDirectoryContext(ctx, d, d, pkgutil.FSOptions{Digests: true}); err != context.Canceled {
+		t.Errorf("Expected %v diffing directories, got %v", context.Canceled, err)
+	}
+}
+
 func TestGetDirectory(t *testing.T) {
 	tests := []struct {
 		descrip  string
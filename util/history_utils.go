@@ -0,0 +1,120 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// Kinds of history entries matched between two images, besides the entries
+// added to and deleted from the second image
+const (
+	// HistoryReused entries ran the same command and produced the same layer
+	HistoryReused = "reused"
+	// HistoryRebuilt entries ran the same command but produced a different layer
+	HistoryRebuilt = "rebuilt"
+)
+
+// HistoryEntry is a history entry of an image config paired with the layer
+// it created. Digest is empty and Size -1 for entries that created no layer.
+type HistoryEntry struct {
+	CreatedBy  string
+	Created    v1.Time
+	Comment    string `json:",omitempty"`
+	EmptyLayer bool
+	Digest     string `json:",omitempty"`
+	Size       int64
+}
+
+// HistoryEntryDiff pairs an entry of the first history with the matching
+// entry of the second. Entry1 is nil for added entries, Entry2 for deleted.
+type HistoryEntryDiff struct {
+	Kind   string
+	Entry1 *HistoryEntry
+	Entry2 *HistoryEntry
+}
+
+// HistDiff aligns the histories of two images. SharedBase is the number of
+// leading entries that are reused by the second image, the builds diverging
+// right after them.
+type HistDiff struct {
+	SharedBase int
+	Entries    []HistoryEntryDiff
+}
+
+// Identical is true when the second history reuses every entry of the first
+func (d HistDiff) Identical() bool {
+	return d.SharedBase == len(d.Entries)
+}
+
+// DiffHistories aligns two histories on their longest common subsequence of
+// commands, after their shared base.
+func DiffHistories(h1, h2 []HistoryEntry) HistDiff {
+	diff := HistDiff{Entries: []HistoryEntryDiff{}}
+	for diff.SharedBase < len(h1) && diff.SharedBase < len(h2) {
+		e1, e2 := h1[diff.SharedBase], h2[diff.SharedBase]
+		if !sameHistoryCommand(e1, e2) || e1.Digest != e2.Digest {
+			break
+		}
+		diff.Entries = append(diff.Entries, HistoryEntryDiff{Kind: HistoryReused, Entry1: &h1[diff.SharedBase], Entry2: &h2[diff.SharedBase]})
+		diff.SharedBase++
+	}
+	rest1, rest2 := h1[diff.SharedBase:], h2[diff.SharedBase:]
+
+	// lengths[i][j] is the length of the longest common subsequence of
+	// rest1[i:] and rest2[j:]
+	lengths := make([][]int, len(rest1)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(rest2)+1)
+	}
+	for i := len(rest1) - 1; i >= 0; i-- {
+		for j := len(rest2) - 1; j >= 0; j-- {
+			if sameHistoryCommand(rest1[i], rest2[j]) {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(rest1) || j < len(rest2) {
+		switch {
+		case i < len(rest1) && j < len(rest2) && sameHistoryCommand(rest1[i], rest2[j]):
+			kind := HistoryReused
+			if rest1[i].Digest != rest2[j].Digest {
+				kind = HistoryRebuilt
+			}
+			diff.Entries = append(diff.Entries, HistoryEntryDiff{Kind: kind, Entry1: &rest1[i], Entry2: &rest2[j]})
+			i++
+			j++
+		case j == len(rest2) || i < len(rest1) && lengths[i+1][j] >= lengths[i][j+1]:
+			diff.Entries = append(diff.Entries, HistoryEntryDiff{Kind: ChangeDeleted, Entry1: &rest1[i]})
+			i++
+		default:
+			diff.Entries = append(diff.Entries, HistoryEntryDiff{Kind: ChangeAdded, Entry2: &rest2[j]})
+			j++
+		}
+	}
+	return diff
+}
+
+func sameHistoryCommand(e1, e2 HistoryEntry) bool {
+	return e1.CreatedBy == e2.CreatedBy && e1.EmptyLayer == e2.EmptyLayer
+}
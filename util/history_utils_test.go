@@ -0,0 +1,95 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffHistories(t *testing.T) {
+	base := HistoryEntry{CreatedBy: "ADD rootfs.tar /", Digest: "sha256:base", Size: 100}
+	env := HistoryEntry{CreatedBy: "ENV A=1", EmptyLayer: true, Size: -1}
+	install := HistoryEntry{CreatedBy: "RUN apt-get install -y curl", Digest: "sha256:curl", Size: 20}
+	reinstall := HistoryEntry{CreatedBy: "RUN apt-get install -y curl", Digest: "sha256:curl2", Size: 25}
+	copyApp := HistoryEntry{CreatedBy: "COPY app /app", Digest: "sha256:app", Size: 5}
+	touch := HistoryEntry{CreatedBy: "RUN touch /a", Digest: "sha256:touch", Size: 1}
+
+	testCases := []struct {
+		descrip    string
+		history1   []HistoryEntry
+		history2   []HistoryEntry
+		sharedBase int
+		kinds      []string
+	}{
+		{
+			descrip:    "identical histories",
+			history1:   []HistoryEntry{base, env, install},
+			history2:   []HistoryEntry{base, env, install},
+			sharedBase: 3,
+			kinds:      []string{HistoryReused, HistoryReused, HistoryReused},
+		},
+		{
+			descrip:    "layer rebuilt after the shared base",
+			history1:   []HistoryEntry{base, env, install, copyApp},
+			history2:   []HistoryEntry{base, env, reinstall, copyApp},
+			sharedBase: 2,
+			kinds:      []string{HistoryReused, HistoryReused, HistoryRebuilt, HistoryReused},
+		},
+		{
+			descrip:    "entry inserted",
+			history1:   []HistoryEntry{base, install, copyApp},
+			history2:   []HistoryEntry{base, env, install, copyApp},
+			sharedBase: 1,
+			kinds:      []string{HistoryReused, ChangeAdded, HistoryReused, HistoryReused},
+		},
+		{
+			descrip:    "repeated commands are kept",
+			history1:   []HistoryEntry{base, touch, touch},
+			history2:   []HistoryEntry{base, touch},
+			sharedBase: 2,
+			kinds:      []string{HistoryReused, HistoryReused, ChangeDeleted},
+		},
+		{
+			descrip:    "different bases",
+			history1:   []HistoryEntry{install},
+			history2:   []HistoryEntry{base},
+			sharedBase: 0,
+			kinds:      []string{ChangeDeleted, ChangeAdded},
+		},
+	}
+	for _, test := range testCases {
+		diff := DiffHistories(test.history1, test.history2)
+		if diff.SharedBase != test.sharedBase {
+			t.Errorf("%s: Expected shared base of %d entries but got %d", test.descrip, test.sharedBase, diff.SharedBase)
+		}
+		kinds := []string{}
+		for _, entry := range diff.Entries {
+			kinds = append(kinds, entry.Kind)
+		}
+		if !reflect.DeepEqual(kinds, test.kinds) {
+			t.Errorf("%s: Expected: %v but got: %v", test.descrip, test.kinds, kinds)
+		}
+	}
+
+	diff := DiffHistories([]HistoryEntry{base, install}, []HistoryEntry{base, reinstall})
+	changes := histDiffChanges(diff)
+	expected := []Change{{Kind: ChangeModified, Name: install.CreatedBy, Size1: 20, Size2: 25}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected changes: %v but got: %v", expected, changes)
+	}
+}
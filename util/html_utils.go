@@ -0,0 +1,183 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+// HTMLFormat is the --output-format value selecting the HTML report
+const HTMLFormat = "html"
+
+type htmlDiffLine struct {
+	Text  string
+	Class string
+}
+
+// htmlDiffLines splits a unified diff into lines, classed for highlighting
+func htmlDiffLines(diff string) []htmlDiffLine {
+	var lines []htmlDiffLine
+	for _, line := range strings.Split(strings.TrimSuffix(diff, "\n"), "\n") {
+		class := ""
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			class = "file"
+		case strings.HasPrefix(line, "@@"):
+			class = "hunk"
+		case strings.HasPrefix(line, "+"):
+			class = "add"
+		case strings.HasPrefix(line, "-"):
+			class = "del"
+		}
+		lines = append(lines, htmlDiffLine{Text: line, Class: class})
+	}
+	return lines
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"diffLines": htmlDiffLines,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>container-diff: {{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #24292f; }
+h1 { font-size: 1.6em; }
+.created { color: #57606a; }
+nav ul { padding-left: 1.2em; }
+details { border: 1px solid #d0d7de; border-radius: 6px; margin: 1em 0; padding: 0.5em 1em; }
+details details { margin-left: 1em; }
+summary { cursor: pointer; font-weight: 600; }
+.count { display: inline-block; font-weight: normal; font-size: 0.85em; border-radius: 1em; padding: 0 0.6em; margin-left: 0.4em; background: #eaeef2; }
+.count.added { background: #dafbe1; }
+.count.deleted { background: #ffebe9; }
+.count.modified { background: #fff8c5; }
+table { border-collapse: collapse; margin: 0.5em 0 1em; font-size: 0.9em; }
+th, td { border: 1px solid #d0d7de; padding: 0.2em 0.6em; text-align: left; vertical-align: top; }
+th { background: #f6f8fa; cursor: pointer; user-select: none; }
+th[aria-sort=ascending]::after { content: " \25B2"; }
+th[aria-sort=descending]::after { content: " \25BC"; }
+tr.added td:first-child { border-left: 4px solid #2da44e; }
+tr.deleted td:first-child { border-left: 4px solid #cf222e; }
+tr.modified td:first-child { border-left: 4px solid #bf8700; }
+td.grew { color: #cf222e; }
+td.shrank { color: #1a7f37; }
+pre { background: #f6f8fa; padding: 0.5em; overflow-x: auto; font-size: 0.85em; }
+pre span { display: block; }
+pre .add { background: #dafbe1; }
+pre .del { background: #ffebe9; }
+pre .hunk { color: #0969da; }
+pre .file { font-weight: 600; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Created}}<p class="created">Generated by container-diff on {{.Created}}</p>{{end}}
+<nav><ul>
+{{- range .Groups}}{{$platform := .Platform}}{{range .Sections}}
+<li><a href="#{{.ID}}">{{if $platform}}{{$platform}} {{end}}{{.Title}}</a>{{template "counts" .Counts}}</li>
+{{- end}}{{end}}
+{{- if .FileDiff}}
+<li><a href="#filename">{{.FileDiff.Filename}}</a></li>
+{{- end}}
+</ul></nav>
+{{range .Groups}}
+{{- if .Platform}}<h2>Platform {{.Platform}}</h2>{{end}}
+{{- range .Sections}}{{template "section" .}}{{end}}
+{{end}}
+{{- with .FileDiff}}
+<details id="filename" open>
+<summary>{{.Filename}}</summary>
+<p>{{.Description}}</p>
+{{template "diff" .Diff}}
+</details>
+{{- end}}
+<script>
+document.querySelectorAll("th").forEach(function(th) {
+  th.addEventListener("click", function() {
+    var body = th.closest("table").tBodies[0];
+    var index = Array.prototype.indexOf.call(th.parentNode.children, th);
+    var ascending = th.getAttribute("aria-sort") !== "ascending";
+    th.parentNode.querySelectorAll("th").forEach(function(h) { h.removeAttribute("aria-sort"); });
+    th.setAttribute("aria-sort", ascending ? "ascending" : "descending");
+    var key = function(row) {
+      var cell = row.cells[index];
+      return cell.hasAttribute("data-sort") ? cell.getAttribute("data-sort") : cell.textContent;
+    };
+    var rows = Array.prototype.slice.call(body.rows);
+    rows.sort(function(a, b) {
+      var x = key(a), y = key(b);
+      var order = (x !== "" && y !== "" && !isNaN(x) && !isNaN(y)) ? Number(x) - Number(y) : x.localeCompare(y);
+      return ascending ? order : -order;
+    });
+    rows.forEach(function(row) { body.appendChild(row); });
+  });
+});
+</script>
+</body>
+</html>
+{{define "counts"}}{{range .}} <span class="count {{.Label}}">{{.Count}} {{.Label}}</span>{{end}}{{end}}
+{{define "diff"}}<pre>{{range diffLines .}}<span{{if .Class}} class="{{.Class}}"{{end}}>{{.Text}}</span>{{end}}</pre>{{end}}
+{{define "section"}}
+<details id="{{.ID}}"{{if not .Layer}} open{{end}}>
+<summary>{{.Title}}{{template "counts" .Counts}}</summary>
+{{- range .Tables}}
+{{- if .Title}}<h4>{{.Title}}{{if not .Rows}}: None{{end}}</h4>{{end}}
+{{- if .Rows}}
+<table>
+<thead><tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>
+{{- range .Rows}}
+<tr{{if .Change}} class="{{.Change}}"{{end}}>{{range .Cells}}<td{{if .Sort}} data-sort="{{.Sort}}"{{end}}{{if .Class}} class="{{.Class}}"{{end}}>{{.Text}}</td>{{end}}</tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+{{- end}}
+{{- range .Diffs}}
+<details open>
+<summary>{{.Title}}</summary>
+{{template "diff" .Text}}
+</details>
+{{- end}}
+{{- range .Layers}}{{template "section" .}}{{end}}
+{{- if .Raw}}<pre>{{.Raw}}</pre>{{end}}
+</details>
+{{end}}`))
+
+// WriteHTMLReport writes report as a self-contained HTML page, with a
+// collapsible section per analyzer and layer, and sortable tables.
+func WriteHTMLReport(writer io.Writer, report Report) error {
+	data := struct {
+		Title    string
+		Created  string
+		Groups   []reportGroup
+		FileDiff *FileNameDiff
+	}{
+		Title:    report.Title,
+		Groups:   reportGroups(report),
+		FileDiff: report.FileDiff,
+	}
+	if !report.Created.IsZero() {
+		data.Created = report.Created.Format(time.RFC1123)
+	}
+	return htmlReportTemplate.Execute(writer, data)
+}
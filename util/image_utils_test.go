@@ -17,9 +17,15 @@ limitations under the License.
 package util
 
 import (
+	"reflect"
 	"testing"
 
 	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
 )
 
 func TestImageTags(t *testing.T) {
@@ -51,3 +57,114 @@ func TestImageTags(t *testing.T) {
 		}
 	}
 }
+
+func TestOCILayoutImage(t *testing.T) {
+	img1, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img2, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest1, _ := img1.Digest()
+	digest2, _ := img2.Digest()
+
+	single := t.TempDir()
+	p, err := layout.Write(single, empty.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AppendImage(img1); err != nil {
+		t.Fatal(err)
+	}
+
+	multi := t.TempDir()
+	p, err = layout.Write(multi, empty.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AppendImage(img1, layout.WithAnnotations(map[string]string{"org.opencontainers.image.ref.name": "v1"})); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AppendImage(img2, layout.WithAnnotations(map[string]string{"org.opencontainers.image.ref.name": "v2"})); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		image    string
+		expected v1.Hash
+		err      bool
+	}{
+		{image: "oci://" + single, expected: digest1},
+		{image: "oci://" + multi, err: true},
+		{image: "oci://" + multi + ":v2", expected: digest2},
+		{image: "oci://" + multi + "@" + digest1.String(), expected: digest1},
+		{image: "oci://" + multi + ":v3", err: true},
+	}
+	for _, test := range tests {
+		image, err := pkgutil.GetImageWithOptions(test.image, pkgutil.ImageOptions{})
+		if test.err {
+			if err == nil {
+				t.Errorf("Expected error retrieving %s but got none", test.image)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Got unexpected error retrieving %s: %s", test.image, err)
+			continue
+		}
+		if image.Digest != test.expected {
+			t.Errorf("Retrieving %s: expected digest %s but got %s", test.image, test.expected, image.Digest)
+		}
+	}
+}
+
+func TestOCILayoutPlatforms(t *testing.T) {
+	amd64, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arm64, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	amd64Digest, _ := amd64.Digest()
+	arm64Digest, _ := arm64.Digest()
+	index := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: amd64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+		mutate.IndexAddendum{Add: arm64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "arm64"}}},
+	)
+	dir := t.TempDir()
+	p, err := layout.Write(dir, empty.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AppendIndex(index); err != nil {
+		t.Fatal(err)
+	}
+
+	platforms, err := pkgutil.GetPlatforms("oci://" + dir)
+	if err != nil {
+		t.Fatalf("Got unexpected error listing platforms: %s", err)
+	}
+	expected := []v1.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}}
+	if !reflect.DeepEqual(platforms, expected) {
+		t.Errorf("Expected platforms %v but got %v", expected, platforms)
+	}
+
+	if _, err := pkgutil.GetImageWithOptions("oci://"+dir, pkgutil.ImageOptions{}); err == nil {
+		t.Errorf("Expected error retrieving multi-platform image without a platform but got none")
+	}
+	for platform, digest := range map[string]v1.Hash{"linux/amd64": amd64Digest, "linux/arm64": arm64Digest} {
+		p, _ := v1.ParsePlatform(platform)
+		image, err := pkgutil.GetImageWithOptions("oci://"+dir, pkgutil.ImageOptions{Platform: p})
+		if err != nil {
+			t.Errorf("Got unexpected error retrieving platform %s: %s", platform, err)
+			continue
+		}
+		if image.Digest != digest {
+			t.Errorf("Retrieving platform %s: expected digest %s but got %s", platform, digest, image.Digest)
+		}
+	}
+}
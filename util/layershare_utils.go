@@ -0,0 +1,116 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+// LayerBlob is a compressed layer blob of an image, as listed in its
+// manifest. UncompressedSize is -1 when unknown.
+type LayerBlob struct {
+	Digest           string
+	DiffID           string
+	Size             int64
+	UncompressedSize int64
+}
+
+// LayerShareAnalysis lists the distinct layer blobs of an image, and their
+// total size.
+type LayerShareAnalysis struct {
+	Layers           []LayerBlob
+	Size             int64
+	UncompressedSize int64
+}
+
+// LayerShareDiff splits the layer blobs of two images into those both images
+// share and those unique to either. PullSize is the compressed size of the
+// blobs a node holding image1 has to download to run image2.
+type LayerShareDiff struct {
+	Shared            []LayerBlob
+	Unique1           []LayerBlob
+	Unique2           []LayerBlob
+	Size1             int64
+	Size2             int64
+	UncompressedSize1 int64
+	UncompressedSize2 int64
+	SharedSize        int64
+	PullSize          int64
+}
+
+// AnalyzeLayerShare drops the repeated blobs of an image's layers, which are
+// only stored and pulled once, and sums up their sizes.
+func AnalyzeLayerShare(layers []LayerBlob) LayerShareAnalysis {
+	analysis := LayerShareAnalysis{Layers: dedupLayerBlobs(layers)}
+	analysis.Size, analysis.UncompressedSize = sumLayerBlobs(analysis.Layers)
+	return analysis
+}
+
+// DiffLayerShare compares the layer blobs of two images by digest.
+func DiffLayerShare(layers1, layers2 []LayerBlob) LayerShareDiff {
+	blobs1, blobs2 := dedupLayerBlobs(layers1), dedupLayerBlobs(layers2)
+	inImage1 := map[string]bool{}
+	for _, blob := range blobs1 {
+		inImage1[blob.Digest] = true
+	}
+	inImage2 := map[string]bool{}
+	for _, blob := range blobs2 {
+		inImage2[blob.Digest] = true
+	}
+
+	diff := LayerShareDiff{Shared: []LayerBlob{}, Unique1: []LayerBlob{}, Unique2: []LayerBlob{}}
+	for _, blob := range blobs1 {
+		if inImage2[blob.Digest] {
+			diff.Shared = append(diff.Shared, blob)
+		} else {
+			diff.Unique1 = append(diff.Unique1, blob)
+		}
+	}
+	for _, blob := range blobs2 {
+		if !inImage1[blob.Digest] {
+			diff.Unique2 = append(diff.Unique2, blob)
+		}
+	}
+	diff.Size1, diff.UncompressedSize1 = sumLayerBlobs(blobs1)
+	diff.Size2, diff.UncompressedSize2 = sumLayerBlobs(blobs2)
+	diff.SharedSize, _ = sumLayerBlobs(diff.Shared)
+	diff.PullSize, _ = sumLayerBlobs(diff.Unique2)
+	return diff
+}
+
+// dedupLayerBlobs keeps the first occurrence of each blob, in layer order
+func dedupLayerBlobs(layers []LayerBlob) []LayerBlob {
+	blobs := []LayerBlob{}
+	seen := map[string]bool{}
+	for _, layer := range layers {
+		if seen[layer.Digest] {
+			continue
+		}
+		seen[layer.Digest] = true
+		blobs = append(blobs, layer)
+	}
+	return blobs
+}
+
+// sumLayerBlobs returns an uncompressed size of -1 if any is unknown
+func sumLayerBlobs(blobs []LayerBlob) (size, uncompressedSize int64) {
+	for _, blob := range blobs {
+		size += blob.Size
+		if blob.UncompressedSize < 0 || uncompressedSize < 0 {
+			uncompressedSize = -1
+			continue
+		}
+		uncompressedSize += blob.UncompressedSize
+	}
+	return size, uncompressedSize
+}
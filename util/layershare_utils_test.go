@@ -0,0 +1,50 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffLayerShare(t *testing.T) {
+	base := LayerBlob{Digest: "sha256:base", Size: 100, UncompressedSize: 300}
+	deps := LayerBlob{Digest: "sha256:deps", Size: 50, UncompressedSize: 150}
+	app1 := LayerBlob{Digest: "sha256:app1", Size: 10, UncompressedSize: 30}
+	app2 := LayerBlob{Digest: "sha256:app2", Size: 12, UncompressedSize: -1}
+
+	diff := DiffLayerShare([]LayerBlob{base, deps, app1}, []LayerBlob{base, deps, app2, app2})
+	expected := LayerShareDiff{
+		Shared:            []LayerBlob{base, deps},
+		Unique1:           []LayerBlob{app1},
+		Unique2:           []LayerBlob{app2},
+		Size1:             160,
+		Size2:             162,
+		UncompressedSize1: 480,
+		UncompressedSize2: -1,
+		SharedSize:        150,
+		PullSize:          12,
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Expected: %+v but got: %+v", expected, diff)
+	}
+
+	analysis := AnalyzeLayerShare([]LayerBlob{base, base, app1})
+	if len(analysis.Layers) != 2 || analysis.Size != 110 || analysis.UncompressedSize != 330 {
+		t.Errorf("Expected repeated layers to be counted once, got: %+v", analysis)
+	}
+}
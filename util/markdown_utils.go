@@ -0,0 +1,276 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+)
+
+// MarkdownFormat is the --output-format value selecting the markdown summary
+const MarkdownFormat = "markdown"
+
+// MarkdownLimits bound the length of markdown summaries, so that they fit
+// e.g. in a GitHub comment.
+type MarkdownLimits struct {
+	// Top is the number of largest changes listed for each analyzer
+	Top int
+	// MaxSize is the size in bytes the summary is cut down to, if positive
+	MaxSize int64
+}
+
+const markdownTruncatedNote = "\n_Some results were left out to fit the size limit. Use `--output-format html` for the full report._\n"
+
+var markdownEscaper = strings.NewReplacer(
+	"\\", "\\\\", "`", "\\`", "*", "\\*", "_", "\\_", "[", "\\[", "]", "\\]",
+	"<", "\\<", ">", "\\>", "|", "\\|", "\r", "", "\n", " ",
+)
+
+// markdownBlock is a collapsible list, such as a table or a content diff,
+// whose lines can be cut to fit the size limit.
+type markdownBlock struct {
+	Summary string
+	Head    string
+	Lines   []string
+	Foot    string
+}
+
+// render writes the first n lines of the block
+func (b markdownBlock) render(n int) string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "<details><summary>%s</summary>\n\n%s", html.EscapeString(b.Summary), b.Head)
+	for _, line := range b.Lines[:n] {
+		s.WriteString(line)
+	}
+	s.WriteString(b.Foot)
+	if n < len(b.Lines) {
+		fmt.Fprintf(&s, "\n_%d more not shown_\n", len(b.Lines)-n)
+	}
+	s.WriteString("\n</details>\n\n")
+	return s.String()
+}
+
+// fit renders as many lines of the block as fit in size bytes, and returns
+// how many. No lines fit if size is too small for the first one.
+func (b markdownBlock) fit(size int64) (string, int) {
+	n := sort.Search(len(b.Lines)+1, func(n int) bool {
+		return int64(len(b.render(n))) > size
+	}) - 1
+	for ; n > 0; n-- {
+		if s := b.render(n); int64(len(s)) <= size {
+			return s, n
+		}
+	}
+	return "", 0
+}
+
+type markdownSection struct {
+	Summary string
+	Blocks  []markdownBlock
+}
+
+// WriteMarkdownReport writes a compact summary of report, made of the counts
+// and largest changes of each analyzer, followed by collapsible blocks
+// listing their results. Once the summaries are written, blocks are added in
+// order while they fit in limits.MaxSize.
+func WriteMarkdownReport(writer io.Writer, report Report, limits MarkdownLimits) error {
+	header := fmt.Sprintf("## container-diff: %s\n\n", escapeMarkdown(report.Title))
+	groups := reportGroups(report)
+	var sections []markdownSection
+	for _, group := range groups {
+		heading := "###"
+		if len(groups) > 1 {
+			heading = "####"
+		}
+		for i, section := range group.Sections {
+			s := markdownSection{
+				Summary: markdownSummary(section, heading, limits.Top),
+				Blocks:  markdownBlocks(section, ""),
+			}
+			if i == 0 && group.Platform != "" {
+				s.Summary = fmt.Sprintf("### Platform %s\n\n%s", escapeMarkdown(group.Platform), s.Summary)
+			}
+			sections = append(sections, s)
+		}
+	}
+	if report.FileDiff != nil {
+		diff := report.FileDiff
+		block := markdownCodeBlock("Diff of "+diff.Filename, "diff", diff.Diff)
+		block.Head = escapeMarkdown(diff.Description) + "\n\n" + block.Head
+		sections = append(sections, markdownSection{Blocks: []markdownBlock{block}})
+	}
+
+	var out strings.Builder
+	out.WriteString(header)
+	// the summaries come first, the blocks share what's left
+	remaining := limits.MaxSize - int64(len(header)+len(markdownTruncatedNote))
+	for _, section := range sections {
+		remaining -= int64(len(section.Summary))
+	}
+	truncated := false
+	for _, section := range sections {
+		if limits.MaxSize > 0 && int64(out.Len()+len(section.Summary)+len(markdownTruncatedNote)) > limits.MaxSize {
+			truncated = true
+			break
+		}
+		out.WriteString(section.Summary)
+		for _, block := range section.Blocks {
+			if limits.MaxSize <= 0 {
+				out.WriteString(block.render(len(block.Lines)))
+				continue
+			}
+			s, n := block.fit(remaining)
+			if n < len(block.Lines) {
+				truncated = true
+			}
+			out.WriteString(s)
+			remaining -= int64(len(s))
+		}
+	}
+	if truncated {
+		out.WriteString(markdownTruncatedNote)
+	}
+	_, err := io.WriteString(writer, out.String())
+	return err
+}
+
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+// markdownSummary shows the counts of a section, and its largest changes.
+func markdownSummary(section reportSection, heading string, top int) string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "%s %s\n\n", heading, escapeMarkdown(section.Title))
+	var counts []string
+	for _, count := range section.Counts {
+		counts = append(counts, fmt.Sprintf("%d %s", count.Count, escapeMarkdown(count.Label)))
+	}
+	if len(counts) > 0 {
+		fmt.Fprintf(&s, "%s\n\n", strings.Join(counts, ", "))
+	}
+
+	changes := largestChanges(section, "")
+	if len(changes) > top {
+		changes = changes[:top]
+	}
+	if len(changes) > 0 {
+		s.WriteString("| Largest changes | Change | Size |\n|---|---|---|\n")
+		for _, change := range changes {
+			fmt.Fprintf(&s, "| %s | %s | %s |\n", escapeMarkdown(change.Name), change.Row.Change, stringifyDelta(change.Row.Delta))
+		}
+		s.WriteString("\n")
+	}
+	return s.String()
+}
+
+type markdownChange struct {
+	Name string
+	Row  reportRow
+}
+
+// largestChanges lists the rows of a section and its layers that changed
+// the size of the image, largest first.
+func largestChanges(section reportSection, prefix string) []markdownChange {
+	var changes []markdownChange
+	for _, table := range section.Tables {
+		for _, row := range table.Rows {
+			if row.Change == "" || row.Delta == 0 || len(row.Cells) == 0 {
+				continue
+			}
+			// entries such as whole images have no name
+			name := row.Cells[0].Text
+			if name == "" {
+				name = section.Title
+			}
+			changes = append(changes, markdownChange{Name: prefix + name, Row: row})
+		}
+	}
+	for _, layer := range section.Layers {
+		changes = append(changes, largestChanges(layer, prefix+layer.Title+": ")...)
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return abs(changes[i].Row.Delta) > abs(changes[j].Row.Delta)
+	})
+	return changes
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// markdownBlocks lists the tables, content diffs and layers of a section,
+// skipping empty tables.
+func markdownBlocks(section reportSection, prefix string) []markdownBlock {
+	var blocks []markdownBlock
+	for _, table := range section.Tables {
+		if len(table.Rows) == 0 {
+			continue
+		}
+		title := table.Title
+		if title == "" {
+			title = section.Title
+		}
+		block := markdownBlock{Summary: fmt.Sprintf("%s%s (%d)", prefix, title, len(table.Rows))}
+		block.Head = markdownTableRow(table.Columns) + "|" + strings.Repeat("---|", len(table.Columns)) + "\n"
+		for _, row := range table.Rows {
+			cells := make([]string, len(row.Cells))
+			for i, cell := range row.Cells {
+				cells[i] = cell.Text
+			}
+			block.Lines = append(block.Lines, markdownTableRow(cells))
+		}
+		blocks = append(blocks, block)
+	}
+	for _, diff := range section.Diffs {
+		blocks = append(blocks, markdownCodeBlock(prefix+"Diff of "+diff.Title, "diff", diff.Text))
+	}
+	for _, layer := range section.Layers {
+		blocks = append(blocks, markdownBlocks(layer, prefix+layer.Title+": ")...)
+	}
+	if section.Raw != "" {
+		blocks = append(blocks, markdownCodeBlock(prefix+section.Title, "json", section.Raw))
+	}
+	return blocks
+}
+
+func markdownTableRow(cells []string) string {
+	escaped := make([]string, len(cells))
+	for i, cell := range cells {
+		escaped[i] = escapeMarkdown(cell)
+	}
+	return "| " + strings.Join(escaped, " | ") + " |\n"
+}
+
+// markdownCodeBlock fences text with more backticks than it contains in a row
+func markdownCodeBlock(summary, language, text string) markdownBlock {
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	block := markdownBlock{Summary: summary, Head: fence + language + "\n", Foot: fence + "\n"}
+	for _, line := range strings.SplitAfter(strings.TrimSuffix(text, "\n"), "\n") {
+		block.Lines = append(block.Lines, strings.TrimSuffix(line, "\n")+"\n")
+	}
+	return block
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+)
+
+func markdownTestReport(files int) Report {
+	var adds []pkgutil.DirectoryEntry
+	for i := 0; i < files; i++ {
+		adds = append(adds, pkgutil.DirectoryEntry{Name: fmt.Sprintf("/usr/lib/file_%04d", i), Size: int64(i + 1)})
+	}
+	return Report{
+		Title: "image1 vs image2",
+		Groups: []ReportGroup{{Results: map[string]Result{
+			"file": &DirDiffResult{Image1: "image1", Image2: "image2", DiffType: "File", Diff: DirDiff{
+				Adds: adds,
+				Dels: []pkgutil.DirectoryEntry{{Name: "/big|file", Size: 1 << 20}},
+				Mods: []EntryDiff{{Name: "/etc/motd", Size1: 10, Size2: 2048, Content: &ContentDiff{Diff: "@@ -1 +1 @@\n-hello\n+```\n"}}},
+			}},
+			"apt": &SingleVersionPackageDiffResult{Image1: "image1", Image2: "image2", DiffType: "Apt", Diff: PackageDiff{
+				Packages1: map[string]PackageInfo{},
+				Packages2: map[string]PackageInfo{},
+			}},
+		}}},
+	}
+}
+
+func TestWriteMarkdownReport(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteMarkdownReport(&buf, markdownTestReport(3), MarkdownLimits{Top: 2}); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	markdown := buf.String()
+	for _, expected := range []string{
+		"## container-diff: image1 vs image2\n",
+		"### Apt\n\n0 added, 0 deleted, 0 modified\n\n### File",
+		"3 added, 1 deleted, 1 modified\n",
+		// the two largest changes, with markdown escaped
+		"| Largest changes | Change | Size |\n|---|---|---|\n| /big\\|file | deleted | -1M |\n| /etc/motd | modified | +2K |\n\n",
+		"<details><summary>Files added to image2 (3)</summary>\n\n| Path | Size |\n|---|---|\n| /usr/lib/file\\_0000 | 1B |\n",
+		// content diffs are fenced with more backticks than they contain
+		"<details><summary>Diff of /etc/motd</summary>\n\n````diff\n@@ -1 +1 @@\n-hello\n+```\n````\n",
+	} {
+		if !strings.Contains(markdown, expected) {
+			t.Errorf("Expected summary to contain %q, got:\n%s", expected, markdown)
+		}
+	}
+	if strings.Contains(markdown, "Packages found only in") {
+		t.Errorf("Expected empty tables to be left out, got:\n%s", markdown)
+	}
+	if strings.Contains(markdown, markdownTruncatedNote) {
+		t.Errorf("Expected no truncation without a size limit")
+	}
+}
+
+func TestWriteMarkdownReportMaxSize(t *testing.T) {
+	report := markdownTestReport(1000)
+	var full bytes.Buffer
+	if err := WriteMarkdownReport(&full, report, MarkdownLimits{Top: 5}); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	for _, maxSize := range []int64{2000, 8000, int64(full.Len()) - 100} {
+		var buf bytes.Buffer
+		if err := WriteMarkdownReport(&buf, report, MarkdownLimits{Top: 5, MaxSize: maxSize}); err != nil {
+			t.Fatalf("Got unexpected error: %s", err)
+		}
+		markdown := buf.String()
+		if int64(len(markdown)) > maxSize {
+			t.Errorf("Expected at most %d bytes, got %d", maxSize, len(markdown))
+		}
+		if !strings.HasSuffix(markdown, markdownTruncatedNote) {
+			t.Errorf("Expected a truncation note with %d bytes", maxSize)
+		}
+		// summaries are always kept, and cut lists say how much was left out of them
+		if !strings.Contains(markdown, "1000 added, 1 deleted, 1 modified") {
+			t.Errorf("Expected summaries with %d bytes, got:\n%s", maxSize, markdown)
+		}
+		if maxSize < 10000 && !strings.Contains(markdown, "more not shown_") {
+			t.Errorf("Expected a cut list with %d bytes, got:\n%s", maxSize, markdown)
+		}
+		// lists stay well-formed
+		if strings.Count(markdown, "<details>") != strings.Count(markdown, "</details>") {
+			t.Errorf("Expected closed details blocks with %d bytes, got:\n%s", maxSize, markdown)
+		}
+	}
+}
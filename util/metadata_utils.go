@@ -0,0 +1,236 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// MetadataDiff is a change to a single field of the image config. Map fields
+// (Env, Labels, ExposedPorts, Volumes) and the rootfs DiffIDs report one
+// change per key or layer index. Value1 and Value2 are nil when the field or
+// key is unset in an image.
+type MetadataDiff struct {
+	Field  string
+	Key    string `json:",omitempty"`
+	Kind   string
+	Value1 interface{}
+	Value2 interface{}
+}
+
+// Name is the field of the change, followed by its key for map fields,
+// e.g. "Env[PATH]"
+func (d MetadataDiff) Name() string {
+	if d.Key == "" {
+		return d.Field
+	}
+	return d.Field + "[" + d.Key + "]"
+}
+
+// DiffConfigs compares two image configs field by field.
+func DiffConfigs(cf1, cf2 *v1.ConfigFile) []MetadataDiff {
+	diffs := []MetadataDiff{}
+	value := func(field string, val1, val2 string) {
+		diffs = append(diffs, diffMetadataValue(field, val1, val2)...)
+	}
+	flag := func(field string, val1, val2 bool) {
+		if val1 != val2 {
+			diffs = append(diffs, MetadataDiff{Field: field, Kind: ChangeModified, Value1: val1, Value2: val2})
+		}
+	}
+	list := func(field string, l1, l2 []string) {
+		diffs = append(diffs, diffMetadataList(field, l1, l2)...)
+	}
+	dict := func(field string, m1, m2 map[string]string) {
+		diffs = append(diffs, diffMetadataMap(field, m1, m2)...)
+	}
+	set := func(field string, s1, s2 map[string]struct{}) {
+		diffs = append(diffs, diffMetadataSet(field, s1, s2)...)
+	}
+
+	value("Architecture", cf1.Architecture, cf2.Architecture)
+	value("OS", cf1.OS, cf2.OS)
+	value("OSVersion", cf1.OSVersion, cf2.OSVersion)
+	value("Variant", cf1.Variant, cf2.Variant)
+	if !cf1.Created.Equal(cf2.Created.Time) {
+		diff := MetadataDiff{Field: "Created", Kind: ChangeModified}
+		if !cf1.Created.IsZero() {
+			diff.Value1 = cf1.Created
+		} else {
+			diff.Kind = ChangeAdded
+		}
+		if !cf2.Created.IsZero() {
+			diff.Value2 = cf2.Created
+		} else {
+			diff.Kind = ChangeDeleted
+		}
+		diffs = append(diffs, diff)
+	}
+	value("Author", cf1.Author, cf2.Author)
+
+	c1, c2 := cf1.Config, cf2.Config
+	value("Domainname", c1.Domainname, c2.Domainname)
+	value("User", c1.User, c2.User)
+	flag("AttachStdin", c1.AttachStdin, c2.AttachStdin)
+	flag("AttachStdout", c1.AttachStdout, c2.AttachStdout)
+	flag("AttachStderr", c1.AttachStderr, c2.AttachStderr)
+	set("ExposedPorts", c1.ExposedPorts, c2.ExposedPorts)
+	flag("Tty", c1.Tty, c2.Tty)
+	flag("OpenStdin", c1.OpenStdin, c2.OpenStdin)
+	flag("StdinOnce", c1.StdinOnce, c2.StdinOnce)
+	dict("Env", envToMap(c1.Env), envToMap(c2.Env))
+	list("Cmd", c1.Cmd, c2.Cmd)
+	if !reflect.DeepEqual(c1.Healthcheck, c2.Healthcheck) {
+		diff := MetadataDiff{Field: "Healthcheck", Kind: ChangeModified, Value1: c1.Healthcheck, Value2: c2.Healthcheck}
+		if c1.Healthcheck == nil {
+			diff.Kind, diff.Value1 = ChangeAdded, nil
+		} else if c2.Healthcheck == nil {
+			diff.Kind, diff.Value2 = ChangeDeleted, nil
+		}
+		diffs = append(diffs, diff)
+	}
+	flag("ArgsEscaped", c1.ArgsEscaped, c2.ArgsEscaped)
+	set("Volumes", c1.Volumes, c2.Volumes)
+	value("WorkingDir", c1.WorkingDir, c2.WorkingDir)
+	list("Entrypoint", c1.Entrypoint, c2.Entrypoint)
+	flag("NetworkDisabled", c1.NetworkDisabled, c2.NetworkDisabled)
+	value("MacAddress", c1.MacAddress, c2.MacAddress)
+	list("OnBuild", c1.OnBuild, c2.OnBuild)
+	dict("Labels", c1.Labels, c2.Labels)
+	value("StopSignal", c1.StopSignal, c2.StopSignal)
+	list("Shell", c1.Shell, c2.Shell)
+
+	diffs = append(diffs, diffDiffIDs(cf1.RootFS.DiffIDs, cf2.RootFS.DiffIDs)...)
+	return diffs
+}
+
+// diffMetadataValue compares a string field, treating empty strings as unset
+func diffMetadataValue(field, val1, val2 string) []MetadataDiff {
+	if val1 == val2 {
+		return nil
+	}
+	switch {
+	case val1 == "":
+		return []MetadataDiff{{Field: field, Kind: ChangeAdded, Value2: val2}}
+	case val2 == "":
+		return []MetadataDiff{{Field: field, Kind: ChangeDeleted, Value1: val1}}
+	}
+	return []MetadataDiff{{Field: field, Kind: ChangeModified, Value1: val1, Value2: val2}}
+}
+
+// diffMetadataList compares an ordered list field as a whole, since the
+// meaning of Cmd or Entrypoint elements depends on their position
+func diffMetadataList(field string, l1, l2 []string) []MetadataDiff {
+	if len(l1) == 0 && len(l2) == 0 || reflect.DeepEqual(l1, l2) {
+		return nil
+	}
+	switch {
+	case len(l1) == 0:
+		return []MetadataDiff{{Field: field, Kind: ChangeAdded, Value2: l2}}
+	case len(l2) == 0:
+		return []MetadataDiff{{Field: field, Kind: ChangeDeleted, Value1: l1}}
+	}
+	return []MetadataDiff{{Field: field, Kind: ChangeModified, Value1: l1, Value2: l2}}
+}
+
+// diffMetadataMap reports the keys added, deleted or changed in a map field,
+// sorted by key
+func diffMetadataMap(field string, m1, m2 map[string]string) []MetadataDiff {
+	keys := []string{}
+	for key := range m1 {
+		keys = append(keys, key)
+	}
+	for key := range m2 {
+		if _, ok := m1[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var diffs []MetadataDiff
+	for _, key := range keys {
+		val1, ok1 := m1[key]
+		val2, ok2 := m2[key]
+		switch {
+		case !ok1:
+			diffs = append(diffs, MetadataDiff{Field: field, Key: key, Kind: ChangeAdded, Value2: val2})
+		case !ok2:
+			diffs = append(diffs, MetadataDiff{Field: field, Key: key, Kind: ChangeDeleted, Value1: val1})
+		case val1 != val2:
+			diffs = append(diffs, MetadataDiff{Field: field, Key: key, Kind: ChangeModified, Value1: val1, Value2: val2})
+		}
+	}
+	return diffs
+}
+
+// diffMetadataSet reports the ports or volumes added or deleted, whose
+// values in the config are always empty
+func diffMetadataSet(field string, s1, s2 map[string]struct{}) []MetadataDiff {
+	var diffs []MetadataDiff
+	for key := range s2 {
+		if _, ok := s1[key]; !ok {
+			diffs = append(diffs, MetadataDiff{Field: field, Key: key, Kind: ChangeAdded})
+		}
+	}
+	for key := range s1 {
+		if _, ok := s2[key]; !ok {
+			diffs = append(diffs, MetadataDiff{Field: field, Key: key, Kind: ChangeDeleted})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Key < diffs[j].Key })
+	return diffs
+}
+
+// diffDiffIDs compares the uncompressed layer digests of the rootfs by
+// position, the key of each change being the index of the layer
+func diffDiffIDs(ids1, ids2 []v1.Hash) []MetadataDiff {
+	var diffs []MetadataDiff
+	for i := 0; i < len(ids1) || i < len(ids2); i++ {
+		diff := MetadataDiff{Field: "DiffIDs", Key: strconv.Itoa(i), Kind: ChangeModified}
+		switch {
+		case i >= len(ids1):
+			diff.Kind, diff.Value2 = ChangeAdded, ids2[i].String()
+		case i >= len(ids2):
+			diff.Kind, diff.Value1 = ChangeDeleted, ids1[i].String()
+		case ids1[i] != ids2[i]:
+			diff.Value1, diff.Value2 = ids1[i].String(), ids2[i].String()
+		default:
+			continue
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs
+}
+
+// envToMap splits environment variables of the form KEY=value
+func envToMap(env []string) map[string]string {
+	m := map[string]string{}
+	for _, variable := range env {
+		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) == 1 {
+			m[parts[0]] = ""
+			continue
+		}
+		m[parts[0]] = parts[1]
+	}
+	return m
+}
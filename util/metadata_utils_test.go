@@ -0,0 +1,111 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	created := v1.Time{Time: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)}
+	layer1 := v1.Hash{Algorithm: "sha256", Hex: "1111"}
+	layer2 := v1.Hash{Algorithm: "sha256", Hex: "2222"}
+	layer3 := v1.Hash{Algorithm: "sha256", Hex: "3333"}
+	healthcheck := &v1.HealthConfig{Test: []string{"CMD", "true"}, Interval: time.Second}
+
+	testCases := []struct {
+		descrip  string
+		config1  v1.ConfigFile
+		config2  v1.ConfigFile
+		expected []MetadataDiff
+	}{
+		{
+			descrip:  "identical configs",
+			config1:  v1.ConfigFile{Architecture: "amd64", Config: v1.Config{Env: []string{"A=1"}}},
+			config2:  v1.ConfigFile{Architecture: "amd64", Config: v1.Config{Env: []string{"A=1"}}},
+			expected: []MetadataDiff{},
+		},
+		{
+			descrip: "single env var changed",
+			config1: v1.ConfigFile{Config: v1.Config{Env: []string{"A=1", "B=2", "C=3"}}},
+			config2: v1.ConfigFile{Config: v1.Config{Env: []string{"A=1", "B=20", "D"}}},
+			expected: []MetadataDiff{
+				{Field: "Env", Key: "B", Kind: ChangeModified, Value1: "2", Value2: "20"},
+				{Field: "Env", Key: "C", Kind: ChangeDeleted, Value1: "3"},
+				{Field: "Env", Key: "D", Kind: ChangeAdded, Value2: ""},
+			},
+		},
+		{
+			descrip: "ports, volumes and labels",
+			config1: v1.ConfigFile{Config: v1.Config{
+				ExposedPorts: map[string]struct{}{"80/tcp": {}, "443/tcp": {}},
+				Labels:       map[string]string{"version": "1"},
+			}},
+			config2: v1.ConfigFile{Config: v1.Config{
+				ExposedPorts: map[string]struct{}{"80/tcp": {}},
+				Volumes:      map[string]struct{}{"/data": {}},
+				Labels:       map[string]string{"version": "2"},
+			}},
+			expected: []MetadataDiff{
+				{Field: "ExposedPorts", Key: "443/tcp", Kind: ChangeDeleted},
+				{Field: "Volumes", Key: "/data", Kind: ChangeAdded},
+				{Field: "Labels", Key: "version", Kind: ChangeModified, Value1: "1", Value2: "2"},
+			},
+		},
+		{
+			descrip: "cmd and entrypoint",
+			config1: v1.ConfigFile{Config: v1.Config{Cmd: []string{"sh", "-c", "a"}}},
+			config2: v1.ConfigFile{Config: v1.Config{Cmd: []string{"sh", "-c", "b"}, Entrypoint: []string{"/entrypoint"}}},
+			expected: []MetadataDiff{
+				{Field: "Cmd", Kind: ChangeModified, Value1: []string{"sh", "-c", "a"}, Value2: []string{"sh", "-c", "b"}},
+				{Field: "Entrypoint", Kind: ChangeAdded, Value2: []string{"/entrypoint"}},
+			},
+		},
+		{
+			descrip: "platform, creation and healthcheck",
+			config1: v1.ConfigFile{Architecture: "amd64", OS: "linux", Created: created},
+			config2: v1.ConfigFile{Architecture: "arm64", OS: "linux", Variant: "v8", Author: "someone",
+				Config: v1.Config{Healthcheck: healthcheck}},
+			expected: []MetadataDiff{
+				{Field: "Architecture", Kind: ChangeModified, Value1: "amd64", Value2: "arm64"},
+				{Field: "Variant", Kind: ChangeAdded, Value2: "v8"},
+				{Field: "Created", Kind: ChangeDeleted, Value1: created},
+				{Field: "Author", Kind: ChangeAdded, Value2: "someone"},
+				{Field: "Healthcheck", Kind: ChangeAdded, Value2: healthcheck},
+			},
+		},
+		{
+			descrip: "rootfs layers",
+			config1: v1.ConfigFile{RootFS: v1.RootFS{DiffIDs: []v1.Hash{layer1, layer2}}},
+			config2: v1.ConfigFile{RootFS: v1.RootFS{DiffIDs: []v1.Hash{layer1, layer3, layer2}}},
+			expected: []MetadataDiff{
+				{Field: "DiffIDs", Key: "1", Kind: ChangeModified, Value1: layer2.String(), Value2: layer3.String()},
+				{Field: "DiffIDs", Key: "2", Kind: ChangeAdded, Value2: layer2.String()},
+			},
+		},
+	}
+	for _, test := range testCases {
+		diffs := DiffConfigs(&test.config1, &test.config2)
+		if !reflect.DeepEqual(diffs, test.expected) {
+			t.Errorf("%s: Expected: %v but got: %v", test.descrip, test.expected, diffs)
+		}
+	}
+}
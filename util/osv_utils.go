@@ -0,0 +1,559 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Severities of vulnerabilities, from most to least severe
+const (
+	SeverityCritical = "CRITICAL"
+	SeverityHigh     = "HIGH"
+	SeverityMedium   = "MEDIUM"
+	SeverityLow      = "LOW"
+	SeverityNone     = "NONE"
+	SeverityUnknown  = "UNKNOWN"
+)
+
+var severityRanks = map[string]int{
+	SeverityCritical: 0,
+	SeverityHigh:     1,
+	SeverityMedium:   2,
+	SeverityLow:      3,
+	SeverityNone:     4,
+	SeverityUnknown:  5,
+}
+
+// osvEcosystems lists the OSV ecosystems whose advisories apply to the
+// packages of each package analyzer.
+var osvEcosystems = map[string][]string{
+	"Apt": {"Debian", "Ubuntu"},
+	"Apk": {"Alpine"},
+	"RPM": {"Red Hat", "Rocky Linux", "AlmaLinux", "SUSE", "openSUSE"},
+}
+
+// osvDistros maps the os-release ID of a distribution to its OSV ecosystem.
+var osvDistros = map[string]string{
+	"debian":        "Debian",
+	"ubuntu":        "Ubuntu",
+	"alpine":        "Alpine",
+	"rhel":          "Red Hat",
+	"rocky":         "Rocky Linux",
+	"almalinux":     "AlmaLinux",
+	"sles":          "SUSE",
+	"opensuse-leap": "openSUSE",
+}
+
+// OSVEntry is a vulnerability advisory in the OSV format.
+type OSVEntry struct {
+	ID               string                 `json:"id"`
+	Aliases          []string               `json:"aliases"`
+	Summary          string                 `json:"summary"`
+	Details          string                 `json:"details"`
+	Severity         []OSVSeverity          `json:"severity"`
+	Affected         []OSVAffected          `json:"affected"`
+	DatabaseSpecific map[string]interface{} `json:"database_specific"`
+}
+
+// OSVSeverity is a severity score of a given type, e.g. a CVSS_V3 vector
+type OSVSeverity struct {
+	Type  string `json:"type"`
+	Score string `json:"score"`
+}
+
+// OSVAffected lists the versions of a package an advisory applies to.
+type OSVAffected struct {
+	Package           OSVPackage             `json:"package"`
+	Ranges            []OSVRange             `json:"ranges"`
+	Versions          []string               `json:"versions"`
+	Severity          []OSVSeverity          `json:"severity"`
+	EcosystemSpecific map[string]interface{} `json:"ecosystem_specific"`
+	DatabaseSpecific  map[string]interface{} `json:"database_specific"`
+}
+
+// OSVPackage identifies a package within an ecosystem, e.g. "Debian:12".
+type OSVPackage struct {
+	Ecosystem string `json:"ecosystem"`
+	Name      string `json:"name"`
+}
+
+// OSVRange is a range of affected versions given by events in version order.
+type OSVRange struct {
+	Type   string     `json:"type"`
+	Events []OSVEvent `json:"events"`
+}
+
+// OSVEvent starts or ends a range of affected versions.
+type OSVEvent struct {
+	Introduced   string `json:"introduced,omitempty"`
+	Fixed        string `json:"fixed,omitempty"`
+	LastAffected string `json:"last_affected,omitempty"`
+	Limit        string `json:"limit,omitempty"`
+}
+
+// OSVDatabase is an offline set of OSV advisories, indexed by package name.
+type OSVDatabase struct {
+	entries map[string][]*OSVEntry
+}
+
+// LoadOSVDatabase reads the OSV advisories in the .json files found under
+// dir, as extracted from the OSV ecosystem dumps. A file may hold one
+// advisory or a list of them. Files that can't be parsed are skipped.
+func LoadOSVDatabase(dir string) (*OSVDatabase, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	db := &OSVDatabase{entries: map[string][]*OSVEntry{}}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+		entries, err := readOSVFile(path)
+		if err != nil {
+			logrus.Warnf("skipping advisory %s: %s", path, err)
+			return nil
+		}
+		for _, entry := range entries {
+			db.Add(entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func readOSVFile(path string) ([]*OSVEntry, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*OSVEntry
+	if strings.HasPrefix(strings.TrimSpace(string(contents)), "[") {
+		err = json.Unmarshal(contents, &entries)
+	} else {
+		var entry OSVEntry
+		err = json.Unmarshal(contents, &entry)
+		entries = append(entries, &entry)
+	}
+	return entries, err
+}
+
+// Add indexes an advisory under the name of every package it affects.
+func (db *OSVDatabase) Add(entry *OSVEntry) {
+	names := map[string]bool{}
+	for _, affected := range entry.Affected {
+		names[affected.Package.Name] = true
+	}
+	for name := range names {
+		db.entries[name] = append(db.entries[name], entry)
+	}
+}
+
+// VulnPackage is an installed package checked against the advisories.
+// Source is the name of the source package it was built from, which
+// distributions publish advisories for, if it differs from Name.
+type VulnPackage struct {
+	Name        string
+	Source      string
+	Version     string
+	AnalyzeType string
+}
+
+// Vuln is an advisory affecting an installed package. FixedVersion is the
+// first version known to fix it, if any.
+type Vuln struct {
+	ID           string
+	Aliases      []string `json:",omitempty"`
+	Summary      string   `json:",omitempty"`
+	Severity     string
+	Score        float64 `json:",omitempty"`
+	Package      string
+	Version      string
+	FixedVersion string `json:",omitempty"`
+}
+
+// FindVulns matches packages against the advisories of the ecosystems of
+// their package managers. If the os-release ID of the image is known, only
+// advisories for that distribution, and release if given, apply.
+func (db *OSVDatabase) FindVulns(packages []VulnPackage, osID, osVersion string) []Vuln {
+	vulns := []Vuln{}
+	for _, pkg := range packages {
+		seen := map[string]bool{}
+		for _, name := range []string{pkg.Name, pkg.Source} {
+			if name == "" {
+				continue
+			}
+			for _, entry := range db.entries[name] {
+				if seen[entry.ID] {
+					continue
+				}
+				for _, affected := range entry.Affected {
+					if affected.Package.Name != name || !matchesEcosystem(affected.Package.Ecosystem, pkg.AnalyzeType, osID, osVersion) {
+						continue
+					}
+					if vulnerable, fixed := affectsVersion(affected, pkg); vulnerable {
+						severity, score := osvSeverity(entry, affected)
+						vulns = append(vulns, Vuln{
+							ID:           entry.ID,
+							Aliases:      entry.Aliases,
+							Summary:      entry.Summary,
+							Severity:     severity,
+							Score:        score,
+							Package:      pkg.Name,
+							Version:      pkg.Version,
+							FixedVersion: fixed,
+						})
+						seen[entry.ID] = true
+						break
+					}
+				}
+			}
+		}
+	}
+	sortVulns(vulns)
+	return vulns
+}
+
+// matchesEcosystem returns whether advisories of an OSV ecosystem, e.g.
+// "Debian:12" or "Alpine:v3.19", apply to a package of analyzeType on the
+// distribution release given by os-release.
+func matchesEcosystem(ecosystem, analyzeType, osID, osVersion string) bool {
+	name, release, _ := strings.Cut(ecosystem, ":")
+	known := false
+	for _, candidate := range osvEcosystems[analyzeType] {
+		known = known || candidate == name
+	}
+	if !known {
+		return false
+	}
+	distro, ok := osvDistros[osID]
+	if !ok {
+		return true
+	}
+	if distro != name {
+		return false
+	}
+	release, _, _ = strings.Cut(release, ":")
+	release = strings.TrimPrefix(release, "v")
+	if release == "" || osVersion == "" || !isDigit(release[0]) {
+		return true
+	}
+	return osVersion == release || strings.HasPrefix(osVersion, release+".")
+}
+
+// affectsVersion returns whether the installed version of pkg is listed by
+// affected or falls within one of its ECOSYSTEM ranges, along with the
+// version fixing that range.
+func affectsVersion(affected OSVAffected, pkg VulnPackage) (bool, string) {
+	for _, version := range affected.Versions {
+		if version == pkg.Version {
+			return true, ""
+		}
+	}
+	compare := func(v1, v2 string) int {
+		// the rpm databases don't record epochs, so ignore them unless the
+		// installed version has one
+		if pkg.AnalyzeType == "RPM" && !strings.Contains(pkg.Version, ":") {
+			if _, rest, ok := strings.Cut(v2, ":"); ok {
+				v2 = rest
+			}
+		}
+		return CompareVersions(pkg.AnalyzeType, v1, v2)
+	}
+	for _, r := range affected.Ranges {
+		if r.Type != "ECOSYSTEM" {
+			continue
+		}
+		vulnerable := false
+		for _, event := range sortOSVEvents(r.Events, pkg.AnalyzeType) {
+			switch {
+			case event.Introduced != "":
+				if event.Introduced == "0" || compare(pkg.Version, event.Introduced) >= 0 {
+					vulnerable = true
+				}
+			case event.Fixed != "":
+				if compare(pkg.Version, event.Fixed) >= 0 {
+					vulnerable = false
+				} else if vulnerable {
+					return true, event.Fixed
+				}
+			case event.LastAffected != "":
+				if compare(pkg.Version, event.LastAffected) > 0 {
+					vulnerable = false
+				} else if vulnerable {
+					return true, ""
+				}
+			case event.Limit != "":
+				if compare(pkg.Version, event.Limit) >= 0 {
+					vulnerable = false
+				}
+			}
+		}
+		if vulnerable {
+			return true, ""
+		}
+	}
+	return false, ""
+}
+
+// sortOSVEvents orders the events of a range by version, with an
+// introduction at "0" first.
+func sortOSVEvents(events []OSVEvent, analyzeType string) []OSVEvent {
+	version := func(e OSVEvent) string {
+		return e.Introduced + e.Fixed + e.LastAffected + e.Limit
+	}
+	sorted := append([]OSVEvent{}, events...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Introduced == "0" || sorted[j].Introduced == "0" {
+			return sorted[i].Introduced == "0" && sorted[j].Introduced != "0"
+		}
+		return CompareVersions(analyzeType, version(sorted[i]), version(sorted[j])) < 0
+	})
+	return sorted
+}
+
+// osvSeverity returns the severity an advisory gives for a package: the
+// rating of its distribution if there is one, or else the rating of its CVSS
+// v3 score, along with that score.
+func osvSeverity(entry *OSVEntry, affected OSVAffected) (string, float64) {
+	score := cvss3Score(entry.Severity, affected.Severity)
+	for _, specific := range []map[string]interface{}{affected.EcosystemSpecific, affected.DatabaseSpecific, entry.DatabaseSpecific} {
+		if severity, ok := specific["severity"].(string); ok && severity != "" {
+			return normalizeSeverity(severity), math.Max(score, 0)
+		}
+	}
+	for _, severity := range append(affected.Severity, entry.Severity...) {
+		if severity.Type == "Ubuntu" {
+			return normalizeSeverity(severity.Score), math.Max(score, 0)
+		}
+	}
+	if score < 0 {
+		return SeverityUnknown, 0
+	}
+	return cvssRating(score), score
+}
+
+func normalizeSeverity(severity string) string {
+	severity = strings.ToUpper(severity)
+	switch severity {
+	case "MODERATE":
+		return SeverityMedium
+	case "IMPORTANT":
+		return SeverityHigh
+	case "NEGLIGIBLE", "UNIMPORTANT":
+		return SeverityLow
+	case "UNASSIGNED", "NOT YET ASSIGNED", "":
+		return SeverityUnknown
+	}
+	return severity
+}
+
+// cvss3Score returns the highest CVSS v3 base score among the severities, or
+// -1 if there is none.
+func cvss3Score(severities ...[]OSVSeverity) float64 {
+	score := -1.0
+	for _, list := range severities {
+		for _, severity := range list {
+			if severity.Type != "CVSS_V3" {
+				continue
+			}
+			if s, err := CVSS3BaseScore(severity.Score); err == nil && s > score {
+				score = s
+			}
+		}
+	}
+	return score
+}
+
+// CVSS3BaseScore computes the base score of a CVSS v3.0 or v3.1 vector,
+// e.g. "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H".
+func CVSS3BaseScore(vector string) (float64, error) {
+	weights := map[string]map[string]float64{
+		"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+		"AC": {"L": 0.77, "H": 0.44},
+		"PR": {"N": 0.85, "L": 0.62, "H": 0.27},
+		"UI": {"N": 0.85, "R": 0.62},
+		"C":  {"H": 0.56, "L": 0.22, "N": 0},
+		"I":  {"H": 0.56, "L": 0.22, "N": 0},
+		"A":  {"H": 0.56, "L": 0.22, "N": 0},
+	}
+	parts := strings.Split(vector, "/")
+	if !strings.HasPrefix(parts[0], "CVSS:3") {
+		return 0, fmt.Errorf("not a CVSS v3 vector: %s", vector)
+	}
+	metrics := map[string]string{}
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(part, ":")
+		metrics[key] = value
+	}
+	changed := metrics["S"] == "C"
+	if !changed && metrics["S"] != "U" {
+		return 0, fmt.Errorf("invalid scope in CVSS vector: %s", vector)
+	}
+	values := map[string]float64{}
+	for metric, weight := range weights {
+		value, ok := weight[metrics[metric]]
+		if !ok {
+			return 0, fmt.Errorf("invalid %s in CVSS vector: %s", metric, vector)
+		}
+		values[metric] = value
+	}
+	if changed && metrics["PR"] == "L" {
+		values["PR"] = 0.68
+	} else if changed && metrics["PR"] == "H" {
+		values["PR"] = 0.5
+	}
+
+	iss := 1 - (1-values["C"])*(1-values["I"])*(1-values["A"])
+	impact := 6.42 * iss
+	if changed {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	}
+	if impact <= 0 {
+		return 0, nil
+	}
+	exploitability := 8.22 * values["AV"] * values["AC"] * values["PR"] * values["UI"]
+	if changed {
+		return cvssRoundUp(math.Min(1.08*(impact+exploitability), 10)), nil
+	}
+	return cvssRoundUp(math.Min(impact+exploitability, 10)), nil
+}
+
+// cvssRoundUp rounds up to one decimal, as defined by CVSS v3.1
+func cvssRoundUp(x float64) float64 {
+	i := int64(math.Round(x * 100000))
+	if i%10000 == 0 {
+		return float64(i) / 100000
+	}
+	return float64(i/10000+1) / 10
+}
+
+func cvssRating(score float64) string {
+	switch {
+	case score >= 9:
+		return SeverityCritical
+	case score >= 7:
+		return SeverityHigh
+	case score >= 4:
+		return SeverityMedium
+	case score > 0:
+		return SeverityLow
+	}
+	return SeverityNone
+}
+
+// sortVulns orders vulnerabilities by severity, most severe first, then by
+// ID and package.
+func sortVulns(vulns []Vuln) {
+	sort.Slice(vulns, func(i, j int) bool {
+		if rank1, rank2 := severityRank(vulns[i].Severity), severityRank(vulns[j].Severity); rank1 != rank2 {
+			return rank1 < rank2
+		}
+		if vulns[i].ID != vulns[j].ID {
+			return vulns[i].ID < vulns[j].ID
+		}
+		return vulns[i].Package < vulns[j].Package
+	})
+}
+
+func severityRank(severity string) int {
+	if rank, ok := severityRanks[severity]; ok {
+		return rank
+	}
+	return len(severityRanks)
+}
+
+// VulnDiff sorts the vulnerabilities found in two images into those fixed in
+// the second image, those it introduced and those found in both.
+type VulnDiff struct {
+	Fixed      []VulnDiffEntry
+	Introduced []VulnDiffEntry
+	Unchanged  []VulnDiffEntry
+}
+
+// VulnDiffEntry is a vulnerability of a package, with the versions of the
+// package installed in each image, empty if it isn't installed.
+type VulnDiffEntry struct {
+	ID           string
+	Aliases      []string `json:",omitempty"`
+	Summary      string   `json:",omitempty"`
+	Severity     string
+	Score        float64 `json:",omitempty"`
+	Package      string
+	Version1     string
+	Version2     string
+	FixedVersion string `json:",omitempty"`
+}
+
+// DiffVulns compares the vulnerabilities found in two images. versions1 and
+// versions2 map the names of the packages installed in each image to their
+// versions.
+func DiffVulns(vulns1, vulns2 []Vuln, versions1, versions2 map[string]string) VulnDiff {
+	diff := VulnDiff{Fixed: []VulnDiffEntry{}, Introduced: []VulnDiffEntry{}, Unchanged: []VulnDiffEntry{}}
+	key := func(v Vuln) string {
+		return v.ID + "\x00" + v.Package
+	}
+	found2 := map[string]Vuln{}
+	for _, vuln := range vulns2 {
+		found2[key(vuln)] = vuln
+	}
+	for _, vuln := range vulns1 {
+		entry := vulnDiffEntry(vuln, versions1, versions2)
+		if vuln2, ok := found2[key(vuln)]; ok {
+			entry.FixedVersion = vuln2.FixedVersion
+			diff.Unchanged = append(diff.Unchanged, entry)
+			delete(found2, key(vuln))
+		} else {
+			diff.Fixed = append(diff.Fixed, entry)
+		}
+	}
+	for _, vuln := range vulns2 {
+		if _, ok := found2[key(vuln)]; ok {
+			diff.Introduced = append(diff.Introduced, vulnDiffEntry(vuln, versions1, versions2))
+		}
+	}
+	return diff
+}
+
+func vulnDiffEntry(vuln Vuln, versions1, versions2 map[string]string) VulnDiffEntry {
+	return VulnDiffEntry{
+		ID:           vuln.ID,
+		Aliases:      vuln.Aliases,
+		Summary:      vuln.Summary,
+		Severity:     vuln.Severity,
+		Score:        vuln.Score,
+		Package:      vuln.Package,
+		Version1:     versions1[vuln.Package],
+		Version2:     versions2[vuln.Package],
+		FixedVersion: vuln.FixedVersion,
+	}
+}
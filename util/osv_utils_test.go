@@ -0,0 +1,139 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCVSS3BaseScore(t *testing.T) {
+	testCases := []struct {
+		vector   string
+		expected float64
+	}{
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", 9.8},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:R/S:C/C:L/I:L/A:N", 6.1},
+		{"CVSS:3.0/AV:L/AC:L/PR:L/UI:N/S:U/C:H/I:N/A:N", 5.5},
+		{"CVSS:3.1/AV:N/AC:H/PR:H/UI:R/S:U/C:N/I:N/A:N", 0},
+	}
+	for _, test := range testCases {
+		score, err := CVSS3BaseScore(test.vector)
+		if err != nil || score != test.expected {
+			t.Errorf("%s: Expected: %.1f but got: %.1f, %v", test.vector, test.expected, score, err)
+		}
+	}
+	if _, err := CVSS3BaseScore("CVSS:2.0/AV:N"); err == nil {
+		t.Errorf("Expected error for a CVSS v2 vector but got none")
+	}
+}
+
+func testOSVDatabase() *OSVDatabase {
+	db := &OSVDatabase{entries: map[string][]*OSVEntry{}}
+	db.Add(&OSVEntry{
+		ID:       "DSA-0001-1",
+		Aliases:  []string{"CVE-2024-0001"},
+		Severity: []OSVSeverity{{Type: "CVSS_V3", Score: "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H"}},
+		Affected: []OSVAffected{{
+			Package: OSVPackage{Ecosystem: "Debian:12", Name: "openssl"},
+			Ranges:  []OSVRange{{Type: "ECOSYSTEM", Events: []OSVEvent{{Fixed: "3.0.11-1~deb12u2"}, {Introduced: "0"}}}},
+		}},
+	})
+	db.Add(&OSVEntry{
+		ID: "DSA-0002-1",
+		Affected: []OSVAffected{{
+			Package:           OSVPackage{Ecosystem: "Debian:11", Name: "openssl"},
+			Ranges:            []OSVRange{{Type: "ECOSYSTEM", Events: []OSVEvent{{Introduced: "0"}}}},
+			EcosystemSpecific: map[string]interface{}{"severity": "low"},
+		}},
+	})
+	db.Add(&OSVEntry{
+		ID: "ALPINE-CVE-2024-0003",
+		Affected: []OSVAffected{{
+			Package:  OSVPackage{Ecosystem: "Alpine:v3.19", Name: "busybox"},
+			Ranges:   []OSVRange{{Type: "ECOSYSTEM", Events: []OSVEvent{{Introduced: "1.36.0-r0"}, {LastAffected: "1.36.1-r15"}}}},
+			Severity: []OSVSeverity{{Type: "Ubuntu", Score: "medium"}},
+		}},
+	})
+	return db
+}
+
+func TestFindVulns(t *testing.T) {
+	db := testOSVDatabase()
+	packages := []VulnPackage{
+		{Name: "libssl3", Source: "openssl", Version: "3.0.11-1~deb12u1", AnalyzeType: "Apt"},
+		{Name: "openssl", Version: "3.0.11-1~deb12u2", AnalyzeType: "Apt"},
+	}
+	expected := []Vuln{{
+		ID:           "DSA-0001-1",
+		Aliases:      []string{"CVE-2024-0001"},
+		Severity:     SeverityCritical,
+		Score:        9.8,
+		Package:      "libssl3",
+		Version:      "3.0.11-1~deb12u1",
+		FixedVersion: "3.0.11-1~deb12u2",
+	}}
+	if vulns := db.FindVulns(packages, "debian", "12"); !reflect.DeepEqual(vulns, expected) {
+		t.Errorf("Expected: %+v but got: %+v", expected, vulns)
+	}
+	// without os-release, advisories of every Debian release apply
+	if vulns := db.FindVulns(packages, "", ""); len(vulns) != 3 {
+		t.Errorf("Expected 3 vulnerabilities but got: %+v", vulns)
+	}
+	// apk packages aren't matched against Debian advisories
+	if vulns := db.FindVulns([]VulnPackage{{Name: "openssl", Version: "3.0.0-r0", AnalyzeType: "Apk"}}, "", ""); len(vulns) != 0 {
+		t.Errorf("Expected no vulnerabilities but got: %+v", vulns)
+	}
+
+	busybox := func(version string) []VulnPackage {
+		return []VulnPackage{{Name: "busybox", Version: version, AnalyzeType: "Apk"}}
+	}
+	if vulns := db.FindVulns(busybox("1.36.1-r15"), "alpine", "3.19.1"); len(vulns) != 1 || vulns[0].Severity != SeverityMedium {
+		t.Errorf("Expected a medium vulnerability but got: %+v", vulns)
+	}
+	for _, version := range []string{"1.35.0-r0", "1.36.1-r16"} {
+		if vulns := db.FindVulns(busybox(version), "alpine", "3.19.1"); len(vulns) != 0 {
+			t.Errorf("Expected no vulnerabilities for %s but got: %+v", version, vulns)
+		}
+	}
+	if vulns := db.FindVulns(busybox("1.36.1-r15"), "alpine", "3.18.4"); len(vulns) != 0 {
+		t.Errorf("Expected no vulnerabilities on another release but got: %+v", vulns)
+	}
+}
+
+func TestDiffVulns(t *testing.T) {
+	fixed := Vuln{ID: "CVE-1", Severity: SeverityHigh, Package: "openssl", Version: "1.0"}
+	unchanged1 := Vuln{ID: "CVE-2", Severity: SeverityLow, Package: "bash", Version: "5.0", FixedVersion: "5.1"}
+	unchanged2 := Vuln{ID: "CVE-2", Severity: SeverityLow, Package: "bash", Version: "5.0", FixedVersion: "5.1"}
+	introduced := Vuln{ID: "CVE-3", Severity: SeverityMedium, Package: "curl", Version: "8.0", FixedVersion: "8.1"}
+	diff := DiffVulns([]Vuln{fixed, unchanged1}, []Vuln{unchanged2, introduced},
+		map[string]string{"openssl": "1.0", "bash": "5.0"},
+		map[string]string{"openssl": "1.1", "bash": "5.0", "curl": "8.0"})
+	expected := VulnDiff{
+		Fixed:      []VulnDiffEntry{{ID: "CVE-1", Severity: SeverityHigh, Package: "openssl", Version1: "1.0", Version2: "1.1"}},
+		Introduced: []VulnDiffEntry{{ID: "CVE-3", Severity: SeverityMedium, Package: "curl", Version2: "8.0", FixedVersion: "8.1"}},
+		Unchanged:  []VulnDiffEntry{{ID: "CVE-2", Severity: SeverityLow, Package: "bash", Version1: "5.0", Version2: "5.0", FixedVersion: "5.1"}},
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Expected: %+v but got: %+v", expected, diff)
+	}
+	changes := GetChanges(&VulnDiffResult{Diff: diff})
+	expectedChanges := []Change{{Kind: ChangeAdded, Name: "CVE-3", Size1: -1, Size2: -1}, {Kind: ChangeDeleted, Name: "CVE-1", Size1: -1, Size2: -1}}
+	if !reflect.DeepEqual(changes, expectedChanges) {
+		t.Errorf("Expected: %+v but got: %+v", expectedChanges, changes)
+	}
+}
@@ -22,8 +22,6 @@ import (
 	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
 )
 
-var SortSize bool
-
 type packageBy func(p1, p2 *PackageOutput) bool
 
 func (by packageBy) Sort(packages []PackageOutput) {
@@ -52,26 +50,31 @@ func (s *packageSorter) Swap(i, j int) {
 }
 
 // If packages have the same name, means they exist where multiple version of the same package are allowed,
-// so sort by version.  If they have the same version, then sort by size.
-var packageNameSort = func(p1, p2 *PackageOutput) bool {
-	if p1.Name == p2.Name {
-		if p1.Version == p2.Version {
-			return p1.Size > p2.Size
+// so sort by version, compared by the rules of the package manager of analyzeType.  If they have the same
+// version, then sort by size.
+func packageNameSort(analyzeType string) func(p1, p2 *PackageOutput) bool {
+	return func(p1, p2 *PackageOutput) bool {
+		if p1.Name == p2.Name {
+			if p1.Version == p2.Version {
+				return p1.Size > p2.Size
+			}
+			return CompareVersions(analyzeType, p1.Version, p2.Version) < 0
 		}
-		return p1.Version < p2.Version
+		return p1.Name < p2.Name
 	}
-	return p1.Name < p2.Name
 }
 
 // If packages have the same size, sort by name.  If they are two versions of the same package, sort by version.
-var packageSizeSort = func(p1, p2 *PackageOutput) bool {
-	if p1.Size == p2.Size {
-		if p1.Name == p2.Name {
-			return p1.Version < p2.Version
+func packageSizeSort(analyzeType string) func(p1, p2 *PackageOutput) bool {
+	return func(p1, p2 *PackageOutput) bool {
+		if p1.Size == p2.Size {
+			if p1.Name == p2.Name {
+				return CompareVersions(analyzeType, p1.Version, p2.Version) < 0
+			}
+			return p1.Name < p2.Name
 		}
-		return p1.Name < p2.Name
+		return p1.Size > p2.Size
 	}
-	return p1.Size > p2.Size
 }
 
 type singleInfoBy func(a, b *Info) bool
@@ -170,21 +173,26 @@ func (infos packageInfoBySize) Less(i, j int) bool {
 	return infos[i].Size > infos[j].Size
 }
 
-type packageInfoByVersion []PackageInfo
+// packageInfoByVersion sorts package infos by version, compared by the rules
+// of the package manager of analyzeType.
+type packageInfoByVersion struct {
+	infos       []PackageInfo
+	analyzeType string
+}
 
-func (infos packageInfoByVersion) Len() int {
-	return len(infos)
+func (s packageInfoByVersion) Len() int {
+	return len(s.infos)
 }
 
-func (infos packageInfoByVersion) Swap(i, j int) {
-	infos[i], infos[j] = infos[j], infos[i]
+func (s packageInfoByVersion) Swap(i, j int) {
+	s.infos[i], s.infos[j] = s.infos[j], s.infos[i]
 }
 
-func (infos packageInfoByVersion) Less(i, j int) bool {
-	if infos[i].Version == infos[j].Version {
-		return infos[i].Size > infos[j].Size
+func (s packageInfoByVersion) Less(i, j int) bool {
+	if s.infos[i].Version == s.infos[j].Version {
+		return s.infos[i].Size > s.infos[j].Size
 	}
-	return infos[i].Version < infos[j].Version
+	return CompareVersions(s.analyzeType, s.infos[i].Version, s.infos[j].Version) < 0
 }
 
 type directoryBy func(e1, e2 *pkgutil.DirectoryEntry) bool
@@ -257,9 +265,9 @@ var MetaDirectoryNameSort = func(e1, e2 *pkgutil.DirectoryMetaEntry) bool {
 	return e1.Name < e2.Name
 }
 
-func sortDirDiff(diff DirDiff) DirDiff {
+func sortDirDiff(diff DirDiff, sortSize bool) DirDiff {
 	adds, dels, mods := diff.Adds, diff.Dels, diff.Mods
-	if SortSize {
+	if sortSize {
 		directoryBy(directorySizeSort).Sort(adds)
 		directoryBy(directorySizeSort).Sort(dels)
 		entryDiffBy(entryDiffSizeSort).Sort(mods)
@@ -273,15 +281,9 @@ func sortDirDiff(diff DirDiff) DirDiff {
 
 func sortMetaDirDiff(diff MetaDirDiff) MetaDirDiff {
 	adds, dels, mods := diff.Adds, diff.Dels, diff.Mods
-	if SortSize {
-		MetaDirectoryBy(MetaDirectoryNameSort).Sort(adds)
-		MetaDirectoryBy(MetaDirectoryNameSort).Sort(dels)
-		MetaEntryDiffBy(MetaEntryDiffNameSort).Sort(mods)
-	} else {
-		MetaDirectoryBy(MetaDirectoryNameSort).Sort(adds)
-		MetaDirectoryBy(MetaDirectoryNameSort).Sort(dels)
-		MetaEntryDiffBy(MetaEntryDiffNameSort).Sort(mods)
-	}
+	MetaDirectoryBy(MetaDirectoryNameSort).Sort(adds)
+	MetaDirectoryBy(MetaDirectoryNameSort).Sort(dels)
+	MetaEntryDiffBy(MetaEntryDiffNameSort).Sort(mods)
 	return MetaDirDiff{adds, dels, mods}
 }
 
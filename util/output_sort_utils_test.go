@@ -39,6 +39,11 @@ var packageTests = [][]PackageOutput{
 		{Name: "a", Version: "1.4", Size: 20},
 		{Name: "a", Version: "1.2", Size: 15},
 	},
+	{
+		{Name: "a", Version: "1.10.0", Size: 10},
+		{Name: "a", Version: "1.9.0", Size: 10},
+		{Name: "a", Version: "1.10.0-rc.1", Size: 10},
+	},
 }
 
 func TestSortPackageOutput(t *testing.T) {
@@ -49,7 +54,7 @@ func TestSortPackageOutput(t *testing.T) {
 	}{
 		{
 			input:  packageTests[0],
-			sortBy: packageSizeSort,
+			sortBy: packageSizeSort(""),
 			expected: []PackageOutput{
 				{Name: "c", Version: "1.4", Size: 20},
 				{Name: "b", Version: "1.5", Size: 12},
@@ -58,7 +63,7 @@ func TestSortPackageOutput(t *testing.T) {
 		},
 		{
 			input:  packageTests[0],
-			sortBy: packageNameSort,
+			sortBy: packageNameSort(""),
 			expected: []PackageOutput{
 				{Name: "a", Version: "1.2", Size: 10},
 				{Name: "b", Version: "1.5", Size: 12},
@@ -67,7 +72,7 @@ func TestSortPackageOutput(t *testing.T) {
 		},
 		{
 			input:  packageTests[1],
-			sortBy: packageSizeSort,
+			sortBy: packageSizeSort(""),
 			expected: []PackageOutput{
 				{Name: "b", Version: "1.5", Size: 12},
 				{Name: "c", Version: "1.4", Size: 12},
@@ -76,13 +81,31 @@ func TestSortPackageOutput(t *testing.T) {
 		},
 		{
 			input:  packageTests[2],
-			sortBy: packageNameSort,
+			sortBy: packageNameSort(""),
 			expected: []PackageOutput{
 				{Name: "a", Version: "1.2", Size: 15},
 				{Name: "a", Version: "1.2", Size: 10},
 				{Name: "a", Version: "1.4", Size: 20},
 			},
 		},
+		{
+			input:  packageTests[3],
+			sortBy: packageNameSort("Node"),
+			expected: []PackageOutput{
+				{Name: "a", Version: "1.9.0", Size: 10},
+				{Name: "a", Version: "1.10.0-rc.1", Size: 10},
+				{Name: "a", Version: "1.10.0", Size: 10},
+			},
+		},
+		{
+			input:  packageTests[3],
+			sortBy: packageSizeSort("Node"),
+			expected: []PackageOutput{
+				{Name: "a", Version: "1.9.0", Size: 10},
+				{Name: "a", Version: "1.10.0-rc.1", Size: 10},
+				{Name: "a", Version: "1.10.0", Size: 10},
+			},
+		},
 	} {
 		actual := test.input
 		packageBy(test.sortBy).Sort(actual)
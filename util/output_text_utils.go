@@ -19,9 +19,12 @@ package util
 import (
 	"fmt"
 	"io/fs"
+	"strings"
+	"time"
 
 	"code.cloudfoundry.org/bytefmt"
 	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 )
 
 type StrPackageOutput struct {
@@ -56,6 +59,7 @@ type StrMultiVersionInfo struct {
 	Package string
 	Info1   []StrPackageInfo
 	Info2   []StrPackageInfo
+	Change  string
 }
 
 type StrPackageInfo struct {
@@ -71,6 +75,7 @@ type StrInfo struct {
 	Package string
 	Info1   StrPackageInfo
 	Info2   StrPackageInfo
+	Change  string
 }
 
 func stringifyPackageDiff(infoDiff []Info) (strInfoDiff []StrInfo) {
@@ -78,7 +83,7 @@ func stringifyPackageDiff(infoDiff []Info) (strInfoDiff []StrInfo) {
 		strInfo1 := stringifyPackageInfo(diff.Info1)
 		strInfo2 := stringifyPackageInfo(diff.Info2)
 
-		strDiff := StrInfo{Package: diff.Package, Info1: strInfo1, Info2: strInfo2}
+		strDiff := StrInfo{Package: diff.Package, Info1: strInfo1, Info2: strInfo2, Change: stringifyChange(diff.Change)}
 		strInfoDiff = append(strInfoDiff, strDiff)
 	}
 	return
@@ -96,25 +101,49 @@ func stringifyMultiVersionPackageDiff(infoDiff []MultiVersionInfo) (strInfoDiff
 			strInfos2 = append(strInfos2, stringifyPackageInfo(info))
 		}
 
-		strDiff := StrMultiVersionInfo{Package: diff.Package, Info1: strInfos1, Info2: strInfos2}
+		strDiff := StrMultiVersionInfo{Package: diff.Package, Info1: strInfos1, Info2: strInfos2, Change: stringifyChange(diff.Change)}
 		strInfoDiff = append(strInfoDiff, strDiff)
 	}
 	return
 }
 
+func stringifyChange(change string) string {
+	if change == "" {
+		return "-"
+	}
+	return change
+}
+
 type StrDirectoryEntry struct {
-	Name string
-	Size string
+	Name   string
+	Size   string
+	Digest string
 }
 
 func stringifyDirectoryEntries(entries []pkgutil.DirectoryEntry) (strEntries []StrDirectoryEntry) {
 	for _, entry := range entries {
-		strEntry := StrDirectoryEntry{Name: entry.Name, Size: stringifySize(entry.Size)}
+		strEntry := StrDirectoryEntry{Name: entry.Name, Size: stringifySize(entry.Size), Digest: stringifyChange(entry.Digest)}
 		strEntries = append(strEntries, strEntry)
 	}
 	return
 }
 
+type StrFileLayerAnalysis struct {
+	Entries   []StrDirectoryEntry
+	Deletions []string
+}
+
+func stringifyWhiteouts(whiteouts []pkgutil.Whiteout) (strWhiteouts []string) {
+	for _, whiteout := range whiteouts {
+		if whiteout.Opaque {
+			strWhiteouts = append(strWhiteouts, strings.TrimSuffix(whiteout.Path, "/")+"/* (opaque directory)")
+		} else {
+			strWhiteouts = append(strWhiteouts, whiteout.Path)
+		}
+	}
+	return
+}
+
 type StrDirectoryMetaEntry struct {
 	Name string
 	Meta string
@@ -129,14 +158,18 @@ func stringifyDirectoryMetaEntries(entries []pkgutil.DirectoryMetaEntry) (strEnt
 }
 
 type StrEntryDiff struct {
-	Name  string
-	Size1 string
-	Size2 string
+	Name    string
+	Size1   string
+	Size2   string
+	Content string
 }
 
 func stringifyEntryDiffs(entries []EntryDiff) (strEntries []StrEntryDiff) {
 	for _, entry := range entries {
 		strEntry := StrEntryDiff{Name: entry.Name, Size1: stringifySize(entry.Size1), Size2: stringifySize(entry.Size2)}
+		if entry.Content != nil {
+			strEntry.Content = strings.TrimSuffix(entry.Content.Summary(), "\n")
+		}
 		strEntries = append(strEntries, strEntry)
 	}
 	return
@@ -157,14 +190,15 @@ func stringifyMetaEntryDiffs(entries []MetaEntryDiff) (strEntries []StrMetaEntry
 }
 
 type StrSizeEntry struct {
-	Name   string
-	Digest string
-	Size   string
+	Name      string
+	Digest    string
+	Size      string
+	Deletions []string
 }
 
 func stringifySizeEntries(entries []SizeEntry) (strEntries []StrSizeEntry) {
 	for _, entry := range entries {
-		strEntry := StrSizeEntry{Name: entry.Name, Digest: entry.Digest.String(), Size: stringifySize(entry.Size)}
+		strEntry := StrSizeEntry{Name: entry.Name, Digest: entry.Digest.String(), Size: stringifySize(entry.Size), Deletions: stringifyWhiteouts(entry.Deletions)}
 		strEntries = append(strEntries, strEntry)
 	}
 	return
@@ -183,3 +217,214 @@ func stringifySizeDiffs(entries []SizeDiff) (strEntries []StrSizeDiff) {
 	}
 	return
 }
+
+type StrMetadataDiff struct {
+	Name   string
+	Kind   string
+	Value1 string
+	Value2 string
+}
+
+func stringifyMetadataDiffs(diffs []MetadataDiff) (strDiffs []StrMetadataDiff) {
+	for _, diff := range diffs {
+		strDiff := StrMetadataDiff{Name: diff.Name(), Kind: diff.Kind, Value1: stringifyMetadataValue(diff.Value1), Value2: stringifyMetadataValue(diff.Value2)}
+		strDiffs = append(strDiffs, strDiff)
+	}
+	return
+}
+
+func stringifyMetadataValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "-"
+	case string:
+		if v == "" {
+			return `""`
+		}
+		return v
+	case []string:
+		return fmt.Sprintf("%q", v)
+	case v1.Time:
+		return v.Format(time.RFC3339)
+	case *v1.HealthConfig:
+		return fmt.Sprintf("test=%q interval=%s timeout=%s start-period=%s retries=%d",
+			v.Test, v.Interval, v.Timeout, v.StartPeriod, v.Retries)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+type StrHistoryEntryDiff struct {
+	Kind      string
+	Layer1    string
+	Size1     string
+	Layer2    string
+	Size2     string
+	CreatedBy string
+}
+
+type StrHistDiff struct {
+	SharedBase int
+	Identical  bool
+	Entries    []StrHistoryEntryDiff
+}
+
+// stringifyHistDiff leaves out the entries of the shared base, which are
+// only counted
+func stringifyHistDiff(diff HistDiff) StrHistDiff {
+	strDiff := StrHistDiff{SharedBase: diff.SharedBase, Identical: diff.Identical()}
+	for _, entry := range diff.Entries[diff.SharedBase:] {
+		strEntry := StrHistoryEntryDiff{Kind: entry.Kind, Layer1: "-", Size1: "-", Layer2: "-", Size2: "-"}
+		if entry.Entry1 != nil {
+			strEntry.Layer1, strEntry.Size1 = stringifyHistoryLayer(*entry.Entry1)
+			strEntry.CreatedBy = entry.Entry1.CreatedBy
+		}
+		if entry.Entry2 != nil {
+			strEntry.Layer2, strEntry.Size2 = stringifyHistoryLayer(*entry.Entry2)
+			strEntry.CreatedBy = entry.Entry2.CreatedBy
+		}
+		strDiff.Entries = append(strDiff.Entries, strEntry)
+	}
+	return strDiff
+}
+
+// stringifyHistoryLayer shortens the layer digest to 12 characters
+func stringifyHistoryLayer(entry HistoryEntry) (string, string) {
+	if entry.Digest == "" {
+		return "-", "-"
+	}
+	digest := entry.Digest
+	if i := strings.Index(digest, ":"); i >= 0 && len(digest) > i+13 {
+		digest = digest[:i+13]
+	}
+	return digest, stringifySize(entry.Size)
+}
+
+type StrLayerBlob struct {
+	Digest           string
+	Size             string
+	UncompressedSize string
+}
+
+func stringifyLayerBlobs(blobs []LayerBlob) (strBlobs []StrLayerBlob) {
+	for _, blob := range blobs {
+		strBlob := StrLayerBlob{Digest: blob.Digest, Size: stringifySize(blob.Size), UncompressedSize: stringifySize(blob.UncompressedSize)}
+		strBlobs = append(strBlobs, strBlob)
+	}
+	return
+}
+
+type StrLayerShareAnalysis struct {
+	Layers           []StrLayerBlob
+	Size             string
+	UncompressedSize string
+}
+
+type StrLayerShareDiff struct {
+	Shared            []StrLayerBlob
+	Unique1           []StrLayerBlob
+	Unique2           []StrLayerBlob
+	Size1             string
+	Size2             string
+	UncompressedSize1 string
+	UncompressedSize2 string
+	SharedSize        string
+	PullSize          string
+}
+
+func stringifyLayerShareDiff(diff LayerShareDiff) StrLayerShareDiff {
+	return StrLayerShareDiff{
+		Shared:            stringifyLayerBlobs(diff.Shared),
+		Unique1:           stringifyLayerBlobs(diff.Unique1),
+		Unique2:           stringifyLayerBlobs(diff.Unique2),
+		Size1:             stringifySize(diff.Size1),
+		Size2:             stringifySize(diff.Size2),
+		UncompressedSize1: stringifySize(diff.UncompressedSize1),
+		UncompressedSize2: stringifySize(diff.UncompressedSize2),
+		SharedSize:        stringifySize(diff.SharedSize),
+		PullSize:          stringifySize(diff.PullSize),
+	}
+}
+
+type StrVuln struct {
+	ID           string
+	Aliases      string
+	Severity     string
+	Package      string
+	Version      string
+	FixedVersion string
+}
+
+func stringifyVulns(vulns []Vuln) (strVulns []StrVuln) {
+	for _, vuln := range vulns {
+		strVulns = append(strVulns, StrVuln{
+			ID:           vuln.ID,
+			Aliases:      stringifyAliases(vuln.Aliases),
+			Severity:     stringifySeverity(vuln.Severity, vuln.Score),
+			Package:      vuln.Package,
+			Version:      vuln.Version,
+			FixedVersion: stringifyVersion(vuln.FixedVersion),
+		})
+	}
+	return
+}
+
+type StrVulnDiffEntry struct {
+	ID           string
+	Aliases      string
+	Severity     string
+	Package      string
+	Version1     string
+	Version2     string
+	FixedVersion string
+}
+
+type StrVulnDiff struct {
+	Fixed      []StrVulnDiffEntry
+	Introduced []StrVulnDiffEntry
+	Unchanged  []StrVulnDiffEntry
+}
+
+func stringifyVulnDiff(diff VulnDiff) StrVulnDiff {
+	return StrVulnDiff{
+		Fixed:      stringifyVulnDiffEntries(diff.Fixed),
+		Introduced: stringifyVulnDiffEntries(diff.Introduced),
+		Unchanged:  stringifyVulnDiffEntries(diff.Unchanged),
+	}
+}
+
+func stringifyVulnDiffEntries(entries []VulnDiffEntry) (strEntries []StrVulnDiffEntry) {
+	for _, entry := range entries {
+		strEntries = append(strEntries, StrVulnDiffEntry{
+			ID:           entry.ID,
+			Aliases:      stringifyAliases(entry.Aliases),
+			Severity:     stringifySeverity(entry.Severity, entry.Score),
+			Package:      entry.Package,
+			Version1:     stringifyVersion(entry.Version1),
+			Version2:     stringifyVersion(entry.Version2),
+			FixedVersion: stringifyVersion(entry.FixedVersion),
+		})
+	}
+	return
+}
+
+func stringifyAliases(aliases []string) string {
+	if len(aliases) == 0 {
+		return "-"
+	}
+	return strings.Join(aliases, ",")
+}
+
+// stringifySeverity adds the CVSS score to a severity, if known
+func stringifySeverity(severity string, score float64) string {
+	if score <= 0 {
+		return severity
+	}
+	return fmt.Sprintf("%s(%.1f)", severity, score)
+}
+
+func stringifyVersion(version string) string {
+	if version == "" {
+		return "-"
+	}
+	return version
+}
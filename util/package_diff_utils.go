@@ -21,6 +21,7 @@ import (
 	"io/ioutil"
 	"path/filepath"
 	"reflect"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 )
@@ -33,10 +34,12 @@ type MultiVersionPackageDiff struct {
 }
 
 // MultiVersionInfo stores the information for one multi-version package in two different images.
+// Change compares the highest versions of the package in each image, when both have one.
 type MultiVersionInfo struct {
 	Package string
 	Info1   []PackageInfo
 	Info2   []PackageInfo
+	Change  string `json:",omitempty"`
 }
 
 // PackageDiff stores the difference information between two images.
@@ -53,12 +56,21 @@ type PackageLayerDiff struct {
 }
 
 // Info stores the information for one package in two different images.
+// Change tells whether the package was upgraded, downgraded or rebuilt.
 type Info struct {
 	Package string
 	Info1   PackageInfo
 	Info2   PackageInfo
+	Change  string `json:",omitempty"`
 }
 
+// Kinds of Change of a package between two images.
+const (
+	PackageUpgraded   = "upgrade"
+	PackageDowngraded = "downgrade"
+	PackageRebuilt    = "rebuild"
+)
+
 // PackageInfo stores the specific metadata about a package.
 type PackageInfo struct {
 	Version string
@@ -90,7 +102,7 @@ func multiVersionDiff(infoDiff []MultiVersionInfo, packageName string, map1, map
 	}
 
 	if len(diff1) > 0 || len(diff2) > 0 {
-		infoDiff = append(infoDiff, MultiVersionInfo{packageName, diff1, diff2})
+		infoDiff = append(infoDiff, MultiVersionInfo{Package: packageName, Info1: diff1, Info2: diff2})
 	}
 	return infoDiff
 }
@@ -168,7 +180,7 @@ func diffMaps(map1, map2 interface{}) interface{} {
 				packageInfo2 := packageEntry2.Interface().(PackageInfo)
 				// If two instances of the same package don't have the same version, then they are considered to be different
 				if packageInfo1.Version != packageInfo2.Version {
-					infoDiff = append(infoDiff, Info{pack.String(), packageInfo1, packageInfo2})
+					infoDiff = append(infoDiff, Info{Package: pack.String(), Info1: packageInfo1, Info2: packageInfo2})
 				}
 			}
 			map2Value.SetMapIndex(pack, reflect.Value{})
@@ -189,6 +201,62 @@ func diffMaps(map1, map2 interface{}) interface{} {
 		Packages2: diff2.Interface().(map[string]PackageInfo), InfoDiff: infoDiff}
 }
 
+// ClassifyChanges sets the Change of each package of the diff, comparing
+// versions by the rules of the package manager of analyzeType.
+func (d *PackageDiff) ClassifyChanges(analyzeType string) {
+	for i, info := range d.InfoDiff {
+		d.InfoDiff[i].Change = ClassifyVersionChange(analyzeType, info.Info1.Version, info.Info2.Version)
+	}
+}
+
+// ClassifyChanges sets the Change of each package of the diff that has
+// versions in both images, comparing the highest version in each.
+func (d *MultiVersionPackageDiff) ClassifyChanges(analyzeType string) {
+	for i, info := range d.InfoDiff {
+		if len(info.Info1) == 0 || len(info.Info2) == 0 {
+			continue
+		}
+		version1 := highestVersion(analyzeType, info.Info1)
+		version2 := highestVersion(analyzeType, info.Info2)
+		d.InfoDiff[i].Change = ClassifyVersionChange(analyzeType, version1, version2)
+	}
+}
+
+// ClassifyVersionChange tells whether going from version1 to version2 of a
+// package is an upgrade, a downgrade or a rebuild of the same version.
+func ClassifyVersionChange(analyzeType, version1, version2 string) string {
+	if analyzeType == "Apt" && trimBinNMU(version1) == trimBinNMU(version2) {
+		return PackageRebuilt
+	}
+	switch c := CompareVersions(analyzeType, version1, version2); {
+	case c < 0:
+		return PackageUpgraded
+	case c > 0:
+		return PackageDowngraded
+	}
+	return PackageRebuilt
+}
+
+// trimBinNMU removes the +bN suffix Debian gives binary-only rebuilds of a
+// package.
+func trimBinNMU(version string) string {
+	i := strings.LastIndex(version, "+b")
+	if i < 0 || !isNumeric(version[i+2:]) {
+		return version
+	}
+	return version[:i]
+}
+
+func highestVersion(analyzeType string, infos []PackageInfo) string {
+	highest := infos[0].Version
+	for _, info := range infos[1:] {
+		if CompareVersions(analyzeType, info.Version, highest) > 0 {
+			highest = info.Version
+		}
+	}
+	return highest
+}
+
 func (pi PackageInfo) string() string {
 	return pi.Version
 }
@@ -76,7 +76,7 @@ func TestDiffMaps(t *testing.T) {
 				Packages1: map[string]PackageInfo{},
 				Packages2: map[string]PackageInfo{},
 				InfoDiff: []Info{
-					{"pac3", PackageInfo{"3.0", 60}, PackageInfo{"4.0", 60}}},
+					{Package: "pac3", Info1: PackageInfo{"3.0", 60}, Info2: PackageInfo{"4.0", 60}}},
 			},
 		},
 		{
@@ -231,6 +231,40 @@ func TestCheckPackageMapType(t *testing.T) {
 		}
 	}
 }
+func TestClassifyChanges(t *testing.T) {
+	diff := PackageDiff{
+		InfoDiff: []Info{
+			{Package: "libc6", Info1: PackageInfo{Version: "2.36-9"}, Info2: PackageInfo{Version: "2.36-9+deb12u4"}},
+			{Package: "bash", Info1: PackageInfo{Version: "5.2.15-2+b2"}, Info2: PackageInfo{Version: "5.2.15-2+b1"}},
+			{Package: "curl", Info1: PackageInfo{Version: "7.88.1-10+deb12u5"}, Info2: PackageInfo{Version: "7.74.0-1.3+deb11u11"}},
+			{Package: "zlib1g", Info1: PackageInfo{Version: "1:1.2.13"}, Info2: PackageInfo{Version: "1:1.2.13+b1"}},
+		},
+	}
+	diff.ClassifyChanges("Apt")
+	expected := []string{PackageUpgraded, PackageRebuilt, PackageDowngraded, PackageRebuilt}
+	for i, info := range diff.InfoDiff {
+		if info.Change != expected[i] {
+			t.Errorf("%s: Expected: %s but got: %s", info.Package, expected[i], info.Change)
+		}
+	}
+
+	multiDiff := MultiVersionPackageDiff{
+		InfoDiff: []MultiVersionInfo{
+			{Package: "lodash", Info1: []PackageInfo{{Version: "4.17.9"}, {Version: "4.17.20"}}, Info2: []PackageInfo{{Version: "4.17.21"}}},
+			{Package: "mock", Info1: []PackageInfo{{Version: "2.0.0"}}, Info2: []PackageInfo{{Version: "0.8.0"}}},
+			{Package: "sax", Info1: []PackageInfo{{Version: "1.2.4"}}, Info2: []PackageInfo{}},
+			{Package: "semver", Info1: []PackageInfo{{Version: "7.5.4"}}, Info2: []PackageInfo{{Version: "v7.5.4"}}},
+		},
+	}
+	multiDiff.ClassifyChanges("Node")
+	expected = []string{PackageUpgraded, PackageDowngraded, "", PackageRebuilt}
+	for i, info := range multiDiff.InfoDiff {
+		if info.Change != expected[i] {
+			t.Errorf("%s: Expected: %s but got: %s", info.Package, expected[i], info.Change)
+		}
+	}
+}
+
 func TestBuildLayerTargets(t *testing.T) {
 	testCases := []struct {
 		descrip  string
@@ -0,0 +1,165 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+)
+
+func TestPathFilter(t *testing.T) {
+	testCases := []struct {
+		descrip  string
+		includes []string
+		excludes []string
+		kept     []string
+		dropped  []string
+		// dirs are the paths of kept and dropped that are directories
+		dirs []string
+	}{
+		{
+			descrip:  "anchored exclude",
+			excludes: []string{"/var/cache"},
+			kept:     []string{"/var", "/var/lib/cache", "/usr/var/cache"},
+			dropped:  []string{"/var/cache", "/var/cache/apk/APKINDEX.tar.gz"},
+		},
+		{
+			descrip:  "unanchored exclude",
+			excludes: []string{"*.pyc", "__pycache__/"},
+			kept:     []string{"/usr/lib/python3/os.py", "/pyc"},
+			dropped:  []string{"/os.pyc", "/usr/lib/python3/os.pyc", "/usr/lib/python3/__pycache__/os.cpython-311.pyc"},
+		},
+		{
+			descrip:  "double star",
+			excludes: []string{"/usr/**/doc"},
+			kept:     []string{"/usr/share/docs", "/doc"},
+			dropped:  []string{"/usr/doc", "/usr/share/doc/README", "/usr/local/share/doc"},
+		},
+		{
+			descrip:  "negated exclude",
+			excludes: []string{"/var/lib", "!/var/lib/dpkg", "/var/lib/dpkg/info"},
+			kept:     []string{"/var", "/var/lib/dpkg", "/var/lib/dpkg/status"},
+			dropped:  []string{"/var/lib/apt/lists", "/var/lib/dpkg/info/libc6.list"},
+		},
+		{
+			descrip:  "directory only",
+			excludes: []string{"cache/", "/opt/data/"},
+			kept:     []string{"/etc/cache", "/var", "/opt/data", "/srv/opt/data/db"},
+			dropped:  []string{"/var/cache", "/var/cache/apk/APKINDEX.tar.gz", "/opt/data/db"},
+			dirs:     []string{"/var", "/var/cache", "/srv/opt/data"},
+		},
+		{
+			descrip:  "includes",
+			includes: []string{"/etc", "*.so"},
+			excludes: []string{"/etc/ssl"},
+			kept:     []string{"/etc/passwd", "/usr/lib/libc.so"},
+			dropped:  []string{"/usr/bin/ls", "/usr/lib", "/etc/ssl/cert.pem"},
+		},
+	}
+	for _, test := range testCases {
+		filter, err := pkgutil.NewPathFilter(test.includes, test.excludes)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.descrip, err)
+			continue
+		}
+		isDir := func(name string) bool {
+			for _, dir := range test.dirs {
+				if name == dir {
+					return true
+				}
+			}
+			return false
+		}
+		for _, name := range test.kept {
+			if !filter.Keep(name, isDir(name)) {
+				t.Errorf("%s: expected %s to be kept", test.descrip, name)
+			}
+		}
+		for _, name := range test.dropped {
+			if filter.Keep(name, isDir(name)) {
+				t.Errorf("%s: expected %s to be dropped", test.descrip, name)
+			}
+		}
+	}
+
+	for _, test := range []struct {
+		includes []string
+		excludes []string
+	}{
+		{includes: []string{"!/etc"}},
+		{excludes: []string{"/"}},
+		{excludes: []string{"//"}},
+		{excludes: []string{"/usr/[lib"}},
+	} {
+		if _, err := pkgutil.NewPathFilter(test.includes, test.excludes); err == nil {
+			t.Errorf("expected an error for includes %v and excludes %v", test.includes, test.excludes)
+		}
+	}
+}
+
+func TestReadIgnoreFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	ignoreFile := filepath.Join(dir, ".containerdiffignore")
+	contents := "# package manager caches\n/var/cache\n\n  *.pyc  \n!/var/cache/keep\n"
+	if err := ioutil.WriteFile(ignoreFile, []byte(contents), 0644); err != nil {
+		t.Fatalf("Error writing ignore file: %s", err)
+	}
+	patterns, err := pkgutil.ReadIgnoreFile(ignoreFile)
+	if err != nil {
+		t.Fatalf("Error reading ignore file: %s", err)
+	}
+	expected := []string{"/var/cache", "*.pyc", "!/var/cache/keep"}
+	if !reflect.DeepEqual(patterns, expected) {
+		t.Errorf("Expected patterns %v but got %v", expected, patterns)
+	}
+}
+
+func TestGetDirectoryFiltered(t *testing.T) {
+	filter, err := pkgutil.NewPathFilter(nil, []string{"/nest", "peach-*"})
+	if err != nil {
+		t.Fatalf("Error creating path filter: %s", err)
+	}
+	dir, err := pkgutil.GetDirectoryContext(context.Background(), "testTars/la-croix3-full", true, filter)
+	if err != nil {
+		t.Fatalf("Error converting directory to Directory struct: %s", err)
+	}
+	expected := []string{"/lime.txt", "/nested-dir", "/nested-dir/f2.txt", "/passionfruit.txt"}
+	if !reflect.DeepEqual(dir.Content, expected) {
+		t.Errorf("Expected content %v but got %v", expected, dir.Content)
+	}
+
+	size := pkgutil.GetFilteredSize("testTars/la-croix3-full", "/", filter)
+	var expectedSize int64
+	for _, name := range expected {
+		expectedSize += pkgutil.GetSize(filepath.Join("testTars/la-croix3-full", name))
+	}
+	// directory sizes count the files under them, so only count files
+	expectedSize -= pkgutil.GetSize("testTars/la-croix3-full/nested-dir")
+	if size != expectedSize {
+		t.Errorf("Expected filtered size %d but got %d", expectedSize, size)
+	}
+}
@@ -0,0 +1,429 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+
+	"code.cloudfoundry.org/bytefmt"
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+	"github.com/sirupsen/logrus"
+)
+
+// Kinds of change reported by differs
+const (
+	ChangeAdded    = "added"
+	ChangeDeleted  = "deleted"
+	ChangeModified = "modified"
+	changeAny      = "any"
+)
+
+// Change is a single difference found by a differ: a file, package, layer,
+// history or metadata entry that was added, deleted or modified. Sizes are
+// -1 when unknown or not applicable.
+type Change struct {
+	Kind  string
+	Name  string
+	Size1 int64
+	Size2 int64
+}
+
+// Policy is a set of rules deciding whether the differences between two
+// images are acceptable.
+type Policy struct {
+	Rules []PolicyRule
+}
+
+// PolicyRule is violated by every change of a differ that it matches.
+type PolicyRule struct {
+	// Description explains the rule when it is violated
+	Description string
+	// Type is the analyzer type the rule applies to, e.g. "apt" or "file".
+	// An empty type applies the rule to every analyzer.
+	Type string
+	// Change is the kind of change matched by the rule: "added", "deleted",
+	// "modified" or "any", the default
+	Change string
+	// Path only matches file changes at or below this path
+	Path string
+	// Package only matches package changes whose name matches this glob
+	Package string
+	// MaxGrowth only matches changes that grow by more than this size,
+	// e.g. "50MB"
+	MaxGrowth string
+
+	maxGrowth int64
+}
+
+// Violation is a change that broke a policy rule.
+type Violation struct {
+	Rule   PolicyRule
+	Type   string
+	Change Change
+}
+
+func (v Violation) String() string {
+	description := v.Rule.Description
+	if description == "" {
+		description = fmt.Sprintf("rule for %s changes", v.Type)
+	}
+	name := v.Change.Name
+	if name == "" {
+		name = v.Type
+	}
+	if v.Rule.MaxGrowth != "" {
+		return fmt.Sprintf("%s: %s grew from %s to %s", description, name,
+			stringifySize(v.Change.Size1), stringifySize(v.Change.Size2))
+	}
+	return fmt.Sprintf("%s: %s %s %s", description, v.Type, name, v.Change.Kind)
+}
+
+// LoadPolicy reads a policy from a JSON file.
+func LoadPolicy(file string) (*Policy, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var policy Policy
+	if err := json.Unmarshal(contents, &policy); err != nil {
+		return nil, fmt.Errorf("parsing policy %s: %s", file, err)
+	}
+	if err := policy.validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %s", file, err)
+	}
+	return &policy, nil
+}
+
+// FailOnDiffPolicy returns a policy violated by any difference.
+func FailOnDiffPolicy() *Policy {
+	return &Policy{
+		Rules: []PolicyRule{{Description: "images differ"}},
+	}
+}
+
+func (p *Policy) validate() error {
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		switch rule.Change {
+		case "":
+			rule.Change = changeAny
+		case changeAny, ChangeAdded, ChangeDeleted, ChangeModified:
+		default:
+			return fmt.Errorf("rule %d: unknown change %q", i, rule.Change)
+		}
+		if rule.Package != "" {
+			if _, err := path.Match(rule.Package, ""); err != nil {
+				return fmt.Errorf("rule %d: invalid package pattern %q", i, rule.Package)
+			}
+		}
+		if rule.MaxGrowth != "" {
+			growth, err := bytefmt.ToBytes(rule.MaxGrowth)
+			if err != nil {
+				return fmt.Errorf("rule %d: invalid size %q: %s", i, rule.MaxGrowth, err)
+			}
+			rule.maxGrowth = int64(growth)
+		}
+	}
+	return nil
+}
+
+// Evaluate checks the diff results against the policy, and returns every
+// change violating one of its rules. Results are keyed by analyzer type.
+func (p *Policy) Evaluate(results map[string]Result) ([]Violation, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	types := []string{}
+	for analyzerType := range results {
+		types = append(types, analyzerType)
+	}
+	sort.Strings(types)
+
+	var violations []Violation
+	for _, analyzerType := range types {
+		changes := GetChanges(results[analyzerType])
+		for _, rule := range p.Rules {
+			if rule.Type != "" && rule.Type != analyzerType {
+				continue
+			}
+			for _, change := range changes {
+				if rule.matches(change) {
+					violations = append(violations, Violation{Rule: rule, Type: analyzerType, Change: change})
+				}
+			}
+		}
+	}
+	return violations, nil
+}
+
+// Covers returns whether any rule of the policy applies to the results of
+// the analyzer type.
+func (p *Policy) Covers(analyzerType string) bool {
+	for _, rule := range p.Rules {
+		if rule.Type == "" || rule.Type == analyzerType {
+			return true
+		}
+	}
+	return false
+}
+
+func (r PolicyRule) matches(change Change) bool {
+	if r.Change != changeAny && r.Change != change.Kind {
+		return false
+	}
+	if r.Path != "" && !pkgutil.HasFilepathPrefix(change.Name, r.Path) {
+		return false
+	}
+	if r.Package != "" {
+		if matched, _ := path.Match(r.Package, change.Name); !matched {
+			return false
+		}
+	}
+	if r.MaxGrowth != "" {
+		size1, size2 := change.Size1, change.Size2
+		if size1 < 0 {
+			size1 = 0
+		}
+		if size2 < 0 {
+			size2 = 0
+		}
+		return size2-size1 > r.maxGrowth
+	}
+	return true
+}
+
+// GetChanges lists the differences reported in a diff result.
+func GetChanges(result Result) []Change {
+	switch r := result.(type) {
+	case *DirDiffResult:
+		if diff, ok := r.Diff.(DirDiff); ok {
+			return dirDiffChanges(diff)
+		}
+	case *MultipleDirDiffResult:
+		if diff, ok := r.Diff.(MultipleDirDiff); ok {
+			var changes []Change
+			for _, dirDiff := range diff.DirDiffs {
+				changes = append(changes, dirDiffChanges(dirDiff)...)
+			}
+			return changes
+		}
+	case *MetaDirDiffResult:
+		if diff, ok := r.Diff.(MetaDirDiff); ok {
+			return metaDirDiffChanges(diff)
+		}
+	case *MultipleMetaDirDiffResult:
+		if diff, ok := r.Diff.(MultipleMetaDirDiff); ok {
+			var changes []Change
+			for _, dirDiff := range diff.DirDiffs {
+				changes = append(changes, metaDirDiffChanges(dirDiff)...)
+			}
+			return changes
+		}
+	case *SizeDiffResult:
+		if diff, ok := r.Diff.([]SizeDiff); ok {
+			return sizeDiffChanges(diff)
+		}
+	case *SizeLayerDiffResult:
+		if diff, ok := r.Diff.([]SizeDiff); ok {
+			return sizeDiffChanges(diff)
+		}
+	case *SingleVersionPackageDiffResult:
+		if diff, ok := r.Diff.(PackageDiff); ok {
+			return packageDiffChanges(diff)
+		}
+	case *MultiVersionPackageDiffResult:
+		if diff, ok := r.Diff.(MultiVersionPackageDiff); ok {
+			return multiVersionPackageDiffChanges(diff)
+		}
+	case *LayerShareDiffResult:
+		if diff, ok := r.Diff.(LayerShareDiff); ok {
+			return layerShareDiffChanges(diff)
+		}
+	case *HistDiffResult:
+		if diff, ok := r.Diff.(HistDiff); ok {
+			return histDiffChanges(diff)
+		}
+	case *MetadataDiffResult:
+		if diff, ok := r.Diff.([]MetadataDiff); ok {
+			return metadataDiffChanges(diff)
+		}
+	case *VulnDiffResult:
+		if diff, ok := r.Diff.(VulnDiff); ok {
+			return vulnDiffChanges(diff)
+		}
+	}
+	logrus.Debugf("No changes can be listed for result of type %T", result)
+	return nil
+}
+
+func dirDiffChanges(diff DirDiff) []Change {
+	var changes []Change
+	for _, entry := range diff.Adds {
+		changes = append(changes, Change{Kind: ChangeAdded, Name: entry.Name, Size1: -1, Size2: entry.Size})
+	}
+	for _, entry := range diff.Dels {
+		changes = append(changes, Change{Kind: ChangeDeleted, Name: entry.Name, Size1: entry.Size, Size2: -1})
+	}
+	for _, entry := range diff.Mods {
+		changes = append(changes, Change{Kind: ChangeModified, Name: entry.Name, Size1: entry.Size1, Size2: entry.Size2})
+	}
+	return changes
+}
+
+func metaDirDiffChanges(diff MetaDirDiff) []Change {
+	var changes []Change
+	for _, entry := range diff.Adds {
+		changes = append(changes, Change{Kind: ChangeAdded, Name: entry.Name, Size1: -1, Size2: -1})
+	}
+	for _, entry := range diff.Dels {
+		changes = append(changes, Change{Kind: ChangeDeleted, Name: entry.Name, Size1: -1, Size2: -1})
+	}
+	for _, entry := range diff.Mods {
+		changes = append(changes, Change{Kind: ChangeModified, Name: entry.Name, Size1: -1, Size2: -1})
+	}
+	return changes
+}
+
+// sizeDiffChanges treats a size of -1, a layer missing from one image, as
+// a layer added or deleted
+func sizeDiffChanges(diff []SizeDiff) []Change {
+	var changes []Change
+	for _, entry := range diff {
+		kind := ChangeModified
+		if entry.Size1 == -1 {
+			kind = ChangeAdded
+		} else if entry.Size2 == -1 {
+			kind = ChangeDeleted
+		}
+		changes = append(changes, Change{Kind: kind, Name: entry.Name, Size1: entry.Size1, Size2: entry.Size2})
+	}
+	return changes
+}
+
+func packageDiffChanges(diff PackageDiff) []Change {
+	var changes []Change
+	for name, info := range diff.Packages2 {
+		changes = append(changes, Change{Kind: ChangeAdded, Name: name, Size1: -1, Size2: info.Size})
+	}
+	for name, info := range diff.Packages1 {
+		changes = append(changes, Change{Kind: ChangeDeleted, Name: name, Size1: info.Size, Size2: -1})
+	}
+	for _, info := range diff.InfoDiff {
+		changes = append(changes, Change{Kind: ChangeModified, Name: info.Package, Size1: info.Info1.Size, Size2: info.Info2.Size})
+	}
+	sortChanges(changes)
+	return changes
+}
+
+func multiVersionPackageDiffChanges(diff MultiVersionPackageDiff) []Change {
+	var changes []Change
+	for name, versions := range diff.Packages2 {
+		changes = append(changes, Change{Kind: ChangeAdded, Name: name, Size1: -1, Size2: totalPackageSize(versions)})
+	}
+	for name, versions := range diff.Packages1 {
+		changes = append(changes, Change{Kind: ChangeDeleted, Name: name, Size1: totalPackageSize(versions), Size2: -1})
+	}
+	for _, info := range diff.InfoDiff {
+		var size1, size2 int64
+		for _, version := range info.Info1 {
+			size1 += version.Size
+		}
+		for _, version := range info.Info2 {
+			size2 += version.Size
+		}
+		changes = append(changes, Change{Kind: ChangeModified, Name: info.Package, Size1: size1, Size2: size2})
+	}
+	sortChanges(changes)
+	return changes
+}
+
+// totalPackageSize sums the sizes of all installed versions of a package
+func totalPackageSize(versions map[string]PackageInfo) int64 {
+	var size int64
+	for _, info := range versions {
+		size += info.Size
+	}
+	return size
+}
+
+// layerShareDiffChanges reports the layer blobs only found in either image,
+// named by digest
+func layerShareDiffChanges(diff LayerShareDiff) []Change {
+	var changes []Change
+	for _, blob := range diff.Unique2 {
+		changes = append(changes, Change{Kind: ChangeAdded, Name: blob.Digest, Size1: -1, Size2: blob.Size})
+	}
+	for _, blob := range diff.Unique1 {
+		changes = append(changes, Change{Kind: ChangeDeleted, Name: blob.Digest, Size1: blob.Size, Size2: -1})
+	}
+	return changes
+}
+
+// histDiffChanges reports the history entries added, deleted and rebuilt,
+// with the sizes of their layers
+func histDiffChanges(diff HistDiff) []Change {
+	var changes []Change
+	for _, entry := range diff.Entries {
+		switch entry.Kind {
+		case ChangeAdded:
+			changes = append(changes, Change{Kind: ChangeAdded, Name: entry.Entry2.CreatedBy, Size1: -1, Size2: entry.Entry2.Size})
+		case ChangeDeleted:
+			changes = append(changes, Change{Kind: ChangeDeleted, Name: entry.Entry1.CreatedBy, Size1: entry.Entry1.Size, Size2: -1})
+		case HistoryRebuilt:
+			changes = append(changes, Change{Kind: ChangeModified, Name: entry.Entry2.CreatedBy, Size1: entry.Entry1.Size, Size2: entry.Entry2.Size})
+		}
+	}
+	sortChanges(changes)
+	return changes
+}
+
+// metadataDiffChanges names each change after its config field and key,
+// e.g. "Env[PATH]"
+func metadataDiffChanges(diff []MetadataDiff) []Change {
+	var changes []Change
+	for _, entry := range diff {
+		changes = append(changes, Change{Kind: entry.Kind, Name: entry.Name(), Size1: -1, Size2: -1})
+	}
+	return changes
+}
+
+// vulnDiffChanges reports introduced vulnerabilities as added and fixed ones
+// as deleted, named by their ID
+func vulnDiffChanges(diff VulnDiff) []Change {
+	var changes []Change
+	for _, entry := range diff.Introduced {
+		changes = append(changes, Change{Kind: ChangeAdded, Name: entry.ID, Size1: -1, Size2: -1})
+	}
+	for _, entry := range diff.Fixed {
+		changes = append(changes, Change{Kind: ChangeDeleted, Name: entry.ID, Size1: -1, Size2: -1})
+	}
+	sortChanges(changes)
+	return changes
+}
+
+func sortChanges(changes []Change) {
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Kind != changes[j].Kind {
+			return changes[i].Kind < changes[j].Kind
+		}
+		return changes[i].Name < changes[j].Name
+	})
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+)
+
+func TestPolicyEvaluate(t *testing.T) {
+	results := map[string]Result{
+		"apt": &SingleVersionPackageDiffResult{
+			DiffType: "Apt",
+			Diff: PackageDiff{
+				Packages1: map[string]PackageInfo{"wget": {Version: "1.0", Size: 10}},
+				Packages2: map[string]PackageInfo{"curl": {Version: "7.0", Size: 20}},
+				InfoDiff:  []Info{{Package: "bash", Info1: PackageInfo{Version: "4.0"}, Info2: PackageInfo{Version: "5.0"}}},
+			},
+		},
+		"file": &DirDiffResult{
+			DiffType: "File",
+			Diff: DirDiff{
+				Adds: []pkgutil.DirectoryEntry{{Name: "/usr/bin/curl", Size: 20}},
+				Mods: []EntryDiff{{Name: "/etc/passwd", Size1: 100, Size2: 120}},
+			},
+		},
+		"size": &SizeDiffResult{
+			DiffType: "Size",
+			Diff:     []SizeDiff{{Size1: 10 * 1024 * 1024, Size2: 70 * 1024 * 1024}},
+		},
+	}
+
+	testCases := []struct {
+		descrip  string
+		rules    []PolicyRule
+		expected []Violation
+	}{
+		{
+			descrip: "added apt package",
+			rules:   []PolicyRule{{Type: "apt", Change: "added"}},
+			expected: []Violation{
+				{Type: "apt", Change: Change{Kind: ChangeAdded, Name: "curl", Size1: -1, Size2: 20}},
+			},
+		},
+		{
+			descrip: "package pattern",
+			rules:   []PolicyRule{{Type: "apt", Package: "w*"}},
+			expected: []Violation{
+				{Type: "apt", Change: Change{Kind: ChangeDeleted, Name: "wget", Size1: 10, Size2: -1}},
+			},
+		},
+		{
+			descrip: "file changed under /etc",
+			rules:   []PolicyRule{{Type: "file", Path: "/etc"}},
+			expected: []Violation{
+				{Type: "file", Change: Change{Kind: ChangeModified, Name: "/etc/passwd", Size1: 100, Size2: 120}},
+			},
+		},
+		{
+			descrip: "size growth over the limit",
+			rules:   []PolicyRule{{Type: "size", MaxGrowth: "50MB"}},
+			expected: []Violation{
+				{Type: "size", Change: Change{Kind: ChangeModified, Size1: 10 * 1024 * 1024, Size2: 70 * 1024 * 1024}},
+			},
+		},
+		{
+			descrip:  "size growth within the limit",
+			rules:    []PolicyRule{{Type: "size", MaxGrowth: "100MB"}},
+			expected: nil,
+		},
+		{
+			descrip:  "rule for an analyzer that didn't run",
+			rules:    []PolicyRule{{Type: "pip"}},
+			expected: nil,
+		},
+	}
+	for _, test := range testCases {
+		policy := Policy{Rules: test.rules}
+		violations, err := policy.Evaluate(results)
+		if err != nil {
+			t.Errorf("%s: Got unexpected error: %s", test.descrip, err)
+			continue
+		}
+		// only compare the changes found, not the normalized rules
+		for i := range violations {
+			violations[i].Rule = PolicyRule{}
+		}
+		if !reflect.DeepEqual(violations, test.expected) {
+			t.Errorf("%s: Expected: %v but got: %v", test.descrip, test.expected, violations)
+		}
+	}
+
+	violations, err := FailOnDiffPolicy().Evaluate(results)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if len(violations) != 6 {
+		t.Errorf("Expected every change to violate the fail on diff policy, got %d violations", len(violations))
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.json")
+	ioutil.WriteFile(valid, []byte(`{"rules": [{"type": "size", "maxGrowth": "50MB"}, {"type": "apt", "change": "added"}]}`), 0644)
+	policy, err := LoadPolicy(valid)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if len(policy.Rules) != 2 || policy.Rules[0].maxGrowth != 50*1024*1024 || policy.Rules[1].Change != ChangeAdded {
+		t.Errorf("Unexpected policy loaded: %+v", policy)
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	ioutil.WriteFile(invalid, []byte(`{"rules": [{"type": "apt", "change": "upgraded"}]}`), 0644)
+	if _, err := LoadPolicy(invalid); err == nil {
+		t.Errorf("Expected error for unknown change but got none")
+	}
+}
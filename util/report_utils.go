@@ -0,0 +1,749 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/bytefmt"
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+)
+
+// Report gathers the results of a diff or analysis, to be written as a
+// single document such as an HTML page.
+type Report struct {
+	// Title names the compared or analyzed images
+	Title   string
+	Created time.Time
+	// Groups holds the results of each platform of a multi-platform image,
+	// or a single group with no platform
+	Groups []ReportGroup
+	// FileDiff is the diff of the file selected with --filename, if any
+	FileDiff *FileNameDiff
+}
+
+// ReportGroup holds the results of the analyzers for one platform.
+type ReportGroup struct {
+	Platform string
+	Results  map[string]Result
+}
+
+// reportSection lays out the result of one analyzer, or one layer of it.
+type reportSection struct {
+	ID     string
+	Title  string
+	Counts []reportCount
+	Tables []reportTable
+	Diffs  []reportDiff
+	Layers []reportSection
+	// Layer is set on the sections of layers, which start collapsed
+	Layer bool
+	// Raw is the JSON output of results that can't be laid out as tables
+	Raw string
+}
+
+type reportCount struct {
+	Label string
+	Count int
+}
+
+type reportTable struct {
+	Title   string
+	Columns []string
+	Rows    []reportRow
+}
+
+// reportRow is a table row. Change is ChangeAdded, ChangeDeleted or
+// ChangeModified for diffs, and empty otherwise.
+type reportRow struct {
+	Change string
+	Cells  []reportCell
+	// Delta is how many bytes the change added to the image, negative if it
+	// removed some, or 0 if unknown. It ranks the largest changes.
+	Delta int64
+}
+
+type reportCell struct {
+	Text string
+	// Sort is the value the column is sorted by when it isn't Text, such as
+	// a size in bytes
+	Sort string
+	// Class is "grew" or "shrank" for size deltas
+	Class string
+}
+
+type reportDiff struct {
+	Title string
+	Text  string
+}
+
+type reportGroup struct {
+	Platform string
+	Sections []reportSection
+}
+
+// reportGroups lays out the results of each group, in analyzer name order.
+func reportGroups(report Report) []reportGroup {
+	var groups []reportGroup
+	for i, group := range report.Groups {
+		var names []string
+		for name := range group.Results {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		g := reportGroup{Platform: group.Platform}
+		for _, name := range names {
+			section := newReportSection(group.Results[name])
+			setReportIDs(&section, fmt.Sprintf("g%d-%s", i, name))
+			g.Sections = append(g.Sections, section)
+		}
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+func setReportIDs(section *reportSection, id string) {
+	section.ID = id
+	for i := range section.Layers {
+		section.Layers[i].Layer = true
+		setReportIDs(&section.Layers[i], fmt.Sprintf("%s-layer%d", id, i))
+	}
+}
+
+// newReportSection lays out a result according to its type, falling back to
+// its JSON output for result types it doesn't know about.
+func newReportSection(result Result) reportSection {
+	value := reflect.Indirect(reflect.ValueOf(result))
+	if !value.IsValid() {
+		return reportSection{Raw: "null"}
+	}
+	var section reportSection
+	var valid bool
+	switch r := value.Interface().(type) {
+	case SingleVersionPackageDiffResult:
+		var diff PackageDiff
+		if diff, valid = r.Diff.(PackageDiff); valid {
+			section = packageDiffSection(diff, r.DiffType, "Packages found only in "+r.Image1, "Packages found only in "+r.Image2, "Version differences", r.SortSize)
+		}
+		section.Title = r.DiffType
+	case MultiVersionPackageDiffResult:
+		var diff MultiVersionPackageDiff
+		if diff, valid = r.Diff.(MultiVersionPackageDiff); valid {
+			section = multiVersionPackageDiffSection(diff, r.DiffType, r.Image1, r.Image2, r.SortSize)
+		}
+		section.Title = r.DiffType
+	case SingleVersionPackageLayerDiffResult:
+		var diff PackageLayerDiff
+		if diff, valid = r.Diff.(PackageLayerDiff); valid {
+			section = packageLayerSection(diff, r.DiffType, r.Image1, r.Image2, r.SortSize)
+		}
+		section.Title = r.DiffType
+	case HistDiffResult:
+		var diff HistDiff
+		if diff, valid = r.Diff.(HistDiff); valid {
+			section = histDiffSection(diff)
+		}
+		section.Title = r.DiffType
+	case MetadataDiffResult:
+		var diffs []MetadataDiff
+		if diffs, valid = r.Diff.([]MetadataDiff); valid {
+			section = metadataDiffSection(diffs)
+		}
+		section.Title = r.DiffType
+	case DirDiffResult:
+		var diff DirDiff
+		if diff, valid = r.Diff.(DirDiff); valid {
+			section = dirDiffSection(diff, r.Image1, r.Image2, r.SortSize)
+		}
+		section.Title = r.DiffType
+	case MetaDirDiffResult:
+		var diff MetaDirDiff
+		if diff, valid = r.Diff.(MetaDirDiff); valid {
+			section = metaDirDiffSection(diff, r.Image1, r.Image2)
+		}
+		section.Title = r.DiffType
+	case MultipleDirDiffResult:
+		var diff MultipleDirDiff
+		if diff, valid = r.Diff.(MultipleDirDiff); valid {
+			for i, d := range diff.DirDiffs {
+				layer := dirDiffSection(d, r.Image1, r.Image2, r.SortSize)
+				layer.Title = fmt.Sprintf("Layer %d", i)
+				section.Layers = append(section.Layers, layer)
+			}
+			section.Counts = sumReportCounts(section.Layers)
+		}
+		section.Title = r.DiffType
+	case MultipleMetaDirDiffResult:
+		var diff MultipleMetaDirDiff
+		if diff, valid = r.Diff.(MultipleMetaDirDiff); valid {
+			for i, d := range diff.DirDiffs {
+				layer := metaDirDiffSection(d, r.Image1, r.Image2)
+				layer.Title = fmt.Sprintf("Layer %d", i)
+				section.Layers = append(section.Layers, layer)
+			}
+			section.Counts = sumReportCounts(section.Layers)
+		}
+		section.Title = r.DiffType
+	case SizeDiffResult:
+		var diffs []SizeDiff
+		if diffs, valid = r.Diff.([]SizeDiff); valid {
+			section = sizeDiffSection(diffs, "Image")
+		}
+		section.Title = r.DiffType
+	case SizeLayerDiffResult:
+		var diffs []SizeDiff
+		if diffs, valid = r.Diff.([]SizeDiff); valid {
+			section = sizeDiffSection(diffs, "Layer")
+		}
+		section.Title = r.DiffType
+	case LayerShareDiffResult:
+		var diff LayerShareDiff
+		if diff, valid = r.Diff.(LayerShareDiff); valid {
+			section = layerShareDiffSection(diff, r.Image1, r.Image2)
+		}
+		section.Title = r.DiffType
+	case VulnDiffResult:
+		var diff VulnDiff
+		if diff, valid = r.Diff.(VulnDiff); valid {
+			section = vulnDiffSection(diff)
+		}
+		section.Title = r.DiffType
+	case ListAnalyzeResult:
+		var list []string
+		if list, valid = r.Analysis.([]string); valid {
+			table := reportTable{Columns: []string{r.AnalyzeType}}
+			for _, item := range list {
+				table.Rows = append(table.Rows, reportRow{Cells: []reportCell{{Text: item}}})
+			}
+			section = reportSection{Counts: []reportCount{{"entries", len(list)}}, Tables: []reportTable{table}}
+		}
+		section.Title = r.AnalyzeType
+	case SingleVersionPackageAnalyzeResult:
+		var packages map[string]PackageInfo
+		if packages, valid = r.Analysis.(map[string]PackageInfo); valid {
+			table := packageTable("", getSingleVersionPackageOutput(packages, r.AnalyzeType, r.SortSize), "", false)
+			section = reportSection{Counts: []reportCount{{"packages", len(table.Rows)}}, Tables: []reportTable{table}}
+		}
+		section.Title = r.AnalyzeType
+	case MultiVersionPackageAnalyzeResult:
+		var packages map[string]map[string]PackageInfo
+		if packages, valid = r.Analysis.(map[string]map[string]PackageInfo); valid {
+			table := packageTable("", getMultiVersionPackageOutput(packages, r.AnalyzeType, r.SortSize), "", true)
+			section = reportSection{Counts: []reportCount{{"packages", len(table.Rows)}}, Tables: []reportTable{table}}
+		}
+		section.Title = r.AnalyzeType
+	case SingleVersionPackageLayerAnalyzeResult:
+		var diff PackageLayerDiff
+		if diff, valid = r.Analysis.(PackageLayerDiff); valid {
+			section = packageLayerSection(diff, r.AnalyzeType, "the previous layers", "the layer", r.SortSize)
+		}
+		section.Title = r.AnalyzeType
+	case FileAnalyzeResult:
+		var entries []pkgutil.DirectoryEntry
+		if entries, valid = r.Analysis.([]pkgutil.DirectoryEntry); valid {
+			table := directoryTable("", entries, "", r.SortSize, hasDigests(entries))
+			section = reportSection{Counts: []reportCount{{"files", len(entries)}}, Tables: []reportTable{table}}
+		}
+		section.Title = r.AnalyzeType
+	case FileMetaAnalyzeResult:
+		var entries []pkgutil.DirectoryMetaEntry
+		if entries, valid = r.Analysis.([]pkgutil.DirectoryMetaEntry); valid {
+			table := directoryMetaTable("", entries, "")
+			section = reportSection{Counts: []reportCount{{"files", len(entries)}}, Tables: []reportTable{table}}
+		}
+		section.Title = r.AnalyzeType
+	case FileLayerAnalyzeResult:
+		var analysis []FileLayerAnalysis
+		if analysis, valid = r.Analysis.([]FileLayerAnalysis); valid {
+			digests := layersHaveDigests(analysis)
+			for i, a := range analysis {
+				layer := reportSection{
+					Title:  fmt.Sprintf("Layer %d", i),
+					Counts: []reportCount{{"files", len(a.Entries)}, {"deletions", len(a.Deletions)}},
+					Tables: []reportTable{directoryTable("Files", a.Entries, "", r.SortSize, digests)},
+				}
+				if len(a.Deletions) > 0 {
+					deletions := reportTable{Title: "Deletions", Columns: []string{"Path"}}
+					for _, whiteout := range stringifyWhiteouts(a.Deletions) {
+						deletions.Rows = append(deletions.Rows, reportRow{Change: ChangeDeleted, Cells: []reportCell{{Text: whiteout}}})
+					}
+					layer.Tables = append(layer.Tables, deletions)
+				}
+				section.Layers = append(section.Layers, layer)
+			}
+			section.Counts = sumReportCounts(section.Layers)
+		}
+		section.Title = r.AnalyzeType
+	case FileMetaLayerAnalyzeResult:
+		var analysis [][]pkgutil.DirectoryMetaEntry
+		if analysis, valid = r.Analysis.([][]pkgutil.DirectoryMetaEntry); valid {
+			for i, entries := range analysis {
+				section.Layers = append(section.Layers, reportSection{
+					Title:  fmt.Sprintf("Layer %d", i),
+					Counts: []reportCount{{"files", len(entries)}},
+					Tables: []reportTable{directoryMetaTable("", entries, "")},
+				})
+			}
+			section.Counts = sumReportCounts(section.Layers)
+		}
+		section.Title = r.AnalyzeType
+	case SizeAnalyzeResult:
+		var entries []SizeEntry
+		if entries, valid = r.Analysis.([]SizeEntry); valid {
+			section = sizeSection(entries, "Image")
+		}
+		section.Title = r.AnalyzeType
+	case SizeLayerAnalyzeResult:
+		var entries []SizeEntry
+		if entries, valid = r.Analysis.([]SizeEntry); valid {
+			section = sizeSection(entries, "Layer")
+			for _, entry := range entries {
+				if len(entry.Deletions) == 0 {
+					continue
+				}
+				deletions := reportTable{Title: "Removed in Layer " + entry.Name, Columns: []string{"Path"}}
+				for _, whiteout := range stringifyWhiteouts(entry.Deletions) {
+					deletions.Rows = append(deletions.Rows, reportRow{Change: ChangeDeleted, Cells: []reportCell{{Text: whiteout}}})
+				}
+				section.Tables = append(section.Tables, deletions)
+			}
+		}
+		section.Title = r.AnalyzeType
+	case LayerShareAnalyzeResult:
+		var analysis LayerShareAnalysis
+		if analysis, valid = r.Analysis.(LayerShareAnalysis); valid {
+			table := layerBlobTable("Layers", analysis.Layers, "")
+			table.Rows = append(table.Rows, reportRow{Cells: []reportCell{{Text: "Total"}, sizeCell(analysis.Size), sizeCell(analysis.UncompressedSize)}})
+			section = reportSection{Counts: []reportCount{{"layers", len(analysis.Layers)}}, Tables: []reportTable{table}}
+		}
+		section.Title = r.AnalyzeType
+	case VulnAnalyzeResult:
+		var vulns []Vuln
+		if vulns, valid = r.Analysis.([]Vuln); valid {
+			table := reportTable{Columns: []string{"ID", "Aliases", "Severity", "Package", "Version", "Fixed version"}}
+			for i, vuln := range stringifyVulns(vulns) {
+				table.Rows = append(table.Rows, reportRow{Cells: []reportCell{
+					{Text: vuln.ID}, {Text: vuln.Aliases}, severityCell(vuln.Severity, vulns[i].Score), {Text: vuln.Package}, {Text: vuln.Version}, {Text: vuln.FixedVersion},
+				}})
+			}
+			section = reportSection{Counts: []reportCount{{"vulnerabilities", len(vulns)}}, Tables: []reportTable{table}}
+		}
+		section.Title = r.AnalyzeType
+	}
+	if !valid {
+		return rawReportSection(result)
+	}
+	return section
+}
+
+// rawReportSection shows the JSON output of a result.
+func rawReportSection(result Result) reportSection {
+	section := reportSection{Title: fmt.Sprintf("%T", result)}
+	output := result.OutputStruct()
+	value := reflect.Indirect(reflect.ValueOf(output))
+	if value.IsValid() && value.Kind() == reflect.Struct {
+		for _, field := range []string{"DiffType", "AnalyzeType"} {
+			if f := value.FieldByName(field); f.IsValid() && f.Kind() == reflect.String && f.String() != "" {
+				section.Title = f.String()
+			}
+		}
+	}
+	raw, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		section.Raw = err.Error()
+	} else {
+		section.Raw = string(raw)
+	}
+	return section
+}
+
+// changeCounts counts the added, deleted and modified entries of a diff
+func changeCounts(added, deleted, modified int) []reportCount {
+	return []reportCount{{ChangeAdded, added}, {ChangeDeleted, deleted}, {ChangeModified, modified}}
+}
+
+// sumReportCounts adds up the counts of layers, by label
+func sumReportCounts(layers []reportSection) []reportCount {
+	var counts []reportCount
+	index := map[string]int{}
+	for _, layer := range layers {
+		for _, count := range layer.Counts {
+			i, ok := index[count.Label]
+			if !ok {
+				i = len(counts)
+				index[count.Label] = i
+				counts = append(counts, reportCount{Label: count.Label})
+			}
+			counts[i].Count += count.Count
+		}
+	}
+	return counts
+}
+
+func textCells(texts ...string) []reportCell {
+	cells := make([]reportCell, len(texts))
+	for i, text := range texts {
+		cells[i] = reportCell{Text: text}
+	}
+	return cells
+}
+
+func sizeCell(size int64) reportCell {
+	return reportCell{Text: stringifySize(size), Sort: strconv.FormatInt(size, 10)}
+}
+
+// deltaCell shows how much a size grew or shrank, unless either is unknown
+func deltaCell(size1, size2 int64) reportCell {
+	if size1 == -1 || size2 == -1 {
+		return reportCell{Text: "-", Sort: "0"}
+	}
+	delta := size2 - size1
+	cell := reportCell{Text: stringifyDelta(delta), Sort: strconv.FormatInt(delta, 10)}
+	switch {
+	case delta > 0:
+		cell.Class = "grew"
+	case delta < 0:
+		cell.Class = "shrank"
+	}
+	return cell
+}
+
+func stringifyDelta(delta int64) string {
+	switch {
+	case delta > 0:
+		return "+" + bytefmt.ByteSize(uint64(delta))
+	case delta < 0:
+		return "-" + bytefmt.ByteSize(uint64(-delta))
+	}
+	return "0"
+}
+
+// sizeDelta is the Delta of a row whose size went from size1 to size2
+func sizeDelta(size1, size2 int64) int64 {
+	if size1 == -1 || size2 == -1 {
+		return 0
+	}
+	return size2 - size1
+}
+
+// changeDelta is the Delta of an added or deleted row of the given size
+func changeDelta(change string, size int64) int64 {
+	switch {
+	case size <= 0:
+		return 0
+	case change == ChangeAdded:
+		return size
+	case change == ChangeDeleted:
+		return -size
+	}
+	return 0
+}
+
+func severityCell(severity string, score float64) reportCell {
+	return reportCell{Text: severity, Sort: strconv.FormatFloat(score, 'f', 1, 64)}
+}
+
+func packageTable(title string, packages []PackageOutput, change string, paths bool) reportTable {
+	table := reportTable{Title: title, Columns: []string{"Name", "Version", "Size"}}
+	if paths {
+		table.Columns = []string{"Name", "Path", "Version", "Size"}
+	}
+	for _, p := range packages {
+		cells := textCells(p.Name, p.Version)
+		if paths {
+			cells = textCells(p.Name, p.Path, p.Version)
+		}
+		table.Rows = append(table.Rows, reportRow{Change: change, Cells: append(cells, sizeCell(p.Size)), Delta: changeDelta(change, p.Size)})
+	}
+	return table
+}
+
+// packageDiffSection lists the packages found only on either side of a
+// package diff, and the packages whose version or size changed.
+func packageDiffSection(diff PackageDiff, diffType, title1, title2, infoTitle string, sortSize bool) reportSection {
+	packages1 := getSingleVersionPackageOutput(diff.Packages1, diffType, sortSize)
+	packages2 := getSingleVersionPackageOutput(diff.Packages2, diffType, sortSize)
+	infos := reportTable{Title: infoTitle, Columns: []string{"Name", "Version 1", "Version 2", "Change", "Size 1", "Size 2", "Size delta"}}
+	for _, info := range getSingleVersionInfoDiffOutput(diff.InfoDiff, sortSize) {
+		infos.Rows = append(infos.Rows, reportRow{Change: ChangeModified, Cells: []reportCell{
+			{Text: info.Package}, {Text: info.Info1.Version}, {Text: info.Info2.Version}, {Text: stringifyChange(info.Change)},
+			sizeCell(info.Info1.Size), sizeCell(info.Info2.Size), deltaCell(info.Info1.Size, info.Info2.Size),
+		}, Delta: sizeDelta(info.Info1.Size, info.Info2.Size)})
+	}
+	return reportSection{
+		Counts: changeCounts(len(packages2), len(packages1), len(diff.InfoDiff)),
+		Tables: []reportTable{
+			packageTable(title1, packages1, ChangeDeleted, false),
+			packageTable(title2, packages2, ChangeAdded, false),
+			infos,
+		},
+	}
+}
+
+func multiVersionPackageDiffSection(diff MultiVersionPackageDiff, diffType, image1, image2 string, sortSize bool) reportSection {
+	packages1 := getMultiVersionPackageOutput(diff.Packages1, diffType, sortSize)
+	packages2 := getMultiVersionPackageOutput(diff.Packages2, diffType, sortSize)
+	infos := reportTable{Title: "Version differences", Columns: []string{"Name", "Versions 1", "Versions 2", "Change", "Size 1", "Size 2", "Size delta"}}
+	for _, info := range getMultiVersionInfoDiffOutput(diff.InfoDiff, diffType, sortSize) {
+		versions1, size1 := joinPackageInfos(info.Info1)
+		versions2, size2 := joinPackageInfos(info.Info2)
+		infos.Rows = append(infos.Rows, reportRow{Change: ChangeModified, Cells: []reportCell{
+			{Text: info.Package}, {Text: versions1}, {Text: versions2}, {Text: stringifyChange(info.Change)},
+			sizeCell(size1), sizeCell(size2), deltaCell(size1, size2),
+		}, Delta: sizeDelta(size1, size2)})
+	}
+	return reportSection{
+		Counts: changeCounts(len(packages2), len(packages1), len(diff.InfoDiff)),
+		Tables: []reportTable{
+			packageTable("Packages found only in "+image1, packages1, ChangeDeleted, true),
+			packageTable("Packages found only in "+image2, packages2, ChangeAdded, true),
+			infos,
+		},
+	}
+}
+
+// joinPackageInfos lists the versions of a package, and adds up their
+// sizes. The total is -1 if the size of any version is unknown.
+func joinPackageInfos(infos []PackageInfo) (string, int64) {
+	var versions []string
+	var size int64
+	for _, info := range infos {
+		versions = append(versions, info.Version)
+		if info.Size == -1 || size == -1 {
+			size = -1
+		} else {
+			size += info.Size
+		}
+	}
+	return strings.Join(versions, ", "), size
+}
+
+func packageLayerSection(diff PackageLayerDiff, diffType, name1, name2 string, sortSize bool) reportSection {
+	var section reportSection
+	for i, d := range diff.PackageDiffs {
+		layer := packageDiffSection(d, diffType, "Packages found only in "+name1, "Packages found only in "+name2, "Version differences", sortSize)
+		layer.Title = fmt.Sprintf("Layer %d", i)
+		section.Layers = append(section.Layers, layer)
+	}
+	section.Counts = sumReportCounts(section.Layers)
+	return section
+}
+
+func histDiffSection(diff HistDiff) reportSection {
+	strDiff := stringifyHistDiff(diff)
+	table := reportTable{Columns: []string{"Change", "Layer 1", "Size 1", "Layer 2", "Size 2", "Created by"}}
+	counts := map[string]int{}
+	for i, entry := range strDiff.Entries {
+		d := diff.Entries[diff.SharedBase+i]
+		row := reportRow{Change: entry.Kind, Cells: textCells(entry.Kind, entry.Layer1, entry.Size1, entry.Layer2, entry.Size2, entry.CreatedBy)}
+		if d.Entry1 != nil && d.Entry1.Digest != "" {
+			row.Cells[2].Sort = strconv.FormatInt(d.Entry1.Size, 10)
+		}
+		if d.Entry2 != nil && d.Entry2.Digest != "" {
+			row.Cells[4].Sort = strconv.FormatInt(d.Entry2.Size, 10)
+		}
+		switch entry.Kind {
+		case HistoryReused:
+			row.Change = ""
+		case HistoryRebuilt:
+			row.Change = ChangeModified
+		}
+		counts[row.Change]++
+		table.Rows = append(table.Rows, row)
+	}
+	section := reportSection{
+		Counts: append([]reportCount{{"shared", diff.SharedBase}}, changeCounts(counts[ChangeAdded], counts[ChangeDeleted], counts[ChangeModified])...),
+		Tables: []reportTable{table},
+	}
+	return section
+}
+
+func metadataDiffSection(diffs []MetadataDiff) reportSection {
+	table := reportTable{Columns: []string{"Field", "Change", "Value 1", "Value 2"}}
+	counts := map[string]int{}
+	for _, diff := range stringifyMetadataDiffs(diffs) {
+		counts[diff.Kind]++
+		table.Rows = append(table.Rows, reportRow{Change: diff.Kind, Cells: textCells(diff.Name, diff.Kind, diff.Value1, diff.Value2)})
+	}
+	return reportSection{
+		Counts: changeCounts(counts[ChangeAdded], counts[ChangeDeleted], counts[ChangeModified]),
+		Tables: []reportTable{table},
+	}
+}
+
+// directoryTable lists entries, with their digests if digests is set.
+func directoryTable(title string, entries []pkgutil.DirectoryEntry, change string, sortSize, digests bool) reportTable {
+	if sortSize {
+		directoryBy(directorySizeSort).Sort(entries)
+	} else {
+		directoryBy(directoryNameSort).Sort(entries)
+	}
+	table := reportTable{Title: title, Columns: []string{"Path", "Size"}}
+	if digests {
+		table.Columns = append(table.Columns, "Digest")
+	}
+	for _, entry := range entries {
+		cells := []reportCell{{Text: entry.Name}, sizeCell(entry.Size)}
+		if digests {
+			cells = append(cells, reportCell{Text: stringifyChange(entry.Digest)})
+		}
+		table.Rows = append(table.Rows, reportRow{Change: change, Cells: cells, Delta: changeDelta(change, entry.Size)})
+	}
+	return table
+}
+
+// dirDiffSection lists the added, deleted and modified files of a diff, and
+// the content diffs of the modified files.
+func dirDiffSection(diff DirDiff, image1, image2 string, sortSize bool) reportSection {
+	diff = sortDirDiff(diff, sortSize)
+	digests := diff.hasDigests()
+	mods := reportTable{Title: "Files changed between " + image1 + " and " + image2, Columns: []string{"Path", "Size 1", "Size 2", "Size delta"}}
+	if digests {
+		mods.Columns = append(mods.Columns, "Digest 1", "Digest 2")
+	}
+	var diffs []reportDiff
+	for _, mod := range diff.Mods {
+		cells := []reportCell{{Text: mod.Name}, sizeCell(mod.Size1), sizeCell(mod.Size2), deltaCell(mod.Size1, mod.Size2)}
+		if digests {
+			cells = append(cells, reportCell{Text: stringifyChange(mod.Digest1)}, reportCell{Text: stringifyChange(mod.Digest2)})
+		}
+		mods.Rows = append(mods.Rows, reportRow{Change: ChangeModified, Cells: cells, Delta: sizeDelta(mod.Size1, mod.Size2)})
+		if mod.Content != nil {
+			diffs = append(diffs, reportDiff{Title: mod.Name, Text: mod.Content.Summary()})
+		}
+	}
+	return reportSection{
+		Counts: changeCounts(len(diff.Adds), len(diff.Dels), len(diff.Mods)),
+		Tables: []reportTable{
+			directoryTable("Files added to "+image2, diff.Adds, ChangeAdded, sortSize, digests),
+			directoryTable("Files deleted from "+image1, diff.Dels, ChangeDeleted, sortSize, digests),
+			mods,
+		},
+		Diffs: diffs,
+	}
+}
+
+func directoryMetaTable(title string, entries []pkgutil.DirectoryMetaEntry, change string) reportTable {
+	MetaDirectoryBy(MetaDirectoryNameSort).Sort(entries)
+	table := reportTable{Title: title, Columns: []string{"Path", "Metadata"}}
+	for _, entry := range entries {
+		table.Rows = append(table.Rows, reportRow{Change: change, Cells: textCells(entry.Name, stringifyMeta(entry.Mode, entry.UID, entry.GID))})
+	}
+	return table
+}
+
+func metaDirDiffSection(diff MetaDirDiff, image1, image2 string) reportSection {
+	diff = sortMetaDirDiff(diff)
+	mods := reportTable{Title: "Files changed between " + image1 + " and " + image2, Columns: []string{"Path", "Metadata 1", "Metadata 2"}}
+	for _, mod := range diff.Mods {
+		mods.Rows = append(mods.Rows, reportRow{Change: ChangeModified, Cells: textCells(mod.Name, stringifyMeta(mod.Mode1, mod.UID1, mod.GID1), stringifyMeta(mod.Mode2, mod.UID2, mod.GID2))})
+	}
+	return reportSection{
+		Counts: changeCounts(len(diff.Adds), len(diff.Dels), len(diff.Mods)),
+		Tables: []reportTable{
+			directoryMetaTable("Files added to "+image2, diff.Adds, ChangeAdded),
+			directoryMetaTable("Files deleted from "+image1, diff.Dels, ChangeDeleted),
+			mods,
+		},
+	}
+}
+
+// sizeDiffSection shows the sizes of images or layers on both sides. Layers
+// missing on one side have a size of -1 there.
+func sizeDiffSection(diffs []SizeDiff, name string) reportSection {
+	table := reportTable{Columns: []string{name, "Size 1", "Size 2", "Size delta"}}
+	counts := map[string]int{}
+	for _, diff := range diffs {
+		change, delta := ChangeModified, sizeDelta(diff.Size1, diff.Size2)
+		switch {
+		case diff.Size1 == -1:
+			change, delta = ChangeAdded, changeDelta(ChangeAdded, diff.Size2)
+		case diff.Size2 == -1:
+			change, delta = ChangeDeleted, changeDelta(ChangeDeleted, diff.Size1)
+		}
+		counts[change]++
+		table.Rows = append(table.Rows, reportRow{Change: change, Cells: []reportCell{{Text: diff.Name}, sizeCell(diff.Size1), sizeCell(diff.Size2), deltaCell(diff.Size1, diff.Size2)}, Delta: delta})
+	}
+	return reportSection{
+		Counts: changeCounts(counts[ChangeAdded], counts[ChangeDeleted], counts[ChangeModified]),
+		Tables: []reportTable{table},
+	}
+}
+
+func sizeSection(entries []SizeEntry, name string) reportSection {
+	table := reportTable{Columns: []string{name, "Digest", "Size"}}
+	for _, entry := range entries {
+		table.Rows = append(table.Rows, reportRow{Cells: []reportCell{{Text: entry.Name}, {Text: entry.Digest.String()}, sizeCell(entry.Size)}})
+	}
+	return reportSection{Counts: []reportCount{{strings.ToLower(name) + "s", len(entries)}}, Tables: []reportTable{table}}
+}
+
+func layerBlobTable(title string, blobs []LayerBlob, change string) reportTable {
+	table := reportTable{Title: title, Columns: []string{"Digest", "Size", "Uncompressed size"}}
+	for _, blob := range blobs {
+		table.Rows = append(table.Rows, reportRow{Change: change, Cells: []reportCell{{Text: blob.Digest}, sizeCell(blob.Size), sizeCell(blob.UncompressedSize)}, Delta: changeDelta(change, blob.Size)})
+	}
+	return table
+}
+
+func layerShareDiffSection(diff LayerShareDiff, image1, image2 string) reportSection {
+	totals := reportTable{Title: "Totals", Columns: []string{"", "Size", "Uncompressed size"}}
+	totals.Rows = []reportRow{
+		{Cells: []reportCell{{Text: image1}, sizeCell(diff.Size1), sizeCell(diff.UncompressedSize1)}},
+		{Cells: []reportCell{{Text: image2}, sizeCell(diff.Size2), sizeCell(diff.UncompressedSize2)}},
+		{Cells: []reportCell{{Text: "Shared"}, sizeCell(diff.SharedSize), {Text: "-"}}},
+		{Cells: []reportCell{{Text: "Pulled for " + image2}, sizeCell(diff.PullSize), {Text: "-"}}},
+	}
+	return reportSection{
+		Counts: []reportCount{{"shared", len(diff.Shared)}, {"only in " + image1, len(diff.Unique1)}, {"only in " + image2, len(diff.Unique2)}},
+		Tables: []reportTable{
+			totals,
+			layerBlobTable("Shared layers", diff.Shared, ""),
+			layerBlobTable("Layers found only in "+image1, diff.Unique1, ChangeDeleted),
+			layerBlobTable("Layers found only in "+image2, diff.Unique2, ChangeAdded),
+		},
+	}
+}
+
+func vulnDiffSection(diff VulnDiff) reportSection {
+	columns := []string{"ID", "Aliases", "Severity", "Package", "Version 1", "Version 2", "Fixed version"}
+	table := func(title string, entries []VulnDiffEntry, change string) reportTable {
+		t := reportTable{Title: title, Columns: columns}
+		for i, entry := range stringifyVulnDiffEntries(entries) {
+			t.Rows = append(t.Rows, reportRow{Change: change, Cells: []reportCell{
+				{Text: entry.ID}, {Text: entry.Aliases}, severityCell(entry.Severity, entries[i].Score), {Text: entry.Package},
+				{Text: entry.Version1}, {Text: entry.Version2}, {Text: entry.FixedVersion},
+			}})
+		}
+		return t
+	}
+	return reportSection{
+		Counts: []reportCount{{"introduced", len(diff.Introduced)}, {"fixed", len(diff.Fixed)}, {"unchanged", len(diff.Unchanged)}},
+		Tables: []reportTable{
+			table("Introduced vulnerabilities", diff.Introduced, ChangeAdded),
+			table("Fixed vulnerabilities", diff.Fixed, ChangeDeleted),
+			table("Unchanged vulnerabilities", diff.Unchanged, ""),
+		},
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+)
+
+type unknownResult struct {
+	Count int
+}
+
+func (r unknownResult) OutputStruct() interface{} {
+	return r
+}
+
+func (r unknownResult) OutputText(writer io.Writer, resultType string, format string) error {
+	return nil
+}
+
+func TestWriteHTMLReport(t *testing.T) {
+	report := Report{
+		Title: "image1 vs image2",
+		Groups: []ReportGroup{{Results: map[string]Result{
+			"file": &DirDiffResult{Image1: "image1", Image2: "image2", DiffType: "File", Diff: DirDiff{
+				Adds: []pkgutil.DirectoryEntry{{Name: "/<script>", Size: 10}},
+				Mods: []EntryDiff{{Name: "/etc/motd", Size1: 12, Size2: 2048, Content: &ContentDiff{Diff: "--- a/etc/motd\n+++ b/etc/motd\n@@ -1 +1 @@\n-hello\n+world\n"}}},
+			}},
+			"apt": &SingleVersionPackageDiffResult{Image1: "image1", Image2: "image2", DiffType: "Apt", Diff: PackageDiff{
+				Packages1: map[string]PackageInfo{"curl": {Version: "7.0", Size: 1024}},
+				Packages2: map[string]PackageInfo{},
+				InfoDiff:  []Info{{Package: "bash", Info1: PackageInfo{Version: "5.0", Size: 4096}, Info2: PackageInfo{Version: "5.1", Size: 1024}, Change: PackageUpgraded}},
+			}},
+			"layer": &MultipleDirDiffResult{Image1: "image1", Image2: "image2", DiffType: "FileLayer", Diff: MultipleDirDiff{DirDiffs: []DirDiff{
+				{Adds: []pkgutil.DirectoryEntry{{Name: "/bin/sh", Size: 1}}},
+				{Dels: []pkgutil.DirectoryEntry{{Name: "/tmp/a", Size: 1}, {Name: "/tmp/b", Size: 1}}},
+			}}},
+			"custom": unknownResult{Count: 42},
+		}}},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteHTMLReport(&buf, report); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	html := buf.String()
+	for _, expected := range []string{
+		"<h1>image1 vs image2</h1>",
+		`<a href="#g0-apt">Apt</a>`,
+		// file names are escaped
+		"<td>/&lt;script&gt;</td>",
+		`<tr class="added">`,
+		// size deltas
+		`<td data-sort="2036" class="grew">&#43;2K</td>`,
+		`<td data-sort="-3072" class="shrank">-3K</td>`,
+		// content diffs are highlighted
+		`<span class="hunk">@@ -1 &#43;1 @@</span><span class="del">-hello</span><span class="add">&#43;world</span>`,
+		// layers start collapsed, and are counted together
+		`<details id="g0-layer-layer1">`,
+		`<span class="count deleted">2 deleted</span>`,
+		// unknown results fall back to their JSON output
+		`&#34;Count&#34;: 42`,
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("Expected report to contain %s, got:\n%s", expected, html)
+		}
+	}
+	if strings.Contains(html, "<td>/<script>") {
+		t.Errorf("Expected file names to be escaped")
+	}
+}
+
+func TestReportCounts(t *testing.T) {
+	section := newReportSection(&SizeLayerDiffResult{DiffType: "SizeLayer", Diff: []SizeDiff{
+		{Name: "0", Size1: 10, Size2: 20},
+		{Name: "1", Size1: -1, Size2: 20},
+	}})
+	expected := []reportCount{{ChangeAdded, 1}, {ChangeDeleted, 0}, {ChangeModified, 1}}
+	if len(section.Counts) != len(expected) {
+		t.Fatalf("Expected counts %v, got %v", expected, section.Counts)
+	}
+	for i, count := range expected {
+		if section.Counts[i] != count {
+			t.Errorf("Expected counts %v, got %v", expected, section.Counts)
+		}
+	}
+	if cell := section.Tables[0].Rows[1].Cells[3]; cell.Text != "-" {
+		t.Errorf("Expected no delta for a missing layer, got %s", cell.Text)
+	}
+}
+
+func TestSizeLayerDeletions(t *testing.T) {
+	result := &SizeLayerAnalyzeResult{AnalyzeType: "SizeLayer", Analysis: []SizeEntry{
+		{Name: "0", Size: 10},
+		{Name: "1", Size: 20, Deletions: []pkgutil.Whiteout{{Path: "/etc/motd"}, {Path: "/var/cache", Opaque: true}}},
+	}}
+	var buf bytes.Buffer
+	if err := result.OutputText(&buf, "SizeLayer", ""); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	expected := "Removed in Layer 1:\n/etc/motd\n/var/cache/* (opaque directory)\n"
+	if text := buf.String(); !strings.Contains(text, expected) || strings.Contains(text, "Removed in Layer 0") {
+		t.Errorf("Expected the deletions of layer 1 only, got:\n%s", text)
+	}
+
+	section := newReportSection(result)
+	if len(section.Tables) != 2 || section.Tables[1].Title != "Removed in Layer 1" || len(section.Tables[1].Rows) != 2 {
+		t.Errorf("Expected a table of the deletions of layer 1, got %+v", section.Tables)
+	}
+}
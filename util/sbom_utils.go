@@ -0,0 +1,243 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+)
+
+// purlTypes maps the analyze type of the package analyzers to the package
+// URL type of their packages. Gentoo has no registered purl type.
+var purlTypes = map[string]string{
+	"Apt":    "deb",
+	"Apk":    "apk",
+	"RPM":    "rpm",
+	"Pip":    "pypi",
+	"Node":   "npm",
+	"Emerge": "generic",
+	"GoMod":  "golang",
+}
+
+// GetSBOM gathers the packages found by the package analyzers into an SBOM.
+// The ID and VERSION_ID of the image's os-release qualify the package URLs
+// of distribution packages. Results of other analyzers are left out.
+func GetSBOM(subject pkgutil.SBOMSubject, osID, osVersion string, results map[string]Result) pkgutil.SBOM {
+	sbom := pkgutil.SBOM{Subject: subject, Packages: []pkgutil.SBOMPackage{}}
+	for _, result := range results {
+		switch r := result.(type) {
+		case *SingleVersionPackageAnalyzeResult:
+			packages, ok := r.Analysis.(map[string]PackageInfo)
+			if !ok {
+				continue
+			}
+			for name, info := range packages {
+				sbom.Packages = append(sbom.Packages, pkgutil.SBOMPackage{
+					Name:    name,
+					Version: info.Version,
+					PURL:    PackageURL(r.AnalyzeType, name, info.Version, osID, osVersion),
+				})
+			}
+		case *MultiVersionPackageAnalyzeResult:
+			packages, ok := r.Analysis.(map[string]map[string]PackageInfo)
+			if !ok {
+				continue
+			}
+			for name, installs := range packages {
+				sbom.Packages = append(sbom.Packages, multiVersionSBOMPackages(r.AnalyzeType, name, installs)...)
+			}
+		}
+	}
+	sort.Slice(sbom.Packages, func(i, j int) bool {
+		if sbom.Packages[i].PURL != sbom.Packages[j].PURL {
+			return sbom.Packages[i].PURL < sbom.Packages[j].PURL
+		}
+		return sbom.Packages[i].Name < sbom.Packages[j].Name
+	})
+	return sbom
+}
+
+// multiVersionSBOMPackages lists each version of a package once, with all
+// the paths it is installed at
+func multiVersionSBOMPackages(analyzeType, name string, installs map[string]PackageInfo) []pkgutil.SBOMPackage {
+	locations := map[string][]string{}
+	for path, info := range installs {
+		locations[info.Version] = append(locations[info.Version], path)
+	}
+	var packages []pkgutil.SBOMPackage
+	for version, paths := range locations {
+		sort.Strings(paths)
+		packages = append(packages, pkgutil.SBOMPackage{
+			Name:      name,
+			Version:   version,
+			PURL:      PackageURL(analyzeType, name, version, "", ""),
+			Locations: paths,
+		})
+	}
+	return packages
+}
+
+// PackageURL returns the package URL (purl) of a package found by the
+// analyzer of analyzeType, or "" for analyzers without packages.
+func PackageURL(analyzeType, name, version, osID, osVersion string) string {
+	purlType, ok := purlTypes[analyzeType]
+	if !ok {
+		return ""
+	}
+	var namespace string
+	var qualifiers []string
+	switch purlType {
+	case "deb", "rpm", "apk":
+		namespace = osID
+		if namespace == "" && purlType == "deb" {
+			namespace = "debian"
+		}
+		if namespace == "" && purlType == "apk" {
+			namespace = "alpine"
+		}
+		if osID != "" && osVersion != "" {
+			qualifiers = append(qualifiers, "distro="+escapePURL(osID+"-"+osVersion))
+		}
+	case "pypi":
+		name = strings.ReplaceAll(strings.ToLower(name), "_", "-")
+	case "npm", "golang", "generic":
+		// scoped npm packages, Go module paths and Gentoo categories
+		if i := strings.LastIndex(name, "/"); i > 0 {
+			namespace, name = name[:i], name[i+1:]
+		}
+	}
+
+	purl := "pkg:" + purlType + "/"
+	if namespace != "" {
+		var segments []string
+		for _, segment := range strings.Split(namespace, "/") {
+			segments = append(segments, escapePURL(segment))
+		}
+		purl += strings.Join(segments, "/") + "/"
+	}
+	purl += escapePURL(name)
+	if version != "" {
+		purl += "@" + escapePURL(version)
+	}
+	if len(qualifiers) > 0 {
+		purl += "?" + strings.Join(qualifiers, "&")
+	}
+	return purl
+}
+
+// GetSBOMPackages lists the packages of an SBOM that the single version
+// analyzer of analyzeType would report, matched by package URL type. SBOMs
+// don't record package sizes, so those are unknown.
+func GetSBOMPackages(sbom pkgutil.SBOM, analyzeType string) map[string]PackageInfo {
+	packages := map[string]PackageInfo{}
+	for _, pkg := range sbom.Packages {
+		if name, ok := sbomPackageName(pkg, analyzeType); ok {
+			packages[name] = PackageInfo{Version: pkg.Version, Size: -1}
+		}
+	}
+	return packages
+}
+
+// GetSBOMMultiVersionPackages lists the packages of an SBOM that the multi
+// version analyzer of analyzeType would report, keyed by their install
+// locations. Packages without locations are keyed by an empty path.
+func GetSBOMMultiVersionPackages(sbom pkgutil.SBOM, analyzeType string) map[string]map[string]PackageInfo {
+	packages := map[string]map[string]PackageInfo{}
+	for _, pkg := range sbom.Packages {
+		name, ok := sbomPackageName(pkg, analyzeType)
+		if !ok {
+			continue
+		}
+		if _, ok := packages[name]; !ok {
+			packages[name] = map[string]PackageInfo{}
+		}
+		locations := pkg.Locations
+		if len(locations) == 0 {
+			locations = []string{""}
+		}
+		for _, location := range locations {
+			packages[name][location] = PackageInfo{Version: pkg.Version, Size: -1}
+		}
+	}
+	return packages
+}
+
+// sbomPackageName returns the name the analyzer of analyzeType knows an SBOM
+// package by, and whether the package belongs to that analyzer at all.
+func sbomPackageName(pkg pkgutil.SBOMPackage, analyzeType string) (string, bool) {
+	purlType, namespace, name, err := parsePURL(pkg.PURL)
+	if err != nil || purlType != purlTypes[analyzeType] {
+		return "", false
+	}
+	if pkg.Name != "" {
+		return pkg.Name, true
+	}
+	switch purlType {
+	case "npm", "golang", "generic":
+		if namespace != "" {
+			return namespace + "/" + name, true
+		}
+	}
+	return name, true
+}
+
+// parsePURL splits a package URL into its type, namespace and name.
+func parsePURL(purl string) (purlType, namespace, name string, err error) {
+	rest, ok := strings.CutPrefix(purl, "pkg:")
+	if !ok {
+		return "", "", "", fmt.Errorf("invalid package URL %s", purl)
+	}
+	if i := strings.IndexAny(rest, "?#"); i >= 0 {
+		rest = rest[:i]
+	}
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		rest = rest[:i]
+	}
+	segments := strings.Split(strings.Trim(rest, "/"), "/")
+	if len(segments) < 2 {
+		return "", "", "", fmt.Errorf("invalid package URL %s", purl)
+	}
+	for i, segment := range segments[1:] {
+		if segments[i+1], err = url.PathUnescape(segment); err != nil {
+			return "", "", "", fmt.Errorf("invalid package URL %s: %s", purl, err)
+		}
+	}
+	purlType = strings.ToLower(segments[0])
+	namespace = strings.Join(segments[1:len(segments)-1], "/")
+	name = segments[len(segments)-1]
+	return purlType, namespace, name, nil
+}
+
+// escapePURL percent-encodes all but the unreserved characters of a purl
+// component
+func escapePURL(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '.', c == '-', c == '_', c == '~', c == '+':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
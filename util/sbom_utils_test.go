@@ -0,0 +1,158 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+)
+
+func TestPackageURL(t *testing.T) {
+	testCases := []struct {
+		analyzeType string
+		name        string
+		version     string
+		osID        string
+		osVersion   string
+		expected    string
+	}{
+		{"Apt", "libc6", "2.36-9+deb12u4", "debian", "12", "pkg:deb/debian/libc6@2.36-9+deb12u4?distro=debian-12"},
+		{"Apt", "bash", "5.0", "", "", "pkg:deb/debian/bash@5.0"},
+		{"RPM", "openssl", "1:3.0.7-24.el9", "rhel", "9.3", "pkg:rpm/rhel/openssl@1%3A3.0.7-24.el9?distro=rhel-9.3"},
+		{"Apk", "musl", "1.2.4-r2", "alpine", "3.19.1", "pkg:apk/alpine/musl@1.2.4-r2?distro=alpine-3.19.1"},
+		{"Pip", "Django_Filter", "23.5", "", "", "pkg:pypi/django-filter@23.5"},
+		{"Node", "@babel/core", "7.23.0", "", "", "pkg:npm/%40babel/core@7.23.0"},
+		{"GoMod", "github.com/spf13/cobra", "v1.8.0", "", "", "pkg:golang/github.com/spf13/cobra@v1.8.0"},
+		{"GoMod", "stdlib", "go1.21.5", "", "", "pkg:golang/stdlib@go1.21.5"},
+		{"Emerge", "sys-libs/glibc", "2.38", "gentoo", "", "pkg:generic/sys-libs/glibc@2.38"},
+		{"Size", "image", "", "", "", ""},
+	}
+	for _, test := range testCases {
+		purl := PackageURL(test.analyzeType, test.name, test.version, test.osID, test.osVersion)
+		if purl != test.expected {
+			t.Errorf("%s %s: Expected: %s but got: %s", test.analyzeType, test.name, test.expected, purl)
+		}
+	}
+}
+
+func TestGetSBOM(t *testing.T) {
+	results := map[string]Result{
+		"apk": &SingleVersionPackageAnalyzeResult{
+			AnalyzeType: "Apk",
+			Analysis:    map[string]PackageInfo{"musl": {Version: "1.2.4-r2", Size: 10}},
+		},
+		"node": &MultiVersionPackageAnalyzeResult{
+			AnalyzeType: "Node",
+			Analysis: map[string]map[string]PackageInfo{
+				"lodash": {
+					"/app/node_modules/lodash/":           {Version: "4.17.21"},
+					"/usr/local/lib/node_modules/lodash/": {Version: "4.17.21"},
+					"/legacy/node_modules/lodash/":        {Version: "4.17.20"},
+				},
+			},
+		},
+		"size": &SizeAnalyzeResult{AnalyzeType: "Size", Analysis: []SizeEntry{}},
+	}
+	subject := pkgutil.SBOMSubject{Name: "gcr.io/foo/bar:1.0", Digest: "sha256:abcd"}
+	sbom := GetSBOM(subject, "alpine", "3.19.1", results)
+	expected := []pkgutil.SBOMPackage{
+		{Name: "musl", Version: "1.2.4-r2", PURL: "pkg:apk/alpine/musl@1.2.4-r2?distro=alpine-3.19.1"},
+		{Name: "lodash", Version: "4.17.20", PURL: "pkg:npm/lodash@4.17.20", Locations: []string{"/legacy/node_modules/lodash/"}},
+		{Name: "lodash", Version: "4.17.21", PURL: "pkg:npm/lodash@4.17.21", Locations: []string{"/app/node_modules/lodash/", "/usr/local/lib/node_modules/lodash/"}},
+	}
+	if !reflect.DeepEqual(sbom.Packages, expected) {
+		t.Errorf("Expected: %+v but got: %+v", expected, sbom.Packages)
+	}
+
+	sbom.Created = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, format := range []string{pkgutil.SPDXJSON, pkgutil.CycloneDXJSON} {
+		var buf bytes.Buffer
+		if err := pkgutil.WriteSBOM(&buf, sbom, format); err != nil {
+			t.Fatalf("%s: Got unexpected error: %s", format, err)
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+			t.Fatalf("%s: Got invalid JSON: %s", format, err)
+		}
+		if !bytes.Contains(buf.Bytes(), []byte("abcd")) || !bytes.Contains(buf.Bytes(), []byte("/legacy/node_modules/lodash/")) {
+			t.Errorf("%s: Expected the image digest and package locations in the document, got %s", format, buf.String())
+		}
+	}
+	if err := pkgutil.WriteSBOM(&bytes.Buffer{}, sbom, "spdx-xml"); err == nil {
+		t.Errorf("Expected error for unknown format but got none")
+	}
+}
+
+func TestReadSBOM(t *testing.T) {
+	sbom := pkgutil.SBOM{
+		Subject: pkgutil.SBOMSubject{Name: "gcr.io/foo/bar:1.0", Digest: "sha256:abcd"},
+		Packages: []pkgutil.SBOMPackage{
+			{Name: "musl", Version: "1.2.4-r2", PURL: "pkg:apk/alpine/musl@1.2.4-r2?distro=alpine-3.19.1"},
+			{Name: "@babel/core", Version: "7.23.0", PURL: "pkg:npm/%40babel/core@7.23.0", Locations: []string{"/app/node_modules/@babel/core/", "/usr/lib/node_modules/@babel/core/"}},
+		},
+		Created: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for _, format := range []string{pkgutil.SPDXJSON, pkgutil.CycloneDXJSON} {
+		var buf bytes.Buffer
+		if err := pkgutil.WriteSBOM(&buf, sbom, format); err != nil {
+			t.Fatalf("%s: Got unexpected error: %s", format, err)
+		}
+		read, err := pkgutil.ReadSBOM(&buf)
+		if err != nil {
+			t.Fatalf("%s: Got unexpected error: %s", format, err)
+		}
+		if !reflect.DeepEqual(read, sbom) {
+			t.Errorf("%s: Expected: %+v but got: %+v", format, sbom, read)
+		}
+	}
+	if _, err := pkgutil.ReadSBOM(bytes.NewBufferString(`{"foo": "bar"}`)); err == nil {
+		t.Errorf("Expected error for a document in no SBOM format but got none")
+	}
+}
+
+func TestGetSBOMPackages(t *testing.T) {
+	sbom := pkgutil.SBOM{
+		Packages: []pkgutil.SBOMPackage{
+			{Name: "libc6", Version: "2.36-9", PURL: "pkg:deb/debian/libc6@2.36-9?distro=debian-12"},
+			{Name: "musl", Version: "1.2.4-r2", PURL: "pkg:apk/alpine/musl@1.2.4-r2"},
+			{Version: "7.23.0", PURL: "pkg:npm/%40babel/core@7.23.0", Locations: []string{"/app/node_modules/@babel/core/"}},
+			{Name: "lodash", Version: "4.17.21", PURL: "pkg:npm/lodash@4.17.21"},
+			{Name: "no-purl", Version: "1.0"},
+		},
+	}
+	apt := GetSBOMPackages(sbom, "Apt")
+	expectedApt := map[string]PackageInfo{"libc6": {Version: "2.36-9", Size: -1}}
+	if !reflect.DeepEqual(apt, expectedApt) {
+		t.Errorf("Expected: %+v but got: %+v", expectedApt, apt)
+	}
+	node := GetSBOMMultiVersionPackages(sbom, "Node")
+	expectedNode := map[string]map[string]PackageInfo{
+		"@babel/core": {"/app/node_modules/@babel/core/": {Version: "7.23.0", Size: -1}},
+		"lodash":      {"": {Version: "4.17.21", Size: -1}},
+	}
+	if !reflect.DeepEqual(node, expectedNode) {
+		t.Errorf("Expected: %+v but got: %+v", expectedNode, node)
+	}
+	if rpm := GetSBOMPackages(sbom, "RPM"); len(rpm) != 0 {
+		t.Errorf("Expected no rpm packages but got: %+v", rpm)
+	}
+}
@@ -16,12 +16,18 @@ limitations under the License.
 
 package util
 
-import "github.com/google/go-containerregistry/pkg/v1"
+import (
+	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+	"github.com/google/go-containerregistry/pkg/v1"
+)
 
+// SizeEntry is the size of an image or of a layer. Deletions are the paths
+// a layer removed from the layers below it.
 type SizeEntry struct {
-	Name   string
-	Digest v1.Hash
-	Size   int64
+	Name      string
+	Digest    v1.Hash
+	Size      int64
+	Deletions []pkgutil.Whiteout `json:",omitempty"`
 }
 
 type SizeDiff struct {
@@ -17,9 +17,20 @@ limitations under the License.
 package util
 
 import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 
 	pkgutil "github.com/GoogleContainerTools/container-diff/pkg/util"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
 )
 
 func TestIsTar(t *testing.T) {
@@ -37,3 +48,252 @@ func TestIsTar(t *testing.T) {
 		}
 	}
 }
+
+func TestGetFileSystemForLayerWhiteouts(t *testing.T) {
+	layer, err := tarball.LayerFromFile("testTars/la-croix-wh.tar")
+	if err != nil {
+		t.Fatalf("Error loading layer: %s", err)
+	}
+	root := filepath.Join(t.TempDir(), "layer")
+	if err := os.Mkdir(root, 0700); err != nil {
+		t.Fatal(err)
+	}
+	whiteouts, err := pkgutil.GetFileSystemForLayer(layer, root, nil)
+	if err != nil {
+		t.Fatalf("Error extracting layer: %s", err)
+	}
+	expected := []pkgutil.Whiteout{{Path: "/lime.txt"}, {Path: "/nest"}}
+	if !reflect.DeepEqual(whiteouts, expected) {
+		t.Errorf("Expected: %v but got: %v", expected, whiteouts)
+	}
+
+	// the markers must not be extracted as regular files
+	dir, err := pkgutil.GetDirectory(root, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedContent := []string{"/nest2", "/nest2/hello"}
+	if !reflect.DeepEqual(dir.Content, expectedContent) {
+		t.Errorf("Expected: %v but got: %v", expectedContent, dir.Content)
+	}
+
+	// whiteouts are kept when the extracted layer is reused
+	whiteouts, err = pkgutil.GetFileSystemForLayer(layer, root, nil)
+	if err != nil {
+		t.Fatalf("Error reading cached layer: %s", err)
+	}
+	if !reflect.DeepEqual(whiteouts, expected) {
+		t.Errorf("Expected: %v but got: %v", expected, whiteouts)
+	}
+}
+
+// testLayer returns a layer holding headers, with "foo" as the content of
+// regular files.
+func testLayer(t *testing.T, headers []*tar.Header) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, header := range headers {
+		if header.Typeflag == tar.TypeReg {
+			header.Size = 3
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte("foo")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testImage writes an image tarball of a single layer holding headers, as
+// for testLayer, and returns its path.
+func testImage(t *testing.T, headers []*tar.Header) string {
+	contents := testLayer(t, headers)
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(contents)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "image.tar")
+	tag, err := name.NewTag("container-diff.test/image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tarball.WriteToFile(path, tag, img); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGetImageFilteredHardlinks(t *testing.T) {
+	path := testImage(t, []*tar.Header{
+		{Name: "usr/lib/libfoo.so", Mode: 0644, Typeflag: tar.TypeReg},
+		{Name: "opt/app/libfoo.so", Linkname: "usr/lib/libfoo.so", Typeflag: tar.TypeLink},
+	})
+
+	// the link is kept while its target is filtered out
+	filter, err := pkgutil.NewPathFilter([]string{"/opt"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := pkgutil.GetImageWithOptions(path, pkgutil.ImageOptions{IncludeLayers: true, Extract: true, Filter: filter})
+	if err != nil {
+		t.Fatalf("Error retrieving image: %s", err)
+	}
+	defer pkgutil.CleanupImage(image)
+	for _, root := range []string{image.FSPath, image.Layers[0].FSPath} {
+		contents, err := ioutil.ReadFile(filepath.Join(root, "opt/app/libfoo.so"))
+		if err != nil || string(contents) != "foo" {
+			t.Errorf("Expected hard link in %s to be created, got %q (error: %v)", root, contents, err)
+		}
+	}
+}
+
+func TestGetImageIndexFiltered(t *testing.T) {
+	path := testImage(t, []*tar.Header{
+		{Name: "etc/cache", Mode: 0644, Typeflag: tar.TypeReg},
+		{Name: "opt/cache/", Mode: 0755, Typeflag: tar.TypeDir},
+		{Name: "opt/cache/a", Mode: 0644, Typeflag: tar.TypeReg},
+	})
+	// a trailing slash only matches directories
+	filter, err := pkgutil.NewPathFilter(nil, []string{"cache/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := pkgutil.GetImageWithOptions(path, pkgutil.ImageOptions{
+		IncludeLayers: true,
+		Extract:       true,
+		ExtractAll:    true,
+		Index:         true,
+		Filter:        filter,
+	})
+	if err != nil {
+		t.Fatalf("Error retrieving image: %s", err)
+	}
+	defer pkgutil.CleanupImage(image)
+
+	expected := []string{"/etc", "/etc/cache", "/opt"}
+	for _, index := range []*pkgutil.FileIndex{image.Index, image.Layers[0].Index} {
+		if !reflect.DeepEqual(index.Names(), expected) {
+			t.Errorf("Expected index: %v but got: %v", expected, index.Names())
+		}
+	}
+	// ExtractAll extracts the filtered paths all the same
+	for _, root := range []string{image.FSPath, image.Layers[0].FSPath} {
+		if _, err := os.Stat(filepath.Join(root, "opt/cache/a")); err != nil {
+			t.Errorf("Expected filtered file to be extracted: %s", err)
+		}
+	}
+}
+
+func TestIndexTarReplacedDirectory(t *testing.T) {
+	contents := testLayer(t, []*tar.Header{
+		{Name: "etc/conf/", Mode: 0755, Typeflag: tar.TypeDir},
+		{Name: "etc/conf/a", Mode: 0644, Typeflag: tar.TypeReg},
+		{Name: "etc/conf/b", Linkname: "etc/conf/a", Typeflag: tar.TypeLink},
+		{Name: "etc/conf", Mode: 0644, Typeflag: tar.TypeReg},
+	})
+	index, _, err := pkgutil.IndexTar(bytes.NewReader(contents), nil, nil)
+	if err != nil {
+		t.Fatalf("Error indexing: %s", err)
+	}
+	expected := []string{"/etc", "/etc/conf"}
+	if !reflect.DeepEqual(index.Names(), expected) {
+		t.Errorf("Expected index: %v but got: %v", expected, index.Names())
+	}
+
+	// the index matches the extracted tree
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(contents)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	if _, err := pkgutil.GetFileSystemForLayer(layer, root, nil); err != nil {
+		t.Fatalf("Error extracting layer: %s", err)
+	}
+	dir, err := pkgutil.GetDirectory(root, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dir.Content, expected) {
+		t.Errorf("Expected extracted files: %v but got: %v", expected, dir.Content)
+	}
+}
+
+func TestDiffIndex(t *testing.T) {
+	indexTar := func(path string) *pkgutil.FileIndex {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		index, _, err := pkgutil.IndexTar(f, nil, nil)
+		if err != nil {
+			t.Fatalf("Error indexing %s: %s", path, err)
+		}
+		return index
+	}
+	base := indexTar("testTars/la-croix1.tar")
+	nested := indexTar("testTars/la-croix2.tar")
+	update := indexTar("testTars/la-croix-update.tar")
+
+	expectedNames := []string{"/lime.txt", "/nest", "/nest/f1.txt", "/passionfruit.txt", "/peach-pear.txt"}
+	if !reflect.DeepEqual(nested.Names(), expectedNames) {
+		t.Errorf("Expected: %v but got: %v", expectedNames, nested.Names())
+	}
+
+	testCases := []struct {
+		descrip  string
+		index1   *pkgutil.FileIndex
+		index2   *pkgutil.FileIndex
+		expected DirDiff
+		same     bool
+	}{
+		{
+			descrip:  "identical indexes",
+			index1:   base,
+			index2:   base,
+			expected: DirDiff{},
+			same:     true,
+		},
+		{
+			descrip: "added directory",
+			index1:  base,
+			index2:  nested,
+			expected: DirDiff{
+				Adds: []pkgutil.DirectoryEntry{{Name: "/nest", Size: 0}, {Name: "/nest/f1.txt", Size: 0}},
+			},
+		},
+		{
+			descrip: "deleted and modified files",
+			index1:  base,
+			index2:  update,
+			expected: DirDiff{
+				Dels: []pkgutil.DirectoryEntry{{Name: "/passionfruit.txt", Size: 0}, {Name: "/peach-pear.txt", Size: 0}},
+				Mods: []EntryDiff{{Name: "/lime.txt", Size1: 0, Size2: 13}},
+			},
+		},
+	}
+	for _, test := range testCases {
+		diff, same := DiffIndex(test.index1, test.index2, false)
+		if !reflect.DeepEqual(diff, test.expected) {
+			t.Errorf("%s: Expected: %v but got: %v", test.descrip, test.expected, diff)
+		}
+		if same != test.same {
+			t.Errorf("%s: Expected same to be %t but got %t", test.descrip, test.same, same)
+		}
+	}
+}
@@ -27,7 +27,10 @@ FILE	SIZE{{range .Diff.Dels}}{{"\n"}}{{.Name}}	{{.Size}}{{end}}{{end}}
 
 These entries have been changed between {{.Image1}} and {{.Image2}}:{{if not .Diff.Mods}} None{{else}}
 FILE	SIZE1	SIZE2{{range .Diff.Mods}}{{"\n"}}{{.Name}}	{{.Size1}}	{{.Size2}}{{end}}
-{{end}}
+{{end}}{{range .Diff.Mods}}{{if .Content}}
+Content changes of {{.Name}}:
+{{.Content}}
+{{end}}{{end}}
 `
 const FSMetaDiffOutput = `
 -----{{.DiffType}}-----
@@ -89,7 +92,7 @@ Packages found only in {{.Image2}}:{{if not .Diff.Packages2}} None{{else}}
 NAME	VERSION	SIZE{{range .Diff.Packages2}}{{"\n"}}{{print "-"}}{{.Name}}	{{.Version}}	{{.Size}}{{end}}{{end}}
 
 Version differences:{{if not .Diff.InfoDiff}} None{{else}}
-PACKAGE	IMAGE1 ({{.Image1}})	IMAGE2 ({{.Image2}}){{range .Diff.InfoDiff}}{{"\n"}}{{print "-"}}{{.Package}}	{{.Info1.Version}}, {{.Info1.Size}}	{{.Info2.Version}}, {{.Info2.Size}}{{end}}
+PACKAGE	IMAGE1 ({{.Image1}})	IMAGE2 ({{.Image2}})	CHANGE{{range .Diff.InfoDiff}}{{"\n"}}{{print "-"}}{{.Package}}	{{.Info1.Version}}, {{.Info1.Size}}	{{.Info2.Version}}, {{.Info2.Size}}	{{.Change}}{{end}}
 {{end}}
 `
 
@@ -103,26 +106,57 @@ Packages found only in {{.Image2}}:{{if not .Diff.Packages2}} None{{else}}
 NAME	VERSION	SIZE{{range .Diff.Packages2}}{{"\n"}}{{print "-"}}{{.Name}}	{{.Version}}	{{.Size}}{{end}}{{end}}
 
 Version differences:{{if not .Diff.InfoDiff}} None{{else}}
-PACKAGE	IMAGE1 ({{.Image1}})	IMAGE2 ({{.Image2}}){{range .Diff.InfoDiff}}{{"\n"}}{{print "-"}}{{.Package}}	{{range .Info1}}{{.Version}}, {{.Size}}{{end}}	{{range .Info2}}{{.Version}}, {{.Size}}{{end}}{{end}}
+PACKAGE	IMAGE1 ({{.Image1}})	IMAGE2 ({{.Image2}})	CHANGE{{range .Diff.InfoDiff}}{{"\n"}}{{print "-"}}{{.Package}}	{{range .Info1}}{{.Version}}, {{.Size}}{{end}}	{{range .Info2}}{{.Version}}, {{.Size}}{{end}}	{{.Change}}{{end}}
 {{end}}
 `
 
 const HistoryDiffOutput = `
 -----{{.DiffType}}-----
 
-Docker history lines found only in {{.Image1}}:{{if not .Diff.Adds}} None{{else}}{{block "list" .Diff.Dels}}{{"\n"}}{{range .}}{{print "-" .}}{{"\n"}}{{end}}{{end}}{{end}}
-
-Docker history lines found only in {{.Image2}}:{{if not .Diff.Dels}} None{{else}}{{block "list2" .Diff.Adds}}{{"\n"}}{{range .}}{{print "-" .}}{{"\n"}}{{end}}{{end}}{{end}}
+{{.Image1}} and {{.Image2}} share {{.Diff.SharedBase}} history entries{{if .Diff.Identical}}, their histories are identical.{{else}}, then diverge:
+CHANGE	LAYER1	SIZE1	LAYER2	SIZE2	CREATED BY{{range .Diff.Entries}}{{"\n"}}{{.Kind}}	{{.Layer1}}	{{.Size1}}	{{.Layer2}}	{{.Size2}}	{{.CreatedBy}}{{end}}
+{{end}}
 `
 
 const MetadataDiffOutput = `
 -----{{.DiffType}}-----
 
-Image metadata differences between {{.Image1}} and {{.Image2}}:
+Image metadata differences between {{.Image1}} and {{.Image2}}:{{if not .Diff}} None{{else}}
+FIELD	CHANGE	VALUE1	VALUE2{{range .Diff}}{{"\n"}}{{.Name}}	{{.Kind}}	{{.Value1}}	{{.Value2}}{{end}}
+{{end}}
+`
+
+const LayerShareDiffOutput = `
+-----{{.DiffType}}-----
+
+Layers shared by {{.Image1}} and {{.Image2}}:{{if not .Diff.Shared}} None{{else}}
+DIGEST	SIZE	UNCOMPRESSED{{range .Diff.Shared}}{{"\n"}}{{.Digest}}	{{.Size}}	{{.UncompressedSize}}{{end}}{{end}}
+
+Layers found only in {{.Image1}}:{{if not .Diff.Unique1}} None{{else}}
+DIGEST	SIZE	UNCOMPRESSED{{range .Diff.Unique1}}{{"\n"}}{{.Digest}}	{{.Size}}	{{.UncompressedSize}}{{end}}{{end}}
 
-{{.Image1}}{{if not .Diff.Adds}} None{{else}}{{block "list" .Diff.Adds}}{{"\n"}}{{range .}}{{print "-" .}}{{"\n"}}{{end}}{{end}}{{end}}
+Layers found only in {{.Image2}}:{{if not .Diff.Unique2}} None{{else}}
+DIGEST	SIZE	UNCOMPRESSED{{range .Diff.Unique2}}{{"\n"}}{{.Digest}}	{{.Size}}	{{.UncompressedSize}}{{end}}{{end}}
 
-{{.Image2}}{{if not .Diff.Dels}} None{{else}}{{block "list2" .Diff.Dels}}{{"\n"}}{{range .}}{{print "-" .}}{{"\n"}}{{end}}{{end}}{{end}}
+IMAGE	SIZE	UNCOMPRESSED
+{{.Image1}}	{{.Diff.Size1}}	{{.Diff.UncompressedSize1}}
+{{.Image2}}	{{.Diff.Size2}}	{{.Diff.UncompressedSize2}}
+
+Shared size: {{.Diff.SharedSize}}
+Pull size from {{.Image1}} to {{.Image2}}: {{.Diff.PullSize}}
+`
+
+const VulnDiffOutput = `
+-----{{.DiffType}}-----
+
+Vulnerabilities fixed in {{.Image2}}:{{if not .Diff.Fixed}} None{{else}}
+ID	ALIASES	SEVERITY	PACKAGE	VERSION1	VERSION2{{range .Diff.Fixed}}{{"\n"}}{{.ID}}	{{.Aliases}}	{{.Severity}}	{{.Package}}	{{.Version1}}	{{.Version2}}{{end}}{{end}}
+
+Vulnerabilities introduced in {{.Image2}}:{{if not .Diff.Introduced}} None{{else}}
+ID	ALIASES	SEVERITY	PACKAGE	VERSION1	VERSION2	FIXED IN{{range .Diff.Introduced}}{{"\n"}}{{.ID}}	{{.Aliases}}	{{.Severity}}	{{.Package}}	{{.Version1}}	{{.Version2}}	{{.FixedVersion}}{{end}}{{end}}
+
+Vulnerabilities found in both images:{{if not .Diff.Unchanged}} None{{else}}
+ID	ALIASES	SEVERITY	PACKAGE	VERSION1	VERSION2	FIXED IN{{range .Diff.Unchanged}}{{"\n"}}{{.ID}}	{{.Aliases}}	{{.Severity}}	{{.Package}}	{{.Version1}}	{{.Version2}}	{{.FixedVersion}}{{end}}{{end}}
 `
 
 const FilenameDiffOutput = `
@@ -158,7 +192,7 @@ const FileAnalysisOutput = `
 -----{{.AnalyzeType}}-----
 
 Analysis for {{.Image}}:{{if not .Analysis}} None{{else}}
-FILE	SIZE{{range .Analysis}}{{"\n"}}{{.Name}}	{{.Size}}{{end}}
+FILE	SIZE{{if .Digests}}	DIGEST{{end}}{{range .Analysis}}{{"\n"}}{{.Name}}	{{.Size}}{{if $.Digests}}	{{.Digest}}{{end}}{{end}}
 {{end}}
 `
 
@@ -166,8 +200,10 @@ const FileLayerAnalysisOutput = `
 -----{{.AnalyzeType}}-----
 {{range $index, $analysis := .Analysis}}
 
-Analysis for {{$.Image}} Layer {{$index}}:{{if not $analysis}} None{{else}}
-FILE	SIZE{{range $analysis}}{{"\n"}}{{.Name}}	{{.Size}}{{end}}
+Analysis for {{$.Image}} Layer {{$index}}:{{if not $analysis.Entries}} None{{else}}
+FILE	SIZE{{if $.Digests}}	DIGEST{{end}}{{range $analysis.Entries}}{{"\n"}}{{.Name}}	{{.Size}}{{if $.Digests}}	{{.Digest}}{{end}}{{end}}
+{{end}}{{if $analysis.Deletions}}
+Removed in Layer {{$index}}:{{range $analysis.Deletions}}{{"\n"}}{{.}}{{end}}
 {{end}}
 {{end}}
 `
@@ -180,12 +216,30 @@ IMAGE	DIGEST	SIZE{{range .Analysis}}{{"\n"}}{{.Name}}	{{.Digest}}	{{.Size}}{{end
 {{end}}
 `
 
+const LayerShareAnalysisOutput = `
+-----{{.AnalyzeType}}-----
+
+Layers of {{.Image}}:{{if not .Analysis.Layers}} None{{else}}
+DIGEST	SIZE	UNCOMPRESSED{{range .Analysis.Layers}}{{"\n"}}{{.Digest}}	{{.Size}}	{{.UncompressedSize}}{{end}}{{end}}
+
+Total size: {{.Analysis.Size}}, uncompressed: {{.Analysis.UncompressedSize}}
+`
+
+const VulnAnalysisOutput = `
+-----{{.AnalyzeType}}-----
+
+Vulnerabilities found in {{.Image}}:{{if not .Analysis}} None{{else}}
+ID	ALIASES	SEVERITY	PACKAGE	VERSION	FIXED IN{{range .Analysis}}{{"\n"}}{{.ID}}	{{.Aliases}}	{{.Severity}}	{{.Package}}	{{.Version}}	{{.FixedVersion}}{{end}}{{end}}
+`
+
 const SizeLayerAnalysisOutput = `
 -----{{.AnalyzeType}}-----
 
 Analysis for {{.Image}}:{{if not .Analysis}} None{{else}}
 LAYER	DIGEST	SIZE{{range .Analysis}}{{"\n"}}{{.Name}}	{{.Digest}}	{{.Size}}{{end}}
-{{end}}
+{{range .Analysis}}{{if .Deletions}}
+Removed in Layer {{.Name}}:{{range .Deletions}}{{"\n"}}{{.}}{{end}}
+{{end}}{{end}}{{end}}
 `
 
 const MultiVersionPackageOutput = `
@@ -215,7 +269,7 @@ NAME	VERSION	SIZE{{range $analysis.Packages1}}{{"\n"}}{{print "-"}}{{.Name}}	{{.
 NAME	VERSION	SIZE{{range $analysis.Packages2}}{{"\n"}}{{print "-"}}{{.Name}}	{{.Version}}	{{.Size}}{{end}}{{end}}
 {{if ne $index 0}}
 Version differences:{{if not $analysis.InfoDiff}} None{{else}}
-PACKAGE	PREV_LAYER	CURRENT_LAYER {{range $analysis.InfoDiff}}{{"\n"}}{{print "-"}}{{.Package}}	{{.Info1.Version}}, {{.Info1.Size}}	{{.Info2.Version}}, {{.Info2.Size}}{{end}}
+PACKAGE	PREV_LAYER	CURRENT_LAYER	CHANGE{{range $analysis.InfoDiff}}{{"\n"}}{{print "-"}}{{.Package}}	{{.Info1.Version}}, {{.Info1.Size}}	{{.Info2.Version}}, {{.Info2.Size}}	{{.Change}}{{end}}
 {{end}}{{end}}{{end}}
 {{end}}
 `
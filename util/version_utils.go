@@ -0,0 +1,645 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// versionComparers compare the package versions of each package analyzer by
+// the rules of its package manager.
+var versionComparers = map[string]func(v1, v2 string) int{
+	"Apt":    CompareDebianVersions,
+	"RPM":    CompareRPMVersions,
+	"Apk":    CompareApkVersions,
+	"Emerge": CompareGentooVersions,
+	"Pip":    ComparePEP440Versions,
+	"Node":   CompareSemanticVersions,
+	"GoMod":  CompareGoVersions,
+}
+
+// CompareVersions compares two versions of a package found by the analyzer
+// of analyzeType. It returns -1, 0 or 1 if v1 is lower than, equal to or
+// greater than v2. Versions of other analyzers are compared as strings.
+func CompareVersions(analyzeType, v1, v2 string) int {
+	if compare, ok := versionComparers[analyzeType]; ok {
+		return compare(v1, v2)
+	}
+	return strings.Compare(v1, v2)
+}
+
+// CompareDebianVersions compares two dpkg versions of the form
+// [epoch:]upstream[-revision].
+func CompareDebianVersions(v1, v2 string) int {
+	epoch1, upstream1, revision1 := splitDebianVersion(v1)
+	epoch2, upstream2, revision2 := splitDebianVersion(v2)
+	if epoch1 != epoch2 {
+		return compareInts(epoch1, epoch2)
+	}
+	if c := compareDebianPart(upstream1, upstream2); c != 0 {
+		return c
+	}
+	return compareDebianPart(revision1, revision2)
+}
+
+func splitDebianVersion(version string) (epoch int64, upstream, revision string) {
+	upstream = strings.TrimSpace(version)
+	if e, rest, ok := strings.Cut(upstream, ":"); ok {
+		epoch, _ = strconv.ParseInt(e, 10, 64)
+		upstream = rest
+	}
+	if i := strings.LastIndex(upstream, "-"); i >= 0 {
+		upstream, revision = upstream[:i], upstream[i+1:]
+	}
+	return epoch, upstream, revision
+}
+
+// compareDebianPart implements verrevcmp from dpkg: alternating non-digit
+// parts, where '~' sorts before anything and letters before other symbols,
+// and digit parts compared numerically.
+func compareDebianPart(a, b string) int {
+	for a != "" || b != "" {
+		for (a != "" && !isDigit(a[0])) || (b != "" && !isDigit(b[0])) {
+			ac, bc := debianOrder(a), debianOrder(b)
+			if ac != bc {
+				return compareInts(int64(ac), int64(bc))
+			}
+			if a != "" {
+				a = a[1:]
+			}
+			if b != "" {
+				b = b[1:]
+			}
+		}
+		var digits1, digits2 string
+		digits1, a = cutDigits(a)
+		digits2, b = cutDigits(b)
+		if c := compareNumeric(digits1, digits2); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func debianOrder(s string) int {
+	switch {
+	case s == "" || isDigit(s[0]):
+		return 0
+	case isLetter(s[0]):
+		return int(s[0])
+	case s[0] == '~':
+		return -1
+	default:
+		return int(s[0]) + 256
+	}
+}
+
+// CompareRPMVersions compares two rpm versions of the form
+// [epoch:]version[-release]. The release is only compared when both
+// versions have one.
+func CompareRPMVersions(v1, v2 string) int {
+	epoch1, version1, release1 := splitRPMVersion(v1)
+	epoch2, version2, release2 := splitRPMVersion(v2)
+	if epoch1 != epoch2 {
+		return compareInts(epoch1, epoch2)
+	}
+	if c := rpmvercmp(version1, version2); c != 0 {
+		return c
+	}
+	if release1 == "" || release2 == "" {
+		return 0
+	}
+	return rpmvercmp(release1, release2)
+}
+
+func splitRPMVersion(version string) (epoch int64, ver, release string) {
+	ver = strings.TrimSpace(version)
+	if e, rest, ok := strings.Cut(ver, ":"); ok {
+		epoch, _ = strconv.ParseInt(e, 10, 64)
+		ver = rest
+	}
+	if i := strings.LastIndex(ver, "-"); i >= 0 {
+		ver, release = ver[:i], ver[i+1:]
+	}
+	return epoch, ver, release
+}
+
+// rpmvercmp compares two version or release strings segment by segment, as
+// rpm does. Segments are runs of digits or letters, and other characters
+// separate them, except '~', which sorts before anything, and '^', which
+// sorts after the end of a version but before any other segment.
+func rpmvercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	for a != "" || b != "" {
+		a = strings.TrimLeftFunc(a, isRPMSeparator)
+		b = strings.TrimLeftFunc(b, isRPMSeparator)
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if strings.HasPrefix(a, "^") || strings.HasPrefix(b, "^") {
+			if a == "" {
+				return -1
+			}
+			if b == "" {
+				return 1
+			}
+			if !strings.HasPrefix(a, "^") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "^") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if a == "" || b == "" {
+			break
+		}
+		var segment1, segment2 string
+		if isDigit(a[0]) {
+			segment1, a = cutDigits(a)
+			segment2, b = cutDigits(b)
+			// a numeric segment is newer than an alphabetic one
+			if segment2 == "" {
+				return 1
+			}
+			if c := compareNumeric(segment1, segment2); c != 0 {
+				return c
+			}
+			continue
+		}
+		segment1, a = cutLetters(a)
+		segment2, b = cutLetters(b)
+		if segment2 == "" {
+			return -1
+		}
+		if c := strings.Compare(segment1, segment2); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	default:
+		return 1
+	}
+}
+
+func isRPMSeparator(r rune) bool {
+	return r < 128 && !isDigit(byte(r)) && !isLetter(byte(r)) && r != '~' && r != '^'
+}
+
+// apkSuffixes rank the suffixes of apk versions. Suffixes before the empty
+// one mark pre-releases, those after it post-releases.
+var apkSuffixes = map[string]int{
+	"alpha": -4,
+	"beta":  -3,
+	"pre":   -2,
+	"rc":    -1,
+	"":      0,
+	"cvs":   1,
+	"svn":   2,
+	"git":   3,
+	"hg":    4,
+	"p":     5,
+}
+
+// gentooSuffixes rank the suffixes of Gentoo versions like apkSuffixes.
+var gentooSuffixes = map[string]int{
+	"alpha": -4,
+	"beta":  -3,
+	"pre":   -2,
+	"rc":    -1,
+	"":      0,
+	"p":     1,
+}
+
+type apkVersion struct {
+	numbers  []string
+	letter   byte
+	suffixes []apkSuffix
+	revision int64
+}
+
+type apkSuffix struct {
+	rank   int
+	number string
+}
+
+// CompareApkVersions compares two apk versions of the form
+// number{.number}[letter]{_suffix[number]}[-rrevision]. Versions that don't
+// follow that form are compared as strings.
+func CompareApkVersions(v1, v2 string) int {
+	return compareApkVersions(v1, v2, apkSuffixes)
+}
+
+// CompareGentooVersions compares two Gentoo versions of the form
+// number{.number}[letter]{_suffix[number]}[-rrevision], which apk versions
+// are derived from.
+func CompareGentooVersions(v1, v2 string) int {
+	return compareApkVersions(v1, v2, gentooSuffixes)
+}
+
+func compareApkVersions(v1, v2 string, suffixes map[string]int) int {
+	version1, ok1 := parseApkVersion(v1, suffixes)
+	version2, ok2 := parseApkVersion(v2, suffixes)
+	if !ok1 || !ok2 {
+		return strings.Compare(v1, v2)
+	}
+	for i := 0; i < len(version1.numbers) && i < len(version2.numbers); i++ {
+		n1, n2 := version1.numbers[i], version2.numbers[i]
+		// like Gentoo, components after the first with a leading zero are
+		// compared as decimal fractions
+		if i > 0 && (strings.HasPrefix(n1, "0") || strings.HasPrefix(n2, "0")) {
+			n1, n2 = strings.TrimRight(n1, "0"), strings.TrimRight(n2, "0")
+			if c := strings.Compare(n1, n2); c != 0 {
+				return c
+			}
+			continue
+		}
+		if c := compareNumeric(n1, n2); c != 0 {
+			return c
+		}
+	}
+	if len(version1.numbers) != len(version2.numbers) {
+		return compareInts(int64(len(version1.numbers)), int64(len(version2.numbers)))
+	}
+	if version1.letter != version2.letter {
+		return compareInts(int64(version1.letter), int64(version2.letter))
+	}
+	for i := 0; i < len(version1.suffixes) || i < len(version2.suffixes); i++ {
+		var s1, s2 apkSuffix
+		if i < len(version1.suffixes) {
+			s1 = version1.suffixes[i]
+		}
+		if i < len(version2.suffixes) {
+			s2 = version2.suffixes[i]
+		}
+		if s1.rank != s2.rank {
+			return compareInts(int64(s1.rank), int64(s2.rank))
+		}
+		if c := compareNumeric(s1.number, s2.number); c != 0 {
+			return c
+		}
+	}
+	return compareInts(version1.revision, version2.revision)
+}
+
+func parseApkVersion(version string, suffixes map[string]int) (apkVersion, bool) {
+	var v apkVersion
+	s := strings.TrimSpace(version)
+	if i := strings.LastIndex(s, "-r"); i >= 0 {
+		revision, err := strconv.ParseInt(s[i+2:], 10, 64)
+		if err != nil {
+			return v, false
+		}
+		s, v.revision = s[:i], revision
+	}
+	// drop a trailing ~hash of a VCS snapshot
+	if i := strings.Index(s, "~"); i >= 0 {
+		s = s[:i]
+	}
+	for {
+		var number string
+		number, s = cutDigits(s)
+		if number == "" {
+			return v, false
+		}
+		v.numbers = append(v.numbers, number)
+		if !strings.HasPrefix(s, ".") {
+			break
+		}
+		s = s[1:]
+	}
+	if s != "" && isLetter(s[0]) {
+		v.letter, s = s[0], s[1:]
+	}
+	for strings.HasPrefix(s, "_") {
+		var name, number string
+		name, s = cutLetters(s[1:])
+		number, s = cutDigits(s)
+		rank, ok := suffixes[name]
+		if !ok || name == "" {
+			return v, false
+		}
+		v.suffixes = append(v.suffixes, apkSuffix{rank: rank, number: number})
+	}
+	return v, s == ""
+}
+
+// pep440Pattern matches the versions PEP 440 accepts, including the
+// alternative spellings it normalizes.
+var pep440Pattern = regexp.MustCompile(`^v?` +
+	`(?:(?P<epoch>[0-9]+)!)?` +
+	`(?P<release>[0-9]+(?:\.[0-9]+)*)` +
+	`(?:[-_.]?(?P<pre_l>alpha|a|beta|b|preview|pre|c|rc)[-_.]?(?P<pre_n>[0-9]+)?)?` +
+	`(?:-(?P<post_n1>[0-9]+)|[-_.]?(?P<post_l>post|rev|r)[-_.]?(?P<post_n2>[0-9]+)?)?` +
+	`(?:[-_.]?(?P<dev_l>dev)[-_.]?(?P<dev_n>[0-9]+)?)?` +
+	`(?:\+(?P<local>[a-z0-9]+(?:[-_.][a-z0-9]+)*))?$`)
+
+// pep440PreReleases rank the pre-release phases of PEP 440 versions.
+var pep440PreReleases = map[string]int{
+	"a":       1,
+	"alpha":   1,
+	"b":       2,
+	"beta":    2,
+	"c":       3,
+	"rc":      3,
+	"pre":     3,
+	"preview": 3,
+}
+
+type pep440Version struct {
+	epoch   string
+	release []string
+	// pre ranks the pre-release phase, 0 for a development release without
+	// one and 4 for a final release
+	pre   int
+	preN  string
+	post  bool
+	postN string
+	dev   bool
+	devN  string
+	local []string
+}
+
+// ComparePEP440Versions compares two Python package versions as PEP 440
+// orders them. Versions that don't follow PEP 440 are compared as strings.
+func ComparePEP440Versions(v1, v2 string) int {
+	version1, ok1 := parsePEP440Version(v1)
+	version2, ok2 := parsePEP440Version(v2)
+	if !ok1 || !ok2 {
+		return strings.Compare(v1, v2)
+	}
+	if c := compareNumeric(version1.epoch, version2.epoch); c != 0 {
+		return c
+	}
+	// trailing zeros of a release are insignificant
+	for i := 0; i < len(version1.release) || i < len(version2.release); i++ {
+		var n1, n2 string
+		if i < len(version1.release) {
+			n1 = version1.release[i]
+		}
+		if i < len(version2.release) {
+			n2 = version2.release[i]
+		}
+		if c := compareNumeric(n1, n2); c != 0 {
+			return c
+		}
+	}
+	if version1.pre != version2.pre {
+		return compareInts(int64(version1.pre), int64(version2.pre))
+	}
+	if c := compareNumeric(version1.preN, version2.preN); c != 0 {
+		return c
+	}
+	if version1.post != version2.post {
+		return compareBools(version1.post, version2.post)
+	}
+	if c := compareNumeric(version1.postN, version2.postN); c != 0 {
+		return c
+	}
+	if version1.dev != version2.dev {
+		return compareBools(version2.dev, version1.dev)
+	}
+	if c := compareNumeric(version1.devN, version2.devN); c != 0 {
+		return c
+	}
+	return comparePEP440Local(version1.local, version2.local)
+}
+
+func parsePEP440Version(version string) (pep440Version, bool) {
+	var v pep440Version
+	match := pep440Pattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(version)))
+	if match == nil {
+		return v, false
+	}
+	group := func(name string) string {
+		return match[pep440Pattern.SubexpIndex(name)]
+	}
+	v.epoch = group("epoch")
+	v.release = strings.Split(group("release"), ".")
+	v.post = group("post_n1") != "" || group("post_l") != ""
+	v.postN = group("post_n1") + group("post_n2")
+	v.dev = group("dev_l") != ""
+	v.devN = group("dev_n")
+	switch {
+	case group("pre_l") != "":
+		v.pre, v.preN = pep440PreReleases[group("pre_l")], group("pre_n")
+	case v.dev && !v.post:
+		// a development release sorts before the pre-releases of its release
+		v.pre = 0
+	default:
+		v.pre = 4
+	}
+	if local := group("local"); local != "" {
+		v.local = strings.FieldsFunc(local, func(r rune) bool {
+			return r == '-' || r == '_' || r == '.'
+		})
+	}
+	return v, true
+}
+
+// comparePEP440Local compares the local labels of two versions, where a
+// version without one sorts first and numeric segments sort after
+// alphanumeric ones.
+func comparePEP440Local(local1, local2 []string) int {
+	for i := 0; i < len(local1) && i < len(local2); i++ {
+		numeric1, numeric2 := isNumeric(local1[i]), isNumeric(local2[i])
+		switch {
+		case numeric1 && numeric2:
+			if c := compareNumeric(local1[i], local2[i]); c != 0 {
+				return c
+			}
+		case numeric1 != numeric2:
+			return compareBools(numeric1, numeric2)
+		default:
+			if c := strings.Compare(local1[i], local2[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareInts(int64(len(local1)), int64(len(local2)))
+}
+
+type semanticVersion struct {
+	core       []string
+	prerelease []string
+}
+
+// CompareSemanticVersions compares two npm package versions as Semantic
+// Versioning orders them, ignoring build metadata. Versions that aren't
+// semantic versions are compared as strings.
+func CompareSemanticVersions(v1, v2 string) int {
+	version1, ok1 := parseSemanticVersion(v1)
+	version2, ok2 := parseSemanticVersion(v2)
+	if !ok1 || !ok2 {
+		return strings.Compare(v1, v2)
+	}
+	return compareSemanticVersions(version1, version2)
+}
+
+// CompareGoVersions compares two Go module versions, which are semantic
+// versions, or Go toolchain versions like go1.21rc2 for the standard library.
+func CompareGoVersions(v1, v2 string) int {
+	version1, ok1 := parseGoVersion(v1)
+	version2, ok2 := parseGoVersion(v2)
+	if !ok1 || !ok2 {
+		return strings.Compare(v1, v2)
+	}
+	return compareSemanticVersions(version1, version2)
+}
+
+func compareSemanticVersions(version1, version2 semanticVersion) int {
+	for i := 0; i < len(version1.core) || i < len(version2.core); i++ {
+		var n1, n2 string
+		if i < len(version1.core) {
+			n1 = version1.core[i]
+		}
+		if i < len(version2.core) {
+			n2 = version2.core[i]
+		}
+		if c := compareNumeric(n1, n2); c != 0 {
+			return c
+		}
+	}
+	// a pre-release sorts before its release
+	if len(version1.prerelease) == 0 || len(version2.prerelease) == 0 {
+		return compareInts(int64(len(version2.prerelease)), int64(len(version1.prerelease)))
+	}
+	for i := 0; i < len(version1.prerelease) && i < len(version2.prerelease); i++ {
+		id1, id2 := version1.prerelease[i], version2.prerelease[i]
+		numeric1, numeric2 := isNumeric(id1), isNumeric(id2)
+		switch {
+		case numeric1 && numeric2:
+			if c := compareNumeric(id1, id2); c != 0 {
+				return c
+			}
+		case numeric1 != numeric2:
+			return compareBools(numeric2, numeric1)
+		default:
+			if c := strings.Compare(id1, id2); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareInts(int64(len(version1.prerelease)), int64(len(version2.prerelease)))
+}
+
+func parseSemanticVersion(version string) (semanticVersion, bool) {
+	var v semanticVersion
+	s := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	s, _, _ = strings.Cut(s, "+")
+	s, prerelease, ok := strings.Cut(s, "-")
+	if ok {
+		v.prerelease = strings.Split(prerelease, ".")
+	}
+	v.core = strings.Split(s, ".")
+	for _, n := range v.core {
+		if !isNumeric(n) {
+			return v, false
+		}
+	}
+	return v, true
+}
+
+func parseGoVersion(version string) (semanticVersion, bool) {
+	s := strings.TrimSpace(version)
+	if !strings.HasPrefix(s, "go") {
+		return parseSemanticVersion(s)
+	}
+	// toolchain versions put their pre-release right after the number, as
+	// in go1.21rc2
+	s = strings.TrimPrefix(s, "go")
+	if i := strings.IndexFunc(s, func(r rune) bool { return r < 128 && isLetter(byte(r)) }); i >= 0 {
+		s = s[:i] + "-" + s[i:]
+	}
+	return parseSemanticVersion(s)
+}
+
+// compareNumeric compares two strings of digits by value, without limits on
+// their length. An empty string counts as zero.
+func compareNumeric(a, b string) int {
+	a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return compareInts(int64(len(a)), int64(len(b)))
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareBools orders false before true.
+func compareBools(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	}
+	return -1
+}
+
+func cutDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func cutLetters(s string) (string, string) {
+	i := 0
+	for i < len(s) && isLetter(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isNumeric(s string) bool {
+	digits, rest := cutDigits(s)
+	return digits != "" && rest == ""
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
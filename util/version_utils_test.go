@@ -0,0 +1,92 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	testCases := []struct {
+		analyzeType string
+		v1          string
+		v2          string
+		expected    int
+	}{
+		{"Apt", "1.0", "1.0", 0},
+		{"Apt", "1.0-1", "1.0-2", -1},
+		{"Apt", "1:1.0", "2.0", 1},
+		{"Apt", "1.0~rc1", "1.0", -1},
+		{"Apt", "1.0+deb12u1", "1.0", 1},
+		{"Apt", "2.36-9+deb12u4", "2.36-9+deb12u10", -1},
+		{"Apt", "1.2a", "1.2+", -1},
+		{"Apt", "1.10", "1.9", 1},
+		{"RPM", "3.0.7-24.el9", "3.0.7-24.el9", 0},
+		{"RPM", "3.0.7-24.el9", "3.0.7-25.el9", -1},
+		{"RPM", "1:3.0.7-1", "3.0.8-1", 1},
+		{"RPM", "1.0~rc1", "1.0", -1},
+		{"RPM", "1.0^git1", "1.0", 1},
+		{"RPM", "1.0^git1", "1.0.1", -1},
+		{"RPM", "1.0a", "1.0.1", -1},
+		{"RPM", "2.17", "2.17-326.el7_9", 0},
+		{"RPM", "1.010", "1.9", 1},
+		{"Apk", "1.36.1-r15", "1.36.1-r16", -1},
+		{"Apk", "3.1.4-r0", "3.1.2-r0", 1},
+		{"Apk", "1.2.3_rc1", "1.2.3", -1},
+		{"Apk", "1.2.3_p1", "1.2.3", 1},
+		{"Apk", "1.2.3a", "1.2.3", 1},
+		{"Apk", "1.2", "1.2.0", -1},
+		{"Apk", "1.01", "1.1", -1},
+		{"Apk", "2.0_alpha1", "2.0_beta1", -1},
+		{"Emerge", "2.38-r10", "2.38-r9", 1},
+		{"Emerge", "1.2.3_pre1", "1.2.3_rc1", -1},
+		{"Emerge", "1.2.3_p1", "1.2.3", 1},
+		{"Emerge", "1.0b", "1.0a", 1},
+		{"Pip", "1.0", "2.0", -1},
+		{"Pip", "1.10", "1.9", 1},
+		{"Pip", "1.0", "1.0.0", 0},
+		{"Pip", "v1.0", "1.0", 0},
+		{"Pip", "1!1.0", "2.0", 1},
+		{"Pip", "1.0rc1", "1.0", -1},
+		{"Pip", "1.0a1", "1.0b1", -1},
+		{"Pip", "1.0-alpha.1", "1.0a1", 0},
+		{"Pip", "1.0.dev1", "1.0a1", -1},
+		{"Pip", "1.0a1.dev1", "1.0a1", -1},
+		{"Pip", "1.0.post1", "1.0", 1},
+		{"Pip", "1.0-1", "1.0.post1", 0},
+		{"Pip", "1.0.post1.dev1", "1.0.post1", -1},
+		{"Pip", "1.0+local.1", "1.0", 1},
+		{"Pip", "1.0+abc", "1.0+1", -1},
+		{"Node", "1.10.0", "1.9.0", 1},
+		{"Node", "v1.0.0", "1.0.0", 0},
+		{"Node", "1.0.0+build.1", "1.0.0+build.2", 0},
+		{"Node", "1.0.0-rc.1", "1.0.0", -1},
+		{"Node", "1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"Node", "1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"Node", "1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"GoMod", "v1.8.0", "v1.10.0", -1},
+		{"GoMod", "v0.0.0-20230101120000-abcdef123456", "v0.1.0", -1},
+		{"GoMod", "go1.21rc2", "go1.21.0", -1},
+		{"GoMod", "go1.21.5", "go1.21.10", -1},
+	}
+	for _, test := range testCases {
+		if c := CompareVersions(test.analyzeType, test.v1, test.v2); c != test.expected {
+			t.Errorf("%s: comparing %s to %s, expected: %d but got: %d", test.analyzeType, test.v1, test.v2, test.expected, c)
+		}
+		if c := CompareVersions(test.analyzeType, test.v2, test.v1); c != -test.expected {
+			t.Errorf("%s: comparing %s to %s, expected: %d but got: %d", test.analyzeType, test.v2, test.v1, -test.expected, c)
+		}
+	}
+}